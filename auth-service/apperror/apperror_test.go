@@ -0,0 +1,104 @@
+package apperror
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin/binding"
+)
+
+func TestConstructors(t *testing.T) {
+	cause := errors.New("pq: connection refused")
+
+	tests := []struct {
+		name       string
+		err        *Error
+		wantStatus int
+		wantCode   Code
+		wantCause  bool
+	}{
+		{name: "bad request", err: New(CodeBadRequest, "Invalid ID"), wantStatus: http.StatusBadRequest, wantCode: "BAD_REQUEST"},
+		{name: "validation", err: InvalidField("email", "email", "must be a valid email address"), wantStatus: http.StatusBadRequest, wantCode: "VALIDATION_ERROR"},
+		{name: "unauthorized", err: New(CodeUnauthorized, "Unauthorized"), wantStatus: http.StatusUnauthorized, wantCode: "UNAUTHORIZED"},
+		{name: "forbidden", err: New(CodeForbidden, "Forbidden"), wantStatus: http.StatusForbidden, wantCode: "FORBIDDEN"},
+		{name: "not found", err: New(CodeNotFound, "Not found"), wantStatus: http.StatusNotFound, wantCode: "NOT_FOUND"},
+		{name: "conflict", err: New(CodeConflict, "Conflict"), wantStatus: http.StatusConflict, wantCode: "CONFLICT"},
+		{name: "too many requests", err: New(CodeTooManyRequests, "Slow down"), wantStatus: http.StatusTooManyRequests, wantCode: "TOO_MANY_REQUESTS"},
+		{name: "service unavailable", err: Wrap(cause, CodeServiceUnavailable, "Try again later"), wantStatus: http.StatusServiceUnavailable, wantCode: "SERVICE_UNAVAILABLE", wantCause: true},
+		{name: "internal", err: Internal(cause, "Database error"), wantStatus: http.StatusInternalServerError, wantCode: "INTERNAL_ERROR", wantCause: true},
+		{name: "plain error", err: From(cause), wantStatus: http.StatusInternalServerError, wantCode: "INTERNAL_ERROR", wantCause: true},
+		{name: "wrapped app error", err: From(fmt.Errorf("loading: %w", New(CodeNotFound, "Not found"))), wantStatus: http.StatusNotFound, wantCode: "NOT_FOUND"},
+		{name: "unregistered code", err: New("SOMETHING_ELSE", "Odd"), wantStatus: http.StatusInternalServerError, wantCode: "SOMETHING_ELSE"},
+		{name: "invalid credentials", err: New(CodeInvalidCredentials, "Invalid email or password"), wantStatus: http.StatusUnauthorized, wantCode: "INVALID_CREDENTIALS"},
+		{name: "email taken", err: New(CodeEmailTaken, "Email already registered"), wantStatus: http.StatusConflict, wantCode: "EMAIL_ALREADY_REGISTERED"},
+		{name: "account locked", err: New(CodeAccountLocked, "Account locked"), wantStatus: http.StatusTooManyRequests, wantCode: "ACCOUNT_LOCKED"},
+		{name: "insufficient scope", err: New(CodeInsufficientScope, "Missing scope"), wantStatus: http.StatusForbidden, wantCode: "INSUFFICIENT_SCOPE"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.err.Status(); got != tt.wantStatus {
+				t.Errorf("status %d, want %d", got, tt.wantStatus)
+			}
+			if tt.err.Code != tt.wantCode {
+				t.Errorf("code %s, want %s", tt.err.Code, tt.wantCode)
+			}
+			if got := errors.Is(tt.err, cause); got != tt.wantCause {
+				t.Errorf("wraps the cause: %v, want %v", got, tt.wantCause)
+			}
+		})
+	}
+}
+
+func TestValidation(t *testing.T) {
+	UseJSONFieldNames()
+
+	type item struct {
+		Quantity int `json:"quantity" binding:"required,min=1"`
+	}
+	type request struct {
+		Email string `json:"email" binding:"required,email"`
+		Items []item `json:"items" binding:"required,min=1,dive"`
+	}
+
+	tests := []struct {
+		name       string
+		body       string
+		wantCode   Code
+		wantFields []FieldError
+	}{
+		{
+			name:     "rules",
+			body:     `{"email": "not-an-email", "items": [{"quantity": 0}]}`,
+			wantCode: CodeValidation,
+			wantFields: []FieldError{
+				{Field: "email", Rule: "email", Message: "must be a valid email address"},
+				{Field: "items[0].quantity", Rule: "required", Message: "is required"},
+			},
+		},
+		{
+			name:       "type",
+			body:       `{"email": "budi@example.com", "items": "two"}`,
+			wantCode:   CodeValidation,
+			wantFields: []FieldError{{Field: "items", Rule: "type", Message: "must be an array"}},
+		},
+		{name: "syntax", body: `{"email": `, wantCode: CodeBadRequest},
+		{name: "empty", body: "", wantCode: CodeBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req request
+			got := Validation(binding.JSON.BindBody([]byte(tt.body), &req))
+			if got.Code != tt.wantCode || got.Status() != http.StatusBadRequest {
+				t.Errorf("code %s and status %d, want %s and 400", got.Code, got.Status(), tt.wantCode)
+			}
+			if !reflect.DeepEqual(got.Fields, tt.wantFields) {
+				t.Errorf("fields %+v, want %+v", got.Fields, tt.wantFields)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package apperror
+
+import "net/http"
+
+// Error codes specific to auth-service
+var (
+	CodeMissingToken       = register("MISSING_TOKEN", http.StatusUnauthorized)
+	CodeInvalidToken       = register("INVALID_TOKEN", http.StatusUnauthorized)
+	CodeInvalidAPIKey      = register("INVALID_API_KEY", http.StatusUnauthorized)
+	CodeInsufficientScope  = register("INSUFFICIENT_SCOPE", http.StatusForbidden)
+	CodeInvalidCredentials = register("INVALID_CREDENTIALS", http.StatusUnauthorized)
+	CodeEmailTaken         = register("EMAIL_ALREADY_REGISTERED", http.StatusConflict)
+	CodeUserNotFound       = register("USER_NOT_FOUND", http.StatusNotFound)
+	CodeAccountLocked      = register("ACCOUNT_LOCKED", http.StatusTooManyRequests)
+
+	CodeInvalidVerificationToken = register("INVALID_VERIFICATION_TOKEN", http.StatusBadRequest)
+	CodeEmailAlreadyVerified     = register("EMAIL_ALREADY_VERIFIED", http.StatusConflict)
+	CodeInvalidResetToken        = register("INVALID_RESET_TOKEN", http.StatusBadRequest)
+
+	CodeTwoFactorEnabled      = register("TWO_FACTOR_ALREADY_ENABLED", http.StatusConflict)
+	CodeTwoFactorNotEnabled   = register("TWO_FACTOR_NOT_ENABLED", http.StatusConflict)
+	CodeInvalidTwoFactorCode  = register("INVALID_TWO_FACTOR_CODE", http.StatusUnauthorized)
+	CodeInvalidChallengeToken = register("INVALID_CHALLENGE_TOKEN", http.StatusUnauthorized)
+
+	CodeOIDCProviderNotFound = register("OIDC_PROVIDER_NOT_FOUND", http.StatusNotFound)
+	CodeInvalidOIDCState     = register("INVALID_OIDC_STATE", http.StatusBadRequest)
+	CodeOIDCLoginFailed      = register("OIDC_LOGIN_FAILED", http.StatusUnauthorized)
+	CodeOIDCEmailNotVerified = register("OIDC_EMAIL_NOT_VERIFIED", http.StatusForbidden)
+
+	CodeAPIKeyNotFound  = register("API_KEY_NOT_FOUND", http.StatusNotFound)
+	CodeAddressNotFound = register("ADDRESS_NOT_FOUND", http.StatusNotFound)
+	CodeAddressLimit    = register("ADDRESS_LIMIT_REACHED", http.StatusConflict)
+	CodeInvalidScope    = register("INVALID_SCOPE", http.StatusBadRequest)
+)
@@ -0,0 +1,50 @@
+// Command mock-oidc runs the mock OpenID Connect provider for local development
+package main
+
+import (
+	"auth-service/logger"
+	"auth-service/oidc/mockoidc"
+	"log/slog"
+	"net/http"
+	"os"
+)
+
+func main() {
+	logger.Init("mock-oidc")
+
+	port := os.Getenv("MOCK_OIDC_PORT")
+	if port == "" {
+		port = "9000"
+	}
+
+	issuer := os.Getenv("MOCK_OIDC_ISSUER")
+	if issuer == "" {
+		issuer = "http://localhost:" + port
+	}
+
+	provider, err := mockoidc.New(issuer, getenv("MOCK_OIDC_CLIENT_ID", "mock-client"), getenv("MOCK_OIDC_CLIENT_SECRET", "mock-secret"))
+	if err != nil {
+		logger.Fatal("failed to create mock OIDC provider", "error", err)
+	}
+
+	if email := os.Getenv("MOCK_OIDC_EMAIL"); email != "" {
+		provider.SetUser(mockoidc.User{
+			Subject:       getenv("MOCK_OIDC_SUBJECT", email),
+			Email:         email,
+			EmailVerified: os.Getenv("MOCK_OIDC_EMAIL_VERIFIED") != "false",
+			Name:          getenv("MOCK_OIDC_NAME", email),
+		})
+	}
+
+	slog.Info("mock OIDC provider starting", "issuer", issuer, "port", port)
+	if err := http.ListenAndServe(":"+port, provider.Handler()); err != nil {
+		logger.Fatal("mock OIDC provider stopped", "error", err)
+	}
+}
+
+func getenv(key, def string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return def
+}
@@ -0,0 +1,50 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+)
+
+// EnsureUniqueEmails creates the index keeping emails unique regardless of
+// case. Accounts whose emails differ only in case belong to people who must be
+// asked which to keep, so they are not merged here. Until they are resolved,
+// each start logs them and leaves the index out, and lookups by email match
+// whichever of them comes first.
+func EnsureUniqueEmails(db *sql.DB) error {
+	return withMigrationLock(db, func() error {
+		rows, err := db.Query(
+			`SELECT LOWER(email), string_agg(id::TEXT, ', ' ORDER BY id)
+			 FROM users GROUP BY LOWER(email) HAVING COUNT(*) > 1 ORDER BY 1`,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to check for duplicate emails: %w", err)
+		}
+		defer rows.Close()
+
+		duplicates := 0
+		for rows.Next() {
+			var email, userIDs string
+			if err := rows.Scan(&email, &userIDs); err != nil {
+				return fmt.Errorf("failed to check for duplicate emails: %w", err)
+			}
+			slog.Warn("accounts have emails that differ only in case; keep one, change the others' email or delete them",
+				"email", email, "user_ids", userIDs)
+			duplicates++
+		}
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to check for duplicate emails: %w", err)
+		}
+
+		if duplicates > 0 {
+			slog.Warn("case-insensitive email index not created; it is created on the first start without duplicates",
+				"duplicates", duplicates)
+			return nil
+		}
+
+		if _, err := db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_lower ON users(LOWER(email))"); err != nil {
+			return fmt.Errorf("failed to create case-insensitive email index: %w", err)
+		}
+		return nil
+	})
+}
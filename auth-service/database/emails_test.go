@@ -0,0 +1,46 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestEnsureUniqueEmails(t *testing.T) {
+	tests := []struct {
+		name       string
+		duplicates *sqlmock.Rows
+		wantIndex  bool
+	}{
+		{name: "no duplicates", duplicates: sqlmock.NewRows([]string{"email", "user_ids"}), wantIndex: true},
+		{
+			// The service still starts, without the index
+			name:       "duplicates",
+			duplicates: sqlmock.NewRows([]string{"email", "user_ids"}).AddRow("ann@example.com", "3, 9"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			mock.ExpectExec("SELECT pg_advisory_lock").WithArgs(migrationLockID).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectQuery("FROM users GROUP BY LOWER\\(email\\) HAVING COUNT\\(\\*\\) > 1").WillReturnRows(tt.duplicates)
+			if tt.wantIndex {
+				mock.ExpectExec("CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email_lower").WillReturnResult(sqlmock.NewResult(0, 0))
+			}
+			mock.ExpectExec("SELECT pg_advisory_unlock").WithArgs(migrationLockID).WillReturnResult(sqlmock.NewResult(0, 0))
+
+			if err := EnsureUniqueEmails(db); err != nil {
+				t.Fatal(err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,463 @@
+package handlers
+
+import (
+	"archive/zip"
+	"auth-service/apperror"
+	"auth-service/logger"
+	"auth-service/middleware"
+	"auth-service/money"
+	"auth-service/outbox"
+	"auth-service/rabbitmq"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// DataExport is everything stored about a user, as returned by GET /profile/export
+type DataExport struct {
+	ExportedAt time.Time        `json:"exported_at"`
+	Profile    ExportProfile    `json:"profile"`
+	Identities []ExportIdentity `json:"identities"`
+	Addresses  []Address        `json:"addresses"`
+	Orders     []ExportOrder    `json:"orders"`
+}
+
+type ExportProfile struct {
+	User
+	TwoFactorEnabled bool `json:"two_factor_enabled"`
+}
+
+type ExportIdentity struct {
+	Provider  string    `json:"provider"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type ExportOrder struct {
+	ID              int                    `json:"id"`
+	Status          string                 `json:"status"`
+	TotalAmount     money.Money            `json:"total_amount"`
+	ShippingAddress *ExportShippingAddress `json:"shipping_address,omitempty"`
+	Items           []ExportOrderItem      `json:"items"`
+	CreatedAt       time.Time              `json:"created_at"`
+	UpdatedAt       time.Time              `json:"updated_at"`
+}
+
+// ExportShippingAddress is the address snapshot stored on an order
+type ExportShippingAddress struct {
+	RecipientName string `json:"recipient_name"`
+	Phone         string `json:"phone"`
+	Line1         string `json:"line1"`
+	Line2         string `json:"line2"`
+	City          string `json:"city"`
+	Region        string `json:"region"`
+	PostalCode    string `json:"postal_code"`
+	Country       string `json:"country"`
+}
+
+type ExportOrderItem struct {
+	ProductID   int         `json:"product_id"`
+	ProductName string      `json:"product_name"`
+	SKU         string      `json:"sku,omitempty"`
+	Quantity    int         `json:"quantity"`
+	Price       money.Money `json:"price"`
+}
+
+type DeleteAccountRequest struct {
+	Password string `json:"password" binding:"required"`
+	// Code is a TOTP or recovery code, required when two-factor is enabled
+	Code string `json:"code"`
+}
+
+// UserDeletedMessage is fanned out to order-service and inventory-worker.
+// The former name and email are only used for the confirmation email.
+type UserDeletedMessage struct {
+	UserID    int       `json:"user_id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// ExportData returns the user's profile, linked identities, addresses and orders, as a
+// JSON document or, with ?format=zip, a ZIP archive of one file per section
+func (h *AuthHandler) ExportData(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	ctx := c.Request.Context()
+
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "zip" {
+		c.Error(apperror.New(apperror.CodeBadRequest, "Format must be json or zip"))
+		return
+	}
+
+	export, err := h.collectExport(ctx, userID)
+	if err == sql.ErrNoRows {
+		c.Error(apperror.New(apperror.CodeUserNotFound, "User not found"))
+		return
+	}
+
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to export data"))
+		return
+	}
+
+	logger.FromContext(ctx).Info("personal data exported", "user_id", userID, "format", format)
+
+	filename := fmt.Sprintf("account-%d-%s", userID, export.ExportedAt.Format("20060102"))
+
+	if format == "json" {
+		c.Header("Content-Disposition", `attachment; filename="`+filename+`.json"`)
+		c.JSON(http.StatusOK, export)
+		return
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`.zip"`)
+	c.Status(http.StatusOK)
+
+	archive := zip.NewWriter(c.Writer)
+	sections := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", export.Profile},
+		{"identities.json", export.Identities},
+		{"addresses.json", export.Addresses},
+		{"orders.json", export.Orders},
+	}
+	for _, section := range sections {
+		file, err := archive.Create(section.name)
+		if err == nil {
+			encoder := json.NewEncoder(file)
+			encoder.SetIndent("", "  ")
+			err = encoder.Encode(section.data)
+		}
+		if err != nil {
+			// Headers are already sent, so the archive is left truncated
+			logger.FromContext(ctx).Error("failed to write export archive", "user_id", userID, "error", err)
+			return
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		logger.FromContext(ctx).Error("failed to write export archive", "user_id", userID, "error", err)
+	}
+}
+
+func (h *AuthHandler) collectExport(ctx context.Context, userID int) (*DataExport, error) {
+	export := &DataExport{
+		ExportedAt: time.Now().UTC(),
+		Identities: []ExportIdentity{},
+		Addresses:  []Address{},
+		Orders:     []ExportOrder{},
+	}
+
+	profile := &export.Profile
+	err := h.db.QueryRowContext(ctx,
+		`SELECT id, name, email, phone, verified_at, pending_email, created_at, updated_at, totp_enabled
+		 FROM users WHERE id = $1`,
+		userID,
+	).Scan(&profile.ID, &profile.Name, &profile.Email, &profile.Phone, &profile.VerifiedAt, &profile.PendingEmail,
+		&profile.CreatedAt, &profile.UpdatedAt, &profile.TwoFactorEnabled)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := h.db.QueryContext(ctx,
+		"SELECT provider, COALESCE(email, ''), created_at FROM user_identities WHERE user_id = $1 ORDER BY created_at",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var identity ExportIdentity
+		if err := rows.Scan(&identity.Provider, &identity.Email, &identity.CreatedAt); err != nil {
+			return nil, err
+		}
+		export.Identities = append(export.Identities, identity)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	addressRows, err := h.db.QueryContext(ctx,
+		"SELECT "+addressColumns+" FROM addresses WHERE user_id = $1 ORDER BY created_at",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer addressRows.Close()
+
+	for addressRows.Next() {
+		address, err := scanAddress(addressRows)
+		if err != nil {
+			return nil, err
+		}
+		export.Addresses = append(export.Addresses, address)
+	}
+	if err := addressRows.Err(); err != nil {
+		return nil, err
+	}
+
+	orderRows, err := h.db.QueryContext(ctx,
+		`SELECT id, status, total_amount, currency, shipping_recipient_name, COALESCE(shipping_phone, ''), COALESCE(shipping_line1, ''),
+			COALESCE(shipping_line2, ''), COALESCE(shipping_city, ''), COALESCE(shipping_region, ''),
+			COALESCE(shipping_postal_code, ''), COALESCE(shipping_country, ''), created_at, updated_at
+		 FROM orders WHERE user_id = $1 ORDER BY created_at`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer orderRows.Close()
+
+	orderIndex := make(map[int]int)
+	for orderRows.Next() {
+		order := ExportOrder{Items: []ExportOrderItem{}}
+		var recipientName *string
+		var a ExportShippingAddress
+		if err := orderRows.Scan(&order.ID, &order.Status, &order.TotalAmount.Amount, &order.TotalAmount.Currency, &recipientName, &a.Phone, &a.Line1,
+			&a.Line2, &a.City, &a.Region, &a.PostalCode, &a.Country, &order.CreatedAt, &order.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if recipientName != nil {
+			a.RecipientName = *recipientName
+			order.ShippingAddress = &a
+		}
+		orderIndex[order.ID] = len(export.Orders)
+		export.Orders = append(export.Orders, order)
+	}
+	if err := orderRows.Err(); err != nil {
+		return nil, err
+	}
+
+	itemRows, err := h.db.QueryContext(ctx,
+		`SELECT oi.order_id, oi.product_id, p.name, COALESCE(v.sku, ''), oi.quantity, oi.price, o.currency
+		 FROM order_items oi
+		 JOIN orders o ON o.id = oi.order_id
+		 JOIN products p ON p.id = oi.product_id
+		 LEFT JOIN product_variants v ON v.id = oi.variant_id
+		 WHERE o.user_id = $1
+		 ORDER BY oi.id`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer itemRows.Close()
+
+	for itemRows.Next() {
+		var orderID int
+		var item ExportOrderItem
+		if err := itemRows.Scan(&orderID, &item.ProductID, &item.ProductName, &item.SKU, &item.Quantity, &item.Price.Amount, &item.Price.Currency); err != nil {
+			return nil, err
+		}
+		if i, ok := orderIndex[orderID]; ok {
+			export.Orders[i].Items = append(export.Orders[i].Items, item)
+		}
+	}
+
+	return export, itemRows.Err()
+}
+
+// DeleteAccount anonymises the user. Pending orders are cancelled; the others
+// stay for accounting but no longer point at personal data once they are
+// settled. Credentials, identities and API keys are removed and every session
+// is revoked.
+func (h *AuthHandler) DeleteAccount(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	ctx := c.Request.Context()
+
+	var req DeleteAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperror.Validation(err))
+		return
+	}
+
+	var user User
+	var secret sql.NullString
+	var twoFactorEnabled bool
+	var lockedFor float64
+	err := h.db.QueryRowContext(ctx,
+		`SELECT id, name, email, password, totp_secret, totp_enabled,
+		        COALESCE(EXTRACT(EPOCH FROM (locked_until - CURRENT_TIMESTAMP)), 0)
+		 FROM users WHERE id = $1 AND deleted_at IS NULL`,
+		userID,
+	).Scan(&user.ID, &user.Name, &user.Email, &user.Password, &secret, &twoFactorEnabled, &lockedFor)
+
+	if err == sql.ErrNoRows {
+		c.Error(apperror.New(apperror.CodeUserNotFound, "User not found"))
+		return
+	}
+
+	if err != nil {
+		c.Error(apperror.Internal(err, "Database error"))
+		return
+	}
+
+	// Deletion must not become a way around the 2FA login lockout
+	if lockedFor > 0 {
+		logger.FromContext(ctx).Warn("account deletion rejected", "user_id", userID, "reason", "account locked")
+		middleware.SetRetryAfter(c, time.Duration(lockedFor*float64(time.Second)))
+		c.Error(apperror.New(apperror.CodeAccountLocked, "Too many failed login attempts, account temporarily locked"))
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		c.Error(apperror.New(apperror.CodeInvalidCredentials, "Password is incorrect"))
+		return
+	}
+
+	if twoFactorEnabled {
+		valid, err := h.verifySecondFactor(ctx, userID, secret.String, req.Code)
+		if err != nil {
+			c.Error(apperror.Internal(err, "Database error"))
+			return
+		}
+		if !valid {
+			logger.FromContext(ctx).Warn("account deletion rejected", "user_id", userID, "reason", "invalid two-factor code")
+			if err := h.recordFailedLogin(ctx, userID); err != nil {
+				c.Error(apperror.Internal(err, "Database error"))
+				return
+			}
+			c.Error(apperror.New(apperror.CodeInvalidTwoFactorCode, "Invalid two-factor code"))
+			return
+		}
+	}
+
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to start transaction"))
+		return
+	}
+	defer tx.Rollback()
+
+	// The .invalid domain can never receive mail, and the empty password
+	// hash matches no password
+	_, err = tx.ExecContext(ctx,
+		`UPDATE users SET
+			name = 'Deleted User',
+			email = 'deleted-' || id || '@deleted.invalid',
+			password = '',
+			phone = '',
+			pending_email = NULL,
+			verified_at = NULL,
+			totp_secret = NULL,
+			totp_enabled = FALSE,
+			totp_last_step = NULL,
+			role = 'user',
+			sessions_revoked_at = CURRENT_TIMESTAMP,
+			deleted_at = CURRENT_TIMESTAMP,
+			updated_at = CURRENT_TIMESTAMP
+		 WHERE id = $1`,
+		userID,
+	)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to delete account"))
+		return
+	}
+
+	// Pending orders are cancelled here rather than by order-service, so
+	// none can be confirmed in the meantime; inventory-worker locks the order
+	// and skips it once it is no longer pending
+	rows, err := tx.QueryContext(ctx,
+		`UPDATE orders SET status = 'CANCELLED', updated_at = CURRENT_TIMESTAMP
+		 WHERE user_id = $1 AND status = 'PENDING'
+		 RETURNING id`,
+		userID,
+	)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to cancel pending orders"))
+		return
+	}
+
+	var cancelled []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			c.Error(apperror.Internal(err, "Failed to cancel pending orders"))
+			return
+		}
+		cancelled = append(cancelled, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		c.Error(apperror.Internal(err, "Failed to cancel pending orders"))
+		return
+	}
+
+	// Give back the coupons the cancelled orders used
+	_, err = tx.ExecContext(ctx,
+		`WITH released AS (
+			UPDATE promotion_redemptions SET released_at = CURRENT_TIMESTAMP
+			WHERE order_id = ANY($1) AND released_at IS NULL
+			RETURNING promotion_id
+		 )
+		 UPDATE promotions p SET redemption_count = p.redemption_count - r.released
+		 FROM (SELECT promotion_id, COUNT(*) AS released FROM released GROUP BY promotion_id) r
+		 WHERE p.id = r.promotion_id`,
+		pq.Array(cancelled),
+	)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to release promotion redemptions"))
+		return
+	}
+
+	// Orders still on their way keep the address they ship to
+	_, err = tx.ExecContext(ctx,
+		`UPDATE orders SET shipping_recipient_name = NULL, shipping_phone = NULL, shipping_line1 = NULL,
+			shipping_line2 = NULL, shipping_city = NULL, shipping_region = NULL, shipping_postal_code = NULL,
+			shipping_country = NULL
+		 WHERE user_id = $1 AND status IN ('CANCELLED', 'FAILED', 'DELIVERED')`,
+		userID,
+	)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to delete account"))
+		return
+	}
+
+	for _, table := range []string{"recovery_codes", "password_resets", "user_identities", "api_keys", "addresses"} {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE user_id = $1", userID); err != nil {
+			c.Error(apperror.Internal(err, "Failed to delete account"))
+			return
+		}
+	}
+
+	// The event is written with the deletion and published from the outbox,
+	// so it is not lost if RabbitMQ is unavailable now
+	err = outbox.Enqueue(ctx, tx, rabbitmq.ExchangeUserDeleted, UserDeletedMessage{
+		UserID:    userID,
+		Name:      user.Name,
+		Email:     user.Email,
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to delete account"))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.Error(apperror.Internal(err, "Failed to commit transaction"))
+		return
+	}
+
+	logger.FromContext(ctx).Info("account deleted", "user_id", userID, "orders_cancelled", len(cancelled))
+	h.events.Notify()
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Account deleted",
+	})
+}
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"auth-service/middleware"
+	"auth-service/money"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestDeleteAccountSecondFactor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	hash, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+
+	tests := []struct {
+		name       string
+		lockedFor  float64
+		wantStatus int
+	}{
+		// A wrong code counts towards the lockout like at the login step
+		{name: "wrong code", wantStatus: http.StatusUnauthorized},
+		{name: "locked", lockedFor: 600, wantStatus: http.StatusTooManyRequests},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			mock.ExpectQuery("SELECT id, name, email, password, totp_secret, totp_enabled").
+				WithArgs(7).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email", "password", "totp_secret", "totp_enabled", "locked_for"}).
+					AddRow(7, "Budi", "budi@example.com", string(hash), "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ", true, tt.lockedFor))
+			if tt.lockedFor == 0 {
+				mock.ExpectExec("UPDATE recovery_codes SET used_at").
+					WithArgs(7, sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery("UPDATE users SET failed_login_attempts = failed_login_attempts \\+ 1").
+					WithArgs(7).
+					WillReturnRows(sqlmock.NewRows([]string{"failed_login_attempts"}).AddRow(1))
+			}
+
+			handler := NewAuthHandler(db, nil, newTestKeys(t), nil, nil, nil, nil)
+			router := gin.New()
+			router.Use(middleware.ErrorHandler())
+			router.DELETE("/profile", func(c *gin.Context) { c.Set("user_id", 7) }, handler.DeleteAccount)
+
+			req := httptest.NewRequest(http.MethodDelete, "/profile",
+				strings.NewReader(`{"password": "password", "code": "not-a-code"}`))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestDeleteAccount(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	hash, _ := bcrypt.GenerateFromPassword([]byte("password"), bcrypt.MinCost)
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT id, name, email, password, totp_secret, totp_enabled").
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "email", "password", "totp_secret", "totp_enabled", "locked_for"}).
+			AddRow(7, "Budi", "budi@example.com", string(hash), nil, false, 0))
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users SET").WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 1))
+	// Pending orders are cancelled with the deletion, so none can be
+	// confirmed without an address before order-service hears of it
+	mock.ExpectQuery("UPDATE orders SET status = 'CANCELLED'").
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(11).AddRow(12))
+	mock.ExpectExec("UPDATE promotion_redemptions SET released_at").
+		WithArgs("{11,12}").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE orders SET shipping_recipient_name = NULL.*status IN \\('CANCELLED', 'FAILED', 'DELIVERED'\\)").
+		WithArgs(7).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	for _, table := range []string{"recovery_codes", "password_resets", "user_identities", "api_keys", "addresses"} {
+		mock.ExpectExec("DELETE FROM " + table).WithArgs(7).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+	// The event commits with the deletion
+	mock.ExpectExec("INSERT INTO outbox_events").
+		WithArgs("user_deleted", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	handler := NewAuthHandler(db, nil, newTestKeys(t), nil, nil, nil, nil)
+	router := gin.New()
+	router.Use(middleware.ErrorHandler())
+	router.DELETE("/profile", func(c *gin.Context) { c.Set("user_id", 7) }, handler.DeleteAccount)
+
+	req := httptest.NewRequest(http.MethodDelete, "/profile", strings.NewReader(`{"password": "password"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("status %d: %s", rec.Code, rec.Body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExportOrderJSON(t *testing.T) {
+	tests := []struct {
+		amount money.Money
+		want   string
+	}{
+		{amount: money.New(14900000, "IDR"), want: `{"amount":"149000.00","currency":"IDR"}`},
+		{amount: money.New(-250, "SGD"), want: `{"amount":"-2.50","currency":"SGD"}`},
+		// Amounts are written with the currency's own number of minor digits
+		{amount: money.New(1500, "JPY"), want: `{"amount":"1500","currency":"JPY"}`},
+	}
+
+	for _, tt := range tests {
+		order := ExportOrder{TotalAmount: tt.amount, Items: []ExportOrderItem{{Price: tt.amount}}}
+		got, err := json.Marshal(order)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var encoded struct {
+			TotalAmount json.RawMessage `json:"total_amount"`
+			Items       []struct {
+				Price json.RawMessage `json:"price"`
+			} `json:"items"`
+		}
+		if err := json.Unmarshal(got, &encoded); err != nil {
+			t.Fatal(err)
+		}
+		if string(encoded.TotalAmount) != tt.want || string(encoded.Items[0].Price) != tt.want {
+			t.Errorf("%v encodes as %s and %s, want %s", tt.amount, encoded.TotalAmount, encoded.Items[0].Price, tt.want)
+		}
+	}
+}
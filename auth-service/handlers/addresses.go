@@ -0,0 +1,374 @@
+package handlers
+
+import (
+	"auth-service/apperror"
+	"auth-service/logger"
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxAddresses bounds the size of each user's address book
+const maxAddresses = 20
+
+// AddressHandler manages the shipping address book of the current user
+type AddressHandler struct {
+	db *sql.DB
+}
+
+type Address struct {
+	ID            int       `json:"id"`
+	Label         string    `json:"label"`
+	RecipientName string    `json:"recipient_name"`
+	Phone         string    `json:"phone"`
+	Line1         string    `json:"line1"`
+	Line2         string    `json:"line2"`
+	City          string    `json:"city"`
+	Region        string    `json:"region"`
+	PostalCode    string    `json:"postal_code"`
+	Country       string    `json:"country"`
+	IsDefault     bool      `json:"is_default"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+type AddressRequest struct {
+	Label         string `json:"label" binding:"max=50"`
+	RecipientName string `json:"recipient_name" binding:"required,max=255"`
+	Phone         string `json:"phone" binding:"required"`
+	Line1         string `json:"line1" binding:"required,max=255"`
+	Line2         string `json:"line2" binding:"max=255"`
+	City          string `json:"city" binding:"required,max=100"`
+	Region        string `json:"region" binding:"max=100"`
+	PostalCode    string `json:"postal_code" binding:"required,max=20"`
+	Country       string `json:"country" binding:"required,iso3166_1_alpha2"`
+	// IsDefault makes this the default address; the first address always is
+	IsDefault bool `json:"is_default"`
+}
+
+const addressColumns = `id, COALESCE(label, ''), recipient_name, phone, line1, COALESCE(line2, ''), city,
+	COALESCE(region, ''), postal_code, country, is_default, created_at, updated_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanAddress(row rowScanner) (Address, error) {
+	var a Address
+	err := row.Scan(&a.ID, &a.Label, &a.RecipientName, &a.Phone, &a.Line1, &a.Line2, &a.City,
+		&a.Region, &a.PostalCode, &a.Country, &a.IsDefault, &a.CreatedAt, &a.UpdatedAt)
+	return a, err
+}
+
+func NewAddressHandler(db *sql.DB) *AddressHandler {
+	return &AddressHandler{db: db}
+}
+
+// ListAddresses returns the user's addresses, default first
+func (h *AddressHandler) ListAddresses(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	ctx := c.Request.Context()
+
+	rows, err := h.db.QueryContext(ctx,
+		"SELECT "+addressColumns+" FROM addresses WHERE user_id = $1 ORDER BY is_default DESC, created_at",
+		userID,
+	)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to fetch addresses"))
+		return
+	}
+	defer rows.Close()
+
+	addresses := []Address{}
+	for rows.Next() {
+		address, err := scanAddress(rows)
+		if err != nil {
+			c.Error(apperror.Internal(err, "Failed to scan address"))
+			return
+		}
+		addresses = append(addresses, address)
+	}
+
+	if err := rows.Err(); err != nil {
+		c.Error(apperror.Internal(err, "Failed to fetch addresses"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    addresses,
+	})
+}
+
+// CreateAddress adds an address to the book
+func (h *AddressHandler) CreateAddress(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	ctx := c.Request.Context()
+
+	req, ok := bindAddress(c)
+	if !ok {
+		return
+	}
+
+	tx, err := h.beginForUser(ctx, userID)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to start transaction"))
+		return
+	}
+	defer tx.Rollback()
+
+	var count int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM addresses WHERE user_id = $1", userID).Scan(&count); err != nil {
+		c.Error(apperror.Internal(err, "Database error"))
+		return
+	}
+
+	if count >= maxAddresses {
+		c.Error(apperror.New(apperror.CodeAddressLimit, "Address book is full, remove an address first"))
+		return
+	}
+
+	makeDefault := req.IsDefault || count == 0
+	if makeDefault {
+		if err := clearDefaultAddress(ctx, tx, userID); err != nil {
+			c.Error(apperror.Internal(err, "Failed to update default address"))
+			return
+		}
+	}
+
+	address, err := scanAddress(tx.QueryRowContext(ctx,
+		`INSERT INTO addresses (user_id, label, recipient_name, phone, line1, line2, city, region, postal_code, country, is_default)
+		 VALUES ($1, NULLIF($2, ''), $3, $4, $5, NULLIF($6, ''), $7, NULLIF($8, ''), $9, $10, $11)
+		 RETURNING `+addressColumns,
+		userID, req.Label, req.RecipientName, req.Phone, req.Line1, req.Line2, req.City, req.Region, req.PostalCode, req.Country, makeDefault,
+	))
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to create address"))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.Error(apperror.Internal(err, "Failed to commit transaction"))
+		return
+	}
+
+	logger.FromContext(ctx).Info("address created", "user_id", userID, "address_id", address.ID)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"message": "Address created successfully",
+		"data":    address,
+	})
+}
+
+// UpdateAddress replaces an address
+func (h *AddressHandler) UpdateAddress(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	ctx := c.Request.Context()
+
+	addressID, ok := addressIDParam(c)
+	if !ok {
+		return
+	}
+
+	req, ok := bindAddress(c)
+	if !ok {
+		return
+	}
+
+	tx, err := h.beginForUser(ctx, userID)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to start transaction"))
+		return
+	}
+	defer tx.Rollback()
+
+	if req.IsDefault {
+		if err := clearDefaultAddress(ctx, tx, userID); err != nil {
+			c.Error(apperror.Internal(err, "Failed to update default address"))
+			return
+		}
+	}
+
+	address, err := scanAddress(tx.QueryRowContext(ctx,
+		`UPDATE addresses SET label = NULLIF($3, ''), recipient_name = $4, phone = $5, line1 = $6, line2 = NULLIF($7, ''),
+			city = $8, region = NULLIF($9, ''), postal_code = $10, country = $11, is_default = is_default OR $12
+		 WHERE id = $1 AND user_id = $2
+		 RETURNING `+addressColumns,
+		addressID, userID, req.Label, req.RecipientName, req.Phone, req.Line1, req.Line2, req.City, req.Region, req.PostalCode, req.Country, req.IsDefault,
+	))
+	if err == sql.ErrNoRows {
+		c.Error(apperror.New(apperror.CodeAddressNotFound, "Address not found"))
+		return
+	}
+
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to update address"))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.Error(apperror.Internal(err, "Failed to commit transaction"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Address updated successfully",
+		"data":    address,
+	})
+}
+
+// SetDefaultAddress makes an address the one orders use when none is given
+func (h *AddressHandler) SetDefaultAddress(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	ctx := c.Request.Context()
+
+	addressID, ok := addressIDParam(c)
+	if !ok {
+		return
+	}
+
+	tx, err := h.beginForUser(ctx, userID)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to start transaction"))
+		return
+	}
+	defer tx.Rollback()
+
+	if err := clearDefaultAddress(ctx, tx, userID); err != nil {
+		c.Error(apperror.Internal(err, "Failed to update default address"))
+		return
+	}
+
+	address, err := scanAddress(tx.QueryRowContext(ctx,
+		"UPDATE addresses SET is_default = TRUE WHERE id = $1 AND user_id = $2 RETURNING "+addressColumns,
+		addressID, userID,
+	))
+	if err == sql.ErrNoRows {
+		c.Error(apperror.New(apperror.CodeAddressNotFound, "Address not found"))
+		return
+	}
+
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to update default address"))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.Error(apperror.Internal(err, "Failed to commit transaction"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Default address updated",
+		"data":    address,
+	})
+}
+
+// DeleteAddress removes an address. When it was the default, the most
+// recently added remaining address becomes the default.
+func (h *AddressHandler) DeleteAddress(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	ctx := c.Request.Context()
+
+	addressID, ok := addressIDParam(c)
+	if !ok {
+		return
+	}
+
+	tx, err := h.beginForUser(ctx, userID)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to start transaction"))
+		return
+	}
+	defer tx.Rollback()
+
+	var wasDefault bool
+	err = tx.QueryRowContext(ctx,
+		"DELETE FROM addresses WHERE id = $1 AND user_id = $2 RETURNING is_default",
+		addressID, userID,
+	).Scan(&wasDefault)
+	if err == sql.ErrNoRows {
+		c.Error(apperror.New(apperror.CodeAddressNotFound, "Address not found"))
+		return
+	}
+
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to delete address"))
+		return
+	}
+
+	if wasDefault {
+		_, err = tx.ExecContext(ctx,
+			`UPDATE addresses SET is_default = TRUE
+			 WHERE id = (SELECT id FROM addresses WHERE user_id = $1 ORDER BY created_at DESC LIMIT 1)`,
+			userID,
+		)
+		if err != nil {
+			c.Error(apperror.Internal(err, "Failed to update default address"))
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.Error(apperror.Internal(err, "Failed to commit transaction"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Address deleted",
+	})
+}
+
+// beginForUser starts a transaction holding the user's row lock, so
+// concurrent changes cannot leave two default addresses
+func (h *AddressHandler) beginForUser(ctx context.Context, userID int) (*sql.Tx, error) {
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, "SELECT 1 FROM users WHERE id = $1 FOR UPDATE", userID); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return tx, nil
+}
+
+func clearDefaultAddress(ctx context.Context, tx *sql.Tx, userID int) error {
+	_, err := tx.ExecContext(ctx, "UPDATE addresses SET is_default = FALSE WHERE user_id = $1 AND is_default", userID)
+	return err
+}
+
+func bindAddress(c *gin.Context) (AddressRequest, bool) {
+	var req AddressRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperror.Validation(err))
+		return req, false
+	}
+
+	phoneNumber, err := normalizePhone(req.Phone)
+	if err != nil {
+		c.Error(err)
+		return req, false
+	}
+	req.Phone = phoneNumber
+
+	return req, true
+}
+
+func addressIDParam(c *gin.Context) (int, bool) {
+	addressID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(apperror.Wrap(err, apperror.CodeBadRequest, "Invalid address ID"))
+		return 0, false
+	}
+	return addressID, true
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"auth-service/apperror"
+	"auth-service/logger"
+	"auth-service/middleware"
+	"database/sql"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+const (
+	// apiKeyPrefix marks API keys so leaked ones are easy to recognise
+	apiKeyPrefix = "ak_"
+	// apiKeyDisplayLength is how much of a key is kept to identify it in listings
+	apiKeyDisplayLength   = len(apiKeyPrefix) + 8
+	defaultAPIKeyLifetime = 90
+)
+
+// APIKeyHandler lets administrators issue and revoke API keys
+type APIKeyHandler struct {
+	db *sql.DB
+}
+
+type APIKey struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedBy  *int       `json:"created_by"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	RevokedAt  *time.Time `json:"revoked_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name string `json:"name" binding:"required,max=255"`
+	// UserID is the account the key acts as, defaulting to the caller
+	UserID        int      `json:"user_id"`
+	Scopes        []string `json:"scopes" binding:"required,min=1"`
+	ExpiresInDays int      `json:"expires_in_days" binding:"omitempty,min=1,max=365"`
+}
+
+func NewAPIKeyHandler(db *sql.DB) *APIKeyHandler {
+	return &APIKeyHandler{db: db}
+}
+
+// CreateAPIKey issues a key. The key itself is only returned here.
+func (h *APIKeyHandler) CreateAPIKey(c *gin.Context) {
+	ctx := c.Request.Context()
+	adminID := c.GetInt("user_id")
+
+	var req CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperror.Validation(err))
+		return
+	}
+
+	for _, scope := range req.Scopes {
+		if !slices.Contains(middleware.Scopes, scope) {
+			c.Error(apperror.New(apperror.CodeInvalidScope, "Unknown scope "+strconv.Quote(scope)))
+			return
+		}
+	}
+	slices.Sort(req.Scopes)
+	req.Scopes = slices.Compact(req.Scopes)
+
+	if req.UserID == 0 {
+		req.UserID = adminID
+	}
+	if req.ExpiresInDays == 0 {
+		req.ExpiresInDays = defaultAPIKeyLifetime
+	}
+
+	secret, err := newRandomToken()
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to generate API key"))
+		return
+	}
+	key := apiKeyPrefix + secret
+
+	var apiKey APIKey
+	err = h.db.QueryRowContext(ctx,
+		`INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, created_by, expires_at)
+		 SELECT id, $2, $3, $4, $5, $6, CURRENT_TIMESTAMP + make_interval(days => $7)
+		 FROM users WHERE id = $1
+		 RETURNING id, user_id, name, prefix, scopes, created_by, expires_at, last_used_at, revoked_at, created_at`,
+		req.UserID, req.Name, key[:apiKeyDisplayLength], middleware.HashAPIKey(key), pq.Array(req.Scopes), adminID, req.ExpiresInDays,
+	).Scan(&apiKey.ID, &apiKey.UserID, &apiKey.Name, &apiKey.Prefix, pq.Array(&apiKey.Scopes), &apiKey.CreatedBy,
+		&apiKey.ExpiresAt, &apiKey.LastUsedAt, &apiKey.RevokedAt, &apiKey.CreatedAt)
+
+	if err == sql.ErrNoRows {
+		c.Error(apperror.New(apperror.CodeUserNotFound, "User not found"))
+		return
+	}
+
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to create API key"))
+		return
+	}
+
+	logger.FromContext(ctx).Info("API key created", "api_key_id", apiKey.ID, "user_id", apiKey.UserID, "created_by", adminID, "scopes", apiKey.Scopes)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"message": "API key created, store it now as it will not be shown again",
+		"data": gin.H{
+			"api_key": apiKey,
+			"key":     key,
+		},
+	})
+}
+
+// ListAPIKeys returns all keys, optionally filtered by ?user_id
+func (h *APIKeyHandler) ListAPIKeys(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	query := `SELECT id, user_id, name, prefix, scopes, created_by, expires_at, last_used_at, revoked_at, created_at
+		 FROM api_keys`
+	var args []interface{}
+
+	if userIDStr := c.Query("user_id"); userIDStr != "" {
+		userID, err := strconv.Atoi(userIDStr)
+		if err != nil {
+			c.Error(apperror.Wrap(err, apperror.CodeBadRequest, "Invalid user ID"))
+			return
+		}
+		query += " WHERE user_id = $1"
+		args = append(args, userID)
+	}
+	query += " ORDER BY created_at DESC"
+
+	rows, err := h.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to fetch API keys"))
+		return
+	}
+	defer rows.Close()
+
+	apiKeys := []APIKey{}
+	for rows.Next() {
+		var apiKey APIKey
+		if err := rows.Scan(&apiKey.ID, &apiKey.UserID, &apiKey.Name, &apiKey.Prefix, pq.Array(&apiKey.Scopes), &apiKey.CreatedBy,
+			&apiKey.ExpiresAt, &apiKey.LastUsedAt, &apiKey.RevokedAt, &apiKey.CreatedAt); err != nil {
+			c.Error(apperror.Internal(err, "Failed to scan API key"))
+			return
+		}
+		apiKeys = append(apiKeys, apiKey)
+	}
+
+	if err := rows.Err(); err != nil {
+		c.Error(apperror.Internal(err, "Failed to fetch API keys"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    apiKeys,
+	})
+}
+
+// RevokeAPIKey disables a key immediately
+func (h *APIKeyHandler) RevokeAPIKey(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(apperror.Wrap(err, apperror.CodeBadRequest, "Invalid API key ID"))
+		return
+	}
+
+	result, err := h.db.ExecContext(ctx,
+		"UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1 AND revoked_at IS NULL",
+		id,
+	)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to revoke API key"))
+		return
+	}
+
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.Error(apperror.New(apperror.CodeAPIKeyNotFound, "API key not found or already revoked"))
+		return
+	}
+
+	logger.FromContext(ctx).Info("API key revoked", "api_key_id", id, "revoked_by", c.GetInt("user_id"))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "API key revoked",
+	})
+}
@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"auth-service/middleware"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+// newAPIKeyRouter serves the API key routes as administrator 7
+func newAPIKeyRouter(t *testing.T) (*gin.Engine, sqlmock.Sqlmock) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	handler := NewAPIKeyHandler(db)
+	router := gin.New()
+	router.Use(middleware.ErrorHandler(), func(c *gin.Context) { c.Set("user_id", 7) })
+	router.POST("/admin/api-keys", handler.CreateAPIKey)
+	router.DELETE("/admin/api-keys/:id", handler.RevokeAPIKey)
+	return router, mock
+}
+
+func TestCreateAPIKey(t *testing.T) {
+	router, mock := newAPIKeyRouter(t)
+
+	// Only the key's hash is stored, with enough of the key to recognise it
+	var prefix, hash string
+	now := time.Now()
+	mock.ExpectQuery("INSERT INTO api_keys").
+		WithArgs(9, "Warehouse", capture(&prefix), capture(&hash), `{"orders:fulfill","orders:read"}`, 7, defaultAPIKeyLifetime).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "name", "prefix", "scopes", "created_by", "expires_at", "last_used_at", "revoked_at", "created_at"}).
+			AddRow(3, 9, "Warehouse", "ak_12345678", "{orders:fulfill,orders:read}", 7, now.AddDate(0, 0, defaultAPIKeyLifetime), nil, nil, now))
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/api-keys",
+		strings.NewReader(`{"name": "Warehouse", "user_id": 9, "scopes": ["orders:read", "orders:fulfill", "orders:read"]}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var body struct {
+		Data struct {
+			Key string `json:"key"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+
+	key := body.Data.Key
+	if !strings.HasPrefix(key, apiKeyPrefix) || len(key) <= apiKeyDisplayLength {
+		t.Errorf("key %q", key)
+	}
+	if prefix != key[:apiKeyDisplayLength] {
+		t.Errorf("stored prefix %q of key %q", prefix, key)
+	}
+	if hash != middleware.HashAPIKey(key) {
+		t.Error("stored hash is not the key's")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCreateAPIKeyRejected(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+	}{
+		{name: "unknown scope", body: `{"name": "Warehouse", "scopes": ["orders:delete"]}`, wantStatus: http.StatusBadRequest},
+		{name: "no scopes", body: `{"name": "Warehouse", "scopes": []}`, wantStatus: http.StatusBadRequest},
+		{name: "too long", body: `{"name": "Warehouse", "scopes": ["orders:read"], "expires_in_days": 366}`, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, mock := newAPIKeyRouter(t)
+
+			req := httptest.NewRequest(http.MethodPost, "/admin/api-keys", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestRevokeAPIKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		affected   int64
+		wantStatus int
+	}{
+		{name: "revoked", affected: 1, wantStatus: http.StatusOK},
+		// Revoking twice would move revoked_at, so it is refused
+		{name: "already revoked", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, mock := newAPIKeyRouter(t)
+			mock.ExpectExec("UPDATE api_keys SET revoked_at = CURRENT_TIMESTAMP WHERE id = \\$1 AND revoked_at IS NULL").
+				WithArgs(3).
+				WillReturnResult(sqlmock.NewResult(0, tt.affected))
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/admin/api-keys/3", nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
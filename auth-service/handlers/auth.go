@@ -1,9 +1,18 @@
 package handlers
 
 import (
+	"auth-service/apperror"
+	"auth-service/keys"
+	"auth-service/logger"
+	"auth-service/middleware"
+	"auth-service/outbox"
+	"auth-service/rabbitmq"
+	"auth-service/verification"
+	"context"
 	"database/sql"
 	"net/http"
-	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -12,17 +21,37 @@ import (
 )
 
 type AuthHandler struct {
-	db *sql.DB
+	db           *sql.DB
+	rmq          *rabbitmq.RabbitMQ
+	keys         *keys.Manager
+	verifier     *verification.Signer
+	challenger   *verification.Signer
+	emailChanger *verification.Signer
+	// events publishes the events written to the outbox
+	events *outbox.Relay
 }
 
+// AccessTokenTTL is the lifetime of access tokens issued on login
+const AccessTokenTTL = 24 * time.Hour
+
+// Progressive lockout after repeated failed logins
+const (
+	maxFailedLogins = 5
+	baseLockout     = time.Minute
+	maxLockout      = time.Hour
+)
+
 type User struct {
-	ID        int       `json:"id"`
-	Name      string    `json:"name"`
-	Email     string    `json:"email"`
-	Password  string    `json:"-"`
-	Phone     string    `json:"phone"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Email      string     `json:"email"`
+	Password   string     `json:"-"`
+	Phone      string     `json:"phone"`
+	VerifiedAt *time.Time `json:"verified_at"` // nil until the email is verified
+	// PendingEmail awaits confirmation from its owner before replacing Email
+	PendingEmail *string   `json:"pending_email,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
 }
 
 type RegisterRequest struct {
@@ -42,73 +71,81 @@ type UpdateProfileRequest struct {
 	Phone string `json:"phone"`
 }
 
-type Claims struct {
-	UserID int    `json:"user_id"`
-	Email  string `json:"email"`
-	jwt.RegisteredClaims
+func NewAuthHandler(db *sql.DB, rmq *rabbitmq.RabbitMQ, keys *keys.Manager, verifier, challenger, emailChanger *verification.Signer, events *outbox.Relay) *AuthHandler {
+	return &AuthHandler{
+		db:           db,
+		rmq:          rmq,
+		keys:         keys,
+		verifier:     verifier,
+		challenger:   challenger,
+		emailChanger: emailChanger,
+		events:       events,
+	}
 }
 
-func NewAuthHandler(db *sql.DB) *AuthHandler {
-	return &AuthHandler{db: db}
+// normalizeEmail lowercases an email address, so each address belongs to one
+// account however it is typed
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
 }
 
 // Register handles user registration
 func (h *AuthHandler) Register(c *gin.Context) {
+	ctx := c.Request.Context()
 	var req RegisterRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   err.Error(),
-		})
+		c.Error(apperror.Validation(err))
 		return
 	}
 
+	phoneNumber, err := normalizePhone(req.Phone)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	req.Email = normalizeEmail(req.Email)
+
 	// Check if email already exists
 	var exists bool
-	err := h.db.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE email = $1)", req.Email).Scan(&exists)
+	err = h.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE LOWER(email) = $1)", req.Email).Scan(&exists)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   "Database error",
-		})
+		c.Error(apperror.Internal(err, "Database error"))
 		return
 	}
 
 	if exists {
-		c.JSON(http.StatusConflict, gin.H{
-			"success": false,
-			"error":   "Email already registered",
-		})
+		c.Error(apperror.New(apperror.CodeEmailTaken, "Email already registered"))
 		return
 	}
 
 	// Hash password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   "Failed to hash password",
-		})
+		c.Error(apperror.Internal(err, "Failed to hash password"))
 		return
 	}
 
 	// Insert user
 	var user User
-	err = h.db.QueryRow(
+	err = h.db.QueryRowContext(ctx,
 		`INSERT INTO users (name, email, password, phone) 
 		 VALUES ($1, $2, $3, $4) 
-		 RETURNING id, name, email, phone, created_at, updated_at`,
-		req.Name, req.Email, string(hashedPassword), req.Phone,
-	).Scan(&user.ID, &user.Name, &user.Email, &user.Phone, &user.CreatedAt, &user.UpdatedAt)
+		 RETURNING id, name, email, phone, verified_at, created_at, updated_at`,
+		req.Name, req.Email, string(hashedPassword), phoneNumber,
+	).Scan(&user.ID, &user.Name, &user.Email, &user.Phone, &user.VerifiedAt, &user.CreatedAt, &user.UpdatedAt)
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   "Failed to create user",
-		})
+		c.Error(apperror.Internal(err, "Failed to create user"))
 		return
 	}
 
+	logger.FromContext(ctx).Info("user registered", "user_id", user.ID)
+
+	// The account exists even if the email cannot be queued; the user can request a resend
+	if err := h.sendVerification(ctx, user); err != nil {
+		logger.FromContext(ctx).Error("failed to queue verification email", "user_id", user.ID, "error", err)
+	}
+
 	c.JSON(http.StatusCreated, gin.H{
 		"success": true,
 		"message": "User registered successfully",
@@ -118,93 +155,90 @@ func (h *AuthHandler) Register(c *gin.Context) {
 
 // Login handles user authentication
 func (h *AuthHandler) Login(c *gin.Context) {
+	ctx := c.Request.Context()
 	var req LoginRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   err.Error(),
-		})
+		c.Error(apperror.Validation(err))
 		return
 	}
 
-	// Get user from database
+	// Get user from database, with the remaining lockout measured by the database clock
 	var user User
-	err := h.db.QueryRow(
-		`SELECT id, name, email, password, phone, created_at, updated_at 
-		 FROM users WHERE email = $1`,
-		req.Email,
-	).Scan(&user.ID, &user.Name, &user.Email, &user.Password, &user.Phone, &user.CreatedAt, &user.UpdatedAt)
+	var failedAttempts int
+	var lockedFor float64
+	var twoFactorEnabled bool
+	err := h.db.QueryRowContext(ctx,
+		`SELECT id, name, email, password, phone, verified_at, created_at, updated_at, failed_login_attempts,
+		        COALESCE(EXTRACT(EPOCH FROM (locked_until - CURRENT_TIMESTAMP)), 0), totp_enabled
+		 FROM users WHERE LOWER(email) = $1`,
+		normalizeEmail(req.Email),
+	).Scan(&user.ID, &user.Name, &user.Email, &user.Password, &user.Phone, &user.VerifiedAt, &user.CreatedAt, &user.UpdatedAt, &failedAttempts, &lockedFor, &twoFactorEnabled)
 
 	if err == sql.ErrNoRows {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"success": false,
-			"error":   "Invalid email or password",
-		})
+		c.Error(apperror.New(apperror.CodeInvalidCredentials, "Invalid email or password"))
 		return
 	}
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   "Database error",
-		})
+		c.Error(apperror.Internal(err, "Database error"))
+		return
+	}
+
+	// Reject locked accounts before checking the password so guesses are not evaluated
+	if lockedFor > 0 {
+		logger.FromContext(ctx).Warn("login rejected", "user_id", user.ID, "reason", "account locked")
+		middleware.SetRetryAfter(c, time.Duration(lockedFor*float64(time.Second)))
+		c.Error(apperror.New(apperror.CodeAccountLocked, "Too many failed login attempts, account temporarily locked"))
 		return
 	}
 
 	// Verify password
 	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password))
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"success": false,
-			"error":   "Invalid email or password",
-		})
+		logger.FromContext(ctx).Warn("login failed", "user_id", user.ID, "reason", "invalid password")
+		if err := h.recordFailedLogin(ctx, user.ID); err != nil {
+			c.Error(apperror.Internal(err, "Database error"))
+			return
+		}
+		c.Error(apperror.New(apperror.CodeInvalidCredentials, "Invalid email or password"))
 		return
 	}
 
-	// Generate JWT token
-	token, err := generateToken(user.ID, user.Email)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   "Failed to generate token",
-		})
+	// With 2FA the password only earns a short-lived challenge for POST /login/2fa.
+	// Failures are cleared only once the second factor succeeds, so re-entering
+	// the password cannot reset the lockout on code guessing.
+	if twoFactorEnabled {
+		h.respondTwoFactorChallenge(c, user)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": "Login successful",
-		"data": gin.H{
-			"token": token,
-			"user":  user,
-		},
-	})
+	// Clear the failure counter after a successful login
+	if failedAttempts > 0 {
+		if _, err := h.db.ExecContext(ctx,
+			"UPDATE users SET failed_login_attempts = 0, locked_until = NULL WHERE id = $1",
+			user.ID,
+		); err != nil {
+			c.Error(apperror.Internal(err, "Database error"))
+			return
+		}
+	}
+
+	h.respondWithToken(c, user, "password")
 }
 
 // GetProfile returns user profile
 func (h *AuthHandler) GetProfile(c *gin.Context) {
 	userID := c.GetInt("user_id")
+	ctx := c.Request.Context()
 
-	var user User
-	err := h.db.QueryRow(
-		`SELECT id, name, email, phone, created_at, updated_at 
-		 FROM users WHERE id = $1`,
-		userID,
-	).Scan(&user.ID, &user.Name, &user.Email, &user.Phone, &user.CreatedAt, &user.UpdatedAt)
-
+	user, err := h.loadProfile(ctx, userID)
 	if err == sql.ErrNoRows {
-		c.JSON(http.StatusNotFound, gin.H{
-			"success": false,
-			"error":   "User not found",
-		})
+		c.Error(apperror.New(apperror.CodeUserNotFound, "User not found"))
 		return
 	}
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   "Database error",
-		})
+		c.Error(apperror.Internal(err, "Database error"))
 		return
 	}
 
@@ -217,68 +251,52 @@ func (h *AuthHandler) GetProfile(c *gin.Context) {
 // UpdateProfile updates user profile
 func (h *AuthHandler) UpdateProfile(c *gin.Context) {
 	userID := c.GetInt("user_id")
+	ctx := c.Request.Context()
 
 	var req UpdateProfileRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   err.Error(),
-		})
+		c.Error(apperror.Validation(err))
 		return
 	}
 
 	// Build dynamic update query
-	query := "UPDATE users SET "
+	var assignments []string
 	args := []interface{}{}
-	argCount := 1
 
 	if req.Name != "" {
-		query += "name = $" + string(rune(argCount+'0')) + ", "
 		args = append(args, req.Name)
-		argCount++
+		assignments = append(assignments, "name = $"+strconv.Itoa(len(args)))
 	}
 
 	if req.Phone != "" {
-		query += "phone = $" + string(rune(argCount+'0')) + ", "
-		args = append(args, req.Phone)
-		argCount++
+		phoneNumber, err := normalizePhone(req.Phone)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+		args = append(args, phoneNumber)
+		assignments = append(assignments, "phone = $"+strconv.Itoa(len(args)))
 	}
 
 	if len(args) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   "No fields to update",
-		})
+		c.Error(apperror.New(apperror.CodeBadRequest, "No fields to update"))
 		return
 	}
 
-	// Remove trailing comma and add WHERE clause
-	query = query[:len(query)-2] + " WHERE id = $" + string(rune(argCount+'0'))
 	args = append(args, userID)
+	query := "UPDATE users SET " + strings.Join(assignments, ", ") + " WHERE id = $" + strconv.Itoa(len(args))
 
 	// Execute update
-	_, err := h.db.Exec(query, args...)
+	_, err := h.db.ExecContext(ctx, query, args...)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   "Failed to update profile",
-		})
+		c.Error(apperror.Internal(err, "Failed to update profile"))
 		return
 	}
 
 	// Get updated user
-	var user User
-	err = h.db.QueryRow(
-		`SELECT id, name, email, phone, created_at, updated_at 
-		 FROM users WHERE id = $1`,
-		userID,
-	).Scan(&user.ID, &user.Name, &user.Email, &user.Phone, &user.CreatedAt, &user.UpdatedAt)
-
+	user, err := h.loadProfile(ctx, userID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   "Failed to fetch updated profile",
-		})
+		c.Error(apperror.Internal(err, "Failed to fetch updated profile"))
 		return
 	}
 
@@ -289,17 +307,94 @@ func (h *AuthHandler) UpdateProfile(c *gin.Context) {
 	})
 }
 
-// generateToken creates a new JWT token
-func generateToken(userID int, email string) (string, error) {
-	claims := Claims{
+// recordFailedLogin increments the failure counter and, once it reaches
+// maxFailedLogins, locks the account for a period that doubles with every
+// further failure up to maxLockout
+func (h *AuthHandler) recordFailedLogin(ctx context.Context, userID int) error {
+	var attempts int
+	err := h.db.QueryRowContext(ctx,
+		`UPDATE users SET failed_login_attempts = failed_login_attempts + 1
+		 WHERE id = $1 RETURNING failed_login_attempts`,
+		userID,
+	).Scan(&attempts)
+	if err != nil {
+		return err
+	}
+
+	if attempts < maxFailedLogins {
+		return nil
+	}
+
+	lockout := maxLockout
+	if shift := attempts - maxFailedLogins; shift < 16 && baseLockout<<shift < maxLockout {
+		lockout = baseLockout << shift
+	}
+
+	_, err = h.db.ExecContext(ctx,
+		"UPDATE users SET locked_until = CURRENT_TIMESTAMP + make_interval(secs => $1) WHERE id = $2",
+		lockout.Seconds(), userID,
+	)
+	if err != nil {
+		return err
+	}
+
+	logger.FromContext(ctx).Warn("account locked", "user_id", userID, "failed_attempts", attempts, "lockout", lockout.String())
+	return nil
+}
+
+// respondTwoFactorChallenge answers a first login step for a 2FA account with
+// a short-lived challenge to exchange at POST /login/2fa
+func (h *AuthHandler) respondTwoFactorChallenge(c *gin.Context, user User) {
+	challenge, err := h.challenger.Sign(user.ID, user.Email)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to generate login challenge"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Two-factor authentication required",
+		"data": gin.H{
+			"two_factor_required": true,
+			"challenge_token":     challenge,
+			"expires_in":          int(h.challenger.TTL().Seconds()),
+		},
+	})
+}
+
+// respondWithToken completes a login by issuing an access token
+func (h *AuthHandler) respondWithToken(c *gin.Context, user User, method string) {
+	token, err := h.generateToken(user.ID, user.Email)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to generate token"))
+		return
+	}
+
+	logger.FromContext(c.Request.Context()).Info("user logged in", "user_id", user.ID, "method", method)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Login successful",
+		"data": gin.H{
+			"token": token,
+			"user":  user,
+		},
+	})
+}
+
+// generateToken creates a new access token signed with the current key
+func (h *AuthHandler) generateToken(userID int, email string) (string, error) {
+	now := time.Now()
+	claims := middleware.Claims{
 		UserID: userID,
 		Email:  email,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			Issuer:    middleware.TokenIssuer,
+			Subject:   strconv.Itoa(userID),
+			ExpiresAt: jwt.NewNumericDate(now.Add(AccessTokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	return h.keys.Sign(claims)
 }
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"auth-service/keys"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// argFunc matches a query argument with a function, which may also record it
+type argFunc func(driver.Value) bool
+
+func (f argFunc) Match(v driver.Value) bool {
+	return f(v)
+}
+
+// capture returns an argument matcher storing the argument in dest
+func capture(dest *string) argFunc {
+	return func(v driver.Value) bool {
+		s, ok := v.(string)
+		*dest = s
+		return ok
+	}
+}
+
+// newTestKeys returns a key manager holding one generated key, loaded from a
+// mock database that hands back the key it stored
+func newTestKeys(t *testing.T) *keys.Manager {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	manager, err := keys.NewManager(db, keys.Config{
+		Algorithm:        keys.AlgorithmEdDSA,
+		RotationInterval: time.Hour,
+		TokenTTL:         time.Hour,
+		Secret:           "test-secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored := sqlmock.NewRows([]string{"kid", "algorithm", "private_key", "not_before"})
+	var kid string
+	notBefore := time.Now().Add(-time.Minute)
+
+	// A key signing now; a next key is already scheduled
+	mock.ExpectBegin()
+	mock.ExpectExec("pg_advisory_xact_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS signing_keys").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT kid, private_key FROM signing_keys").WillReturnRows(sqlmock.NewRows([]string{"kid", "private_key"}))
+	mock.ExpectQuery("FROM signing_keys").WillReturnRows(sqlmock.NewRows([]string{"signing", "scheduled"}).AddRow(false, true))
+	mock.ExpectQuery("INSERT INTO signing_keys").
+		WithArgs(capture(&kid), keys.AlgorithmEdDSA, argFunc(func(v driver.Value) bool {
+			stored.AddRow(kid, keys.AlgorithmEdDSA, v, notBefore)
+			return true
+		}), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"not_before"}).AddRow(notBefore))
+	mock.ExpectExec("DELETE FROM signing_keys").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	mock.ExpectQuery("SELECT kid, algorithm, private_key, not_before FROM signing_keys").WillReturnRows(stored)
+
+	if err := manager.Load(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	return manager
+}
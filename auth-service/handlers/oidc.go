@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"auth-service/apperror"
+	"auth-service/logger"
+	"auth-service/oidc"
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// oidcStateTTL bounds how long a user may take at the provider
+	oidcStateTTL = 10 * time.Minute
+	// oidcStateCookie binds the state to the browser that started the login,
+	// preventing login CSRF with someone else's callback URL
+	oidcStateCookie = "oidc_state"
+)
+
+// OIDCHandler signs users in through external OpenID Connect providers
+type OIDCHandler struct {
+	auth      *AuthHandler
+	providers *oidc.Registry
+}
+
+func NewOIDCHandler(auth *AuthHandler, providers *oidc.Registry) *OIDCHandler {
+	return &OIDCHandler{
+		auth:      auth,
+		providers: providers,
+	}
+}
+
+// ListProviders returns the names of the configured providers
+func (h *OIDCHandler) ListProviders(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    h.providers.Names(),
+	})
+}
+
+// Login starts the authorization code flow, redirecting to the provider
+func (h *OIDCHandler) Login(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	provider, err := h.providers.Get(c.Param("provider"))
+	if err != nil {
+		c.Error(apperror.New(apperror.CodeOIDCProviderNotFound, "Unknown login provider"))
+		return
+	}
+
+	state, err := newRandomToken()
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to generate state"))
+		return
+	}
+	nonce, err := newRandomToken()
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to generate nonce"))
+		return
+	}
+	codeVerifier := oidc.GenerateVerifier()
+
+	authURL, err := provider.AuthCodeURL(ctx, state, nonce, codeVerifier)
+	if err != nil {
+		c.Error(apperror.Wrap(err, apperror.CodeServiceUnavailable, "Login provider is unavailable"))
+		return
+	}
+
+	// Drop abandoned logins while here
+	if _, err := h.auth.db.ExecContext(ctx, "DELETE FROM oidc_login_states WHERE expires_at <= CURRENT_TIMESTAMP"); err != nil {
+		logger.FromContext(ctx).Warn("failed to remove expired OIDC states", "error", err)
+	}
+
+	_, err = h.auth.db.ExecContext(ctx,
+		`INSERT INTO oidc_login_states (state_hash, provider, nonce, code_verifier, expires_at)
+		 VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP + make_interval(secs => $5))`,
+		hashToken(state), provider.Name(), nonce, codeVerifier, oidcStateTTL.Seconds(),
+	)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to store login state"))
+		return
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, state, int(oidcStateTTL.Seconds()), "/oidc", "", c.Request.TLS != nil, true)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// Callback completes the flow: it consumes the state, redeems the code with
+// the PKCE verifier, checks the ID token nonce and signs the user in
+func (h *OIDCHandler) Callback(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	provider, err := h.providers.Get(c.Param("provider"))
+	if err != nil {
+		c.Error(apperror.New(apperror.CodeOIDCProviderNotFound, "Unknown login provider"))
+		return
+	}
+
+	state := c.Query("state")
+	cookie, _ := c.Cookie(oidcStateCookie)
+	c.SetCookie(oidcStateCookie, "", -1, "/oidc", "", c.Request.TLS != nil, true)
+
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(cookie)) != 1 {
+		c.Error(apperror.New(apperror.CodeInvalidOIDCState, "Login state is invalid or expired, please try again"))
+		return
+	}
+
+	// Deleting the row makes each state single use
+	var nonce, codeVerifier string
+	err = h.auth.db.QueryRowContext(ctx,
+		`DELETE FROM oidc_login_states
+		 WHERE state_hash = $1 AND provider = $2 AND expires_at > CURRENT_TIMESTAMP
+		 RETURNING nonce, code_verifier`,
+		hashToken(state), provider.Name(),
+	).Scan(&nonce, &codeVerifier)
+
+	if err == sql.ErrNoRows {
+		c.Error(apperror.New(apperror.CodeInvalidOIDCState, "Login state is invalid or expired, please try again"))
+		return
+	}
+
+	if err != nil {
+		c.Error(apperror.Internal(err, "Database error"))
+		return
+	}
+
+	if providerErr := c.Query("error"); providerErr != "" {
+		logger.FromContext(ctx).Warn("OIDC login rejected by provider", "provider", provider.Name(), "error", providerErr)
+		c.Error(apperror.New(apperror.CodeOIDCLoginFailed, "Login was not completed at the provider"))
+		return
+	}
+
+	identity, err := provider.Exchange(ctx, c.Query("code"), codeVerifier, nonce)
+	if err != nil {
+		c.Error(apperror.Wrap(err, apperror.CodeOIDCLoginFailed, "Could not complete login with the provider"))
+		return
+	}
+
+	user, twoFactorEnabled, err := h.linkIdentity(ctx, provider.Name(), identity)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
+	if twoFactorEnabled {
+		h.auth.respondTwoFactorChallenge(c, user)
+		return
+	}
+
+	h.auth.respondWithToken(c, user, "oidc:"+provider.Name())
+}
+
+// linkIdentity finds the user for an external identity. Unknown identities are
+// linked to the user with the same verified email, or to a new user.
+func (h *OIDCHandler) linkIdentity(ctx context.Context, provider string, identity *oidc.Identity) (User, bool, error) {
+	user, twoFactorEnabled, err := h.findLinkedUser(ctx, provider, identity.Subject)
+	if err == nil {
+		return user, twoFactorEnabled, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return User{}, false, apperror.Internal(err, "Database error")
+	}
+
+	// Linking by email is only safe when the provider vouches for the address
+	if identity.Email == "" || !identity.EmailVerified {
+		return User{}, false, apperror.New(apperror.CodeOIDCEmailNotVerified, "The provider did not return a verified email address")
+	}
+
+	identity.Email = normalizeEmail(identity.Email)
+
+	tx, err := h.auth.db.BeginTx(ctx, nil)
+	if err != nil {
+		return User{}, false, apperror.Internal(err, "Failed to start transaction")
+	}
+	defer tx.Rollback()
+
+	var userID int
+	var verified bool
+	err = tx.QueryRowContext(ctx,
+		"SELECT id, verified_at IS NOT NULL FROM users WHERE LOWER(email) = $1 FOR UPDATE",
+		identity.Email,
+	).Scan(&userID, &verified)
+
+	if err == sql.ErrNoRows {
+		user, err = createExternalUser(ctx, tx, identity)
+	} else if err == nil {
+		// Anyone could have registered an unverified account with this
+		// address, so nothing they set up may survive the real owner
+		// claiming it
+		if !verified {
+			if err := resetUnverifiedAccount(ctx, tx, userID); err != nil {
+				return User{}, false, apperror.Internal(err, "Failed to link account")
+			}
+			logger.FromContext(ctx).Warn("unverified account reset on external login", "user_id", userID, "provider", provider)
+		}
+
+		err = tx.QueryRowContext(ctx,
+			`UPDATE users SET verified_at = COALESCE(verified_at, CURRENT_TIMESTAMP)
+			 WHERE id = $1
+			 RETURNING id, name, email, phone, verified_at, created_at, updated_at, totp_enabled`,
+			userID,
+		).Scan(&user.ID, &user.Name, &user.Email, &user.Phone, &user.VerifiedAt, &user.CreatedAt, &user.UpdatedAt, &twoFactorEnabled)
+	}
+	if err != nil {
+		return User{}, false, apperror.Internal(err, "Failed to link account")
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO user_identities (user_id, provider, subject, email)
+		 VALUES ($1, $2, $3, $4)
+		 ON CONFLICT (provider, subject) DO NOTHING`,
+		user.ID, provider, identity.Subject, identity.Email,
+	)
+	if err != nil {
+		return User{}, false, apperror.Internal(err, "Failed to link account")
+	}
+
+	if err := tx.Commit(); err != nil {
+		return User{}, false, apperror.Internal(err, "Failed to commit transaction")
+	}
+
+	logger.FromContext(ctx).Info("external identity linked", "user_id", user.ID, "provider", provider)
+
+	// Re-read through the link in case a concurrent callback linked it first
+	user, twoFactorEnabled, err = h.findLinkedUser(ctx, provider, identity.Subject)
+	if err != nil {
+		return User{}, false, apperror.Internal(err, "Database error")
+	}
+	return user, twoFactorEnabled, nil
+}
+
+func (h *OIDCHandler) findLinkedUser(ctx context.Context, provider, subject string) (User, bool, error) {
+	var user User
+	var twoFactorEnabled bool
+	err := h.auth.db.QueryRowContext(ctx,
+		`SELECT u.id, u.name, u.email, u.phone, u.verified_at, u.created_at, u.updated_at, u.totp_enabled
+		 FROM user_identities i JOIN users u ON u.id = i.user_id
+		 WHERE i.provider = $1 AND i.subject = $2`,
+		provider, subject,
+	).Scan(&user.ID, &user.Name, &user.Email, &user.Phone, &user.VerifiedAt, &user.CreatedAt, &user.UpdatedAt, &twoFactorEnabled)
+	return user, twoFactorEnabled, err
+}
+
+// resetUnverifiedAccount removes the credentials of an account whose email
+// was never proven: the password, two-factor setup, API keys, pending resets
+// and sessions. The owner can set a password via the reset flow.
+func resetUnverifiedAccount(ctx context.Context, tx *sql.Tx, userID int) error {
+	// The empty password hash matches no password
+	_, err := tx.ExecContext(ctx,
+		`UPDATE users SET
+			password = '',
+			pending_email = NULL,
+			totp_secret = NULL,
+			totp_enabled = FALSE,
+			totp_last_step = NULL,
+			sessions_revoked_at = CURRENT_TIMESTAMP,
+			updated_at = CURRENT_TIMESTAMP
+		 WHERE id = $1`,
+		userID,
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, table := range []string{"recovery_codes", "password_resets", "api_keys"} {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE user_id = $1", userID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createExternalUser registers a user from an external identity. The random
+// password is never revealed; the user can set one via the reset flow.
+func createExternalUser(ctx context.Context, tx *sql.Tx, identity *oidc.Identity) (User, error) {
+	name := identity.Name
+	if name == "" {
+		name = strings.SplitN(identity.Email, "@", 2)[0]
+	}
+
+	randomPassword, err := newRandomToken()
+	if err != nil {
+		return User{}, err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(randomPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return User{}, err
+	}
+
+	var user User
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO users (name, email, password, phone, verified_at)
+		 VALUES ($1, $2, $3, '', CURRENT_TIMESTAMP)
+		 RETURNING id, name, email, phone, verified_at, created_at, updated_at`,
+		name, identity.Email, string(hashedPassword),
+	).Scan(&user.ID, &user.Name, &user.Email, &user.Phone, &user.VerifiedAt, &user.CreatedAt, &user.UpdatedAt)
+	return user, err
+}
@@ -0,0 +1,350 @@
+package handlers
+
+import (
+	"auth-service/middleware"
+	"auth-service/oidc"
+	"auth-service/oidc/mockoidc"
+	"auth-service/verification"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+type oidcTest struct {
+	router   *gin.Engine
+	provider *mockoidc.Provider
+	mock     sqlmock.Sqlmock
+
+	// nonce and verifier are stored by the last login
+	nonce, verifier string
+}
+
+func newOIDCTest(t *testing.T) *oidcTest {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	test := &oidcTest{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		test.provider.Handler().ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	provider, err := mockoidc.New(server.URL, "client", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	test.provider = provider
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	test.mock = mock
+
+	registry := oidc.NewRegistry([]oidc.Config{{
+		Name:         "mock",
+		Issuer:       server.URL,
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  "http://auth.test/oidc/mock/callback",
+		Scopes:       []string{"openid", "email", "profile"},
+	}})
+	auth := NewAuthHandler(db, nil, newTestKeys(t), nil,
+		verification.NewSigner("test-secret", verification.AudienceLoginChallenge, time.Minute), nil, nil)
+	handler := NewOIDCHandler(auth, registry)
+
+	test.router = gin.New()
+	test.router.Use(middleware.ErrorHandler())
+	test.router.GET("/oidc/:provider/login", handler.Login)
+	test.router.GET("/oidc/:provider/callback", handler.Callback)
+	return test
+}
+
+// login starts a login and follows the provider's redirect, returning the
+// callback query and the state cookie
+func (o *oidcTest) login(t *testing.T) (url.Values, *http.Cookie) {
+	t.Helper()
+
+	o.mock.ExpectExec("DELETE FROM oidc_login_states WHERE expires_at").WillReturnResult(sqlmock.NewResult(0, 0))
+	o.mock.ExpectExec("INSERT INTO oidc_login_states").
+		WithArgs(sqlmock.AnyArg(), "mock", capture(&o.nonce), capture(&o.verifier), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	rec := httptest.NewRecorder()
+	o.router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/oidc/mock/login", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login status %d: %s", rec.Code, rec.Body)
+	}
+
+	var cookie *http.Cookie
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == oidcStateCookie {
+			cookie = c
+		}
+	}
+	if cookie == nil || !cookie.HttpOnly {
+		t.Fatalf("login set no HttpOnly state cookie: %v", rec.Result().Cookies())
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("provider status %d", resp.StatusCode)
+	}
+
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return callback.Query(), cookie
+}
+
+// expectState makes the stored login state return nonce and verifier
+func (o *oidcTest) expectState(state, nonce, verifier string) {
+	o.mock.ExpectQuery("DELETE FROM oidc_login_states WHERE state_hash").
+		WithArgs(hashToken(state), "mock").
+		WillReturnRows(sqlmock.NewRows([]string{"nonce", "code_verifier"}).AddRow(nonce, verifier))
+}
+
+func (o *oidcTest) callback(query url.Values, cookie *http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/oidc/mock/callback?"+query.Encode(), nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	o.router.ServeHTTP(rec, req)
+	return rec
+}
+
+var userColumns = []string{"id", "name", "email", "phone", "verified_at", "created_at", "updated_at", "totp_enabled"}
+
+func assertError(t *testing.T, rec *httptest.ResponseRecorder, wantStatus int, wantCode string) {
+	t.Helper()
+	var body struct {
+		Code string `json:"code"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	if rec.Code != wantStatus || body.Code != wantCode {
+		t.Errorf("status %d %s, want %d %s: %s", rec.Code, body.Code, wantStatus, wantCode, rec.Body)
+	}
+}
+
+func TestOIDCCallbackRejectsBadState(t *testing.T) {
+	tests := []struct {
+		name   string
+		state  func(query url.Values, cookie *http.Cookie) (url.Values, *http.Cookie)
+		stored bool
+	}{
+		{
+			name: "cookie mismatch",
+			state: func(query url.Values, cookie *http.Cookie) (url.Values, *http.Cookie) {
+				cookie.Value = "another-state"
+				return query, cookie
+			},
+		},
+		{
+			name: "missing cookie",
+			state: func(query url.Values, _ *http.Cookie) (url.Values, *http.Cookie) {
+				return query, nil
+			},
+		},
+		{
+			name: "missing state",
+			state: func(query url.Values, cookie *http.Cookie) (url.Values, *http.Cookie) {
+				query.Del("state")
+				cookie.Value = ""
+				return query, cookie
+			},
+		},
+		{
+			name:   "expired or used state",
+			stored: true,
+			state: func(query url.Values, cookie *http.Cookie) (url.Values, *http.Cookie) {
+				return query, cookie
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := newOIDCTest(t)
+			query, cookie := tt.state(o.login(t))
+			if tt.stored {
+				o.mock.ExpectQuery("DELETE FROM oidc_login_states WHERE state_hash").
+					WillReturnError(sql.ErrNoRows)
+			}
+
+			assertError(t, o.callback(query, cookie), http.StatusBadRequest, "INVALID_OIDC_STATE")
+			if err := o.mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackRejectsBadExchange(t *testing.T) {
+	tests := []struct {
+		name string
+		// stored changes the nonce and verifier read back for the callback
+		stored func(nonce, verifier string) (string, string)
+	}{
+		{
+			name: "PKCE verifier mismatch",
+			stored: func(nonce, _ string) (string, string) {
+				return nonce, oidc.GenerateVerifier()
+			},
+		},
+		{
+			name: "nonce mismatch",
+			stored: func(_, verifier string) (string, string) {
+				return "another-nonce", verifier
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := newOIDCTest(t)
+			query, cookie := o.login(t)
+			nonce, verifier := tt.stored(o.nonce, o.verifier)
+			o.expectState(query.Get("state"), nonce, verifier)
+
+			assertError(t, o.callback(query, cookie), http.StatusUnauthorized, "OIDC_LOGIN_FAILED")
+			if err := o.mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackRejectsUnverifiedEmail(t *testing.T) {
+	o := newOIDCTest(t)
+	o.provider.SetUser(mockoidc.User{Subject: "sub-1", Email: "user@example.com", EmailVerified: false})
+
+	query, cookie := o.login(t)
+	o.expectState(query.Get("state"), o.nonce, o.verifier)
+	o.mock.ExpectQuery("FROM user_identities").
+		WithArgs("mock", "sub-1").
+		WillReturnError(sql.ErrNoRows)
+
+	assertError(t, o.callback(query, cookie), http.StatusForbidden, "OIDC_EMAIL_NOT_VERIFIED")
+	if err := o.mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestOIDCCallbackLinksAccount(t *testing.T) {
+	tests := []struct {
+		name string
+		// existing is whether an account with the email exists, and verified
+		// whether its email was proven
+		existing, verified bool
+	}{
+		{name: "new account"},
+		{name: "verified account", existing: true, verified: true},
+		{name: "unverified account", existing: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			o := newOIDCTest(t)
+			// The provider's address is matched case-insensitively
+			o.provider.SetUser(mockoidc.User{Subject: "sub-1", Email: "User@Example.com", EmailVerified: true, Name: "User"})
+			now := time.Now()
+			user := []driver.Value{42, "User", "user@example.com", "", now, now, now, false}
+
+			query, cookie := o.login(t)
+			o.expectState(query.Get("state"), o.nonce, o.verifier)
+			o.mock.ExpectQuery("FROM user_identities").
+				WithArgs("mock", "sub-1").
+				WillReturnError(sql.ErrNoRows)
+
+			o.mock.ExpectBegin()
+			account := o.mock.ExpectQuery("SELECT id, verified_at IS NOT NULL FROM users WHERE LOWER\\(email\\) = \\$1 FOR UPDATE").
+				WithArgs("user@example.com")
+			if !tt.existing {
+				account.WillReturnError(sql.ErrNoRows)
+				o.mock.ExpectQuery("INSERT INTO users").
+					WithArgs("User", "user@example.com", sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows(userColumns[:7]).AddRow(user[:7]...))
+			} else {
+				account.WillReturnRows(sqlmock.NewRows([]string{"id", "verified"}).AddRow(42, tt.verified))
+				if !tt.verified {
+					// Whoever registered the address loses every way in
+					o.mock.ExpectExec("UPDATE users SET password = '', .* sessions_revoked_at = CURRENT_TIMESTAMP").
+						WithArgs(42).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+					for _, table := range []string{"recovery_codes", "password_resets", "api_keys"} {
+						o.mock.ExpectExec("DELETE FROM " + table + " WHERE user_id").
+							WithArgs(42).
+							WillReturnResult(sqlmock.NewResult(0, 0))
+					}
+				}
+				o.mock.ExpectQuery("UPDATE users SET verified_at").
+					WithArgs(42).
+					WillReturnRows(sqlmock.NewRows(userColumns).AddRow(user...))
+			}
+			o.mock.ExpectExec("INSERT INTO user_identities").
+				WithArgs(42, "mock", "sub-1", "user@example.com").
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			o.mock.ExpectCommit()
+			o.mock.ExpectQuery("FROM user_identities").
+				WithArgs("mock", "sub-1").
+				WillReturnRows(sqlmock.NewRows(userColumns).AddRow(user...))
+
+			rec := o.callback(query, cookie)
+			var body struct {
+				Data struct {
+					Token string `json:"token"`
+					User  User   `json:"user"`
+				} `json:"data"`
+			}
+			json.Unmarshal(rec.Body.Bytes(), &body)
+			if rec.Code != http.StatusOK || body.Data.Token == "" || body.Data.User.ID != 42 {
+				t.Errorf("status %d, want a token for user 42: %s", rec.Code, rec.Body)
+			}
+			if err := o.mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackRequiresTwoFactor(t *testing.T) {
+	o := newOIDCTest(t)
+	o.provider.SetUser(mockoidc.User{Subject: "sub-1", Email: "user@example.com", EmailVerified: true})
+	now := time.Now()
+
+	query, cookie := o.login(t)
+	o.expectState(query.Get("state"), o.nonce, o.verifier)
+	o.mock.ExpectQuery("FROM user_identities").
+		WithArgs("mock", "sub-1").
+		WillReturnRows(sqlmock.NewRows(userColumns).AddRow(42, "User", "user@example.com", "", now, now, now, true))
+
+	rec := o.callback(query, cookie)
+	var body struct {
+		Data struct {
+			Token          string `json:"token"`
+			ChallengeToken string `json:"challenge_token"`
+		} `json:"data"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	if rec.Code != http.StatusOK || body.Data.Token != "" || body.Data.ChallengeToken == "" {
+		t.Errorf("status %d, want a two-factor challenge instead of a token: %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,310 @@
+package handlers
+
+import (
+	"auth-service/apperror"
+	"auth-service/logger"
+	"auth-service/rabbitmq"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// resetTokenTTL is how long a password reset link stays valid
+const resetTokenTTL = time.Hour
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required,min=6"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" binding:"required"`
+	NewPassword string `json:"new_password" binding:"required,min=6"`
+}
+
+// PasswordResetMessage asks the notification worker to email a reset link
+type PasswordResetMessage struct {
+	UserID    int       `json:"user_id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	ResetURL  string    `json:"reset_url"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// ChangePassword replaces the current user's password after checking the old one.
+// Other sessions are revoked and a fresh token is returned for this one.
+func (h *AuthHandler) ChangePassword(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	ctx := c.Request.Context()
+
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperror.Validation(err))
+		return
+	}
+
+	var user User
+	err := h.db.QueryRowContext(ctx,
+		"SELECT id, email, password FROM users WHERE id = $1",
+		userID,
+	).Scan(&user.ID, &user.Email, &user.Password)
+
+	if err == sql.ErrNoRows {
+		c.Error(apperror.New(apperror.CodeUserNotFound, "User not found"))
+		return
+	}
+
+	if err != nil {
+		c.Error(apperror.Internal(err, "Database error"))
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.CurrentPassword)); err != nil {
+		c.Error(apperror.New(apperror.CodeInvalidCredentials, "Current password is incorrect"))
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to hash password"))
+		return
+	}
+
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to start transaction"))
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE users SET password = $1, sessions_revoked_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		 WHERE id = $2`,
+		string(hashedPassword), userID,
+	)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to update password"))
+		return
+	}
+
+	// A reset link sent before the change must not undo it
+	if err := invalidateResetTokens(ctx, tx, userID); err != nil {
+		c.Error(apperror.Internal(err, "Failed to update password"))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.Error(apperror.Internal(err, "Failed to commit transaction"))
+		return
+	}
+
+	logger.FromContext(ctx).Info("password changed", "user_id", userID)
+
+	// Issued after the revocation, so this token stays valid
+	token, err := h.generateToken(user.ID, user.Email)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to generate token"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Password changed successfully",
+		"data": gin.H{
+			"token": token,
+		},
+	})
+}
+
+// ForgotPassword emails a single-use reset link. It responds the same way
+// whether or not the email is registered so accounts cannot be enumerated.
+func (h *AuthHandler) ForgotPassword(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperror.Validation(err))
+		return
+	}
+
+	accepted := gin.H{
+		"success": true,
+		"message": "If the email is registered, a password reset link has been sent",
+	}
+
+	var user User
+	err := h.db.QueryRowContext(ctx,
+		"SELECT id, name, email FROM users WHERE LOWER(email) = $1",
+		normalizeEmail(req.Email),
+	).Scan(&user.ID, &user.Name, &user.Email)
+
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusAccepted, accepted)
+		return
+	}
+
+	if err != nil {
+		c.Error(apperror.Internal(err, "Database error"))
+		return
+	}
+
+	token, err := newRandomToken()
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to generate reset token"))
+		return
+	}
+
+	// Expiry is enforced by the database clock; the message copy is informational
+	expiresAt := time.Now().Add(resetTokenTTL)
+	_, err = h.db.ExecContext(ctx,
+		`INSERT INTO password_resets (user_id, token_hash, expires_at)
+		 VALUES ($1, $2, CURRENT_TIMESTAMP + make_interval(secs => $3))`,
+		user.ID, hashToken(token), resetTokenTTL.Seconds(),
+	)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to create reset token"))
+		return
+	}
+
+	err = h.rmq.Publish(ctx, rabbitmq.QueuePasswordReset, PasswordResetMessage{
+		UserID:    user.ID,
+		Name:      user.Name,
+		Email:     user.Email,
+		ResetURL:  resetURL(token),
+		ExpiresAt: expiresAt,
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		// Still answer 202 so a queue outage does not reveal which emails are registered
+		logger.FromContext(ctx).Error("failed to queue password reset email", "user_id", user.ID, "error", err)
+	} else {
+		logger.FromContext(ctx).Info("password reset requested", "user_id", user.ID)
+	}
+
+	c.JSON(http.StatusAccepted, accepted)
+}
+
+// ResetPassword sets a new password using a reset token, consuming the token,
+// clearing any login lockout and revoking every existing session
+func (h *AuthHandler) ResetPassword(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperror.Validation(err))
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to hash password"))
+		return
+	}
+
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to start transaction"))
+		return
+	}
+	defer tx.Rollback()
+
+	// Lock the token row so concurrent requests cannot both consume it
+	var userID int
+	err = tx.QueryRowContext(ctx,
+		`SELECT user_id FROM password_resets
+		 WHERE token_hash = $1 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP
+		 FOR UPDATE`,
+		hashToken(req.Token),
+	).Scan(&userID)
+
+	if err == sql.ErrNoRows {
+		c.Error(apperror.New(apperror.CodeInvalidResetToken, "Reset token is invalid or expired"))
+		return
+	}
+
+	if err != nil {
+		c.Error(apperror.Internal(err, "Database error"))
+		return
+	}
+
+	if err := resetPassword(ctx, tx, userID, string(hashedPassword)); err != nil {
+		c.Error(apperror.Internal(err, "Failed to reset password"))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.Error(apperror.Internal(err, "Failed to commit transaction"))
+		return
+	}
+
+	logger.FromContext(ctx).Info("password reset", "user_id", userID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Password reset successfully, please log in again",
+	})
+}
+
+// resetPassword stores the new password, revokes sessions and invalidates all
+// of the user's outstanding reset tokens
+func resetPassword(ctx context.Context, tx *sql.Tx, userID int, hashedPassword string) error {
+	_, err := tx.ExecContext(ctx,
+		`UPDATE users SET password = $1, sessions_revoked_at = CURRENT_TIMESTAMP,
+		 failed_login_attempts = 0, locked_until = NULL, updated_at = CURRENT_TIMESTAMP
+		 WHERE id = $2`,
+		hashedPassword, userID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return invalidateResetTokens(ctx, tx, userID)
+}
+
+// invalidateResetTokens marks the user's unused reset tokens as used
+func invalidateResetTokens(ctx context.Context, tx *sql.Tx, userID int) error {
+	_, err := tx.ExecContext(ctx,
+		"UPDATE password_resets SET used_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND used_at IS NULL",
+		userID,
+	)
+	return err
+}
+
+// newRandomToken returns a random URL-safe token
+func newRandomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken returns the SHA-256 hex digest stored in place of a random token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// resetURL builds the link emailed to the user, pointing at PASSWORD_RESET_URL
+func resetURL(token string) string {
+	base := os.Getenv("PASSWORD_RESET_URL")
+	if base == "" {
+		base = "http://localhost:8001/password/reset"
+	}
+	return base + "?token=" + url.QueryEscape(token)
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"auth-service/middleware"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestChangePasswordInvalidatesResetTokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	hash, _ := bcrypt.GenerateFromPassword([]byte("old-password"), bcrypt.MinCost)
+	mock.ExpectQuery("SELECT id, email, password FROM users").
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "email", "password"}).AddRow(7, "user@example.com", string(hash)))
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users SET password = \\$1, sessions_revoked_at").
+		WithArgs(sqlmock.AnyArg(), 7).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE password_resets SET used_at = CURRENT_TIMESTAMP WHERE user_id = \\$1 AND used_at IS NULL").
+		WithArgs(7).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	handler := NewAuthHandler(db, nil, newTestKeys(t), nil, nil, nil, nil)
+	router := gin.New()
+	router.Use(middleware.ErrorHandler())
+	router.PUT("/password", func(c *gin.Context) { c.Set("user_id", 7) }, handler.ChangePassword)
+
+	req := httptest.NewRequest(http.MethodPut, "/password",
+		strings.NewReader(`{"current_password": "old-password", "new_password": "new-password"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("status %d: %s", rec.Code, rec.Body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"auth-service/apperror"
+	"auth-service/logger"
+	"auth-service/phone"
+	"auth-service/rabbitmq"
+	"context"
+	"database/sql"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type ChangeEmailRequest struct {
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required"`
+}
+
+// EmailChangeMessage asks the notification worker to send the confirmation
+// link to the new address and a notice to the previous one
+type EmailChangeMessage struct {
+	UserID          int       `json:"user_id"`
+	Name            string    `json:"name"`
+	Email           string    `json:"email"`
+	PreviousEmail   string    `json:"previous_email"`
+	ConfirmationURL string    `json:"confirmation_url"`
+	Timestamp       time.Time `json:"timestamp"`
+}
+
+func (h *AuthHandler) loadProfile(ctx context.Context, userID int) (User, error) {
+	var user User
+	err := h.db.QueryRowContext(ctx,
+		`SELECT id, name, email, phone, verified_at, pending_email, created_at, updated_at
+		 FROM users WHERE id = $1`,
+		userID,
+	).Scan(&user.ID, &user.Name, &user.Email, &user.Phone, &user.VerifiedAt, &user.PendingEmail, &user.CreatedAt, &user.UpdatedAt)
+	return user, err
+}
+
+// normalizePhone converts a phone number to E.164, reading local numbers
+// (leading 0) with the country code in PHONE_DEFAULT_COUNTRY_CODE
+func normalizePhone(raw string) (string, error) {
+	normalized, err := phone.Normalize(raw, os.Getenv("PHONE_DEFAULT_COUNTRY_CODE"))
+	if err != nil {
+		return "", apperror.InvalidField("phone", "e164", err.Error())
+	}
+	return normalized, nil
+}
+
+// emailChangeURL builds the confirmation link, pointing at EMAIL_CHANGE_URL
+// (defaults to this service's /profile/email/confirm)
+func emailChangeURL(token string) string {
+	base := os.Getenv("EMAIL_CHANGE_URL")
+	if base == "" {
+		base = "http://localhost:8001/profile/email/confirm"
+	}
+	return base + "?token=" + url.QueryEscape(token)
+}
+
+// ChangeEmail starts an email change. The current address stays in use until
+// the link sent to the new one is opened.
+func (h *AuthHandler) ChangeEmail(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	ctx := c.Request.Context()
+
+	var req ChangeEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperror.Validation(err))
+		return
+	}
+
+	var user User
+	err := h.db.QueryRowContext(ctx,
+		"SELECT id, name, email, password FROM users WHERE id = $1",
+		userID,
+	).Scan(&user.ID, &user.Name, &user.Email, &user.Password)
+
+	if err == sql.ErrNoRows {
+		c.Error(apperror.New(apperror.CodeUserNotFound, "User not found"))
+		return
+	}
+
+	if err != nil {
+		c.Error(apperror.Internal(err, "Database error"))
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		c.Error(apperror.New(apperror.CodeInvalidCredentials, "Password is incorrect"))
+		return
+	}
+
+	req.Email = normalizeEmail(req.Email)
+	if req.Email == strings.ToLower(user.Email) {
+		c.Error(apperror.New(apperror.CodeBadRequest, "New email is the same as the current one"))
+		return
+	}
+
+	var exists bool
+	err = h.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE LOWER(email) = $1)", req.Email).Scan(&exists)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Database error"))
+		return
+	}
+
+	if exists {
+		c.Error(apperror.New(apperror.CodeEmailTaken, "Email already registered"))
+		return
+	}
+
+	// Only the latest request can be confirmed, since the token must match pending_email
+	if _, err := h.db.ExecContext(ctx, "UPDATE users SET pending_email = $1 WHERE id = $2", req.Email, userID); err != nil {
+		c.Error(apperror.Internal(err, "Failed to start email change"))
+		return
+	}
+
+	token, err := h.emailChanger.Sign(userID, req.Email)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to generate confirmation token"))
+		return
+	}
+
+	err = h.rmq.Publish(ctx, rabbitmq.QueueEmailChange, EmailChangeMessage{
+		UserID:          userID,
+		Name:            user.Name,
+		Email:           req.Email,
+		PreviousEmail:   user.Email,
+		ConfirmationURL: emailChangeURL(token),
+		Timestamp:       time.Now(),
+	})
+	if err != nil {
+		c.Error(apperror.Wrap(err, apperror.CodeServiceUnavailable, "Failed to send confirmation email"))
+		return
+	}
+
+	logger.FromContext(ctx).Info("email change requested", "user_id", userID)
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"success": true,
+		"message": "Confirmation link sent to the new email address",
+	})
+}
+
+// ConfirmEmailChange switches to the pending email using the emailed token.
+// Opening the link proves ownership, so the new address counts as verified.
+func (h *AuthHandler) ConfirmEmailChange(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	token := c.Query("token")
+	if token == "" {
+		c.Error(apperror.New(apperror.CodeInvalidVerificationToken, "Confirmation token is required"))
+		return
+	}
+
+	userID, email, err := h.emailChanger.Verify(token)
+	if err != nil {
+		c.Error(apperror.Wrap(err, apperror.CodeInvalidVerificationToken, "Confirmation token is invalid or expired"))
+		return
+	}
+
+	var exists bool
+	err = h.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE LOWER(email) = $1)", email).Scan(&exists)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Database error"))
+		return
+	}
+
+	if exists {
+		c.Error(apperror.New(apperror.CodeEmailTaken, "Email already registered"))
+		return
+	}
+
+	result, err := h.db.ExecContext(ctx,
+		`UPDATE users SET email = pending_email, pending_email = NULL, verified_at = CURRENT_TIMESTAMP
+		 WHERE id = $1 AND pending_email = $2 AND deleted_at IS NULL`,
+		userID, email,
+	)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to change email"))
+		return
+	}
+
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.Error(apperror.New(apperror.CodeInvalidVerificationToken, "Confirmation token is invalid or expired"))
+		return
+	}
+
+	logger.FromContext(ctx).Info("email changed", "user_id", userID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Email changed successfully",
+	})
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"auth-service/middleware"
+	"auth-service/verification"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+func TestConfirmEmailChange(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		taken      bool
+		wantStatus int
+	}{
+		{name: "changed", wantStatus: http.StatusOK},
+		// Stored emails may predate lowercasing, so the check ignores case
+		{name: "taken in another case", taken: true, wantStatus: http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			mock.ExpectQuery("SELECT EXISTS\\(SELECT 1 FROM users WHERE LOWER\\(email\\) = \\$1\\)").
+				WithArgs("new@example.com").
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(tt.taken))
+			if !tt.taken {
+				mock.ExpectExec("UPDATE users SET email = pending_email").
+					WithArgs(7, "new@example.com").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			emailChanger := verification.NewSigner("test-secret", verification.AudienceEmailChange, time.Hour)
+			token, err := emailChanger.Sign(7, "new@example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			handler := NewAuthHandler(db, nil, newTestKeys(t), nil, nil, emailChanger, nil)
+			router := gin.New()
+			router.Use(middleware.ErrorHandler())
+			router.GET("/email/confirm", handler.ConfirmEmailChange)
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/email/confirm?token="+token, nil))
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
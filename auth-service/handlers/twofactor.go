@@ -0,0 +1,433 @@
+package handlers
+
+import (
+	"auth-service/apperror"
+	"auth-service/logger"
+	"auth-service/middleware"
+	"auth-service/totp"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// recoveryCodeCount is how many single-use recovery codes are issued on enrollment
+const recoveryCodeCount = 10
+
+type ConfirmTwoFactorRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type DisableTwoFactorRequest struct {
+	Password string `json:"password" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+type LoginTwoFactorRequest struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+	Code           string `json:"code" binding:"required"`
+}
+
+// EnrollTwoFactor generates a new TOTP secret for the current user. It only
+// takes effect once confirmed with a code from the authenticator app.
+func (h *AuthHandler) EnrollTwoFactor(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	ctx := c.Request.Context()
+
+	var email string
+	var enabled bool
+	err := h.db.QueryRowContext(ctx,
+		"SELECT email, totp_enabled FROM users WHERE id = $1",
+		userID,
+	).Scan(&email, &enabled)
+
+	if err == sql.ErrNoRows {
+		c.Error(apperror.New(apperror.CodeUserNotFound, "User not found"))
+		return
+	}
+
+	if err != nil {
+		c.Error(apperror.Internal(err, "Database error"))
+		return
+	}
+
+	if enabled {
+		c.Error(apperror.New(apperror.CodeTwoFactorEnabled, "Two-factor authentication is already enabled"))
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to generate secret"))
+		return
+	}
+
+	_, err = h.db.ExecContext(ctx,
+		"UPDATE users SET totp_secret = $1, totp_last_step = NULL, updated_at = CURRENT_TIMESTAMP WHERE id = $2",
+		secret, userID,
+	)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to start enrollment"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Scan the otpauth URI with an authenticator app, then confirm with a code",
+		"data": gin.H{
+			"secret":      secret,
+			"otpauth_uri": totp.URI(totpIssuer(), email, secret),
+		},
+	})
+}
+
+// ConfirmTwoFactor enables 2FA after checking a first code against the pending
+// secret and returns recovery codes, which are shown only this once
+func (h *AuthHandler) ConfirmTwoFactor(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	ctx := c.Request.Context()
+
+	var req ConfirmTwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperror.Validation(err))
+		return
+	}
+
+	var secret sql.NullString
+	var enabled bool
+	err := h.db.QueryRowContext(ctx,
+		"SELECT totp_secret, totp_enabled FROM users WHERE id = $1",
+		userID,
+	).Scan(&secret, &enabled)
+
+	if err == sql.ErrNoRows {
+		c.Error(apperror.New(apperror.CodeUserNotFound, "User not found"))
+		return
+	}
+
+	if err != nil {
+		c.Error(apperror.Internal(err, "Database error"))
+		return
+	}
+
+	if enabled {
+		c.Error(apperror.New(apperror.CodeTwoFactorEnabled, "Two-factor authentication is already enabled"))
+		return
+	}
+
+	if !secret.Valid {
+		c.Error(apperror.New(apperror.CodeTwoFactorNotEnabled, "Start two-factor enrollment first"))
+		return
+	}
+
+	step, ok := totp.Validate(secret.String, req.Code, time.Now())
+	if !ok {
+		c.Error(apperror.New(apperror.CodeInvalidTwoFactorCode, "Invalid two-factor code"))
+		return
+	}
+
+	codes, err := generateRecoveryCodes()
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to generate recovery codes"))
+		return
+	}
+
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to start transaction"))
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE users SET totp_enabled = TRUE, totp_last_step = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2",
+		step, userID,
+	)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to enable two-factor authentication"))
+		return
+	}
+
+	if err := replaceRecoveryCodes(ctx, tx, userID, codes); err != nil {
+		c.Error(apperror.Internal(err, "Failed to store recovery codes"))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.Error(apperror.Internal(err, "Failed to commit transaction"))
+		return
+	}
+
+	logger.FromContext(ctx).Info("two-factor authentication enabled", "user_id", userID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Two-factor authentication enabled. Store the recovery codes somewhere safe.",
+		"data": gin.H{
+			"recovery_codes": codes,
+		},
+	})
+}
+
+// DisableTwoFactor turns 2FA off after checking the password and a current code
+func (h *AuthHandler) DisableTwoFactor(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	ctx := c.Request.Context()
+
+	var req DisableTwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperror.Validation(err))
+		return
+	}
+
+	var password string
+	var secret sql.NullString
+	var enabled bool
+	var lockedFor float64
+	err := h.db.QueryRowContext(ctx,
+		`SELECT password, totp_secret, totp_enabled,
+		        COALESCE(EXTRACT(EPOCH FROM (locked_until - CURRENT_TIMESTAMP)), 0)
+		 FROM users WHERE id = $1`,
+		userID,
+	).Scan(&password, &secret, &enabled, &lockedFor)
+
+	if err == sql.ErrNoRows {
+		c.Error(apperror.New(apperror.CodeUserNotFound, "User not found"))
+		return
+	}
+
+	if err != nil {
+		c.Error(apperror.Internal(err, "Database error"))
+		return
+	}
+
+	if !enabled {
+		c.Error(apperror.New(apperror.CodeTwoFactorNotEnabled, "Two-factor authentication is not enabled"))
+		return
+	}
+
+	if lockedFor > 0 {
+		logger.FromContext(ctx).Warn("two-factor disable rejected", "user_id", userID, "reason", "account locked")
+		middleware.SetRetryAfter(c, time.Duration(lockedFor*float64(time.Second)))
+		c.Error(apperror.New(apperror.CodeAccountLocked, "Too many failed login attempts, account temporarily locked"))
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(password), []byte(req.Password)); err != nil {
+		c.Error(apperror.New(apperror.CodeInvalidCredentials, "Password is incorrect"))
+		return
+	}
+
+	valid, err := h.verifySecondFactor(ctx, userID, secret.String, req.Code)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Database error"))
+		return
+	}
+	if !valid {
+		logger.FromContext(ctx).Warn("two-factor disable rejected", "user_id", userID, "reason", "invalid two-factor code")
+		if err := h.recordFailedLogin(ctx, userID); err != nil {
+			c.Error(apperror.Internal(err, "Database error"))
+			return
+		}
+		c.Error(apperror.New(apperror.CodeInvalidTwoFactorCode, "Invalid two-factor code"))
+		return
+	}
+
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to start transaction"))
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		`UPDATE users SET totp_enabled = FALSE, totp_secret = NULL, totp_last_step = NULL, updated_at = CURRENT_TIMESTAMP
+		 WHERE id = $1`,
+		userID,
+	)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to disable two-factor authentication"))
+		return
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		c.Error(apperror.Internal(err, "Failed to remove recovery codes"))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.Error(apperror.Internal(err, "Failed to commit transaction"))
+		return
+	}
+
+	logger.FromContext(ctx).Info("two-factor authentication disabled", "user_id", userID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Two-factor authentication disabled",
+	})
+}
+
+// LoginTwoFactor exchanges the challenge token from Login plus a TOTP or
+// recovery code for an access token. Wrong codes count towards the lockout.
+func (h *AuthHandler) LoginTwoFactor(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	var req LoginTwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperror.Validation(err))
+		return
+	}
+
+	userID, email, err := h.challenger.Verify(req.ChallengeToken)
+	if err != nil {
+		c.Error(apperror.Wrap(err, apperror.CodeInvalidChallengeToken, "Login challenge is invalid or expired, please log in again"))
+		return
+	}
+
+	var user User
+	var secret sql.NullString
+	var enabled bool
+	var lockedFor float64
+	err = h.db.QueryRowContext(ctx,
+		`SELECT id, name, email, phone, verified_at, created_at, updated_at, totp_secret, totp_enabled,
+		        COALESCE(EXTRACT(EPOCH FROM (locked_until - CURRENT_TIMESTAMP)), 0)
+		 FROM users WHERE id = $1 AND email = $2`,
+		userID, email,
+	).Scan(&user.ID, &user.Name, &user.Email, &user.Phone, &user.VerifiedAt, &user.CreatedAt, &user.UpdatedAt, &secret, &enabled, &lockedFor)
+
+	if err == sql.ErrNoRows {
+		c.Error(apperror.New(apperror.CodeInvalidChallengeToken, "Login challenge is invalid or expired, please log in again"))
+		return
+	}
+
+	if err != nil {
+		c.Error(apperror.Internal(err, "Database error"))
+		return
+	}
+
+	if lockedFor > 0 {
+		logger.FromContext(ctx).Warn("login rejected", "user_id", user.ID, "reason", "account locked")
+		middleware.SetRetryAfter(c, time.Duration(lockedFor*float64(time.Second)))
+		c.Error(apperror.New(apperror.CodeAccountLocked, "Too many failed login attempts, account temporarily locked"))
+		return
+	}
+
+	// 2FA was disabled since the challenge was issued
+	if !enabled {
+		c.Error(apperror.New(apperror.CodeInvalidChallengeToken, "Login challenge is invalid or expired, please log in again"))
+		return
+	}
+
+	valid, err := h.verifySecondFactor(ctx, user.ID, secret.String, req.Code)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Database error"))
+		return
+	}
+
+	if !valid {
+		logger.FromContext(ctx).Warn("login failed", "user_id", user.ID, "reason", "invalid two-factor code")
+		if err := h.recordFailedLogin(ctx, user.ID); err != nil {
+			c.Error(apperror.Internal(err, "Database error"))
+			return
+		}
+		c.Error(apperror.New(apperror.CodeInvalidTwoFactorCode, "Invalid two-factor code"))
+		return
+	}
+
+	if _, err := h.db.ExecContext(ctx,
+		"UPDATE users SET failed_login_attempts = 0, locked_until = NULL WHERE id = $1",
+		user.ID,
+	); err != nil {
+		c.Error(apperror.Internal(err, "Database error"))
+		return
+	}
+
+	h.respondWithToken(c, user, "two_factor")
+}
+
+// verifySecondFactor accepts a TOTP code for a time step newer than the last
+// one used, or an unused recovery code, consuming either atomically
+func (h *AuthHandler) verifySecondFactor(ctx context.Context, userID int, secret, code string) (bool, error) {
+	if step, ok := totp.Validate(secret, code, time.Now()); ok {
+		result, err := h.db.ExecContext(ctx,
+			`UPDATE users SET totp_last_step = $1
+			 WHERE id = $2 AND (totp_last_step IS NULL OR totp_last_step < $1)`,
+			step, userID,
+		)
+		if err != nil {
+			return false, err
+		}
+		rows, err := result.RowsAffected()
+		return rows == 1, err
+	}
+
+	result, err := h.db.ExecContext(ctx,
+		`UPDATE recovery_codes SET used_at = CURRENT_TIMESTAMP
+		 WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`,
+		userID, hashToken(normalizeRecoveryCode(code)),
+	)
+	if err != nil {
+		return false, err
+	}
+	rows, err := result.RowsAffected()
+	if rows > 0 {
+		logger.FromContext(ctx).Warn("recovery code used", "user_id", userID)
+	}
+	return rows > 0, err
+}
+
+// replaceRecoveryCodes swaps the user's recovery codes for hashes of codes
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID int, codes []string) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+
+	for _, code := range codes {
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)",
+			userID, hashToken(normalizeRecoveryCode(code)),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// generateRecoveryCodes returns random codes formatted as "xxxxx-xxxxx"
+func generateRecoveryCodes() ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(recoveryEncoding.EncodeToString(b))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+	}
+	return codes, nil
+}
+
+// normalizeRecoveryCode ignores case, spaces and dashes typed by the user
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// totpIssuer names the account in authenticator apps, from TOTP_ISSUER
+func totpIssuer() string {
+	if issuer := os.Getenv("TOTP_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "Backend Bootcamp"
+}
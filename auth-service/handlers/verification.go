@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"auth-service/apperror"
+	"auth-service/logger"
+	"auth-service/rabbitmq"
+	"context"
+	"database/sql"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UserRegisteredMessage asks the notification worker to send a verification email
+type UserRegisteredMessage struct {
+	UserID          int       `json:"user_id"`
+	Name            string    `json:"name"`
+	Email           string    `json:"email"`
+	VerificationURL string    `json:"verification_url"`
+	Timestamp       time.Time `json:"timestamp"`
+}
+
+// sendVerification publishes a user_registered event carrying a fresh verification link
+func (h *AuthHandler) sendVerification(ctx context.Context, user User) error {
+	token, err := h.verifier.Sign(user.ID, user.Email)
+	if err != nil {
+		return err
+	}
+
+	return h.rmq.Publish(ctx, rabbitmq.QueueUserRegistered, UserRegisteredMessage{
+		UserID:          user.ID,
+		Name:            user.Name,
+		Email:           user.Email,
+		VerificationURL: verificationURL(token),
+		Timestamp:       time.Now(),
+	})
+}
+
+// verificationURL builds the link emailed to the user, pointing at
+// EMAIL_VERIFICATION_URL (defaults to this service's /verify-email)
+func verificationURL(token string) string {
+	base := os.Getenv("EMAIL_VERIFICATION_URL")
+	if base == "" {
+		base = "http://localhost:8001/verify-email"
+	}
+	return base + "?token=" + url.QueryEscape(token)
+}
+
+// VerifyEmail marks the user's email as verified using the emailed token
+func (h *AuthHandler) VerifyEmail(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	token := c.Query("token")
+	if token == "" {
+		c.Error(apperror.New(apperror.CodeInvalidVerificationToken, "Verification token is required"))
+		return
+	}
+
+	userID, email, err := h.verifier.Verify(token)
+	if err != nil {
+		c.Error(apperror.Wrap(err, apperror.CodeInvalidVerificationToken, "Verification token is invalid or expired"))
+		return
+	}
+
+	// The email must still match, so a token issued before an email change is void
+	var verifiedAt sql.NullTime
+	err = h.db.QueryRowContext(ctx,
+		"SELECT verified_at FROM users WHERE id = $1 AND email = $2",
+		userID, email,
+	).Scan(&verifiedAt)
+
+	if err == sql.ErrNoRows {
+		c.Error(apperror.New(apperror.CodeInvalidVerificationToken, "Verification token is invalid or expired"))
+		return
+	}
+
+	if err != nil {
+		c.Error(apperror.Internal(err, "Database error"))
+		return
+	}
+
+	if verifiedAt.Valid {
+		c.Error(apperror.New(apperror.CodeEmailAlreadyVerified, "Email already verified"))
+		return
+	}
+
+	_, err = h.db.ExecContext(ctx,
+		"UPDATE users SET verified_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP WHERE id = $1 AND verified_at IS NULL",
+		userID,
+	)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to verify email"))
+		return
+	}
+
+	logger.FromContext(ctx).Info("email verified", "user_id", userID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Email verified successfully",
+	})
+}
+
+// ResendVerification emails a new verification link to the current user
+func (h *AuthHandler) ResendVerification(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	ctx := c.Request.Context()
+
+	var user User
+	err := h.db.QueryRowContext(ctx,
+		`SELECT id, name, email, phone, verified_at, created_at, updated_at
+		 FROM users WHERE id = $1`,
+		userID,
+	).Scan(&user.ID, &user.Name, &user.Email, &user.Phone, &user.VerifiedAt, &user.CreatedAt, &user.UpdatedAt)
+
+	if err == sql.ErrNoRows {
+		c.Error(apperror.New(apperror.CodeUserNotFound, "User not found"))
+		return
+	}
+
+	if err != nil {
+		c.Error(apperror.Internal(err, "Database error"))
+		return
+	}
+
+	if user.VerifiedAt != nil {
+		c.Error(apperror.New(apperror.CodeEmailAlreadyVerified, "Email already verified"))
+		return
+	}
+
+	if err := h.sendVerification(ctx, user); err != nil {
+		c.Error(apperror.Wrap(err, apperror.CodeServiceUnavailable, "Failed to send verification email"))
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"success": true,
+		"message": "Verification email sent",
+	})
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"auth-service/middleware"
+	"auth-service/verification"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+func TestVerifyEmail(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	verifier := verification.NewSigner("test-secret", verification.AudienceEmailVerification, time.Hour)
+	valid, err := verifier.Sign(7, "user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := verification.NewSigner("test-secret", verification.AudienceEmailVerification, -time.Minute).Sign(7, "user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Swap the signature for another token's
+	other, err := verifier.Sign(8, "other@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := valid[:strings.LastIndex(valid, ".")] + other[strings.LastIndex(other, "."):]
+
+	tests := []struct {
+		name  string
+		token string
+		// found is whether the user still has the token's email, and
+		// verifiedAt when they verified it
+		found      bool
+		verifiedAt interface{}
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "verified", token: valid, found: true, wantStatus: http.StatusOK},
+		{name: "already verified", token: valid, found: true, verifiedAt: time.Now(), wantStatus: http.StatusConflict, wantCode: "EMAIL_ALREADY_VERIFIED"},
+		{name: "email changed since", token: valid, wantStatus: http.StatusBadRequest, wantCode: "INVALID_VERIFICATION_TOKEN"},
+		{name: "missing", wantStatus: http.StatusBadRequest, wantCode: "INVALID_VERIFICATION_TOKEN"},
+		{name: "expired", token: expired, wantStatus: http.StatusBadRequest, wantCode: "INVALID_VERIFICATION_TOKEN"},
+		{name: "tampered", token: tampered, wantStatus: http.StatusBadRequest, wantCode: "INVALID_VERIFICATION_TOKEN"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			if tt.token == valid {
+				query := mock.ExpectQuery("SELECT verified_at FROM users WHERE id = \\$1 AND email = \\$2").
+					WithArgs(7, "user@example.com")
+				if tt.found {
+					query.WillReturnRows(sqlmock.NewRows([]string{"verified_at"}).AddRow(tt.verifiedAt))
+				} else {
+					query.WillReturnError(sql.ErrNoRows)
+				}
+			}
+			if tt.wantStatus == http.StatusOK {
+				mock.ExpectExec("UPDATE users SET verified_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP WHERE id = \\$1 AND verified_at IS NULL").
+					WithArgs(7).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			handler := NewAuthHandler(db, nil, newTestKeys(t), verifier, nil, nil, nil)
+			router := gin.New()
+			router.Use(middleware.ErrorHandler())
+			router.GET("/verify-email", handler.VerifyEmail)
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/verify-email?token="+tt.token, nil))
+
+			if rec.Code != tt.wantStatus || !strings.Contains(rec.Body.String(), tt.wantCode) {
+				t.Errorf("status %d, want %d with %s: %s", rec.Code, tt.wantStatus, tt.wantCode, rec.Body)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
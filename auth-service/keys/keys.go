@@ -0,0 +1,497 @@
+package keys
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Supported signing algorithms
+const (
+	AlgorithmEdDSA = "EdDSA"
+	AlgorithmRS256 = "RS256"
+)
+
+// rotationLockID serializes key rotation across auth-service replicas
+const rotationLockID = 7350001
+
+// encryptedPrefix marks private keys sealed with the key secret, followed by
+// the base64 nonce and ciphertext
+const encryptedPrefix = "enc:v1:"
+
+// schema creates the key table. Only auth-service creates or reads it: the
+// other services share the database, so private keys are stored encrypted
+// and they verify tokens through the JWKS instead.
+// Keys sign from not_before, and are published in the JWKS from created_at
+// until expires_at.
+const schema = `CREATE TABLE IF NOT EXISTS signing_keys (
+	kid VARCHAR(64) PRIMARY KEY,
+	algorithm VARCHAR(16) NOT NULL,
+	private_key TEXT NOT NULL,
+	not_before TIMESTAMP NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	expires_at TIMESTAMP NOT NULL
+)`
+
+// minReload bounds how often an unknown kid triggers a reload, so tokens with
+// made-up kids cannot hammer the database
+const minReload = 30 * time.Second
+
+// JWKSMaxAge is how long clients may cache the JWKS
+const JWKSMaxAge = 5 * time.Minute
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// Config controls how signing keys are generated and rotated
+type Config struct {
+	// Algorithm used for newly generated keys
+	Algorithm string
+	// RotationInterval is how long a key signs new tokens before being replaced
+	RotationInterval time.Duration
+	// TokenTTL is the lifetime of issued tokens; retired keys stay published
+	// for this long so tokens they signed remain verifiable
+	TokenTTL time.Duration
+	// Secret encrypts private keys in the database. Anyone reading the
+	// table without it cannot sign tokens.
+	Secret string
+}
+
+type signingKey struct {
+	id        string
+	algorithm string
+	private   crypto.Signer
+	notBefore time.Time
+}
+
+// Manager holds the key set shared by all auth-service replicas through the
+// signing_keys table. The next key is published a rotation interval before it
+// starts signing, so every replica and JWKS client knows it by then. The newest
+// key past its not-before time signs tokens; every unexpired key stays
+// available for verification and in the JWKS.
+type Manager struct {
+	db     *sql.DB
+	config Config
+	aead   cipher.AEAD
+
+	mu       sync.RWMutex
+	keys     map[string]*signingKey
+	order    []string // key IDs, newest first
+	loadedAt time.Time
+}
+
+func NewManager(db *sql.DB, config Config) (*Manager, error) {
+	switch config.Algorithm {
+	case AlgorithmEdDSA, AlgorithmRS256:
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", config.Algorithm)
+	}
+
+	if config.RotationInterval <= 0 {
+		return nil, fmt.Errorf("rotation interval must be positive")
+	}
+
+	// The next key is created at the latest one refresh into the current
+	// key's interval, and must reach every cache before it signs
+	if lead := publicationLead(config.RotationInterval); config.RotationInterval < lead+refreshInterval(config.RotationInterval) {
+		return nil, fmt.Errorf("rotation interval must be at least %s", lead+refreshInterval(config.RotationInterval))
+	}
+
+	if config.Secret == "" {
+		return nil, fmt.Errorf("a secret to encrypt signing keys is required")
+	}
+
+	// Any secret string becomes an AES-256 key
+	secret := sha256.Sum256([]byte(config.Secret))
+	block, err := aes.NewCipher(secret[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Manager{db: db, config: config, aead: aead, keys: make(map[string]*signingKey)}, nil
+}
+
+// refreshInterval is how often replicas reload the key set
+func refreshInterval(rotation time.Duration) time.Duration {
+	return min(rotation/10, time.Hour)
+}
+
+// publicationLead is how long a key must be published before it signs: one
+// refresh for every replica to serve it in the JWKS, then one JWKS cache
+// lifetime for clients to fetch it
+func publicationLead(rotation time.Duration) time.Duration {
+	return refreshInterval(rotation) + JWKSMaxAge
+}
+
+// Load reads the key set, first generating a signing key when none is signing
+// and scheduling the next one when none is scheduled
+func (m *Manager) Load(ctx context.Context) error {
+	if err := m.rotateIfDue(ctx); err != nil {
+		return err
+	}
+	return m.reload(ctx)
+}
+
+// reload replaces the in-memory key set with the unexpired keys in the database
+func (m *Manager) reload(ctx context.Context) error {
+	rows, err := m.db.QueryContext(ctx,
+		`SELECT kid, algorithm, private_key, not_before FROM signing_keys
+		 WHERE expires_at > CURRENT_TIMESTAMP
+		 ORDER BY not_before DESC`,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to load signing keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := make(map[string]*signingKey)
+	var order []string
+	for rows.Next() {
+		var id, algorithm, encoded string
+		var notBefore time.Time
+		if err := rows.Scan(&id, &algorithm, &encoded, &notBefore); err != nil {
+			return fmt.Errorf("failed to scan signing key: %w", err)
+		}
+
+		private, err := m.decrypt(id, encoded)
+		if err != nil {
+			return fmt.Errorf("failed to decode signing key %s: %w", id, err)
+		}
+
+		keys[id] = &signingKey{id: id, algorithm: algorithm, private: private, notBefore: notBefore}
+		order = append(order, id)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to load signing keys: %w", err)
+	}
+
+	if len(order) == 0 {
+		return fmt.Errorf("no signing keys available")
+	}
+
+	m.mu.Lock()
+	m.keys = keys
+	m.order = order
+	m.loadedAt = time.Now()
+	m.mu.Unlock()
+
+	return nil
+}
+
+// rotateIfDue inserts new keys under an advisory lock so concurrent replicas
+// rotate only once, and removes keys no longer needed for verification.
+// Keys stored before encryption are encrypted on the way.
+//
+// A key is inserted to sign at once only on a fresh database, or when every
+// replica was down for longer than a rotation interval. Otherwise the next
+// key is scheduled to sign when the current one's interval ends, and never
+// before it has been published for the publication lead.
+func (m *Manager) rotateIfDue(ctx context.Context) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", rotationLockID); err != nil {
+		return fmt.Errorf("failed to acquire rotation lock: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, schema); err != nil {
+		return fmt.Errorf("failed to create signing key table: %w", err)
+	}
+
+	if err := m.encryptPlaintextKeys(ctx, tx); err != nil {
+		return err
+	}
+
+	var signing, scheduled bool
+	err = tx.QueryRowContext(ctx,
+		`SELECT
+			COALESCE(BOOL_OR(not_before <= CURRENT_TIMESTAMP AND not_before > CURRENT_TIMESTAMP - make_interval(secs => $1)), FALSE),
+			COALESCE(BOOL_OR(not_before > CURRENT_TIMESTAMP), FALSE)
+		 FROM signing_keys`,
+		m.config.RotationInterval.Seconds(),
+	).Scan(&signing, &scheduled)
+	if err != nil {
+		return fmt.Errorf("failed to check signing keys: %w", err)
+	}
+
+	if !signing {
+		if err := m.insertKey(ctx, tx, "SELECT CURRENT_TIMESTAMP"); err != nil {
+			return err
+		}
+	}
+
+	if !scheduled {
+		err := m.insertKey(ctx, tx,
+			`SELECT GREATEST(MAX(not_before) + make_interval(secs => $5), CURRENT_TIMESTAMP + make_interval(secs => $6))
+			 FROM signing_keys`,
+			m.config.RotationInterval.Seconds(), publicationLead(m.config.RotationInterval).Seconds(),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM signing_keys WHERE expires_at <= CURRENT_TIMESTAMP"); err != nil {
+		return fmt.Errorf("failed to remove expired signing keys: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// insertKey generates and stores a key that signs from the time start selects,
+// for one interval, then verifies its tokens until they expire. start may use
+// parameters from $5, given in args.
+func (m *Manager) insertKey(ctx context.Context, tx *sql.Tx, start string, args ...interface{}) error {
+	id, encoded, err := generateKey(m.config.Algorithm)
+	if err != nil {
+		return fmt.Errorf("failed to generate signing key: %w", err)
+	}
+	encoded, err = m.encrypt(id, encoded)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt signing key: %w", err)
+	}
+
+	var notBefore time.Time
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO signing_keys (kid, algorithm, private_key, not_before, expires_at)
+		 SELECT $1, $2, $3, start, start + make_interval(secs => $4) FROM (`+start+`) AS s(start)
+		 RETURNING not_before`,
+		append([]interface{}{id, m.config.Algorithm, encoded, (m.config.RotationInterval + m.config.TokenTTL).Seconds()}, args...)...,
+	).Scan(&notBefore)
+	if err != nil {
+		return fmt.Errorf("failed to store signing key: %w", err)
+	}
+
+	slog.Info("signing key created", "kid", id, "algorithm", m.config.Algorithm, "not_before", notBefore)
+	return nil
+}
+
+// encryptPlaintextKeys encrypts keys stored as plain PEM by earlier versions
+func (m *Manager) encryptPlaintextKeys(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, "SELECT kid, private_key FROM signing_keys WHERE private_key NOT LIKE $1", encryptedPrefix+"%")
+	if err != nil {
+		return fmt.Errorf("failed to load signing keys: %w", err)
+	}
+
+	plaintext := map[string]string{}
+	for rows.Next() {
+		var id, encoded string
+		if err := rows.Scan(&id, &encoded); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan signing key: %w", err)
+		}
+		plaintext[id] = encoded
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to load signing keys: %w", err)
+	}
+
+	for id, encoded := range plaintext {
+		sealed, err := m.encrypt(id, encoded)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt signing key %s: %w", id, err)
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE signing_keys SET private_key = $1 WHERE kid = $2", sealed, id); err != nil {
+			return fmt.Errorf("failed to store signing key %s: %w", id, err)
+		}
+		slog.Info("signing key encrypted", "kid", id)
+	}
+	return nil
+}
+
+// Run reloads the key set periodically, scheduling the next key when due,
+// until ctx is done
+func (m *Manager) Run(ctx context.Context) {
+	ticker := time.NewTicker(refreshInterval(m.config.RotationInterval))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.Load(ctx); err != nil {
+				slog.Error("failed to refresh signing keys", "error", err)
+			}
+		}
+	}
+}
+
+// Sign signs claims with the current key, setting the kid header
+func (m *Manager) Sign(claims jwt.Claims) (string, error) {
+	key := m.current(time.Now())
+	if key == nil {
+		return "", fmt.Errorf("no signing key loaded")
+	}
+
+	token := jwt.NewWithClaims(signingMethod(key.algorithm), claims)
+	token.Header["kid"] = key.id
+	return token.SignedString(key.private)
+}
+
+// Keyfunc resolves the public key for a token by its kid header, rejecting
+// tokens whose algorithm does not match the key. An unknown kid reloads the
+// key set, since another replica may have just rotated.
+func (m *Manager) Keyfunc(token *jwt.Token) (interface{}, error) {
+	id, _ := token.Header["kid"].(string)
+
+	key, ok := m.lookup(id)
+	if !ok && m.reloadAllowed() {
+		if err := m.reload(context.Background()); err != nil {
+			slog.Error("failed to reload signing keys", "error", err)
+		}
+		key, ok = m.lookup(id)
+	}
+
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if token.Method.Alg() != key.algorithm {
+		return nil, fmt.Errorf("unexpected signing algorithm %s", token.Method.Alg())
+	}
+	return key.private.Public(), nil
+}
+
+// current returns the newest key allowed to sign at now. Replicas switch to a
+// scheduled key at its not-before time without reloading.
+func (m *Manager) current(now time.Time) *signingKey {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var current *signingKey
+	for _, key := range m.keys {
+		if !key.notBefore.After(now) && (current == nil || key.notBefore.After(current.notBefore)) {
+			current = key
+		}
+	}
+	return current
+}
+
+func (m *Manager) lookup(id string) (*signingKey, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	key, ok := m.keys[id]
+	return key, ok
+}
+
+func (m *Manager) reloadAllowed() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return time.Since(m.loadedAt) >= minReload
+}
+
+// ValidMethods lists the algorithms accepted when parsing tokens
+func ValidMethods() []string {
+	return []string{AlgorithmEdDSA, AlgorithmRS256}
+}
+
+func signingMethod(algorithm string) jwt.SigningMethod {
+	if algorithm == AlgorithmRS256 {
+		return jwt.SigningMethodRS256
+	}
+	return jwt.SigningMethodEdDSA
+}
+
+// generateKey creates a key pair, returning a random kid and the PKCS #8 PEM private key
+func generateKey(algorithm string) (string, string, error) {
+	var private crypto.Signer
+	var err error
+
+	switch algorithm {
+	case AlgorithmRS256:
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	default:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return "", "", err
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return "", "", err
+	}
+
+	encoded := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	return hex.EncodeToString(id), string(encoded), nil
+}
+
+// encrypt seals a PEM private key, bound to its kid so sealed keys cannot be
+// swapped between rows
+func (m *Manager) encrypt(id, encoded string) (string, error) {
+	nonce := make([]byte, m.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := m.aead.Seal(nonce, nonce, []byte(encoded), []byte(id))
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decrypt opens a sealed private key. Plain PEM is refused, so a key
+// written by anyone without the secret is never used to sign.
+func (m *Manager) decrypt(id, stored string) (crypto.Signer, error) {
+	encoded, ok := strings.CutPrefix(stored, encryptedPrefix)
+	if !ok {
+		return nil, fmt.Errorf("key is not encrypted")
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < m.aead.NonceSize() {
+		return nil, fmt.Errorf("invalid encrypted key")
+	}
+
+	nonce, ciphertext := sealed[:m.aead.NonceSize()], sealed[m.aead.NonceSize():]
+	plain, err := m.aead.Open(nil, nonce, ciphertext, []byte(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt key, the secret may be wrong: %w", err)
+	}
+	return decodePrivateKey(string(plain))
+}
+
+func decodePrivateKey(encoded string) (crypto.Signer, error) {
+	block, _ := pem.Decode([]byte(encoded))
+	if block == nil {
+		return nil, fmt.Errorf("invalid PEM")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	return signer, nil
+}
@@ -0,0 +1,442 @@
+package keys
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"database/sql/driver"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var algorithms = []string{AlgorithmEdDSA, AlgorithmRS256}
+
+type argFunc func(driver.Value) bool
+
+func (f argFunc) Match(v driver.Value) bool { return f(v) }
+
+func newTestManager(t *testing.T, algorithm string) (*Manager, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	manager, err := NewManager(db, Config{
+		Algorithm:        algorithm,
+		RotationInterval: time.Hour,
+		TokenTTL:         15 * time.Minute,
+		Secret:           "test-secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return manager, mock
+}
+
+// storedKey generates a key and returns its kid and the sealed form stored
+// in signing_keys
+func storedKey(t *testing.T, m *Manager, algorithm string) (string, string) {
+	t.Helper()
+	id, encoded, err := generateKey(algorithm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := m.encrypt(id, encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id, sealed
+}
+
+func keyRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"kid", "algorithm", "private_key", "not_before"})
+}
+
+// signed is a not-before time of a key already signing
+var signed = time.Now().Add(-time.Minute)
+
+func checkRows(signing, scheduled bool) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"signing", "scheduled"}).AddRow(signing, scheduled)
+}
+
+func TestNewManager(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+	}{
+		{name: "unknown algorithm", config: Config{Algorithm: "HS256", RotationInterval: time.Hour, Secret: "s"}},
+		{name: "no rotation interval", config: Config{Algorithm: AlgorithmEdDSA, Secret: "s"}},
+		{name: "no secret", config: Config{Algorithm: AlgorithmEdDSA, RotationInterval: time.Hour}},
+		// The next key could not reach JWKS caches before it signs
+		{name: "short rotation interval", config: Config{Algorithm: AlgorithmEdDSA, RotationInterval: 5 * time.Minute, Secret: "s"}},
+	}
+
+	for _, tt := range tests {
+		if _, err := NewManager(nil, tt.config); err == nil {
+			t.Errorf("%s: no error", tt.name)
+		}
+	}
+}
+
+// expectInsert expects a key to be stored, adding it to stored with the
+// not-before time notBefore, and records its kid
+func expectInsert(mock sqlmock.Sqlmock, stored *sqlmock.Rows, algorithm string, notBefore time.Time, kid *string, start ...driver.Value) {
+	args := []driver.Value{
+		argFunc(func(v driver.Value) bool {
+			*kid, _ = v.(string)
+			return *kid != ""
+		}),
+		algorithm,
+		argFunc(func(v driver.Value) bool {
+			sealed, _ := v.(string)
+			stored.AddRow(*kid, algorithm, sealed, notBefore)
+			return strings.HasPrefix(sealed, encryptedPrefix) && !strings.Contains(sealed, "PRIVATE KEY")
+		}),
+		// The key verifies for a token lifetime after it stops signing
+		(time.Hour + 15*time.Minute).Seconds(),
+	}
+	mock.ExpectQuery("INSERT INTO signing_keys").
+		WithArgs(append(args, start...)...).
+		WillReturnRows(sqlmock.NewRows([]string{"not_before"}).AddRow(notBefore))
+}
+
+func TestLoadRotates(t *testing.T) {
+	for _, algorithm := range algorithms {
+		t.Run(algorithm, func(t *testing.T) {
+			m, mock := newTestManager(t, algorithm)
+
+			// A fresh database gets a key signing now and the next one,
+			// published from now but signing an interval later
+			var current, next string
+			stored := keyRows()
+			mock.ExpectBegin()
+			mock.ExpectExec("SELECT pg_advisory_xact_lock").
+				WithArgs(rotationLockID).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec("CREATE TABLE IF NOT EXISTS signing_keys").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectQuery("SELECT kid, private_key FROM signing_keys").WillReturnRows(sqlmock.NewRows([]string{"kid", "private_key"}))
+			mock.ExpectQuery("BOOL_OR").
+				WithArgs(time.Hour.Seconds()).
+				WillReturnRows(checkRows(false, false))
+			expectInsert(mock, stored, algorithm, signed, &current)
+			// Scheduled one interval after the newest key, and no sooner than
+			// a refresh and a JWKS cache lifetime from now
+			expectInsert(mock, stored, algorithm, signed.Add(time.Hour), &next, time.Hour.Seconds(), (6*time.Minute + JWKSMaxAge).Seconds())
+			mock.ExpectExec("DELETE FROM signing_keys WHERE expires_at <= CURRENT_TIMESTAMP").WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectCommit()
+			mock.ExpectQuery("SELECT kid, algorithm, private_key, not_before FROM signing_keys").WillReturnRows(stored)
+
+			if err := m.Load(t.Context()); err != nil {
+				t.Fatal(err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+
+			signedToken, err := m.Sign(jwt.RegisteredClaims{Subject: "7"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			token, err := jwt.Parse(signedToken, m.Keyfunc, jwt.WithValidMethods(ValidMethods()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token.Header["kid"] != current || token.Method.Alg() != algorithm {
+				t.Errorf("token signed by %v with %s, want %s with %s", token.Header["kid"], token.Method.Alg(), current, algorithm)
+			}
+
+			// Both keys are published
+			set := m.JWKS()
+			if len(set.Keys) != 2 {
+				t.Fatalf("JWKS %+v, want %s and %s", set.Keys, next, current)
+			}
+			for _, kid := range []string{current, next} {
+				if set.Keys[0].KeyID != kid && set.Keys[1].KeyID != kid {
+					t.Errorf("JWKS %+v lacks %s", set.Keys, kid)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadSchedulesNextKey(t *testing.T) {
+	m, mock := newTestManager(t, AlgorithmEdDSA)
+	current, currentSealed := storedKey(t, m, AlgorithmEdDSA)
+
+	var next string
+	stored := keyRows()
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT kid, private_key FROM signing_keys").WillReturnRows(sqlmock.NewRows([]string{"kid", "private_key"}))
+	// The scheduled key has started signing, so only a new next key is made
+	mock.ExpectQuery("BOOL_OR").WillReturnRows(checkRows(true, false))
+	expectInsert(mock, stored, AlgorithmEdDSA, signed.Add(time.Hour), &next, time.Hour.Seconds(), (6*time.Minute + JWKSMaxAge).Seconds())
+	mock.ExpectExec("DELETE FROM signing_keys").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	stored.AddRow(current, AlgorithmEdDSA, currentSealed, signed)
+	mock.ExpectQuery("SELECT kid, algorithm, private_key, not_before FROM signing_keys").WillReturnRows(stored)
+
+	if err := m.Load(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The next key is published but does not sign until its time comes
+	if key := m.current(time.Now()); key == nil || key.id != current {
+		t.Errorf("signing with %v, want %s", key, current)
+	}
+	if key := m.current(signed.Add(time.Hour)); key == nil || key.id != next {
+		t.Errorf("after the interval signing with %v, want %s", key, next)
+	}
+	if _, ok := m.lookup(next); !ok {
+		t.Error("the next key is not published")
+	}
+}
+
+func TestLoadKeepsCurrentKey(t *testing.T) {
+	m, mock := newTestManager(t, AlgorithmEdDSA)
+	next, nextSealed := storedKey(t, m, AlgorithmRS256)
+	newer, newerSealed := storedKey(t, m, AlgorithmEdDSA)
+	older, olderSealed := storedKey(t, m, AlgorithmRS256)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT kid, private_key FROM signing_keys").WillReturnRows(sqlmock.NewRows([]string{"kid", "private_key"}))
+	mock.ExpectQuery("BOOL_OR").WillReturnRows(checkRows(true, true))
+	mock.ExpectExec("DELETE FROM signing_keys").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	mock.ExpectQuery("SELECT kid, algorithm, private_key, not_before FROM signing_keys").
+		WillReturnRows(keyRows().
+			AddRow(next, AlgorithmRS256, nextSealed, time.Now().Add(time.Hour)).
+			AddRow(newer, AlgorithmEdDSA, newerSealed, signed).
+			AddRow(older, AlgorithmRS256, olderSealed, signed.Add(-time.Hour)))
+
+	if err := m.Load(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The newest key past its not-before time signs; the scheduled and the
+	// older key are published
+	signedToken, err := m.Sign(jwt.RegisteredClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _, err := jwt.NewParser().ParseUnverified(signedToken, jwt.MapClaims{})
+	if err != nil || token.Header["kid"] != newer {
+		t.Errorf("signed by %v, want %s", token.Header["kid"], newer)
+	}
+
+	set := m.JWKS()
+	if len(set.Keys) != 3 || set.Keys[0].KeyID != next || set.Keys[1].KeyID != newer || set.Keys[2].KeyID != older {
+		t.Errorf("JWKS %+v, want %s, %s then %s", set.Keys, next, newer, older)
+	}
+}
+
+func TestLoadFailsWithoutLock(t *testing.T) {
+	m, mock := newTestManager(t, AlgorithmEdDSA)
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WillReturnError(errors.New("connection reset"))
+	mock.ExpectRollback()
+
+	if err := m.Load(t.Context()); err == nil {
+		t.Error("Load succeeded without the rotation lock")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEncryptPlaintextKeys(t *testing.T) {
+	m, mock := newTestManager(t, AlgorithmEdDSA)
+	id, plain, err := generateKey(AlgorithmEdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sealed string
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT kid, private_key FROM signing_keys WHERE private_key NOT LIKE \\$1").
+		WithArgs(encryptedPrefix + "%").
+		WillReturnRows(sqlmock.NewRows([]string{"kid", "private_key"}).AddRow(id, plain))
+	mock.ExpectExec("UPDATE signing_keys SET private_key = \\$1 WHERE kid = \\$2").
+		WithArgs(argFunc(func(v driver.Value) bool {
+			sealed, _ = v.(string)
+			return true
+		}), id).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("BOOL_OR").WillReturnRows(checkRows(true, true))
+	mock.ExpectExec("DELETE FROM signing_keys").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	if err := m.rotateIfDue(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(sealed, encryptedPrefix) {
+		t.Fatalf("key stored as %q", sealed)
+	}
+	original, _ := decodePrivateKey(plain)
+	decrypted, err := m.decrypt(id, sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !original.Public().(ed25519.PublicKey).Equal(decrypted.Public()) {
+		t.Error("decrypted key differs from the original")
+	}
+}
+
+func TestDecrypt(t *testing.T) {
+	m, _ := newTestManager(t, AlgorithmEdDSA)
+	other, err := NewManager(nil, Config{Algorithm: AlgorithmEdDSA, RotationInterval: time.Hour, Secret: "other-secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id, plain, err := generateKey(AlgorithmRS256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := m.encrypt(id, plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := m.decrypt(id, sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := key.Public().(*rsa.PublicKey); !ok {
+		t.Errorf("decrypted a %T", key.Public())
+	}
+
+	tests := []struct {
+		name    string
+		manager *Manager
+		id      string
+		stored  string
+	}{
+		{name: "wrong secret", manager: other, id: id, stored: sealed},
+		// A sealed key is bound to its row
+		{name: "other kid", manager: m, id: "0123456789abcdef", stored: sealed},
+		// Plain PEM could have been written by anyone with database access
+		{name: "plaintext", manager: m, id: id, stored: plain},
+		{name: "not base64", manager: m, id: id, stored: encryptedPrefix + "!!"},
+		{name: "truncated", manager: m, id: id, stored: encryptedPrefix + base64.StdEncoding.EncodeToString([]byte("short"))},
+		{name: "tampered", manager: m, id: id, stored: sealed[:len(sealed)-4] + "AAAA"},
+	}
+
+	for _, tt := range tests {
+		if _, err := tt.manager.decrypt(tt.id, tt.stored); err == nil {
+			t.Errorf("%s: decrypted", tt.name)
+		}
+	}
+}
+
+func TestKeyfuncReloadsUnknownKid(t *testing.T) {
+	m, mock := newTestManager(t, AlgorithmEdDSA)
+	first, firstSealed := storedKey(t, m, AlgorithmEdDSA)
+	mock.ExpectQuery("SELECT kid, algorithm, private_key").WillReturnRows(keyRows().AddRow(first, AlgorithmEdDSA, firstSealed, signed))
+	if err := m.reload(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another replica rotates, and a token signed with its new key arrives
+	second, secondSealed := storedKey(t, m, AlgorithmRS256)
+	rows := keyRows().AddRow(second, AlgorithmRS256, secondSealed, signed).AddRow(first, AlgorithmEdDSA, firstSealed, signed.Add(-time.Hour))
+	private, err := m.decrypt(second, secondSealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.RegisteredClaims{})
+	token.Header["kid"] = second
+	signed, err := token.SignedString(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Within the throttle window the unknown kid is rejected without a query
+	mock.ExpectQuery("SELECT kid, algorithm, private_key").WillReturnRows(rows)
+	if _, err := jwt.Parse(signed, m.Keyfunc); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("error = %v, want ErrUnknownKey", err)
+	}
+	if err := mock.ExpectationsWereMet(); err == nil {
+		t.Fatal("reloaded within the throttle window")
+	}
+
+	m.loadedAt = time.Now().Add(-minReload)
+	if _, err := jwt.Parse(signed, m.Keyfunc); err != nil {
+		t.Errorf("token from the rotated key: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestKeyfuncRejectsOtherAlgorithm(t *testing.T) {
+	m, mock := newTestManager(t, AlgorithmEdDSA)
+	id, sealed := storedKey(t, m, AlgorithmEdDSA)
+	mock.ExpectQuery("SELECT kid, algorithm, private_key").WillReturnRows(keyRows().AddRow(id, AlgorithmEdDSA, sealed, signed))
+	if err := m.reload(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	token := jwt.New(jwt.SigningMethodRS256)
+	token.Header["kid"] = id
+	if _, err := m.Keyfunc(token); err == nil {
+		t.Error("an RS256 token was accepted for an EdDSA key")
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	m, mock := newTestManager(t, AlgorithmEdDSA)
+	edID, edSealed := storedKey(t, m, AlgorithmEdDSA)
+	rsaID, rsaSealed := storedKey(t, m, AlgorithmRS256)
+	mock.ExpectQuery("SELECT kid, algorithm, private_key").
+		WillReturnRows(keyRows().AddRow(edID, AlgorithmEdDSA, edSealed, signed).AddRow(rsaID, AlgorithmRS256, rsaSealed, signed.Add(-time.Hour)))
+	if err := m.reload(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	set := m.JWKS()
+	if len(set.Keys) != 2 {
+		t.Fatalf("%d keys, want 2", len(set.Keys))
+	}
+
+	ed := set.Keys[0]
+	x, _ := base64.RawURLEncoding.DecodeString(ed.X)
+	if ed.KeyType != "OKP" || ed.Curve != "Ed25519" || ed.Algorithm != AlgorithmEdDSA || ed.Use != "sig" ||
+		!m.keys[edID].private.Public().(ed25519.PublicKey).Equal(ed25519.PublicKey(x)) {
+		t.Errorf("Ed25519 JWK %+v", ed)
+	}
+
+	rs := set.Keys[1]
+	n, _ := base64.RawURLEncoding.DecodeString(rs.N)
+	e, _ := base64.RawURLEncoding.DecodeString(rs.E)
+	public := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	if rs.KeyType != "RSA" || rs.Algorithm != AlgorithmRS256 || !m.keys[rsaID].private.Public().(*rsa.PublicKey).Equal(public) {
+		t.Errorf("RSA JWK %+v", rs)
+	}
+	if rs.X != "" || rs.Curve != "" || ed.N != "" || ed.E != "" {
+		t.Errorf("JWKS mixes key fields: %+v", set)
+	}
+}
@@ -1,19 +1,34 @@
 package main
 
 import (
+	"auth-service/apperror"
+	"auth-service/cache"
 	"auth-service/database"
 	"auth-service/handlers"
+	"auth-service/health"
+	"auth-service/keys"
+	"auth-service/logger"
+	"auth-service/metrics"
 	"auth-service/middleware"
+	"auth-service/oidc"
+	"auth-service/outbox"
+	"auth-service/rabbitmq"
+	"auth-service/ratelimit"
+	"auth-service/tracing"
+	"auth-service/verification"
 	"context"
-	"log"
+	"errors"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
 func main() {
@@ -21,39 +36,298 @@ func main() {
 	// nolint:errcheck
 	godotenv.Load()
 
+	// Initialize structured logging
+	logger.Init("auth-service")
+
+	// Initialize tracing
+	shutdownTracing, err := tracing.Init(context.Background(), "auth-service")
+	if err != nil {
+		logger.Fatal("failed to initialize tracing", "error", err)
+	}
+	defer shutdownTracing(context.Background())
+
 	// Initialize database
 	db, err := database.Connect()
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		logger.Fatal("failed to connect to database", "error", err)
 	}
 	defer db.Close()
 
 	// Initialize database schema
 	if err := database.InitDB(db); err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+		logger.Fatal("failed to initialize database", "error", err)
+	}
+	if err := database.EnsureUniqueEmails(db); err != nil {
+		logger.Fatal("failed to check for duplicate emails", "error", err)
+	}
+
+	// Grant the admin role to the accounts listed in ADMIN_EMAILS
+	if adminEmails := os.Getenv("ADMIN_EMAILS"); adminEmails != "" {
+		if err := database.GrantAdmins(db, strings.Split(adminEmails, ",")); err != nil {
+			logger.Fatal("failed to grant admin role", "error", err)
+		}
+	}
+
+	// Initialize Redis for shared rate limits; limits fall back to memory without it
+	redisClient, err := cache.Connect()
+	if err != nil {
+		slog.Warn("Redis unavailable, rate limits will be tracked in memory", "error", err)
+	} else {
+		defer redisClient.Close()
+	}
+
+	// Initialize RabbitMQ for user_registered events
+	rmq, err := rabbitmq.Connect(
+		os.Getenv("RABBITMQ_HOST"),
+		os.Getenv("RABBITMQ_PORT"),
+		os.Getenv("RABBITMQ_USER"),
+		os.Getenv("RABBITMQ_PASSWORD"),
+	)
+	if err != nil {
+		logger.Fatal("failed to connect to RabbitMQ", "error", err)
+	}
+	defer rmq.Close()
+
+	// Load the JWT key set, generating the first key on a fresh database
+	rotation, err := time.ParseDuration(envOrDefault("JWT_KEY_ROTATION", "720h"))
+	if err != nil {
+		logger.Fatal("invalid JWT_KEY_ROTATION", "error", err)
+	}
+	keyManager, err := keys.NewManager(db, keys.Config{
+		Algorithm:        envOrDefault("JWT_SIGNING_ALG", keys.AlgorithmEdDSA),
+		RotationInterval: rotation,
+		TokenTTL:         handlers.AccessTokenTTL,
+		Secret:           os.Getenv("JWT_KEY_SECRET"),
+	})
+	if err != nil {
+		logger.Fatal("invalid JWT signing configuration", "error", err)
+	}
+	if err := keyManager.Load(context.Background()); err != nil {
+		logger.Fatal("failed to load signing keys", "error", err)
+	}
+	rotationCtx, stopRotation := context.WithCancel(context.Background())
+	defer stopRotation()
+	go keyManager.Run(rotationCtx)
+
+	// Email verification and login challenge tokens never leave auth-service,
+	// so they stay HMAC-signed with a secret only this service holds
+	tokenSecret := os.Getenv("TOKEN_SECRET")
+	if tokenSecret == "" {
+		logger.Fatal("TOKEN_SECRET is required")
+	}
+	verifier := verification.NewSigner(envOrDefault("EMAIL_TOKEN_SECRET", tokenSecret), verification.AudienceEmailVerification, 24*time.Hour)
+
+	// Challenges bridge the password and TOTP steps of a two-factor login
+	challenger := verification.NewSigner(tokenSecret, verification.AudienceLoginChallenge, 5*time.Minute)
+
+	// Email change links prove ownership of the new address
+	emailChanger := verification.NewSigner(envOrDefault("EMAIL_TOKEN_SECRET", tokenSecret), verification.AudienceEmailChange, 24*time.Hour)
+
+	// Events that must survive a RabbitMQ outage go through the outbox
+	events := outbox.NewRelay(db, rmq, 30*time.Second)
+	if err := events.Init(context.Background()); err != nil {
+		logger.Fatal("failed to initialize outbox", "error", err)
 	}
+	relayCtx, stopRelay := context.WithCancel(context.Background())
+	defer stopRelay()
+	go events.Run(relayCtx)
 
 	// Initialize handlers
-	authHandler := handlers.NewAuthHandler(db)
+	authHandler := handlers.NewAuthHandler(db, rmq, keyManager, verifier, challenger, emailChanger, events)
+
+	// External login providers, none unless OIDC_PROVIDERS is set
+	oidcConfigs, err := oidc.LoadConfigs(envOrDefault("AUTH_PUBLIC_URL", "http://localhost:8001"))
+	if err != nil {
+		logger.Fatal("invalid OIDC configuration", "error", err)
+	}
+	oidcHandler := handlers.NewOIDCHandler(authHandler, oidc.NewRegistry(oidcConfigs))
+	apiKeyHandler := handlers.NewAPIKeyHandler(db)
+	addressHandler := handlers.NewAddressHandler(db)
 
 	// Setup Gin router
-	router := gin.Default()
+	router := gin.New()
 
-	// Health check endpoint
-	router.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"status": "healthy", "service": "auth-service"})
+	// Only proxies listed in TRUSTED_PROXIES may set X-Forwarded-For;
+	// otherwise any client could choose the IP that rate limits key on
+	var trustedProxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			trustedProxies = append(trustedProxies, proxy)
+		}
+	}
+	if err := router.SetTrustedProxies(trustedProxies); err != nil {
+		logger.Fatal("invalid TRUSTED_PROXIES", "error", err)
+	}
+
+	router.Use(gin.Recovery())
+	router.Use(middleware.RequestID())
+	router.Use(otelgin.Middleware("auth-service"))
+	router.Use(metrics.Middleware())
+	router.Use(middleware.RequestLogger())
+	router.Use(middleware.ErrorHandler())
+	apperror.UseJSONFieldNames()
+
+	// Prometheus metrics endpoint
+	router.GET("/metrics", metrics.Handler())
+
+	// Liveness and readiness probes
+	checker := health.NewChecker("auth-service", 2*time.Second)
+	checker.Register("database", db.PingContext)
+	if redisClient != nil {
+		checker.Register("redis", func(ctx context.Context) error {
+			return redisClient.Ping(ctx).Err()
+		})
+	}
+	checker.Register("rabbitmq", func(ctx context.Context) error {
+		if !rmq.IsConnected() {
+			return errors.New("connection closed")
+		}
+		return nil
 	})
+	router.GET("/livez", gin.WrapF(checker.LivenessHandler))
+	router.GET("/readyz", gin.WrapF(checker.ReadinessHandler))
+	// Kept for monitors configured before /readyz existed
+	router.GET("/health", gin.WrapF(checker.ReadinessHandler))
+
+	// Public keys for validating access tokens
+	router.GET("/.well-known/jwks.json", gin.WrapF(keyManager.JWKSHandler))
+
+	// Rate limits, overridable as "<requests>/<window>"
+	limiter := ratelimit.New(redisClient)
+	loginLimit := middleware.RateLimit(limiter,
+		middleware.RateLimitRule{
+			Name:  "login_ip",
+			Limit: ratelimit.FromEnv("RATE_LIMIT_LOGIN_IP", ratelimit.Limit{Requests: 20, Window: time.Minute}),
+			Key:   middleware.ClientIPKey,
+		},
+		middleware.RateLimitRule{
+			Name:  "login_email",
+			Limit: ratelimit.FromEnv("RATE_LIMIT_LOGIN_EMAIL", ratelimit.Limit{Requests: 5, Window: time.Minute}),
+			Key:   middleware.EmailKey,
+		},
+	)
+	registerLimit := middleware.RateLimit(limiter,
+		middleware.RateLimitRule{
+			Name:  "register_ip",
+			Limit: ratelimit.FromEnv("RATE_LIMIT_REGISTER_IP", ratelimit.Limit{Requests: 5, Window: time.Hour}),
+			Key:   middleware.ClientIPKey,
+		},
+	)
+
+	resendVerificationLimit := middleware.RateLimit(limiter,
+		middleware.RateLimitRule{
+			Name:  "resend_verification_user",
+			Limit: ratelimit.FromEnv("RATE_LIMIT_RESEND_VERIFICATION", ratelimit.Limit{Requests: 3, Window: time.Hour}),
+			Key:   middleware.UserKey,
+		},
+	)
+
+	forgotPasswordLimit := middleware.RateLimit(limiter,
+		middleware.RateLimitRule{
+			Name:  "forgot_password_ip",
+			Limit: ratelimit.FromEnv("RATE_LIMIT_FORGOT_PASSWORD_IP", ratelimit.Limit{Requests: 10, Window: time.Hour}),
+			Key:   middleware.ClientIPKey,
+		},
+		middleware.RateLimitRule{
+			Name:  "forgot_password_email",
+			Limit: ratelimit.FromEnv("RATE_LIMIT_FORGOT_PASSWORD_EMAIL", ratelimit.Limit{Requests: 3, Window: time.Hour}),
+			Key:   middleware.EmailKey,
+		},
+	)
+	resetPasswordLimit := middleware.RateLimit(limiter,
+		middleware.RateLimitRule{
+			Name:  "reset_password_ip",
+			Limit: ratelimit.FromEnv("RATE_LIMIT_RESET_PASSWORD_IP", ratelimit.Limit{Requests: 10, Window: time.Hour}),
+			Key:   middleware.ClientIPKey,
+		},
+	)
+	changePasswordLimit := middleware.RateLimit(limiter,
+		middleware.RateLimitRule{
+			Name:  "change_password_user",
+			Limit: ratelimit.FromEnv("RATE_LIMIT_CHANGE_PASSWORD", ratelimit.Limit{Requests: 5, Window: 15 * time.Minute}),
+			Key:   middleware.UserKey,
+		},
+	)
+
+	loginTwoFactorLimit := middleware.RateLimit(limiter,
+		middleware.RateLimitRule{
+			Name:  "login_2fa_ip",
+			Limit: ratelimit.FromEnv("RATE_LIMIT_LOGIN_2FA_IP", ratelimit.Limit{Requests: 20, Window: time.Minute}),
+			Key:   middleware.ClientIPKey,
+		},
+	)
+	twoFactorLimit := middleware.RateLimit(limiter,
+		middleware.RateLimitRule{
+			Name:  "two_factor_user",
+			Limit: ratelimit.FromEnv("RATE_LIMIT_TWO_FACTOR", ratelimit.Limit{Requests: 10, Window: 15 * time.Minute}),
+			Key:   middleware.UserKey,
+		},
+	)
+
+	changeEmailLimit := middleware.RateLimit(limiter,
+		middleware.RateLimitRule{
+			Name:  "change_email_user",
+			Limit: ratelimit.FromEnv("RATE_LIMIT_CHANGE_EMAIL", ratelimit.Limit{Requests: 3, Window: time.Hour}),
+			Key:   middleware.UserKey,
+		},
+	)
+
+	accountDataLimit := middleware.RateLimit(limiter,
+		middleware.RateLimitRule{
+			Name:  "account_data_user",
+			Limit: ratelimit.FromEnv("RATE_LIMIT_ACCOUNT_DATA", ratelimit.Limit{Requests: 5, Window: time.Hour}),
+			Key:   middleware.UserKey,
+		},
+	)
+
+	oidcLimit := middleware.RateLimit(limiter,
+		middleware.RateLimitRule{
+			Name:  "oidc_ip",
+			Limit: ratelimit.FromEnv("RATE_LIMIT_OIDC_IP", ratelimit.Limit{Requests: 30, Window: time.Minute}),
+			Key:   middleware.ClientIPKey,
+		},
+	)
 
 	// Public routes
-	router.POST("/register", authHandler.Register)
-	router.POST("/login", authHandler.Login)
+	router.POST("/register", registerLimit, authHandler.Register)
+	router.POST("/login", loginLimit, authHandler.Login)
+	router.POST("/login/2fa", loginTwoFactorLimit, authHandler.LoginTwoFactor)
+	router.GET("/oidc/providers", oidcHandler.ListProviders)
+	router.GET("/oidc/:provider/login", oidcLimit, oidcHandler.Login)
+	router.GET("/oidc/:provider/callback", oidcLimit, oidcHandler.Callback)
+	router.GET("/verify-email", authHandler.VerifyEmail)
+	router.GET("/profile/email/confirm", authHandler.ConfirmEmailChange)
+	router.POST("/password/forgot", forgotPasswordLimit, authHandler.ForgotPassword)
+	router.POST("/password/reset", resetPasswordLimit, authHandler.ResetPassword)
 
-	// Protected routes
+	// Protected routes, for user sessions only
 	protected := router.Group("/")
-	protected.Use(middleware.AuthMiddleware())
+	protected.Use(middleware.AuthMiddleware(db, keyManager.Keyfunc), middleware.RequireUser())
 	{
 		protected.GET("/profile", authHandler.GetProfile)
 		protected.PUT("/profile", authHandler.UpdateProfile)
+		protected.DELETE("/profile", accountDataLimit, authHandler.DeleteAccount)
+		protected.GET("/profile/export", accountDataLimit, authHandler.ExportData)
+		protected.PUT("/profile/email", changeEmailLimit, authHandler.ChangeEmail)
+		protected.GET("/profile/addresses", addressHandler.ListAddresses)
+		protected.POST("/profile/addresses", addressHandler.CreateAddress)
+		protected.PUT("/profile/addresses/:id", addressHandler.UpdateAddress)
+		protected.PUT("/profile/addresses/:id/default", addressHandler.SetDefaultAddress)
+		protected.DELETE("/profile/addresses/:id", addressHandler.DeleteAddress)
+		protected.POST("/verify-email/resend", resendVerificationLimit, authHandler.ResendVerification)
+		protected.PUT("/password", changePasswordLimit, authHandler.ChangePassword)
+		protected.POST("/2fa/enroll", twoFactorLimit, authHandler.EnrollTwoFactor)
+		protected.POST("/2fa/confirm", twoFactorLimit, authHandler.ConfirmTwoFactor)
+		protected.POST("/2fa/disable", twoFactorLimit, authHandler.DisableTwoFactor)
+	}
+
+	// Admin routes; API keys cannot manage API keys
+	admin := protected.Group("/admin")
+	admin.Use(middleware.RequireAdmin(db, ""))
+	{
+		admin.POST("/api-keys", apiKeyHandler.CreateAPIKey)
+		admin.GET("/api-keys", apiKeyHandler.ListAPIKeys)
+		admin.DELETE("/api-keys/:id", apiKeyHandler.RevokeAPIKey)
 	}
 
 	// Get service port
@@ -70,9 +344,9 @@ func main() {
 
 	// Start server in a goroutine
 	go func() {
-		log.Printf("Auth Service starting on port %s", port)
+		slog.Info("auth service starting", "port", port)
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Failed to start server: %v", err)
+			logger.Fatal("failed to start server", "error", err)
 		}
 	}()
 
@@ -81,15 +355,22 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
-	log.Println("Shutting down server...")
+	slog.Info("shutting down server")
 
 	// Graceful shutdown with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatal("Server forced to shutdown:", err)
+		logger.Fatal("server forced to shutdown", "error", err)
 	}
 
-	log.Println("Server exited")
+	slog.Info("server exited")
+}
+
+func envOrDefault(key, def string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return def
 }
@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMiddlewareRouteLabels(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	httpRequestsTotal.Reset()
+	httpRequestDuration.Reset()
+
+	router := gin.New()
+	router.Use(Middleware())
+	router.GET("/addresses/:id", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for _, path := range []string{"/addresses/1", "/addresses/2", "/addresses/3", "/wp-login.php", "/addresses/1/unknown"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	// Requests are labelled by route template, so IDs and probes of unknown
+	// paths do not add series
+	if n := testutil.ToFloat64(httpRequestsTotal.WithLabelValues("GET", "/addresses/:id", "200")); n != 3 {
+		t.Errorf("%v requests to /addresses/:id, want 3", n)
+	}
+	if n := testutil.ToFloat64(httpRequestsTotal.WithLabelValues("GET", "unmatched", "404")); n != 2 {
+		t.Errorf("%v unmatched requests, want 2", n)
+	}
+	if n := testutil.CollectAndCount(httpRequestsTotal); n != 2 {
+		t.Errorf("%d request series, want 2", n)
+	}
+	if n := testutil.CollectAndCount(httpRequestDuration); n != 2 {
+		t.Errorf("%d latency series, want 2", n)
+	}
+}
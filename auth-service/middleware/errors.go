@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"auth-service/apperror"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const problemContentType = "application/problem+json"
+
+// errorBody is the default error envelope, compatible with the success/error
+// fields clients already read
+type errorBody struct {
+	Success   bool                  `json:"success"`
+	Error     string                `json:"error"`
+	Code      apperror.Code         `json:"code"`
+	Details   []apperror.FieldError `json:"details,omitempty"`
+	RequestID string                `json:"request_id,omitempty"`
+}
+
+// problemBody is an RFC 7807 problem details document
+type problemBody struct {
+	Type      string                `json:"type"`
+	Title     string                `json:"title"`
+	Status    int                   `json:"status"`
+	Detail    string                `json:"detail"`
+	Instance  string                `json:"instance"`
+	Code      apperror.Code         `json:"code"`
+	Errors    []apperror.FieldError `json:"errors,omitempty"`
+	RequestID string                `json:"request_id,omitempty"`
+}
+
+// ErrorHandler renders the last error attached with c.Error as a JSON response.
+// Clients get RFC 7807 problem+json when they ask for it in the Accept header
+// or when ERROR_FORMAT=problem is set.
+func ErrorHandler() gin.HandlerFunc {
+	problemByDefault := os.Getenv("ERROR_FORMAT") == "problem"
+
+	return func(c *gin.Context) {
+		c.Next()
+
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+
+		appErr := apperror.From(c.Errors.Last().Err)
+		status := appErr.Status()
+		requestID := c.GetString("request_id")
+
+		// Never expose the cause of internal errors to clients
+		message := appErr.Message
+		if status >= http.StatusInternalServerError && message == "" {
+			message = http.StatusText(status)
+		}
+
+		if problemByDefault || strings.Contains(c.GetHeader("Accept"), problemContentType) {
+			c.Header("Content-Type", problemContentType)
+			c.JSON(status, problemBody{
+				Type:      "urn:problem-type:" + strings.ToLower(string(appErr.Code)),
+				Title:     http.StatusText(status),
+				Status:    status,
+				Detail:    message,
+				Instance:  c.Request.URL.Path,
+				Code:      appErr.Code,
+				Errors:    appErr.Fields,
+				RequestID: requestID,
+			})
+			return
+		}
+
+		c.JSON(status, errorBody{
+			Success:   false,
+			Error:     message,
+			Code:      appErr.Code,
+			Details:   appErr.Fields,
+			RequestID: requestID,
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package middleware
+
+import (
+	"auth-service/apperror"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newErrorRouter(err error) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(RequestID(), ErrorHandler())
+	router.GET("/fail", func(c *gin.Context) { c.Error(err) })
+	router.GET("/written", func(c *gin.Context) {
+		c.Error(err)
+		c.JSON(http.StatusOK, gin.H{"success": true})
+	})
+	return router
+}
+
+func TestErrorHandler(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		wantStatus  int
+		wantCode    string
+		wantMessage string
+		wantDetails int
+	}{
+		{
+			name:        "app error",
+			err:         apperror.New(apperror.CodeNotFound, "Not found"),
+			wantStatus:  http.StatusNotFound,
+			wantCode:    "NOT_FOUND",
+			wantMessage: "Not found",
+		},
+		{
+			name:        "validation",
+			err:         apperror.InvalidField("email", "email", "must be a valid email address"),
+			wantStatus:  http.StatusBadRequest,
+			wantCode:    "VALIDATION_ERROR",
+			wantMessage: "Request validation failed",
+			wantDetails: 1,
+		},
+		{
+			name:        "internal",
+			err:         apperror.Internal(errors.New("pq: password authentication failed"), "Database error"),
+			wantStatus:  http.StatusInternalServerError,
+			wantCode:    "INTERNAL_ERROR",
+			wantMessage: "Database error",
+		},
+		{
+			name:        "plain error",
+			err:         errors.New("pq: password authentication failed"),
+			wantStatus:  http.StatusInternalServerError,
+			wantCode:    "INTERNAL_ERROR",
+			wantMessage: "Internal server error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/fail", nil)
+			req.Header.Set(RequestIDHeader, "req-1")
+			rec := httptest.NewRecorder()
+			newErrorRouter(tt.err).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			// The cause is logged, never returned
+			if strings.Contains(rec.Body.String(), "pq:") {
+				t.Errorf("internal error text in the response: %s", rec.Body)
+			}
+
+			var body struct {
+				Success   bool                  `json:"success"`
+				Error     string                `json:"error"`
+				Code      string                `json:"code"`
+				Details   []apperror.FieldError `json:"details"`
+				RequestID string                `json:"request_id"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if body.Success || body.Code != tt.wantCode || body.Error != tt.wantMessage || body.RequestID != "req-1" {
+				t.Errorf("body %+v, want code %s, error %q and request ID req-1", body, tt.wantCode, tt.wantMessage)
+			}
+			if len(body.Details) != tt.wantDetails {
+				t.Errorf("%d details, want %d", len(body.Details), tt.wantDetails)
+			}
+		})
+	}
+}
+
+func TestErrorHandlerProblemJSON(t *testing.T) {
+	err := apperror.InvalidField("email", "email", "must be a valid email address")
+
+	req := httptest.NewRequest(http.MethodGet, "/fail", nil)
+	req.Header.Set("Accept", "application/problem+json")
+	req.Header.Set(RequestIDHeader, "req-1")
+	rec := httptest.NewRecorder()
+	newErrorRouter(err).ServeHTTP(rec, req)
+
+	if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "application/problem+json") {
+		t.Errorf("content type %q", got)
+	}
+
+	var body struct {
+		Type      string                `json:"type"`
+		Status    int                   `json:"status"`
+		Detail    string                `json:"detail"`
+		Instance  string                `json:"instance"`
+		Code      string                `json:"code"`
+		Errors    []apperror.FieldError `json:"errors"`
+		RequestID string                `json:"request_id"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Type != "urn:problem-type:validation_error" || body.Status != http.StatusBadRequest || body.Instance != "/fail" ||
+		body.Code != "VALIDATION_ERROR" || len(body.Errors) != 1 || body.RequestID != "req-1" {
+		t.Errorf("problem %+v", body)
+	}
+}
+
+func TestErrorHandlerKeepsWrittenResponse(t *testing.T) {
+	rec := httptest.NewRecorder()
+	newErrorRouter(apperror.New(apperror.CodeConflict, "Conflict")).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/written", nil))
+
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "CONFLICT") {
+		t.Errorf("status %d: %s", rec.Code, rec.Body)
+	}
+}
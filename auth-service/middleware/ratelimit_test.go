@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"auth-service/ratelimit"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newRateLimitedRouter(t *testing.T, trustedProxies []string, limit ratelimit.Limit) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	router := gin.New()
+	if err := router.SetTrustedProxies(trustedProxies); err != nil {
+		t.Fatal(err)
+	}
+	router.Use(ErrorHandler())
+	router.GET("/limited", RateLimit(ratelimit.NewMemoryLimiter(), RateLimitRule{
+		Name:  "test",
+		Limit: limit,
+		Key:   ClientIPKey,
+	}), func(c *gin.Context) {
+		c.Status(http.StatusNoContent)
+	})
+	return router
+}
+
+func sendLimited(router *gin.Engine, remoteAddr, forwardedFor string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/limited", nil)
+	req.RemoteAddr = remoteAddr
+	if forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	router := newRateLimitedRouter(t, nil, ratelimit.Limit{Requests: 2, Window: 90 * time.Second})
+
+	tests := []struct {
+		wantStatus     int
+		wantRemaining  string
+		wantRetryAfter string
+	}{
+		{wantStatus: http.StatusNoContent, wantRemaining: "1"},
+		{wantStatus: http.StatusNoContent, wantRemaining: "0"},
+		{wantStatus: http.StatusTooManyRequests, wantRemaining: "0", wantRetryAfter: "90"},
+	}
+
+	for i, tt := range tests {
+		rec := sendLimited(router, "192.0.2.1:1234", "")
+		if rec.Code != tt.wantStatus {
+			t.Errorf("request %d: status %d, want %d", i+1, rec.Code, tt.wantStatus)
+		}
+		if got := rec.Header().Get("X-RateLimit-Limit"); got != "2" {
+			t.Errorf("request %d: X-RateLimit-Limit %q, want 2", i+1, got)
+		}
+		if got := rec.Header().Get("X-RateLimit-Remaining"); got != tt.wantRemaining {
+			t.Errorf("request %d: X-RateLimit-Remaining %q, want %q", i+1, got, tt.wantRemaining)
+		}
+		if got := rec.Header().Get("Retry-After"); got != tt.wantRetryAfter {
+			t.Errorf("request %d: Retry-After %q, want %q", i+1, got, tt.wantRetryAfter)
+		}
+	}
+}
+
+func TestRateLimitIgnoresUntrustedForwardedFor(t *testing.T) {
+	router := newRateLimitedRouter(t, nil, ratelimit.Limit{Requests: 1, Window: time.Minute})
+
+	sendLimited(router, "192.0.2.1:1234", "203.0.113.1")
+	// A new X-Forwarded-For from the same client must not reset its limit
+	if rec := sendLimited(router, "192.0.2.1:1234", "203.0.113.2"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("spoofed X-Forwarded-For: status %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestRateLimitTrustedProxy(t *testing.T) {
+	router := newRateLimitedRouter(t, []string{"10.0.0.0/8"}, ratelimit.Limit{Requests: 1, Window: time.Minute})
+
+	// Behind a trusted proxy, each forwarded client has its own limit
+	sendLimited(router, "10.0.0.5:1234", "203.0.113.1")
+	if rec := sendLimited(router, "10.0.0.5:1234", "203.0.113.2"); rec.Code != http.StatusNoContent {
+		t.Errorf("second client behind the proxy: status %d, want %d", rec.Code, http.StatusNoContent)
+	}
+	if rec := sendLimited(router, "10.0.0.5:1234", "203.0.113.1"); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("first client again: status %d, want %d", rec.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestSetRetryAfter(t *testing.T) {
+	tests := []struct {
+		wait time.Duration
+		want string
+	}{
+		{wait: 0, want: "1"},
+		{wait: 200 * time.Millisecond, want: "1"},
+		{wait: time.Second, want: "1"},
+		{wait: 1500 * time.Millisecond, want: "2"},
+		{wait: time.Hour, want: "3600"},
+	}
+
+	gin.SetMode(gin.TestMode)
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		SetRetryAfter(c, tt.wait)
+		if got := c.Writer.Header().Get("Retry-After"); got != tt.want {
+			t.Errorf("SetRetryAfter(%v) = %q, want %q", tt.wait, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// DefaultCurrency is the currency of the catalog unless stated otherwise
+const DefaultCurrency = "IDR"
+
+// exponents maps the supported ISO 4217 currencies to their number of minor
+// unit digits
+var exponents = map[string]int{
+	"IDR": 2,
+	"USD": 2,
+	"EUR": 2,
+	"GBP": 2,
+	"SGD": 2,
+	"MYR": 2,
+	"AUD": 2,
+	"JPY": 0,
+}
+
+// style is how amounts of a currency are written for people
+type style struct {
+	symbol    string
+	thousands string
+	decimal   string
+	// space separates the symbol from the digits
+	space bool
+}
+
+var styles = map[string]style{
+	"IDR": {symbol: "Rp", thousands: ".", decimal: ",", space: true},
+	"USD": {symbol: "$", thousands: ",", decimal: "."},
+	"EUR": {symbol: "€", thousands: ".", decimal: ","},
+	"GBP": {symbol: "£", thousands: ",", decimal: "."},
+	"SGD": {symbol: "S$", thousands: ",", decimal: "."},
+	"MYR": {symbol: "RM", thousands: ",", decimal: ".", space: true},
+	"AUD": {symbol: "A$", thousands: ",", decimal: "."},
+	"JPY": {symbol: "¥", thousands: ",", decimal: "."},
+}
+
+var (
+	ErrUnknownCurrency  = errors.New("unknown currency")
+	ErrCurrencyMismatch = errors.New("currency mismatch")
+	ErrOverflow         = errors.New("amount out of range")
+	ErrInvalidAmount    = errors.New("invalid amount")
+)
+
+// Money is an exact amount in integer minor units (e.g. sen for IDR) of an
+// ISO 4217 currency. Amounts are stored in BIGINT columns in minor units and
+// encoded in JSON as {"amount": "15999000.00", "currency": "IDR"}, keeping
+// the decimal as a string so clients never round it through a float.
+type Money struct {
+	Amount   int64
+	Currency string
+}
+
+// New returns minor units of currency
+func New(minor int64, currency string) Money {
+	return Money{Amount: minor, Currency: currency}
+}
+
+// Zero returns a zero amount of currency
+func Zero(currency string) Money {
+	return Money{Currency: currency}
+}
+
+// Exponent returns the number of minor unit digits of currency
+func Exponent(currency string) (int, error) {
+	exponent, ok := exponents[currency]
+	if !ok {
+		return 0, fmt.Errorf("%w %q", ErrUnknownCurrency, currency)
+	}
+	return exponent, nil
+}
+
+// IsSupported reports whether currency is a known ISO 4217 code
+func IsSupported(currency string) bool {
+	_, ok := exponents[currency]
+	return ok
+}
+
+// Parse reads a decimal string such as "15999000.50" as an amount of
+// currency. More fractional digits than the currency has are rejected
+// unless they are zeros, so no value is silently rounded.
+func Parse(amount, currency string) (Money, error) {
+	exponent, err := Exponent(currency)
+	if err != nil {
+		return Money{}, err
+	}
+
+	value, ok := new(big.Rat).SetString(amount)
+	if !ok || strings.ContainsAny(amount, "eE/") {
+		return Money{}, fmt.Errorf("%w %q", ErrInvalidAmount, amount)
+	}
+
+	value.Mul(value, new(big.Rat).SetInt(Pow10(exponent)))
+	if !value.IsInt() {
+		return Money{}, fmt.Errorf("%w %q: more than %d decimal places for %s", ErrInvalidAmount, amount, exponent, currency)
+	}
+
+	return fromBig(value.Num(), currency)
+}
+
+// MustParse is Parse for constants, panicking on invalid input
+func MustParse(amount, currency string) Money {
+	m, err := Parse(amount, currency)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// Add returns m + other, which must be in the same currency
+func (m Money) Add(other Money) (Money, error) {
+	if m.Currency != other.Currency {
+		return Money{}, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, other.Currency)
+	}
+
+	sum := m.Amount + other.Amount
+	if (other.Amount > 0 && sum < m.Amount) || (other.Amount < 0 && sum > m.Amount) {
+		return Money{}, ErrOverflow
+	}
+	return Money{Amount: sum, Currency: m.Currency}, nil
+}
+
+// Sub returns m - other, which must be in the same currency
+func (m Money) Sub(other Money) (Money, error) {
+	if other.Amount == math.MinInt64 {
+		return Money{}, ErrOverflow
+	}
+	return m.Add(Money{Amount: -other.Amount, Currency: other.Currency})
+}
+
+// Mul returns m multiplied by a whole quantity, which is always exact
+func (m Money) Mul(quantity int64) (Money, error) {
+	product := new(big.Int).Mul(big.NewInt(m.Amount), big.NewInt(quantity))
+	return fromBig(product, m.Currency)
+}
+
+// MulRat returns m * num / den rounded to the nearest minor unit, with
+// halves rounded away from zero (so 0.5 sen becomes 1 sen and -0.5 becomes
+// -1). Use it for rates, percentages and proportional splits.
+func (m Money) MulRat(num, den int64) (Money, error) {
+	if den == 0 {
+		return Money{}, errors.New("division by zero")
+	}
+
+	value := new(big.Rat).SetFrac(new(big.Int).Mul(big.NewInt(m.Amount), big.NewInt(num)), big.NewInt(den))
+	return fromBig(roundHalfAway(value), m.Currency)
+}
+
+// IsZero reports whether the amount is zero
+func (m Money) IsZero() bool {
+	return m.Amount == 0
+}
+
+// IsNegative reports whether the amount is below zero
+func (m Money) IsNegative() bool {
+	return m.Amount < 0
+}
+
+// Cmp compares two amounts of the same currency, returning -1, 0 or +1
+func (m Money) Cmp(other Money) (int, error) {
+	if m.Currency != other.Currency {
+		return 0, fmt.Errorf("%w: %s and %s", ErrCurrencyMismatch, m.Currency, other.Currency)
+	}
+	switch {
+	case m.Amount < other.Amount:
+		return -1, nil
+	case m.Amount > other.Amount:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// Decimal formats the amount in major units with all minor digits, e.g. "15999000.00"
+func (m Money) Decimal() string {
+	exponent, err := Exponent(m.Currency)
+	if err != nil {
+		exponent = 0
+	}
+
+	digits := strconv.FormatInt(m.Amount, 10)
+	sign := ""
+	if strings.HasPrefix(digits, "-") {
+		sign, digits = "-", digits[1:]
+	}
+	if exponent == 0 {
+		return sign + digits
+	}
+
+	if len(digits) <= exponent {
+		digits = strings.Repeat("0", exponent-len(digits)+1) + digits
+	}
+	split := len(digits) - exponent
+	return sign + digits[:split] + "." + digits[split:]
+}
+
+// String returns the amount with its currency, e.g. "IDR 15999000.00"
+func (m Money) String() string {
+	return m.Currency + " " + m.Decimal()
+}
+
+// Format writes the amount the way customers of the currency expect, e.g.
+// "Rp 15.999.000,00" or "$1,234.56", for emails and documents
+func (m Money) Format() string {
+	st, ok := styles[m.Currency]
+	if !ok {
+		return m.String()
+	}
+
+	decimal := m.Decimal()
+	sign := ""
+	if strings.HasPrefix(decimal, "-") {
+		sign, decimal = "-", decimal[1:]
+	}
+
+	whole, fraction, _ := strings.Cut(decimal, ".")
+	var grouped strings.Builder
+	for i, digit := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			grouped.WriteString(st.thousands)
+		}
+		grouped.WriteRune(digit)
+	}
+	if fraction != "" {
+		grouped.WriteString(st.decimal + fraction)
+	}
+
+	symbol := st.symbol
+	if st.space {
+		symbol += " "
+	}
+	return sign + symbol + grouped.String()
+}
+
+// Convert returns m in another currency. rate is the price of one major unit
+// of m's currency in major units of currency; the result is rounded to the
+// nearest minor unit, halves away from zero.
+func (m Money) Convert(currency string, rate *big.Rat) (Money, error) {
+	from, err := Exponent(m.Currency)
+	if err != nil {
+		return Money{}, err
+	}
+	to, err := Exponent(currency)
+	if err != nil {
+		return Money{}, err
+	}
+	if rate.Sign() <= 0 {
+		return Money{}, errors.New("exchange rate must be positive")
+	}
+
+	value := new(big.Rat).Mul(new(big.Rat).SetInt64(m.Amount), rate)
+	value.Mul(value, new(big.Rat).SetFrac(Pow10(to), Pow10(from)))
+	return fromBig(roundHalfAway(value), currency)
+}
+
+// MarshalJSON encodes the amount as a decimal string alongside its currency
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Amount   string `json:"amount"`
+		Currency string `json:"currency"`
+	}{m.Decimal(), m.Currency})
+}
+
+// UnmarshalJSON accepts the amount as a decimal string or a JSON number
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var value struct {
+		Amount   json.Number `json:"amount"`
+		Currency string      `json:"currency"`
+	}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidAmount, err)
+	}
+
+	parsed, err := Parse(value.Amount.String(), value.Currency)
+	if err != nil {
+		return err
+	}
+	*m = parsed
+	return nil
+}
+
+func fromBig(value *big.Int, currency string) (Money, error) {
+	if !value.IsInt64() {
+		return Money{}, ErrOverflow
+	}
+	return Money{Amount: value.Int64(), Currency: currency}, nil
+}
+
+// roundHalfAway rounds to the nearest integer, halves away from zero
+func roundHalfAway(value *big.Rat) *big.Int {
+	num := new(big.Int).Abs(value.Num())
+	den := value.Denom()
+
+	// floor((2*|num| + den) / (2*den)) rounds |value| half up
+	quotient := new(big.Int).Mul(num, big.NewInt(2))
+	quotient.Add(quotient, den)
+	quotient.Quo(quotient, new(big.Int).Mul(den, big.NewInt(2)))
+
+	if value.Sign() < 0 {
+		quotient.Neg(quotient)
+	}
+	return quotient
+}
+
+// Pow10 returns 10 to the power of exponent, the minor units in a major unit
+// of a currency with that exponent
+func Pow10(exponent int) *big.Int {
+	return new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(exponent)), nil)
+}
@@ -0,0 +1,249 @@
+package money
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestExponent(t *testing.T) {
+	tests := []struct {
+		currency string
+		want     int
+		wantErr  error
+	}{
+		{currency: "IDR", want: 2},
+		{currency: "USD", want: 2},
+		{currency: "JPY", want: 0},
+		{currency: "XXX", wantErr: ErrUnknownCurrency},
+		{currency: "idr", wantErr: ErrUnknownCurrency},
+	}
+
+	for _, tt := range tests {
+		got, err := Exponent(tt.currency)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("Exponent(%q) error = %v, want %v", tt.currency, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Exponent(%q) = %d, want %d", tt.currency, got, tt.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		amount   string
+		currency string
+		want     int64
+		wantErr  error
+	}{
+		{amount: "15999000.50", currency: "IDR", want: 1599900050},
+		{amount: "15999000", currency: "IDR", want: 1599900000},
+		{amount: "0.010", currency: "IDR", want: 1},
+		{amount: "-12.34", currency: "USD", want: -1234},
+		{amount: "1500", currency: "JPY", want: 1500},
+		{amount: "1500.00", currency: "JPY", want: 1500},
+		{amount: "92233720368547758.07", currency: "USD", want: math.MaxInt64},
+		{amount: "0.001", currency: "IDR", wantErr: ErrInvalidAmount},
+		{amount: "1.5", currency: "JPY", wantErr: ErrInvalidAmount},
+		{amount: "1e3", currency: "IDR", wantErr: ErrInvalidAmount},
+		{amount: "1/2", currency: "IDR", wantErr: ErrInvalidAmount},
+		{amount: "abc", currency: "IDR", wantErr: ErrInvalidAmount},
+		{amount: "", currency: "IDR", wantErr: ErrInvalidAmount},
+		{amount: "92233720368547758.08", currency: "USD", wantErr: ErrOverflow},
+		{amount: "1", currency: "XXX", wantErr: ErrUnknownCurrency},
+	}
+
+	for _, tt := range tests {
+		got, err := Parse(tt.amount, tt.currency)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("Parse(%q, %s) error = %v, want %v", tt.amount, tt.currency, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr == nil && got != New(tt.want, tt.currency) {
+			t.Errorf("Parse(%q, %s) = %+v, want %d", tt.amount, tt.currency, got, tt.want)
+		}
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	tests := []struct {
+		name    string
+		op      func() (Money, error)
+		want    int64
+		wantErr error
+	}{
+		{name: "add", op: func() (Money, error) { return New(150, "IDR").Add(New(-50, "IDR")) }, want: 100},
+		{name: "sub", op: func() (Money, error) { return New(100, "IDR").Sub(New(250, "IDR")) }, want: -150},
+		{name: "mul", op: func() (Money, error) { return New(1999, "IDR").Mul(3) }, want: 5997},
+		{name: "add mismatch", op: func() (Money, error) { return New(1, "IDR").Add(New(1, "USD")) }, wantErr: ErrCurrencyMismatch},
+		{name: "add overflow", op: func() (Money, error) { return New(math.MaxInt64, "IDR").Add(New(1, "IDR")) }, wantErr: ErrOverflow},
+		{name: "add underflow", op: func() (Money, error) { return New(math.MinInt64, "IDR").Add(New(-1, "IDR")) }, wantErr: ErrOverflow},
+		{name: "sub min", op: func() (Money, error) { return New(0, "IDR").Sub(New(math.MinInt64, "IDR")) }, wantErr: ErrOverflow},
+		{name: "mul overflow", op: func() (Money, error) { return New(math.MaxInt64/2+1, "IDR").Mul(2) }, wantErr: ErrOverflow},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.op()
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: error = %v, want %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr == nil && got.Amount != tt.want {
+			t.Errorf("%s = %d, want %d", tt.name, got.Amount, tt.want)
+		}
+	}
+}
+
+func TestMulRat(t *testing.T) {
+	tests := []struct {
+		amount   int64
+		num, den int64
+		want     int64
+		wantErr  bool
+	}{
+		{amount: 100, num: 1, den: 2, want: 50},
+		{amount: 1, num: 1, den: 2, want: 1},
+		{amount: -1, num: 1, den: 2, want: -1},
+		{amount: 3, num: 1, den: 2, want: 2},
+		{amount: -3, num: 1, den: 2, want: -2},
+		{amount: 4, num: 1, den: 3, want: 1},
+		{amount: -4, num: 1, den: 3, want: -1},
+		{amount: 5, num: 1, den: 3, want: 2},
+		{amount: -5, num: 1, den: 3, want: -2},
+		{amount: 10, num: 1, den: -4, want: -3},
+		{amount: 1599900000, num: 15, den: 100, want: 239985000},
+		{amount: 0, num: 7, den: 9, want: 0},
+		{amount: math.MaxInt64, num: 2, den: 1, wantErr: true},
+		{amount: 1, num: 1, den: 0, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := New(tt.amount, "IDR").MulRat(tt.num, tt.den)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%d * %d/%d error = %v, want error %v", tt.amount, tt.num, tt.den, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got.Amount != tt.want {
+			t.Errorf("%d * %d/%d = %d, want %d", tt.amount, tt.num, tt.den, got.Amount, tt.want)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		from    Money
+		to      string
+		rate    string
+		want    Money
+		wantErr bool
+	}{
+		{from: New(100, "USD"), to: "IDR", rate: "16000", want: New(1600000, "IDR")},
+		{from: New(1000, "JPY"), to: "USD", rate: "0.0067", want: New(670, "USD")},
+		{from: New(1, "USD"), to: "JPY", rate: "150.5", want: New(2, "JPY")},
+		{from: New(-1, "USD"), to: "JPY", rate: "150", want: New(-2, "JPY")},
+		{from: New(1, "IDR"), to: "USD", rate: "1/16000", want: New(0, "USD")},
+		{from: New(1, "USD"), to: "IDR", rate: "0", wantErr: true},
+		{from: New(1, "USD"), to: "IDR", rate: "-1", wantErr: true},
+		{from: New(1, "USD"), to: "XXX", rate: "1", wantErr: true},
+		{from: New(math.MaxInt64, "JPY"), to: "IDR", rate: "1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		rate, _ := new(big.Rat).SetString(tt.rate)
+		got, err := tt.from.Convert(tt.to, rate)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s to %s at %s error = %v, want error %v", tt.from, tt.to, tt.rate, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s to %s at %s = %s, want %s", tt.from, tt.to, tt.rate, got, tt.want)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		money       Money
+		wantDecimal string
+		wantFormat  string
+	}{
+		{money: New(1599900000, "IDR"), wantDecimal: "15999000.00", wantFormat: "Rp 15.999.000,00"},
+		{money: New(123456, "USD"), wantDecimal: "1234.56", wantFormat: "$1,234.56"},
+		{money: New(-123456, "USD"), wantDecimal: "-1234.56", wantFormat: "-$1,234.56"},
+		{money: New(5, "USD"), wantDecimal: "0.05", wantFormat: "$0.05"},
+		{money: New(-5, "IDR"), wantDecimal: "-0.05", wantFormat: "-Rp 0,05"},
+		{money: New(100000000, "EUR"), wantDecimal: "1000000.00", wantFormat: "€1.000.000,00"},
+		{money: New(999, "MYR"), wantDecimal: "9.99", wantFormat: "RM 9.99"},
+		{money: New(1500, "JPY"), wantDecimal: "1500", wantFormat: "¥1,500"},
+		{money: New(-7, "JPY"), wantDecimal: "-7", wantFormat: "-¥7"},
+		{money: New(5, "XXX"), wantDecimal: "5", wantFormat: "XXX 5"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.money.Decimal(); got != tt.wantDecimal {
+			t.Errorf("%+v.Decimal() = %q, want %q", tt.money, got, tt.wantDecimal)
+		}
+		if got := tt.money.Format(); got != tt.wantFormat {
+			t.Errorf("%+v.Format() = %q, want %q", tt.money, got, tt.wantFormat)
+		}
+	}
+}
+
+func TestJSON(t *testing.T) {
+	for _, m := range []Money{New(1599900050, "IDR"), New(-1, "USD"), New(1500, "JPY"), Zero("EUR")} {
+		data, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded Money
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Errorf("Unmarshal(%s): %v", data, err)
+			continue
+		}
+		if decoded != m {
+			t.Errorf("round trip of %+v = %+v", m, decoded)
+		}
+	}
+
+	data, _ := json.Marshal(New(1599900050, "IDR"))
+	if want := `{"amount":"15999000.50","currency":"IDR"}`; string(data) != want {
+		t.Errorf("Marshal = %s, want %s", data, want)
+	}
+}
+
+func TestUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		data    string
+		want    Money
+		wantErr error
+	}{
+		{data: `{"amount":"15999000.50","currency":"IDR"}`, want: New(1599900050, "IDR")},
+		{data: `{"amount":15999000.5,"currency":"IDR"}`, want: New(1599900050, "IDR")},
+		{data: `{"amount":1500,"currency":"JPY"}`, want: New(1500, "JPY")},
+		{data: `{"amount":"-0.01","currency":"USD"}`, want: New(-1, "USD")},
+		{data: `{"amount":"1.5","currency":"JPY"}`, wantErr: ErrInvalidAmount},
+		{data: `{"amount":0.001,"currency":"IDR"}`, wantErr: ErrInvalidAmount},
+		{data: `{"amount":"abc","currency":"IDR"}`, wantErr: ErrInvalidAmount},
+		{data: `{"amount":true,"currency":"IDR"}`, wantErr: ErrInvalidAmount},
+		{data: `{"amount":"1e3","currency":"IDR"}`, wantErr: ErrInvalidAmount},
+		{data: `{"amount":"1","currency":"XXX"}`, wantErr: ErrUnknownCurrency},
+		{data: `{"amount":"1"}`, wantErr: ErrUnknownCurrency},
+		{data: `{"amount":"92233720368547758.08","currency":"USD"}`, wantErr: ErrOverflow},
+	}
+
+	for _, tt := range tests {
+		var got Money
+		err := json.Unmarshal([]byte(tt.data), &got)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("Unmarshal(%s) error = %v, want %v", tt.data, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Unmarshal(%s) = %+v, want %+v", tt.data, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+var (
+	ErrUnknownProvider = errors.New("unknown OIDC provider")
+	ErrNonceMismatch   = errors.New("ID token nonce does not match")
+)
+
+// Config describes one OpenID Connect provider
+type Config struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// Identity is the verified subset of ID token claims used to sign a user in
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// LoadConfigs reads providers named in OIDC_PROVIDERS (comma separated), each
+// configured by OIDC_<NAME>_ISSUER, _CLIENT_ID, _CLIENT_SECRET, _REDIRECT_URL
+// and optionally _SCOPES. redirectBase builds the default redirect URL.
+func LoadConfigs(redirectBase string) ([]Config, error) {
+	var configs []Config
+
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		config := Config{
+			Name:         name,
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  os.Getenv(prefix + "REDIRECT_URL"),
+			Scopes:       strings.Fields(os.Getenv(prefix + "SCOPES")),
+		}
+
+		if config.Issuer == "" || config.ClientID == "" {
+			return nil, fmt.Errorf("OIDC provider %s needs %sISSUER and %sCLIENT_ID", name, prefix, prefix)
+		}
+		if config.RedirectURL == "" {
+			config.RedirectURL = strings.TrimSuffix(redirectBase, "/") + "/oidc/" + name + "/callback"
+		}
+		if len(config.Scopes) == 0 {
+			config.Scopes = []string{gooidc.ScopeOpenID, "email", "profile"}
+		}
+
+		configs = append(configs, config)
+	}
+
+	return configs, nil
+}
+
+// Provider runs the authorization code flow with PKCE against one issuer.
+// Discovery happens on first use so auth-service can start while the
+// provider is unreachable.
+type Provider struct {
+	config Config
+
+	mu       sync.Mutex
+	oauth    *oauth2.Config
+	verifier *gooidc.IDTokenVerifier
+}
+
+// Registry holds the configured providers by name
+type Registry struct {
+	providers map[string]*Provider
+}
+
+func NewRegistry(configs []Config) *Registry {
+	providers := make(map[string]*Provider, len(configs))
+	for _, config := range configs {
+		providers[config.Name] = &Provider{config: config}
+	}
+	return &Registry{providers: providers}
+}
+
+// Get returns the provider with the given name
+func (r *Registry) Get(name string) (*Provider, error) {
+	provider, ok := r.providers[name]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	return provider, nil
+}
+
+// Names lists the configured providers in alphabetical order
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.providers))
+	for name := range r.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (p *Provider) Name() string {
+	return p.config.Name
+}
+
+// discover fetches the provider metadata once, retrying on later calls after a failure
+func (p *Provider) discover(ctx context.Context) (*oauth2.Config, *gooidc.IDTokenVerifier, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.oauth != nil {
+		return p.oauth, p.verifier, nil
+	}
+
+	// The provider keeps the context for refreshing its signing keys, so it must
+	// outlive the request that triggered discovery
+	provider, err := gooidc.NewProvider(context.WithoutCancel(ctx), p.config.Issuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to discover OIDC provider %s: %w", p.config.Name, err)
+	}
+
+	p.oauth = &oauth2.Config{
+		ClientID:     p.config.ClientID,
+		ClientSecret: p.config.ClientSecret,
+		RedirectURL:  p.config.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       p.config.Scopes,
+	}
+	p.verifier = provider.Verifier(&gooidc.Config{ClientID: p.config.ClientID})
+
+	return p.oauth, p.verifier, nil
+}
+
+// AuthCodeURL returns the provider URL the user is redirected to. The PKCE
+// challenge is derived from codeVerifier, which must be kept for Exchange.
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, codeVerifier string) (string, error) {
+	oauth, _, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return oauth.AuthCodeURL(state,
+		gooidc.Nonce(nonce),
+		oauth2.S256ChallengeOption(codeVerifier),
+	), nil
+}
+
+// Exchange redeems the authorization code and verifies the returned ID token,
+// including its signature, issuer, audience, expiry and nonce
+func (p *Provider) Exchange(ctx context.Context, code, codeVerifier, nonce string) (*Identity, error) {
+	oauth, verifier, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := oauth.Exchange(ctx, code, oauth2.VerifierOption(codeVerifier))
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange authorization code: %w", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, fmt.Errorf("token response has no id_token")
+	}
+
+	idToken, err := verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify ID token: %w", err)
+	}
+
+	if idToken.Nonce != nonce {
+		return nil, ErrNonceMismatch
+	}
+
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("failed to decode ID token claims: %w", err)
+	}
+
+	return &Identity{
+		Subject:       idToken.Subject,
+		Email:         strings.ToLower(strings.TrimSpace(claims.Email)),
+		EmailVerified: claims.EmailVerified,
+		Name:          claims.Name,
+	}, nil
+}
+
+// GenerateVerifier returns a random PKCE code verifier
+func GenerateVerifier() string {
+	return oauth2.GenerateVerifier()
+}
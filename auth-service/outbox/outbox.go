@@ -0,0 +1,222 @@
+// Package outbox delivers events that must not be lost along with the change
+// that caused them. Events are written in the same transaction as the change
+// and published afterwards by a Relay, which retries until RabbitMQ accepts
+// them, so each event is delivered at least once.
+package outbox
+
+import (
+	"auth-service/logger"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// schema creates the outbox table. Only auth-service creates or reads it.
+const schema = `CREATE TABLE IF NOT EXISTS outbox_events (
+	id BIGSERIAL PRIMARY KEY,
+	exchange VARCHAR(100) NOT NULL,
+	payload JSONB NOT NULL,
+	trace_context JSONB NOT NULL DEFAULT '{}',
+	correlation_id VARCHAR(64) NOT NULL DEFAULT '',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	published_at TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS idx_outbox_events_unpublished ON outbox_events(id) WHERE published_at IS NULL`
+
+// batchSize bounds how many events one flush publishes
+const batchSize = 100
+
+// retention is how long published events are kept for inspection
+const retention = 7 * 24 * time.Hour
+
+// Publisher publishes a message to every queue bound to a fanout exchange
+type Publisher interface {
+	PublishEvent(ctx context.Context, exchange string, message interface{}) error
+}
+
+// Enqueue records an event for exchange in tx. It is published once tx
+// commits, carrying the trace and correlation ID of ctx.
+func Enqueue(ctx context.Context, tx *sql.Tx, exchange string, message interface{}) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	traceContext, err := json.Marshal(carrier)
+	if err != nil {
+		return fmt.Errorf("failed to marshal trace context: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO outbox_events (exchange, payload, trace_context, correlation_id) VALUES ($1, $2, $3, $4)",
+		exchange, payload, traceContext, logger.CorrelationID(ctx),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to store event: %w", err)
+	}
+	return nil
+}
+
+// Relay publishes the events in the outbox. Replicas may run one each; rows
+// are locked while being published, so each flush takes a disjoint batch.
+type Relay struct {
+	db        *sql.DB
+	publisher Publisher
+	interval  time.Duration
+	wake      chan struct{}
+}
+
+func NewRelay(db *sql.DB, publisher Publisher, interval time.Duration) *Relay {
+	return &Relay{db: db, publisher: publisher, interval: interval, wake: make(chan struct{}, 1)}
+}
+
+// Init creates the outbox table
+func (r *Relay) Init(ctx context.Context) error {
+	if _, err := r.db.ExecContext(ctx, schema); err != nil {
+		return fmt.Errorf("failed to create outbox table: %w", err)
+	}
+	return nil
+}
+
+// Notify asks Run to flush now rather than at its next tick, e.g. right after
+// a transaction enqueueing an event commits
+func (r *Relay) Notify() {
+	if r == nil {
+		return
+	}
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run flushes the outbox when notified and on every interval, which retries
+// events RabbitMQ refused, until ctx is done
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.wake:
+		}
+
+		if _, err := r.Flush(ctx); err != nil {
+			slog.Error("failed to flush outbox", "error", err)
+		}
+	}
+}
+
+type event struct {
+	id            int64
+	exchange      string
+	payload       json.RawMessage
+	traceContext  propagation.MapCarrier
+	correlationID string
+}
+
+// Flush publishes unpublished events in the order they were written, stopping
+// at the first one RabbitMQ refuses so later events do not overtake it. It
+// returns how many were published.
+func (r *Relay) Flush(ctx context.Context) (int, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		`SELECT id, exchange, payload, trace_context, correlation_id FROM outbox_events
+		 WHERE published_at IS NULL
+		 ORDER BY id
+		 LIMIT $1
+		 FOR UPDATE SKIP LOCKED`,
+		batchSize,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load events: %w", err)
+	}
+
+	var events []event
+	for rows.Next() {
+		var e event
+		var payload, traceContext []byte
+		if err := rows.Scan(&e.id, &e.exchange, &payload, &traceContext, &e.correlationID); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan event: %w", err)
+		}
+		e.payload = json.RawMessage(payload)
+		// A trace context that cannot be read only loses the link to the trace
+		json.Unmarshal(traceContext, &e.traceContext)
+		events = append(events, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to load events: %w", err)
+	}
+
+	published := 0
+	var publishErr error
+	for _, e := range events {
+		eventCtx := ctx
+		if e.traceContext != nil {
+			eventCtx = otel.GetTextMapPropagator().Extract(eventCtx, e.traceContext)
+		}
+		if e.correlationID != "" {
+			eventCtx = logger.WithCorrelationID(eventCtx, e.correlationID)
+		}
+
+		if publishErr = r.publisher.PublishEvent(eventCtx, e.exchange, e.payload); publishErr != nil {
+			logger.FromContext(eventCtx).Warn("failed to publish outbox event", "event_id", e.id, "exchange", e.exchange, "error", publishErr)
+			_, err := tx.ExecContext(ctx,
+				"UPDATE outbox_events SET attempts = attempts + 1, last_error = $1 WHERE id = $2",
+				publishErr.Error(), e.id,
+			)
+			if err != nil {
+				return 0, fmt.Errorf("failed to record failed event: %w", err)
+			}
+			break
+		}
+
+		_, err := tx.ExecContext(ctx,
+			"UPDATE outbox_events SET attempts = attempts + 1, last_error = NULL, published_at = CURRENT_TIMESTAMP WHERE id = $1",
+			e.id,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("failed to mark event published: %w", err)
+		}
+		published++
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"DELETE FROM outbox_events WHERE published_at < CURRENT_TIMESTAMP - make_interval(secs => $1)",
+		retention.Seconds(),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to remove published events: %w", err)
+	}
+
+	// An event published before a failed commit is published again on the
+	// next flush; consumers must tolerate duplicates
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	if publishErr != nil {
+		return published, fmt.Errorf("failed to publish event: %w", publishErr)
+	}
+	return published, nil
+}
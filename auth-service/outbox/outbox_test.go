@@ -0,0 +1,152 @@
+package outbox
+
+import (
+	"auth-service/logger"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var eventColumns = []string{"id", "exchange", "payload", "trace_context", "correlation_id"}
+
+type published struct {
+	exchange      string
+	payload       string
+	correlationID string
+}
+
+// fakePublisher records published events, refusing those in fail
+type fakePublisher struct {
+	published []published
+	fail      map[string]bool
+}
+
+func (p *fakePublisher) PublishEvent(ctx context.Context, exchange string, message interface{}) error {
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	if p.fail[string(payload)] {
+		return errors.New("channel closed")
+	}
+	p.published = append(p.published, published{exchange, string(payload), logger.CorrelationID(ctx)})
+	return nil
+}
+
+func TestEnqueue(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO outbox_events").
+		WithArgs("user_deleted", []byte(`{"user_id":7}`), []byte(`{}`), "corr-1").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := logger.WithCorrelationID(context.Background(), "corr-1")
+	if err := Enqueue(ctx, tx, "user_deleted", map[string]int{"user_id": 7}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("FOR UPDATE SKIP LOCKED").
+		WithArgs(batchSize).
+		WillReturnRows(sqlmock.NewRows(eventColumns).
+			AddRow(1, "user_deleted", `{"user_id":7}`, `{}`, "corr-1").
+			AddRow(2, "user_deleted", `{"user_id":8}`, `{}`, ""))
+	mock.ExpectExec("UPDATE outbox_events SET attempts = attempts \\+ 1, last_error = NULL, published_at").
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE outbox_events SET attempts = attempts \\+ 1, last_error = NULL, published_at").
+		WithArgs(2).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM outbox_events").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	publisher := &fakePublisher{}
+	n, err := NewRelay(db, publisher, 0).Flush(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("published %d events, want 2", n)
+	}
+
+	want := []published{
+		{"user_deleted", `{"user_id":7}`, "corr-1"},
+		{"user_deleted", `{"user_id":8}`, ""},
+	}
+	if len(publisher.published) != len(want) {
+		t.Fatalf("published %+v, want %+v", publisher.published, want)
+	}
+	for i := range want {
+		if publisher.published[i] != want[i] {
+			t.Errorf("event %d is %+v, want %+v", i, publisher.published[i], want[i])
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFlushStopsAtRefusedEvent(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("FOR UPDATE SKIP LOCKED").
+		WillReturnRows(sqlmock.NewRows(eventColumns).
+			AddRow(1, "user_deleted", `{"user_id":7}`, `{}`, "").
+			AddRow(2, "user_deleted", `{"user_id":8}`, `{}`, ""))
+	// The refused event stays unpublished for the next flush, and the one
+	// after it waits so events are not reordered
+	mock.ExpectExec("UPDATE outbox_events SET attempts = attempts \\+ 1, last_error = \\$1 WHERE id = \\$2").
+		WithArgs("channel closed", 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM outbox_events").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	publisher := &fakePublisher{fail: map[string]bool{`{"user_id":7}`: true}}
+	n, err := NewRelay(db, publisher, 0).Flush(context.Background())
+	if err == nil {
+		t.Error("a refused event was not reported")
+	}
+	if n != 0 || len(publisher.published) != 0 {
+		t.Errorf("published %d events after a refused one: %+v", n, publisher.published)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNotifyDoesNotBlock(t *testing.T) {
+	relay := NewRelay(nil, nil, 0)
+	relay.Notify()
+	relay.Notify()
+
+	var none *Relay
+	none.Notify()
+}
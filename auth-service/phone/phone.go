@@ -0,0 +1,52 @@
+package phone
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrInvalid = errors.New("phone number must be in international format, e.g. +628123456789")
+
+// E.164 allows at most 15 digits; shorter than 8 is not a dialable subscriber number
+const (
+	minDigits = 8
+	maxDigits = 15
+)
+
+// Normalize converts a phone number to E.164 (+<country code><number>).
+// Spaces, dashes, dots and parentheses are ignored, a leading 00 is read as
+// the international prefix, and a leading 0 is replaced by defaultCountryCode
+// when one is given (e.g. "62" turns 0812... into +62812...).
+func Normalize(raw, defaultCountryCode string) (string, error) {
+	var digits strings.Builder
+	international := false
+
+	for i, r := range strings.TrimSpace(raw) {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == '+' && i == 0:
+			international = true
+		case r == ' ' || r == '-' || r == '.' || r == '(' || r == ')':
+		default:
+			return "", ErrInvalid
+		}
+	}
+
+	number := digits.String()
+	switch {
+	case international:
+	case strings.HasPrefix(number, "00"):
+		number = number[2:]
+	case strings.HasPrefix(number, "0") && defaultCountryCode != "":
+		number = defaultCountryCode + number[1:]
+	default:
+		return "", ErrInvalid
+	}
+
+	if len(number) < minDigits || len(number) > maxDigits || number[0] == '0' {
+		return "", ErrInvalid
+	}
+
+	return "+" + number, nil
+}
@@ -0,0 +1,244 @@
+package rabbitmq
+
+import (
+	"auth-service/logger"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	QueueOrderPlaced    = "order_placed"
+	QueueOrderConfirmed = "order_confirmed"
+	QueueOrderFailed    = "order_failed"
+	QueueUserRegistered = "user_registered"
+	QueuePasswordReset  = "password_reset_requested"
+	QueueEmailChange    = "email_change_requested"
+	QueueOrderShipment  = "order_shipment"
+)
+
+// ExchangeUserDeleted fans user_deleted events out to one queue per service
+const ExchangeUserDeleted = "user_deleted"
+
+// Queues bound to ExchangeUserDeleted
+const (
+	QueueUserDeletedOrders    = "order-service.user_deleted"
+	QueueUserDeletedInventory = "inventory-worker.user_deleted"
+)
+
+type RabbitMQ struct {
+	conn    *amqp.Connection
+	channel *amqp.Channel
+}
+
+var tracer = otel.Tracer("auth-service/rabbitmq")
+
+// headerCarrier adapts AMQP headers to carry trace context
+type headerCarrier amqp.Table
+
+func (h headerCarrier) Get(key string) string {
+	if v, ok := h[key].(string); ok {
+		return v
+	}
+	return ""
+}
+
+func (h headerCarrier) Set(key, value string) {
+	h[key] = value
+}
+
+func (h headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// Connect establishes connection to RabbitMQ with retry logic
+func Connect(host, port, user, password string) (*RabbitMQ, error) {
+	url := "amqps://rabbitmqlinks"
+
+	var conn *amqp.Connection
+	var err error
+
+	// Retry connection up to 10 times
+	for i := 0; i < 10; i++ {
+		conn, err = amqp.Dial(url)
+		if err == nil {
+			break
+		}
+		slog.Warn("failed to connect to RabbitMQ", "attempt", i+1, "max_attempts", 10, "error", err)
+		time.Sleep(3 * time.Second)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to RabbitMQ after 10 attempts: %w", err)
+	}
+
+	channel, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to open channel: %w", err)
+	}
+
+	slog.Info("connected to RabbitMQ")
+
+	rmq := &RabbitMQ{
+		conn:    conn,
+		channel: channel,
+	}
+
+	// Declare all queues
+	if err := rmq.declareQueues(); err != nil {
+		rmq.Close()
+		return nil, err
+	}
+
+	return rmq, nil
+}
+
+// declareQueues declares all required queues
+func (r *RabbitMQ) declareQueues() error {
+	queues := []string{QueueOrderPlaced, QueueOrderConfirmed, QueueOrderFailed, QueueUserRegistered, QueuePasswordReset, QueueEmailChange, QueueOrderShipment}
+
+	for _, queue := range queues {
+		_, err := r.channel.QueueDeclare(
+			queue, // name
+			true,  // durable
+			false, // delete when unused
+			false, // exclusive
+			false, // no-wait
+			nil,   // arguments
+		)
+		if err != nil {
+			return fmt.Errorf("failed to declare queue %s: %w", queue, err)
+		}
+		slog.Info("queue declared", "queue", queue)
+	}
+
+	// Declaring the bound queues here too keeps events from being dropped
+	// while a consuming service is down
+	if err := r.declareFanout(ExchangeUserDeleted, QueueUserDeletedOrders, QueueUserDeletedInventory); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// declareFanout declares a durable fanout exchange and binds the given queues to it
+func (r *RabbitMQ) declareFanout(exchange string, queues ...string) error {
+	if err := r.channel.ExchangeDeclare(
+		exchange, // name
+		"fanout", // kind
+		true,     // durable
+		false,    // auto-deleted
+		false,    // internal
+		false,    // no-wait
+		nil,      // arguments
+	); err != nil {
+		return fmt.Errorf("failed to declare exchange %s: %w", exchange, err)
+	}
+
+	for _, queue := range queues {
+		if _, err := r.channel.QueueDeclare(queue, true, false, false, false, nil); err != nil {
+			return fmt.Errorf("failed to declare queue %s: %w", queue, err)
+		}
+		if err := r.channel.QueueBind(queue, "", exchange, false, nil); err != nil {
+			return fmt.Errorf("failed to bind queue %s to %s: %w", queue, exchange, err)
+		}
+	}
+
+	slog.Info("exchange declared", "exchange", exchange, "queues", queues)
+	return nil
+}
+
+// Publish publishes a message to a queue, propagating the trace context in its
+// headers and the correlation ID of ctx in the message properties
+func (r *RabbitMQ) Publish(ctx context.Context, queueName string, message interface{}) error {
+	return r.publish(ctx, "", queueName, message)
+}
+
+// PublishEvent publishes a message to every queue bound to a fanout exchange
+func (r *RabbitMQ) PublishEvent(ctx context.Context, exchange string, message interface{}) error {
+	return r.publish(ctx, exchange, "", message)
+}
+
+func (r *RabbitMQ) publish(ctx context.Context, exchange, routingKey string, message interface{}) error {
+	destination := routingKey
+	if exchange != "" {
+		destination = exchange
+	}
+
+	ctx, span := tracer.Start(ctx, destination+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "rabbitmq"),
+			attribute.String("messaging.destination.name", destination),
+		),
+	)
+	defer span.End()
+
+	body, err := json.Marshal(message)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "marshal failed")
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	err = r.channel.Publish(
+		exchange,   // exchange, empty for the default direct-to-queue exchange
+		routingKey, // routing key (queue name)
+		false,      // mandatory
+		false,      // immediate
+		newPublishing(ctx, body),
+	)
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "publish failed")
+		return fmt.Errorf("failed to publish message: %w", err)
+	}
+
+	logger.FromContext(ctx).Info("message published", "destination", destination)
+	return nil
+}
+
+// newPublishing wraps a JSON body in a persistent message carrying the trace
+// context of ctx in its headers and its correlation ID
+func newPublishing(ctx context.Context, body []byte) amqp.Publishing {
+	headers := amqp.Table{}
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier(headers))
+
+	return amqp.Publishing{
+		Headers:       headers,
+		CorrelationId: logger.CorrelationID(ctx),
+		DeliveryMode:  amqp.Persistent,
+		ContentType:   "application/json",
+		Body:          body,
+	}
+}
+
+// Close closes the RabbitMQ connection
+func (r *RabbitMQ) Close() {
+	if r.channel != nil {
+		r.channel.Close()
+	}
+	if r.conn != nil {
+		r.conn.Close()
+	}
+	slog.Info("RabbitMQ connection closed")
+}
+
+// IsConnected checks if the connection is alive
+func (r *RabbitMQ) IsConnected() bool {
+	return r.conn != nil && !r.conn.IsClosed()
+}
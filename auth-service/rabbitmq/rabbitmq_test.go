@@ -0,0 +1,44 @@
+package rabbitmq
+
+import (
+	"auth-service/logger"
+	"context"
+	"testing"
+
+	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// startTrace starts a sampled span under W3C trace context propagation, as
+// tracing.Init sets up
+func startTrace(t *testing.T) (context.Context, trace.SpanContext) {
+	t.Helper()
+	previous := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTextMapPropagator(previous) })
+
+	ctx, span := sdktrace.NewTracerProvider().Tracer("test").Start(context.Background(), "DELETE /account")
+	t.Cleanup(func() { span.End() })
+	return ctx, span.SpanContext()
+}
+
+func TestNewPublishing(t *testing.T) {
+	ctx, sc := startTrace(t)
+	ctx = logger.WithCorrelationID(ctx, "req-1")
+
+	msg := newPublishing(ctx, []byte(`{"user_id":7}`))
+
+	want := "00-" + sc.TraceID().String() + "-" + sc.SpanID().String() + "-01"
+	if got := msg.Headers["traceparent"]; got != want {
+		t.Errorf("traceparent %v, want %s", got, want)
+	}
+	if msg.CorrelationId != "req-1" {
+		t.Errorf("correlation ID %q", msg.CorrelationId)
+	}
+	if msg.DeliveryMode != amqp.Persistent || msg.ContentType != "application/json" || string(msg.Body) != `{"user_id":7}` {
+		t.Errorf("message %+v", msg)
+	}
+}
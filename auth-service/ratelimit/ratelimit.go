@@ -0,0 +1,204 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Limit allows Requests requests per sliding Window
+type Limit struct {
+	Requests int
+	Window   time.Duration
+}
+
+// Result is the outcome of a single Allow call
+type Result struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration
+}
+
+// Limiter decides whether a request identified by key fits within limit
+type Limiter interface {
+	Allow(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// New returns a Redis-backed limiter that falls back to process memory when
+// Redis is unavailable, or a memory-only limiter when client is nil
+func New(client *redis.Client) Limiter {
+	memory := NewMemoryLimiter()
+	if client == nil {
+		return memory
+	}
+	return &fallbackLimiter{primary: NewRedisLimiter(client), fallback: memory}
+}
+
+// FromEnv reads a limit formatted as "<requests>/<window>", e.g. "10/1m",
+// returning def when the variable is unset or invalid
+func FromEnv(key string, def Limit) Limit {
+	value := os.Getenv(key)
+	if value == "" {
+		return def
+	}
+
+	limit, err := Parse(value)
+	if err != nil {
+		slog.Warn("invalid rate limit, using default", "env", key, "value", value, "error", err)
+		return def
+	}
+	return limit
+}
+
+// Parse parses a limit formatted as "<requests>/<window>"
+func Parse(value string) (Limit, error) {
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) != 2 {
+		return Limit{}, fmt.Errorf("expected <requests>/<window>")
+	}
+
+	requests, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil || requests <= 0 {
+		return Limit{}, fmt.Errorf("invalid request count %q", parts[0])
+	}
+
+	window, err := time.ParseDuration(strings.TrimSpace(parts[1]))
+	if err != nil || window <= 0 {
+		return Limit{}, fmt.Errorf("invalid window %q", parts[1])
+	}
+
+	return Limit{Requests: requests, Window: window}, nil
+}
+
+// slidingWindowScript keeps one sorted-set member per request scored by its
+// timestamp, drops members older than the window and admits the request only
+// while fewer than limit members remain
+var slidingWindowScript = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+
+redis.call('ZREMRANGEBYSCORE', key, 0, now - window)
+local count = redis.call('ZCARD', key)
+
+if count < limit then
+	redis.call('ZADD', key, now, ARGV[4])
+	redis.call('PEXPIRE', key, window)
+	return {1, limit - count - 1, 0}
+end
+
+local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+return {0, 0, tonumber(oldest[2]) + window - now}
+`)
+
+// RedisLimiter implements a sliding window log shared by all instances
+type RedisLimiter struct {
+	client *redis.Client
+}
+
+func NewRedisLimiter(client *redis.Client) *RedisLimiter {
+	return &RedisLimiter{client: client}
+}
+
+func (l *RedisLimiter) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	now := time.Now()
+	// Random suffix keeps members unique across concurrent instances
+	member := strconv.FormatInt(now.UnixNano(), 10) + "-" + strconv.FormatUint(rand.Uint64(), 36)
+
+	values, err := slidingWindowScript.Run(ctx, l.client,
+		[]string{"ratelimit:" + key},
+		now.UnixMilli(), limit.Window.Milliseconds(), limit.Requests, member,
+	).Int64Slice()
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to evaluate rate limit: %w", err)
+	}
+
+	return Result{
+		Allowed:    values[0] == 1,
+		Remaining:  int(values[1]),
+		RetryAfter: time.Duration(values[2]) * time.Millisecond,
+	}, nil
+}
+
+// MemoryLimiter implements the same sliding window in process memory
+type MemoryLimiter struct {
+	mu        sync.Mutex
+	requests  map[string][]time.Time
+	maxWindow time.Duration // longest window seen, so sweeps never drop live keys
+}
+
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{requests: make(map[string][]time.Time)}
+}
+
+// maxTrackedKeys bounds memory before idle keys are swept
+const maxTrackedKeys = 10000
+
+func (l *MemoryLimiter) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	cutoff := now.Add(-limit.Window)
+
+	if limit.Window > l.maxWindow {
+		l.maxWindow = limit.Window
+	}
+	if len(l.requests) > maxTrackedKeys {
+		l.sweep(now.Add(-l.maxWindow))
+	}
+
+	// Drop timestamps that slid out of the window
+	recent := l.requests[key][:0]
+	for _, t := range l.requests[key] {
+		if t.After(cutoff) {
+			recent = append(recent, t)
+		}
+	}
+
+	if len(recent) >= limit.Requests {
+		l.requests[key] = recent
+		return Result{
+			Allowed:    false,
+			RetryAfter: recent[0].Add(limit.Window).Sub(now),
+		}, nil
+	}
+
+	l.requests[key] = append(recent, now)
+	return Result{Allowed: true, Remaining: limit.Requests - len(recent) - 1}, nil
+}
+
+// sweep removes keys whose newest request is older than cutoff
+func (l *MemoryLimiter) sweep(cutoff time.Time) {
+	for key, times := range l.requests {
+		if len(times) == 0 || !times[len(times)-1].After(cutoff) {
+			delete(l.requests, key)
+		}
+	}
+}
+
+// fallbackLimiter uses the primary limiter and switches to the fallback for
+// any request where the primary returns an error
+type fallbackLimiter struct {
+	primary  Limiter
+	fallback Limiter
+}
+
+func (l *fallbackLimiter) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	result, err := l.primary.Allow(ctx, key, limit)
+	if err == nil {
+		return result, nil
+	}
+
+	slog.Warn("rate limiter falling back to memory", "error", err)
+	return l.fallback.Allow(ctx, key, limit)
+}
@@ -0,0 +1,129 @@
+package totp
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed of the RFC 4226 and RFC 6238 test vectors,
+// "12345678901234567890", in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestGenerateHOTP(t *testing.T) {
+	// RFC 4226 appendix D
+	want := []string{"755224", "287082", "359152", "969429", "338314", "254676", "287922", "162583", "399871", "520489"}
+
+	key, _ := encoding.DecodeString(rfcSecret)
+	for counter, code := range want {
+		if got := generate(key, int64(counter)); got != code {
+			t.Errorf("counter %d: %s, want %s", counter, got, code)
+		}
+	}
+}
+
+func TestValidateRFC6238(t *testing.T) {
+	// RFC 6238 appendix B for SHA-1, keeping the last 6 of its 8 digits
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{unix: 59, code: "287082"},
+		{unix: 1111111109, code: "081804"},
+		{unix: 1111111111, code: "050471"},
+		{unix: 1234567890, code: "005924"},
+		{unix: 2000000000, code: "279037"},
+		{unix: 20000000000, code: "353130"},
+	}
+
+	for _, tt := range tests {
+		step, ok := Validate(rfcSecret, tt.code, time.Unix(tt.unix, 0))
+		if !ok || step != tt.unix/30 {
+			t.Errorf("at %d: Validate(%s) = %d, %v, want step %d", tt.unix, tt.code, step, ok, tt.unix/30)
+		}
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	key, _ := encoding.DecodeString(rfcSecret)
+	now := time.Unix(1111111111, 0)
+	current := now.Unix() / 30
+
+	tests := []struct {
+		offset int64
+		want   bool
+	}{
+		{offset: -2, want: false},
+		{offset: -1, want: true},
+		{offset: 0, want: true},
+		{offset: 1, want: true},
+		{offset: 2, want: false},
+	}
+
+	for _, tt := range tests {
+		step, ok := Validate(rfcSecret, generate(key, current+tt.offset), now)
+		if ok != tt.want {
+			t.Errorf("code from step %+d: valid %v, want %v", tt.offset, ok, tt.want)
+		}
+		// The matched step lets callers refuse to accept it twice
+		if ok && step != current+tt.offset {
+			t.Errorf("code from step %+d: matched step %d, want %d", tt.offset, step, current+tt.offset)
+		}
+	}
+}
+
+func TestValidateInput(t *testing.T) {
+	now := time.Unix(59, 0)
+
+	tests := []struct {
+		name   string
+		secret string
+		code   string
+		want   bool
+	}{
+		{name: "spaces", secret: rfcSecret, code: " 287 082 ", want: true},
+		{name: "lowercase secret", secret: "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", code: "287082", want: true},
+		{name: "wrong code", secret: rfcSecret, code: "287083"},
+		{name: "short code", secret: rfcSecret, code: "87082"},
+		{name: "long code", secret: rfcSecret, code: "2870820"},
+		{name: "empty code", secret: rfcSecret, code: ""},
+		{name: "invalid secret", secret: "not base32!", code: "287082"},
+	}
+
+	for _, tt := range tests {
+		if _, ok := Validate(tt.secret, tt.code, now); ok != tt.want {
+			t.Errorf("%s: valid %v, want %v", tt.name, ok, tt.want)
+		}
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := encoding.DecodeString(secret)
+	if err != nil || len(key) != 20 {
+		t.Fatalf("secret %q decodes to %d bytes, %v, want 20", secret, len(key), err)
+	}
+	if other, _ := GenerateSecret(); other == secret {
+		t.Error("two secrets are equal")
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri, err := url.Parse(URI("Bootcamp Store", "budi@example.com", rfcSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/Bootcamp Store:budi@example.com" {
+		t.Errorf("URI %s has the wrong type or label", uri)
+	}
+	query := uri.Query()
+	for param, want := range map[string]string{"secret": rfcSecret, "issuer": "Bootcamp Store", "algorithm": "SHA1", "digits": "6", "period": "30"} {
+		if got := query.Get(param); got != want {
+			t.Errorf("%s = %q, want %q", param, got, want)
+		}
+	}
+}
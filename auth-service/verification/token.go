@@ -0,0 +1,83 @@
+package verification
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Audiences scope tokens to one purpose so they can never be used as access
+// tokens or swapped for each other
+const (
+	AudienceEmailVerification = "email-verification"
+	AudienceLoginChallenge    = "login-challenge"
+	AudienceEmailChange       = "email-change"
+)
+
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+type claims struct {
+	Email string `json:"email"`
+	jwt.RegisteredClaims
+}
+
+// Signer issues and checks signed, expiring tokens for a single audience.
+// Tokens are bound to the email address, so they stop working if the user
+// changes their email in the meantime.
+type Signer struct {
+	secret   []byte
+	audience string
+	ttl      time.Duration
+}
+
+func NewSigner(secret, audience string, ttl time.Duration) *Signer {
+	return &Signer{secret: []byte(secret), audience: audience, ttl: ttl}
+}
+
+// TTL returns how long issued tokens stay valid
+func (s *Signer) TTL() time.Duration {
+	return s.ttl
+}
+
+// Sign creates a token for the user and email
+func (s *Signer) Sign(userID int, email string) (string, error) {
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims{
+		Email: email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.Itoa(userID),
+			Audience:  jwt.ClaimStrings{s.audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.ttl)),
+		},
+	})
+	return token.SignedString(s.secret)
+}
+
+// Verify returns the user ID and email a valid token was issued for
+func (s *Signer) Verify(tokenString string) (int, string, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &claims{}, func(token *jwt.Token) (interface{}, error) {
+		return s.secret, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithAudience(s.audience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil || !token.Valid {
+		return 0, "", ErrInvalidToken
+	}
+
+	c, ok := token.Claims.(*claims)
+	if !ok {
+		return 0, "", ErrInvalidToken
+	}
+
+	userID, err := strconv.Atoi(c.Subject)
+	if err != nil {
+		return 0, "", ErrInvalidToken
+	}
+
+	return userID, c.Email, nil
+}
@@ -0,0 +1,111 @@
+package verification
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestSignVerify(t *testing.T) {
+	signer := NewSigner("test-secret", AudienceEmailVerification, time.Hour)
+	token, err := signer.Sign(7, "user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userID, email, err := signer.Verify(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userID != 7 || email != "user@example.com" {
+		t.Errorf("verified user %d with %s", userID, email)
+	}
+}
+
+// tamper replaces the token's claims with claims, keeping the signature
+func tamper(t *testing.T, token string, claims string) string {
+	t.Helper()
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("token has %d parts", len(parts))
+	}
+	parts[1] = base64.RawURLEncoding.EncodeToString([]byte(claims))
+	return strings.Join(parts, ".")
+}
+
+// sign signs claims with key and method, bypassing Sign
+func sign(t *testing.T, method jwt.SigningMethod, key interface{}, claims jwt.MapClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestVerifyRejects(t *testing.T) {
+	signer := NewSigner("test-secret", AudienceEmailVerification, time.Hour)
+	valid, err := signer.Sign(7, "user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := NewSigner("test-secret", AudienceEmailVerification, -time.Minute).Sign(7, "user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherSecret, err := NewSigner("other-secret", AudienceEmailVerification, time.Hour).Sign(7, "user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A login challenge must not verify an email, nor the other way round
+	otherAudience, err := NewSigner("test-secret", AudienceLoginChallenge, time.Hour).Sign(7, "user@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := time.Now().Add(time.Hour).Unix()
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{name: "empty"},
+		{name: "garbage", token: "not-a-token"},
+		{name: "expired", token: expired},
+		{name: "other secret", token: otherSecret},
+		{name: "other audience", token: otherAudience},
+		{
+			name:  "email swapped",
+			token: tamper(t, valid, `{"email":"attacker@example.com","sub":"7","aud":["email-verification"],"exp":`+strconv.FormatInt(exp, 10)+`}`),
+		},
+		{
+			name:  "user swapped",
+			token: tamper(t, valid, `{"email":"user@example.com","sub":"8","aud":["email-verification"],"exp":`+strconv.FormatInt(exp, 10)+`}`),
+		},
+		{name: "signature cut", token: valid[:strings.LastIndex(valid, ".")+1]},
+		{
+			name:  "unsigned",
+			token: sign(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, jwt.MapClaims{"email": "user@example.com", "sub": "7", "aud": AudienceEmailVerification, "exp": exp}),
+		},
+		{
+			name:  "no expiry",
+			token: sign(t, jwt.SigningMethodHS256, []byte("test-secret"), jwt.MapClaims{"email": "user@example.com", "sub": "7", "aud": AudienceEmailVerification}),
+		},
+		{
+			name:  "non-numeric subject",
+			token: sign(t, jwt.SigningMethodHS256, []byte("test-secret"), jwt.MapClaims{"email": "user@example.com", "sub": "admin", "aud": AudienceEmailVerification, "exp": exp}),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userID, email, err := signer.Verify(tt.token)
+			if err != ErrInvalidToken {
+				t.Errorf("verified user %d with %q, error %v", userID, email, err)
+			}
+		})
+	}
+}
@@ -1,11 +1,14 @@
 package consumers
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"inventory-worker/logger"
+	"inventory-worker/metrics"
+	"inventory-worker/money"
 	"inventory-worker/rabbitmq"
-	"log"
 	"time"
 )
 
@@ -18,11 +21,13 @@ type OrderPlacedMessage struct {
 	OrderID     int                `json:"order_id"`
 	UserID      int                `json:"user_id"`
 	Items       []OrderItemRequest `json:"items"`
-	TotalAmount float64            `json:"total_amount"`
+	TotalAmount money.Money        `json:"total_amount"`
 	Timestamp   time.Time          `json:"timestamp"`
 }
 
 type OrderItemRequest struct {
+	// VariantID is missing from orders placed before variants existed
+	VariantID int `json:"variant_id"`
 	ProductID int `json:"product_id"`
 	Quantity  int `json:"quantity"`
 }
@@ -42,6 +47,52 @@ type OrderFailedMessage struct {
 	Timestamp time.Time `json:"timestamp"`
 }
 
+type UserRegisteredMessage struct {
+	UserID          int       `json:"user_id"`
+	Name            string    `json:"name"`
+	Email           string    `json:"email"`
+	VerificationURL string    `json:"verification_url"`
+	Timestamp       time.Time `json:"timestamp"`
+}
+
+type PasswordResetMessage struct {
+	UserID    int       `json:"user_id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	ResetURL  string    `json:"reset_url"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+type EmailChangeMessage struct {
+	UserID          int       `json:"user_id"`
+	Name            string    `json:"name"`
+	Email           string    `json:"email"`
+	PreviousEmail   string    `json:"previous_email"`
+	ConfirmationURL string    `json:"confirmation_url"`
+	Timestamp       time.Time `json:"timestamp"`
+}
+
+// OrderShipmentMessage is published by order-service when an order ships or is delivered
+type OrderShipmentMessage struct {
+	OrderID        int       `json:"order_id"`
+	UserID         int       `json:"user_id"`
+	UserEmail      string    `json:"user_email"`
+	Status         string    `json:"status"`
+	Carrier        string    `json:"carrier"`
+	TrackingNumber string    `json:"tracking_number"`
+	Timestamp      time.Time `json:"timestamp"`
+}
+
+// UserDeletedMessage carries the former address only so the deletion can be
+// confirmed to the user; it is not stored
+type UserDeletedMessage struct {
+	UserID    int       `json:"user_id"`
+	Name      string    `json:"name"`
+	Email     string    `json:"email"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
 func NewInventoryConsumer(db *sql.DB, rmq *rabbitmq.RabbitMQ) *InventoryConsumer {
 	return &InventoryConsumer{
 		db:  db,
@@ -50,39 +101,62 @@ func NewInventoryConsumer(db *sql.DB, rmq *rabbitmq.RabbitMQ) *InventoryConsumer
 }
 
 // ProcessOrder processes order_placed messages with atomic inventory updates
-func (c *InventoryConsumer) ProcessOrder(body []byte) error {
+func (c *InventoryConsumer) ProcessOrder(ctx context.Context, body []byte) error {
 	var msg OrderPlacedMessage
 	if err := json.Unmarshal(body, &msg); err != nil {
 		return fmt.Errorf("failed to unmarshal message: %w", err)
 	}
 
-	log.Printf("Processing order #%d for user #%d", msg.OrderID, msg.UserID)
+	log := logger.FromContext(ctx).With("order_id", msg.OrderID, "user_id", msg.UserID)
+	log.Info("processing order")
 
 	// Start database transaction for atomic operations
-	tx, err := c.db.Begin()
+	tx, err := c.db.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("failed to start transaction: %w", err)
 	}
 	defer func() {
 		if r := tx.Rollback(); r != nil && r != sql.ErrTxDone {
-			log.Printf("tx rollback failed: %v", r)
+			log.Error("transaction rollback failed", "error", r)
 		}
 	}()
 
+	// Lock the order so it is processed once, and skip orders cancelled
+	// meanwhile, e.g. because the account was deleted
+	var status string
+	err = tx.QueryRowContext(ctx, `SELECT status FROM orders WHERE id = $1 FOR UPDATE`, msg.OrderID).Scan(&status)
+	if err == sql.ErrNoRows {
+		log.Warn("order not found, skipping")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to lock order: %w", err)
+	}
+	if status != "PENDING" {
+		log.Info("order no longer pending, skipping", "status", status)
+		return nil
+	}
+
 	// Check and update inventory for each item
 	for _, item := range msg.Items {
 		var currentStock int
-		var productName string
-
-		// Lock the row for update (prevents race conditions)
-		err := tx.QueryRow(
-			`SELECT name, stock FROM products WHERE id = $1 FOR UPDATE`,
-			item.ProductID,
-		).Scan(&productName, &currentStock)
+		var productName, sku string
+
+		// Lock the variant row for update (prevents race conditions).
+		// Orders from before variants existed take the product's default variant.
+		query := `SELECT v.id, p.name, v.sku, v.stock FROM product_variants v JOIN products p ON p.id = v.product_id
+			WHERE v.id = $1 FOR UPDATE OF v`
+		key := item.VariantID
+		if key == 0 {
+			query = `SELECT v.id, p.name, v.sku, v.stock FROM product_variants v JOIN products p ON p.id = v.product_id
+				WHERE v.product_id = $1 ORDER BY v.id LIMIT 1 FOR UPDATE OF v`
+			key = item.ProductID
+		}
+		err := tx.QueryRowContext(ctx, query, key).Scan(&item.VariantID, &productName, &sku, &currentStock)
 
 		if err == sql.ErrNoRows {
-			log.Printf("Product #%d not found for order #%d", item.ProductID, msg.OrderID)
-			c.failOrder(tx, msg.OrderID, msg.UserID, fmt.Sprintf("Product #%d not found", item.ProductID))
+			log.Warn("variant not found", "product_id", item.ProductID, "variant_id", item.VariantID)
+			c.failOrder(ctx, tx, msg.OrderID, msg.UserID, fmt.Sprintf("Product #%d not found", item.ProductID))
 			return nil
 		}
 
@@ -92,18 +166,20 @@ func (c *InventoryConsumer) ProcessOrder(body []byte) error {
 
 		// Check if sufficient stock is available
 		if currentStock < item.Quantity {
-			log.Printf("Insufficient stock for product #%d (%s). Available: %d, Requested: %d",
-				item.ProductID, productName, currentStock, item.Quantity)
-			c.failOrder(tx, msg.OrderID, msg.UserID,
-				fmt.Sprintf("Insufficient stock for %s. Available: %d, Requested: %d",
-					productName, currentStock, item.Quantity))
+			metrics.StockOuts.Inc()
+			log.Warn("insufficient stock",
+				"product_id", item.ProductID, "variant_id", item.VariantID, "sku", sku, "product_name", productName,
+				"available", currentStock, "requested", item.Quantity)
+			c.failOrder(ctx, tx, msg.OrderID, msg.UserID,
+				fmt.Sprintf("Insufficient stock for %s (%s). Available: %d, Requested: %d",
+					productName, sku, currentStock, item.Quantity))
 			return nil
 		}
 
-		// Decrement stock atomically
-		result, err := tx.Exec(
-			`UPDATE products SET stock = stock - $1 WHERE id = $2`,
-			item.Quantity, item.ProductID,
+		// Decrement stock atomically; a trigger updates the product's total
+		result, err := tx.ExecContext(ctx,
+			`UPDATE product_variants SET stock = stock - $1 WHERE id = $2`,
+			item.Quantity, item.VariantID,
 		)
 
 		if err != nil {
@@ -112,15 +188,16 @@ func (c *InventoryConsumer) ProcessOrder(body []byte) error {
 
 		rowsAffected, _ := result.RowsAffected()
 		if rowsAffected == 0 {
-			return fmt.Errorf("failed to update stock for product #%d", item.ProductID)
+			return fmt.Errorf("failed to update stock for variant #%d", item.VariantID)
 		}
 
-		log.Printf("Decremented stock for product #%d (%s) by %d units. New stock: %d",
-			item.ProductID, productName, item.Quantity, currentStock-item.Quantity)
+		log.Info("stock decremented",
+			"product_id", item.ProductID, "variant_id", item.VariantID, "sku", sku, "product_name", productName,
+			"quantity", item.Quantity, "new_stock", currentStock-item.Quantity)
 	}
 
 	// All items have sufficient stock - update order status to CONFIRMED
-	_, err = tx.Exec(
+	_, err = tx.ExecContext(ctx,
 		`UPDATE orders SET status = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2`,
 		"CONFIRMED", msg.OrderID,
 	)
@@ -129,16 +206,24 @@ func (c *InventoryConsumer) ProcessOrder(body []byte) error {
 		return fmt.Errorf("failed to update order status: %w", err)
 	}
 
+	// Invoice the order in the same transaction, so every confirmed order
+	// has exactly one invoice
+	invoiceNumber, err := issueInvoice(ctx, tx, msg.OrderID)
+	if err != nil {
+		return err
+	}
+
 	// Commit the transaction
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
-	log.Printf("Order #%d confirmed successfully", msg.OrderID)
+	log.Info("order confirmed", "invoice_number", invoiceNumber)
+	metrics.OrdersProcessed.WithLabelValues("CONFIRMED").Inc()
 
 	// Get user email for notification
 	var userEmail string
-	email_err := c.db.QueryRow("SELECT email FROM users WHERE id = $1", msg.UserID).Scan(&userEmail)
+	email_err := c.db.QueryRowContext(ctx, "SELECT email FROM users WHERE id = $1", msg.UserID).Scan(&userEmail)
 	if email_err != nil {
 		return fmt.Errorf("failed to send email: %w", email_err)
 	}
@@ -151,9 +236,9 @@ func (c *InventoryConsumer) ProcessOrder(body []byte) error {
 		Timestamp: time.Now(),
 	}
 
-	err = c.rmq.Publish(rabbitmq.QueueOrderConfirmed, confirmedMsg)
+	err = c.rmq.Publish(ctx, rabbitmq.QueueOrderConfirmed, confirmedMsg)
 	if err != nil {
-		log.Printf("Failed to publish order_confirmed message: %v", err)
+		log.Error("failed to publish order_confirmed message", "error", err)
 		// Don't return error - order is already confirmed
 	}
 
@@ -161,31 +246,50 @@ func (c *InventoryConsumer) ProcessOrder(body []byte) error {
 }
 
 // failOrder marks order as CANCELLED and publishes failure message
-func (c *InventoryConsumer) failOrder(tx *sql.Tx, orderID, userID int, reason string) {
-	log.Printf("Failing order #%d: %s", orderID, reason)
+func (c *InventoryConsumer) failOrder(ctx context.Context, tx *sql.Tx, orderID, userID int, reason string) {
+	log := logger.FromContext(ctx).With("order_id", orderID, "user_id", userID)
+	log.Warn("failing order", "reason", reason)
 
 	// Update order status to CANCELLED
-	_, err := tx.Exec(
+	_, err := tx.ExecContext(ctx,
 		`UPDATE orders SET status = $1, updated_at = CURRENT_TIMESTAMP WHERE id = $2`,
 		"CANCELLED", orderID,
 	)
 
 	if err != nil {
-		log.Printf("Failed to update order status to CANCELLED: %v", err)
+		log.Error("failed to update order status to CANCELLED", "error", err)
+		return
+	}
+
+	// Give back the coupon the order used, so it counts towards no limit
+	_, err = tx.ExecContext(ctx,
+		`WITH released AS (
+			UPDATE promotion_redemptions SET released_at = CURRENT_TIMESTAMP
+			WHERE order_id = $1 AND released_at IS NULL
+			RETURNING promotion_id
+		 )
+		 UPDATE promotions SET redemption_count = redemption_count - 1
+		 WHERE id IN (SELECT promotion_id FROM released)`,
+		orderID,
+	)
+
+	if err != nil {
+		log.Error("failed to release promotion redemption", "error", err)
 		return
 	}
 
 	// Commit the transaction
 	if err := tx.Commit(); err != nil {
-		log.Printf("Failed to commit transaction: %v", err)
+		log.Error("failed to commit transaction", "error", err)
 		return
 	}
+	metrics.OrdersProcessed.WithLabelValues("CANCELLED").Inc()
 
 	// Get user email
 	var userEmail string
-	if err := c.db.QueryRow("SELECT email FROM users WHERE id = $1", userID).Scan(&userEmail); err != nil {
-		log.Printf("failed to get email for user %d: %v", userID, err)
-		return // cukup keluar dari fungsi
+	if err := c.db.QueryRowContext(ctx, "SELECT email FROM users WHERE id = $1", userID).Scan(&userEmail); err != nil {
+		log.Error("failed to get user email", "error", err)
+		return
 	}
 
 	// Publish order_failed message
@@ -197,8 +301,8 @@ func (c *InventoryConsumer) failOrder(tx *sql.Tx, orderID, userID int, reason st
 		Timestamp: time.Now(),
 	}
 
-	err = c.rmq.Publish(rabbitmq.QueueOrderFailed, failedMsg)
+	err = c.rmq.Publish(ctx, rabbitmq.QueueOrderFailed, failedMsg)
 	if err != nil {
-		log.Printf("Failed to publish order_failed message: %v", err)
+		log.Error("failed to publish order_failed message", "error", err)
 	}
 }
@@ -0,0 +1,99 @@
+package consumers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"inventory-worker/invoice"
+	"strings"
+	"time"
+)
+
+// issueInvoice numbers and renders the invoice of an order being confirmed in
+// tx. The number is taken from the year's sequence row, which stays locked
+// until tx ends, so concurrent confirmations queue for it and a rollback
+// leaves no gap.
+func issueInvoice(ctx context.Context, tx *sql.Tx, orderID int) (string, error) {
+	issuedAt := time.Now()
+	year := issuedAt.Year()
+
+	var sequence int
+	err := tx.QueryRowContext(ctx,
+		`INSERT INTO invoice_sequences (year, last_number) VALUES ($1, 1)
+		 ON CONFLICT (year) DO UPDATE SET last_number = invoice_sequences.last_number + 1
+		 RETURNING last_number`,
+		year,
+	).Scan(&sequence)
+	if err != nil {
+		return "", fmt.Errorf("failed to allocate invoice number: %w", err)
+	}
+
+	inv := invoice.Invoice{
+		Number:   invoice.Number(year, sequence),
+		IssuedAt: issuedAt,
+		OrderID:  orderID,
+	}
+
+	var recipient, line1, line2, city, region, postalCode, country string
+	err = tx.QueryRowContext(ctx,
+		`SELECT o.created_at, u.name, u.email,
+			COALESCE(o.shipping_recipient_name, ''), COALESCE(o.shipping_line1, ''), COALESCE(o.shipping_line2, ''),
+			COALESCE(o.shipping_city, ''), COALESCE(o.shipping_region, ''), COALESCE(o.shipping_postal_code, ''),
+			COALESCE(o.shipping_country, '')
+		 FROM orders o JOIN users u ON u.id = o.user_id
+		 WHERE o.id = $1`,
+		orderID,
+	).Scan(&inv.OrderedAt, &inv.CustomerName, &inv.CustomerEmail,
+		&recipient, &line1, &line2, &city, &region, &postalCode, &country)
+	if err != nil {
+		return "", fmt.Errorf("failed to get invoice details: %w", err)
+	}
+
+	if recipient != "" {
+		for _, line := range []string{recipient, line1, line2, strings.TrimSpace(city + " " + postalCode), region, country} {
+			if line != "" {
+				inv.ShipTo = append(inv.ShipTo, line)
+			}
+		}
+	}
+
+	summary, err := loadOrderSummary(ctx, tx, orderID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get invoice details: %w", err)
+	}
+
+	inv.Subtotal, inv.Discount, inv.PromotionCode = summary.Subtotal, summary.Discount, summary.PromotionCode
+	inv.Tax, inv.TaxInclusive, inv.Total = summary.Tax, summary.TaxInclusive, summary.Total
+	for _, item := range summary.Items {
+		amount, err := item.Price.Mul(int64(item.Quantity))
+		if err != nil {
+			return "", fmt.Errorf("failed to price invoice line: %w", err)
+		}
+		inv.Lines = append(inv.Lines, invoice.Line{
+			Name:         item.Name,
+			Quantity:     item.Quantity,
+			UnitPrice:    item.Price,
+			Amount:       amount,
+			Discount:     item.Discount,
+			TaxName:      item.TaxName,
+			TaxRate:      item.TaxRate,
+			TaxInclusive: item.TaxInclusive,
+			Tax:          item.Tax,
+		})
+	}
+
+	pdf, err := invoice.Render(inv)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO invoices (order_id, number, year, sequence, pdf) VALUES ($1, $2, $3, $4, $5)`,
+		orderID, inv.Number, year, sequence, pdf,
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to store invoice: %w", err)
+	}
+
+	return inv.Number, nil
+}
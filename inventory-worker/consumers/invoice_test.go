@@ -0,0 +1,153 @@
+package consumers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"inventory-worker/database"
+	"inventory-worker/invoice"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// openTestDB connects to the database in TEST_DATABASE_URL, skipping the
+// test when it is not set
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := database.InitDB(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// createOrders inserts a user with n orders, removed again after the test
+func createOrders(t *testing.T, db *sql.DB, n int) []int {
+	t.Helper()
+
+	var userID int
+	err := db.QueryRow(
+		"INSERT INTO users (name, email, password) VALUES ('Invoice Test', $1, '') RETURNING id",
+		fmt.Sprintf("invoice-test-%d@example.com", time.Now().UnixNano()),
+	).Scan(&userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orderIDs := make([]int, n)
+	for i := range orderIDs {
+		err := db.QueryRow("INSERT INTO orders (user_id, total_amount) VALUES ($1, 10000000) RETURNING id", userID).Scan(&orderIDs[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Cleanup(func() {
+		db.Exec("DELETE FROM invoices WHERE order_id IN (SELECT id FROM orders WHERE user_id = $1)", userID)
+		db.Exec("DELETE FROM orders WHERE user_id = $1", userID)
+		db.Exec("DELETE FROM users WHERE id = $1", userID)
+	})
+	return orderIDs
+}
+
+func TestIssueInvoiceConcurrently(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	orderIDs := createOrders(t, db, 2)
+
+	// Both confirmations start their transactions before either takes a number
+	var started, wg sync.WaitGroup
+	started.Add(len(orderIDs))
+	numbers := make([]string, len(orderIDs))
+	errs := make([]error, len(orderIDs))
+	for i, orderID := range orderIDs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tx, err := db.BeginTx(ctx, nil)
+			started.Done()
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			defer tx.Rollback()
+			started.Wait()
+
+			if numbers[i], errs[i] = issueInvoice(ctx, tx, orderID); errs[i] == nil {
+				errs[i] = tx.Commit()
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("order %d: %v", orderIDs[i], err)
+		}
+	}
+
+	sequences := make([]int, len(orderIDs))
+	for i, orderID := range orderIDs {
+		var number string
+		var year int
+		err := db.QueryRow("SELECT number, year, sequence FROM invoices WHERE order_id = $1", orderID).Scan(&number, &year, &sequences[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if number != numbers[i] || number != invoice.Number(year, sequences[i]) {
+			t.Errorf("order %d: stored %s for sequence %d, issued %s", orderID, number, sequences[i], numbers[i])
+		}
+	}
+
+	low, high := min(sequences[0], sequences[1]), max(sequences[0], sequences[1])
+	if high != low+1 {
+		t.Errorf("sequences %v are not consecutive", sequences)
+	}
+}
+
+func TestIssueInvoiceRollbackLeavesNoGap(t *testing.T) {
+	db := openTestDB(t)
+	ctx := context.Background()
+	orderIDs := createOrders(t, db, 2)
+
+	issue := func(orderID int, commit bool) int {
+		t.Helper()
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Rollback()
+
+		number, err := issueInvoice(ctx, tx, orderID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if commit {
+			if err := tx.Commit(); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		var sequence int
+		fmt.Sscanf(number[len(number)-6:], "%d", &sequence)
+		return sequence
+	}
+
+	first := issue(orderIDs[0], true)
+	issue(orderIDs[1], false)
+	if second := issue(orderIDs[1], true); second != first+1 {
+		t.Errorf("sequence %d after %d and a rolled back confirmation, want %d", second, first, first+1)
+	}
+}
@@ -1,11 +1,20 @@
 package consumers
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"log"
+	"inventory-worker/logger"
+	"inventory-worker/metrics"
+	"inventory-worker/money"
+	"mime"
+	"mime/multipart"
 	"net/smtp"
+	"net/textproto"
+	"strings"
 	"time"
 )
 
@@ -20,115 +29,336 @@ func NewNotificationConsumer(db *sql.DB) *NotificationConsumer {
 const (
 	smtpHost = "qweqwe"
 	smtpPort = "qweqwe"
-	smtpUser = "vsddsvsvd" // ganti dengan emailmu
-	smtpPass = "asfdgsdvs" // ganti dengan App Password
+	smtpUser = "vsddsvsvd" // replace with the sender address
+	smtpPass = "asfdgsdvs" // replace with the SMTP app password
 )
 
 // ProcessConfirmed handles order_confirmed messages and simulates sending email
-func (c *NotificationConsumer) ProcessConfirmed(body []byte) error {
+func (c *NotificationConsumer) ProcessConfirmed(ctx context.Context, body []byte) error {
 	var msg OrderConfirmedMessage
 	if err := json.Unmarshal(body, &msg); err != nil {
 		return fmt.Errorf("failed to unmarshal message: %w", err)
 	}
 
-	log.Printf("Processing order confirmation notification for order #%d", msg.OrderID)
+	log := logger.FromContext(ctx).With("order_id", msg.OrderID, "user_id", msg.UserID)
+	log.Info("processing order confirmation notification")
 
 	// Get order details
-	var totalAmount float64
-	err := c.db.QueryRow(
-		`SELECT total_amount FROM orders WHERE id = $1`,
-		msg.OrderID,
-	).Scan(&totalAmount)
-
+	summary, err := loadOrderSummary(ctx, c.db, msg.OrderID)
 	if err != nil {
 		return fmt.Errorf("failed to get order details: %w", err)
 	}
 
 	// Simulate sending confirmation email
-	email_err := c.sendConfirmationEmail(msg.UserEmail, msg.OrderID, totalAmount)
+	email_err := c.sendConfirmationEmail(ctx, msg.UserEmail, msg.OrderID, summary)
 	if email_err != nil {
 		return fmt.Errorf("failed to send email: %w", email_err)
 	}
 
-	log.Printf("Confirmation email sent to %s for order #%d", msg.UserEmail, msg.OrderID)
+	log.Info("confirmation email sent")
 
 	return nil
 }
 
 // ProcessFailed handles order_failed messages and simulates sending email
-func (c *NotificationConsumer) ProcessFailed(body []byte) error {
+func (c *NotificationConsumer) ProcessFailed(ctx context.Context, body []byte) error {
 	var msg OrderFailedMessage
 	if err := json.Unmarshal(body, &msg); err != nil {
 		return fmt.Errorf("failed to unmarshal message: %w", err)
 	}
 
-	log.Printf("Processing order failure notification for order #%d", msg.OrderID)
+	log := logger.FromContext(ctx).With("order_id", msg.OrderID, "user_id", msg.UserID)
+	log.Info("processing order failure notification")
 
 	// Simulate sending failure email
-	if err := c.sendFailureEmail(msg.UserEmail, msg.OrderID, msg.Reason); err != nil {
+	if err := c.sendFailureEmail(ctx, msg.UserEmail, msg.OrderID, msg.Reason); err != nil {
 		return fmt.Errorf("failed to send failure email: %w", err)
 	}
 
-	log.Printf("Failure email sent to %s for order #%d", msg.UserEmail, msg.OrderID)
+	log.Info("failure email sent")
+
+	return nil
+}
+
+// ProcessUserRegistered handles user_registered messages by emailing the verification link
+func (c *NotificationConsumer) ProcessUserRegistered(ctx context.Context, body []byte) error {
+	var msg UserRegisteredMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return fmt.Errorf("failed to unmarshal message: %w", err)
+	}
+
+	log := logger.FromContext(ctx).With("user_id", msg.UserID)
+	log.Info("processing email verification notification")
+
+	if err := c.sendVerificationEmail(ctx, msg.Email, msg.Name, msg.VerificationURL); err != nil {
+		return fmt.Errorf("failed to send verification email: %w", err)
+	}
+
+	log.Info("verification email sent")
+
+	return nil
+}
+
+// ProcessPasswordReset handles password_reset_requested messages by emailing the reset link
+func (c *NotificationConsumer) ProcessPasswordReset(ctx context.Context, body []byte) error {
+	var msg PasswordResetMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return fmt.Errorf("failed to unmarshal message: %w", err)
+	}
+
+	log := logger.FromContext(ctx).With("user_id", msg.UserID)
+	log.Info("processing password reset notification")
+
+	// A reset link that expired while queued is useless, so drop the message
+	if time.Now().After(msg.ExpiresAt) {
+		log.Warn("password reset link expired before delivery")
+		return nil
+	}
+
+	if err := c.sendPasswordResetEmail(ctx, msg.Email, msg.Name, msg.ResetURL, msg.ExpiresAt); err != nil {
+		return fmt.Errorf("failed to send password reset email: %w", err)
+	}
+
+	log.Info("password reset email sent")
+
+	return nil
+}
+
+// ProcessEmailChange sends the confirmation link to the new address and lets
+// the previous address know, so an unexpected change does not go unnoticed
+func (c *NotificationConsumer) ProcessEmailChange(ctx context.Context, body []byte) error {
+	var msg EmailChangeMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return fmt.Errorf("failed to unmarshal message: %w", err)
+	}
+
+	log := logger.FromContext(ctx).With("user_id", msg.UserID)
+	log.Info("processing email change notification")
+
+	if err := c.sendEmailChangeEmail(ctx, msg.Email, msg.Name, msg.ConfirmationURL); err != nil {
+		return fmt.Errorf("failed to send email change confirmation: %w", err)
+	}
+
+	// The link already went out, so a failed notice must not requeue the message
+	if err := c.sendEmailChangeNotice(ctx, msg.PreviousEmail, msg.Name, msg.Email); err != nil {
+		log.Error("failed to notify previous address of email change", "error", err)
+	}
+
+	log.Info("email change confirmation sent")
+
+	return nil
+}
+
+// ProcessShipment handles order_shipment messages, emailing tracking details
+// when an order ships and a notice when it is delivered
+func (c *NotificationConsumer) ProcessShipment(ctx context.Context, body []byte) error {
+	var msg OrderShipmentMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return fmt.Errorf("failed to unmarshal message: %w", err)
+	}
+
+	log := logger.FromContext(ctx).With("order_id", msg.OrderID, "user_id", msg.UserID, "status", msg.Status)
+	log.Info("processing shipment notification")
+
+	switch msg.Status {
+	case "SHIPPED":
+		// Show where the parcel is going, as snapshotted on the order
+		var recipient, city, country string
+		err := c.db.QueryRowContext(ctx,
+			`SELECT COALESCE(shipping_recipient_name, ''), COALESCE(shipping_city, ''), COALESCE(shipping_country, '')
+			 FROM orders WHERE id = $1`,
+			msg.OrderID,
+		).Scan(&recipient, &city, &country)
+		if err != nil {
+			return fmt.Errorf("failed to get order details: %w", err)
+		}
+
+		destination := "the address on your order"
+		if recipient != "" {
+			destination = fmt.Sprintf("%s, %s (%s)", recipient, city, country)
+		}
+
+		if err := c.sendShippedEmail(ctx, msg.UserEmail, msg.OrderID, msg.Carrier, msg.TrackingNumber, destination); err != nil {
+			return fmt.Errorf("failed to send shipment email: %w", err)
+		}
+	case "DELIVERED":
+		if err := c.sendDeliveredEmail(ctx, msg.UserEmail, msg.OrderID); err != nil {
+			return fmt.Errorf("failed to send delivery email: %w", err)
+		}
+	default:
+		log.Warn("no notification for fulfillment status, skipping")
+		return nil
+	}
+
+	log.Info("shipment email sent")
+
+	return nil
+}
+
+// ProcessUserDeleted confirms to the former address that the account is gone
+func (c *NotificationConsumer) ProcessUserDeleted(ctx context.Context, body []byte) error {
+	var msg UserDeletedMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return fmt.Errorf("failed to unmarshal message: %w", err)
+	}
+
+	log := logger.FromContext(ctx).With("user_id", msg.UserID)
+	log.Info("processing account deletion notification")
+
+	if msg.Email == "" {
+		return nil
+	}
+
+	if err := c.sendAccountDeletedEmail(ctx, msg.Email, msg.Name); err != nil {
+		return fmt.Errorf("failed to send account deletion email: %w", err)
+	}
+
+	log.Info("account deletion email sent")
 
 	return nil
 }
 
-func (c *NotificationConsumer) HandleOrderConfirmed(body []byte) error {
+func (c *NotificationConsumer) HandleOrderConfirmed(ctx context.Context, body []byte) error {
 	var order OrderConfirmedMessage
 	if err := json.Unmarshal(body, &order); err != nil {
 		return fmt.Errorf("failed to unmarshal order: %w", err)
 	}
 
-	log.Printf("📧 Sending confirmation email to %s for order %d", order.UserEmail, order.OrderID)
+	logger.FromContext(ctx).Info("sending confirmation email", "order_id", order.OrderID)
 
-	if err := c.sendConfirmationEmail(order.UserEmail, order.OrderID, 0); err != nil {
+	summary, err := loadOrderSummary(ctx, c.db, order.OrderID)
+	if err != nil {
+		return fmt.Errorf("failed to get order details: %w", err)
+	}
+
+	if err := c.sendConfirmationEmail(ctx, order.UserEmail, order.OrderID, summary); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (c *NotificationConsumer) HandleOrderFailed(body []byte) error {
+func (c *NotificationConsumer) HandleOrderFailed(ctx context.Context, body []byte) error {
 	var msg OrderFailedMessage
 	if err := json.Unmarshal(body, &msg); err != nil {
 		return fmt.Errorf("failed to unmarshal failed order: %w", err)
 	}
 
-	return c.sendFailureEmail(msg.UserEmail, msg.OrderID, msg.Reason)
-}
-
-// sendConfirmationEmail simulates sending a confirmation email
-//
-//	func (c *NotificationConsumer) sendConfirmationEmail(email string, orderID int, totalAmount float64) {
-//		// In production, this would integrate with an email service (SendGrid, AWS SES, etc.)
-//		log.Println("==============================================")
-//		log.Println("📧 SENDING CONFIRMATION EMAIL")
-//		log.Println("==============================================")
-//		log.Printf("To: %s", email)
-//		log.Printf("Subject: Order #%d Confirmed! 🎉", orderID)
-//		log.Println("----------------------------------------------")
-//		log.Println("Dear Customer,")
-//		log.Println("")
-//		log.Printf("Your order #%d has been confirmed successfully!", orderID)
-//		log.Printf("Total Amount: Rp %.2f", totalAmount)
-//		log.Println("")
-//		log.Println("We will process your order shortly and keep you updated.")
-//		log.Println("")
-//		log.Println("Thank you for shopping with us!")
-//		log.Println("")
-//		log.Printf("Order Date: %s", time.Now().Format("2006-01-02 15:04:05"))
-//		log.Println("==============================================")
-//		log.Println("")
-//	}
-func (c *NotificationConsumer) sendConfirmationEmail(email string, orderID int, totalAmount float64) error {
-	// Buat subject dan body email
+	return c.sendFailureEmail(ctx, msg.UserEmail, msg.OrderID, msg.Reason)
+}
+
+// orderSummary is the itemized breakdown of an order for emails
+type orderSummary struct {
+	Items         []summaryItem
+	Subtotal      money.Money
+	Discount      money.Money
+	PromotionCode string
+	Tax           money.Money
+	// TaxInclusive is set when all of Tax is already part of the prices
+	TaxInclusive bool
+	Total        money.Money
+}
+
+type summaryItem struct {
+	Name         string
+	Quantity     int
+	Price        money.Money
+	Discount     money.Money
+	TaxName      string
+	TaxRate      string
+	TaxInclusive bool
+	Tax          money.Money
+}
+
+// queryer is a *sql.DB or, to read uncommitted changes, a *sql.Tx
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// loadOrderSummary reads an order's totals and items
+func loadOrderSummary(ctx context.Context, q queryer, orderID int) (*orderSummary, error) {
+	var s orderSummary
+	var currency string
+	err := q.QueryRowContext(ctx,
+		`SELECT COALESCE(subtotal_amount, total_amount), discount_amount, COALESCE(promotion_code, ''),
+			tax_amount, total_amount, currency
+		 FROM orders WHERE id = $1`,
+		orderID,
+	).Scan(&s.Subtotal.Amount, &s.Discount.Amount, &s.PromotionCode, &s.Tax.Amount, &s.Total.Amount, &currency)
+	if err != nil {
+		return nil, err
+	}
+	s.Subtotal.Currency, s.Discount.Currency, s.Tax.Currency, s.Total.Currency = currency, currency, currency, currency
+
+	rows, err := q.QueryContext(ctx,
+		`SELECT p.name || COALESCE(' (' || v.sku || ')', ''), oi.quantity, oi.price, oi.discount_amount,
+			COALESCE(oi.tax_name, ''), oi.tax_rate::TEXT, oi.tax_inclusive, oi.tax_amount
+		 FROM order_items oi JOIN products p ON p.id = oi.product_id
+		 LEFT JOIN product_variants v ON v.id = oi.variant_id
+		 WHERE oi.order_id = $1 ORDER BY oi.id`,
+		orderID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	s.TaxInclusive = true
+	for rows.Next() {
+		var item summaryItem
+		err := rows.Scan(&item.Name, &item.Quantity, &item.Price.Amount, &item.Discount.Amount, &item.TaxName, &item.TaxRate,
+			&item.TaxInclusive, &item.Tax.Amount)
+		if err != nil {
+			return nil, err
+		}
+		item.Price.Currency, item.Discount.Currency, item.Tax.Currency = currency, currency, currency
+		item.TaxRate = strings.TrimSuffix(strings.TrimRight(item.TaxRate, "0"), ".")
+		if item.TaxName != "" && !item.TaxInclusive {
+			s.TaxInclusive = false
+		}
+		s.Items = append(s.Items, item)
+	}
+	return &s, rows.Err()
+}
+
+// sendConfirmationEmail sends the order confirmation email with an itemized breakdown
+func (c *NotificationConsumer) sendConfirmationEmail(ctx context.Context, email string, orderID int, summary *orderSummary) error {
+	invoice, err := loadInvoice(ctx, c.db, orderID)
+	if err != nil {
+		return fmt.Errorf("failed to get invoice: %w", err)
+	}
+
+	var breakdown strings.Builder
+	for _, item := range summary.Items {
+		lineTotal, _ := item.Price.Mul(int64(item.Quantity))
+		fmt.Fprintf(&breakdown, "  %d x %s @ %s = %s\n", item.Quantity, item.Name, item.Price.Format(), lineTotal.Format())
+		if !item.Discount.IsZero() {
+			fmt.Fprintf(&breakdown, "      discount -%s\n", item.Discount.Format())
+		}
+		if item.TaxName != "" {
+			fmt.Fprintf(&breakdown, "      %s %s%%: %s\n", item.TaxName, item.TaxRate, item.Tax.Format())
+		}
+	}
+
+	fmt.Fprintf(&breakdown, "\nSubtotal: %s\n", summary.Subtotal.Format())
+	if !summary.Discount.IsZero() {
+		fmt.Fprintf(&breakdown, "Discount (%s): -%s\n", summary.PromotionCode, summary.Discount.Format())
+	}
+	if summary.TaxInclusive {
+		fmt.Fprintf(&breakdown, "Tax (included): %s\n", summary.Tax.Format())
+	} else {
+		fmt.Fprintf(&breakdown, "Tax: %s\n", summary.Tax.Format())
+	}
+
+	// Build subject and body
 	subject := fmt.Sprintf("Order #%d Confirmed! 🎉", orderID)
 	body := fmt.Sprintf(
 		`Dear Customer,
 
 Your order #%d has been confirmed successfully!
-Total Amount: Rp %.2f
+
+%sTotal Amount: %s
 
 We will process your order shortly and keep you updated.
 
@@ -137,33 +367,27 @@ Thank you for shopping with us!
 Order Date: %s
 `,
 		orderID,
-		totalAmount,
+		breakdown.String(),
+		summary.Total.Format(),
 		time.Now().Format("2006-01-02 15:04:05"),
 	)
 
-	// Buat message email lengkap
-	msg := "From: " + smtpUser + "\n" +
-		"To: " + email + "\n" +
-		"Subject: " + subject + "\n\n" +
-		body
-
-	// Setup SMTP auth
-	addr := smtpHost + ":" + smtpPort
-	auth := smtp.PlainAuth("", smtpUser, smtpPass, smtpHost)
+	var attachments []attachment
+	if invoice != nil {
+		attachments = append(attachments, *invoice)
+	}
 
-	// Kirim email
-	err := smtp.SendMail(addr, auth, smtpUser, []string{email}, []byte(msg))
-	if err != nil {
-		return fmt.Errorf("failed to send email: %w", err)
+	if err := sendEmail(email, subject, body, "confirmation", attachments...); err != nil {
+		return err
 	}
 
-	log.Printf("✅ Email sent to %s successfully!", email)
+	logger.FromContext(ctx).Info("email sent", "type", "confirmation", "order_id", orderID)
 	return nil
 }
 
-// sendFailureEmail simulates sending a failure email
-func (c *NotificationConsumer) sendFailureEmail(email string, orderID int, reason string) error {
-	// Subject dan body email
+// sendFailureEmail sends the order cancellation email
+func (c *NotificationConsumer) sendFailureEmail(ctx context.Context, email string, orderID int, reason string) error {
+	// Build subject and body
 	subject := fmt.Sprintf("Order #%d Cancelled ❌", orderID)
 	body := fmt.Sprintf(
 		`Dear Customer,
@@ -181,21 +405,263 @@ Order Date: %s
 		time.Now().Format("2006-01-02 15:04:05"),
 	)
 
-	// Buat message email lengkap
+	if err := sendEmail(email, subject, body, "failure"); err != nil {
+		return err
+	}
+
+	logger.FromContext(ctx).Info("email sent", "type", "failure", "order_id", orderID)
+	return nil
+}
+
+// sendShippedEmail sends the carrier and tracking number of a shipped order
+func (c *NotificationConsumer) sendShippedEmail(ctx context.Context, email string, orderID int, carrier, trackingNumber, destination string) error {
+	// Build subject and body
+	subject := fmt.Sprintf("Order #%d Shipped 📦", orderID)
+	body := fmt.Sprintf(
+		`Dear Customer,
+
+Good news! Your order #%d is on its way to %s.
+
+Carrier: %s
+Tracking Number: %s
+
+You can follow the delivery on the carrier's website using the tracking number.
+
+Shipped Date: %s
+`,
+		orderID,
+		destination,
+		carrier,
+		trackingNumber,
+		time.Now().Format("2006-01-02 15:04:05"),
+	)
+
+	if err := sendEmail(email, subject, body, "shipped"); err != nil {
+		return err
+	}
+
+	logger.FromContext(ctx).Info("email sent", "type", "shipped", "order_id", orderID)
+	return nil
+}
+
+// sendDeliveredEmail lets the customer know their order arrived
+func (c *NotificationConsumer) sendDeliveredEmail(ctx context.Context, email string, orderID int) error {
+	// Build subject and body
+	subject := fmt.Sprintf("Order #%d Delivered ✅", orderID)
+	body := fmt.Sprintf(
+		`Dear Customer,
+
+Your order #%d has been delivered. We hope you enjoy your purchase!
+
+If anything is missing or damaged, please contact our customer service.
+
+Delivered Date: %s
+`,
+		orderID,
+		time.Now().Format("2006-01-02 15:04:05"),
+	)
+
+	if err := sendEmail(email, subject, body, "delivered"); err != nil {
+		return err
+	}
+
+	logger.FromContext(ctx).Info("email sent", "type", "delivered", "order_id", orderID)
+	return nil
+}
+
+// sendVerificationEmail sends the email address verification link
+func (c *NotificationConsumer) sendVerificationEmail(ctx context.Context, email, name, verificationURL string) error {
+	// Build subject and body
+	subject := "Please verify your email address"
+	body := fmt.Sprintf(
+		`Hi %s,
+
+Thanks for registering! Please confirm your email address by opening the link below:
+
+%s
+
+The link expires in 24 hours. If you did not create an account, you can ignore this email.
+`,
+		name,
+		verificationURL,
+	)
+
+	if err := sendEmail(email, subject, body, "verification"); err != nil {
+		return err
+	}
+
+	logger.FromContext(ctx).Info("email sent", "type", "verification")
+	return nil
+}
+
+// sendPasswordResetEmail sends the password reset link
+func (c *NotificationConsumer) sendPasswordResetEmail(ctx context.Context, email, name, resetURL string, expiresAt time.Time) error {
+	// Build subject and body
+	subject := "Reset your password"
+	body := fmt.Sprintf(
+		`Hi %s,
+
+We received a request to reset your password. Open the link below to choose a new one:
+
+%s
+
+The link can be used once and expires at %s.
+If you did not request a password reset, you can safely ignore this email.
+`,
+		name,
+		resetURL,
+		expiresAt.Format("2006-01-02 15:04:05"),
+	)
+
+	if err := sendEmail(email, subject, body, "password_reset"); err != nil {
+		return err
+	}
+
+	logger.FromContext(ctx).Info("email sent", "type", "password_reset")
+	return nil
+}
+
+func (c *NotificationConsumer) sendEmailChangeEmail(ctx context.Context, email, name, confirmationURL string) error {
+	// Build subject and body
+	subject := "Confirm your new email address"
+	body := fmt.Sprintf(
+		`Hi %s,
+
+Please confirm that you want to use this address for your account by opening the link below:
+
+%s
+
+The link expires in 24 hours. Until then your previous address stays in use.
+If you did not request this change, you can ignore this email.
+`,
+		name,
+		confirmationURL,
+	)
+
+	if err := sendEmail(email, subject, body, "email_change"); err != nil {
+		return err
+	}
+
+	logger.FromContext(ctx).Info("email sent", "type", "email_change")
+	return nil
+}
+
+func (c *NotificationConsumer) sendEmailChangeNotice(ctx context.Context, email, name, newEmail string) error {
+	// Build subject and body
+	subject := "Your email address is being changed"
+	body := fmt.Sprintf(
+		`Hi %s,
+
+A request was made to change the email address of your account to %s.
+The change takes effect once the new address is confirmed.
+
+If you did not request this, change your password right away.
+`,
+		name,
+		newEmail,
+	)
+
+	if err := sendEmail(email, subject, body, "email_change_notice"); err != nil {
+		return err
+	}
+
+	logger.FromContext(ctx).Info("email sent", "type", "email_change_notice")
+	return nil
+}
+
+func (c *NotificationConsumer) sendAccountDeletedEmail(ctx context.Context, email, name string) error {
+	// Build subject and body
+	subject := "Your account has been deleted"
+	body := fmt.Sprintf(
+		`Hi %s,
+
+Your account and personal data have been deleted as requested.
+Records of past orders are kept without your personal details for accounting purposes.
+
+If you did not request this, please contact support.
+`,
+		name,
+	)
+
+	if err := sendEmail(email, subject, body, "account_deleted"); err != nil {
+		return err
+	}
+
+	logger.FromContext(ctx).Info("email sent", "type", "account_deleted")
+	return nil
+}
+
+// sendEmail delivers a plain text email over SMTP, counting failures by kind
+// attachment is a file sent along with an email
+type attachment struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+func sendEmail(to, subject, body, kind string, attachments ...attachment) error {
+	// Assemble the full message
 	msg := "From: " + smtpUser + "\n" +
-		"To: " + email + "\n" +
-		"Subject: " + subject + "\n\n" +
-		body
+		"To: " + to + "\n" +
+		"Subject: " + subject + "\n"
+	if len(attachments) == 0 {
+		msg += "\n" + body
+	} else {
+		msg += multipartBody(body, attachments)
+	}
 
 	// Setup SMTP auth
 	addr := smtpHost + ":" + smtpPort
 	auth := smtp.PlainAuth("", smtpUser, smtpPass, smtpHost)
 
-	// Kirim email
-	if err := smtp.SendMail(addr, auth, smtpUser, []string{email}, []byte(msg)); err != nil {
-		return fmt.Errorf("failed to send failure email: %w", err)
+	// Send the email
+	if err := smtp.SendMail(addr, auth, smtpUser, []string{to}, []byte(msg)); err != nil {
+		metrics.EmailFailures.WithLabelValues(kind).Inc()
+		return fmt.Errorf("failed to send %s email: %w", kind, err)
 	}
-
-	log.Printf("❌ Failure email sent to %s successfully!", email)
 	return nil
 }
+
+// multipartBody returns the MIME headers and body of a message with
+// attachments, each base64-encoded in lines of 76 characters
+func multipartBody(body string, attachments []attachment) string {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+
+	part, _ := w.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=UTF-8"}})
+	part.Write([]byte(body))
+
+	for _, a := range attachments {
+		part, _ := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {a.ContentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename})},
+		})
+		encoded := base64.StdEncoding.EncodeToString(a.Data)
+		for len(encoded) > 76 {
+			part.Write([]byte(encoded[:76] + "\r\n"))
+			encoded = encoded[76:]
+		}
+		part.Write([]byte(encoded))
+	}
+	w.Close()
+
+	return "MIME-Version: 1.0\n" +
+		"Content-Type: multipart/mixed; boundary=" + w.Boundary() + "\n\n" +
+		buf.String()
+}
+
+// loadInvoice returns an order's invoice as an attachment, or nil for
+// orders confirmed before invoices were issued
+func loadInvoice(ctx context.Context, q queryer, orderID int) (*attachment, error) {
+	var number string
+	var pdf []byte
+	err := q.QueryRowContext(ctx, "SELECT number, pdf FROM invoices WHERE order_id = $1", orderID).Scan(&number, &pdf)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &attachment{Filename: number + ".pdf", ContentType: "application/pdf", Data: pdf}, nil
+}
@@ -0,0 +1,134 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+// CheckFunc reports an error when a dependency is unavailable
+type CheckFunc func(ctx context.Context) error
+
+// Result is the outcome of a single dependency check
+type Result struct {
+	Status    string `json:"status"`
+	LatencyMS int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Report aggregates the results of all dependency checks
+type Report struct {
+	Status  string            `json:"status"`
+	Service string            `json:"service"`
+	Checks  map[string]Result `json:"checks"`
+}
+
+// Checker runs named dependency checks with a per-check timeout
+type Checker struct {
+	service string
+	timeout time.Duration
+	names   []string
+	checks  map[string]CheckFunc
+}
+
+func NewChecker(service string, timeout time.Duration) *Checker {
+	return &Checker{
+		service: service,
+		timeout: timeout,
+		checks:  make(map[string]CheckFunc),
+	}
+}
+
+// Register adds a named dependency check
+func (h *Checker) Register(name string, check CheckFunc) {
+	h.names = append(h.names, name)
+	h.checks[name] = check
+}
+
+// Run executes all checks concurrently and returns the aggregated report
+func (h *Checker) Run(ctx context.Context) Report {
+	report := Report{
+		Status:  StatusUp,
+		Service: h.service,
+		Checks:  make(map[string]Result, len(h.names)),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, name := range h.names {
+		wg.Add(1)
+		go func(name string, check CheckFunc) {
+			defer wg.Done()
+
+			checkCtx, cancel := context.WithTimeout(ctx, h.timeout)
+			defer cancel()
+
+			start := time.Now()
+			err := runCheck(checkCtx, check)
+			result := Result{Status: StatusUp, LatencyMS: time.Since(start).Milliseconds()}
+			if err != nil {
+				result.Status = StatusDown
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			report.Checks[name] = result
+			if err != nil {
+				report.Status = StatusDown
+			}
+			mu.Unlock()
+		}(name, h.checks[name])
+	}
+
+	wg.Wait()
+	return report
+}
+
+// runCheck returns when the check finishes or its context expires,
+// so a check that ignores ctx cannot hang the probe
+func runCheck(ctx context.Context, check CheckFunc) error {
+	done := make(chan error, 1)
+	go func() { done <- check(ctx) }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// LivenessHandler reports that the process is running without touching dependencies
+func (h *Checker) LivenessHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"status":  StatusUp,
+		"service": h.service,
+	})
+}
+
+// ReadinessHandler reports per-dependency status, responding 503 when any check fails
+func (h *Checker) ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	report := h.Run(r.Context())
+
+	status := http.StatusOK
+	if report.Status != StatusUp {
+		status = http.StatusServiceUnavailable
+	}
+
+	writeJSON(w, status, report)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,126 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func up(ctx context.Context) error { return nil }
+
+func TestRun(t *testing.T) {
+	checker := NewChecker("test-service", time.Second)
+	checker.Register("database", up)
+	checker.Register("redis", up)
+
+	report := checker.Run(context.Background())
+	if report.Status != StatusUp || report.Service != "test-service" {
+		t.Errorf("report %+v", report)
+	}
+	for _, name := range []string{"database", "redis"} {
+		if report.Checks[name].Status != StatusUp {
+			t.Errorf("%s is %+v", name, report.Checks[name])
+		}
+	}
+	if len(report.Checks) != 2 {
+		t.Errorf("%d checks, want 2", len(report.Checks))
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	checker := NewChecker("test-service", 50*time.Millisecond)
+	checker.Register("database", up)
+	// Ignores its context, so only the checker's timeout ends it
+	block := make(chan struct{})
+	defer close(block)
+	checker.Register("rabbitmq", func(ctx context.Context) error {
+		<-block
+		return nil
+	})
+	checker.Register("redis", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	start := time.Now()
+	report := checker.Run(context.Background())
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("checks took %s with a 50ms timeout", elapsed)
+	}
+
+	if report.Status != StatusDown {
+		t.Errorf("status %s, want down", report.Status)
+	}
+	for _, name := range []string{"rabbitmq", "redis"} {
+		result := report.Checks[name]
+		if result.Status != StatusDown || result.Error != context.DeadlineExceeded.Error() || result.LatencyMS < 50 {
+			t.Errorf("%s is %+v, want down after the timeout", name, result)
+		}
+	}
+	// Checks time out separately, so a slow one does not fail the others
+	if report.Checks["database"].Status != StatusUp {
+		t.Errorf("database is %+v", report.Checks["database"])
+	}
+}
+
+func TestReadinessHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		redis      CheckFunc
+		wantStatus int
+	}{
+		{name: "all up", redis: up, wantStatus: http.StatusOK},
+		{
+			name:       "one down",
+			redis:      func(ctx context.Context) error { return errors.New("connection refused") },
+			wantStatus: http.StatusServiceUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := NewChecker("test-service", time.Second)
+			checker.Register("database", up)
+			checker.Register("redis", tt.redis)
+
+			rec := httptest.NewRecorder()
+			checker.ReadinessHandler(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if rec.Header().Get("Cache-Control") != "no-store" {
+				t.Errorf("Cache-Control %q", rec.Header().Get("Cache-Control"))
+			}
+
+			var report Report
+			if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+				t.Fatal(err)
+			}
+			if report.Checks["database"].Status != StatusUp {
+				t.Errorf("database is %+v", report.Checks["database"])
+			}
+			if tt.wantStatus == http.StatusServiceUnavailable &&
+				(report.Status != StatusDown || report.Checks["redis"].Error != "connection refused") {
+				t.Errorf("report %+v", report)
+			}
+		})
+	}
+}
+
+func TestLivenessHandler(t *testing.T) {
+	checker := NewChecker("test-service", time.Second)
+	checker.Register("database", func(ctx context.Context) error {
+		t.Error("liveness ran a dependency check")
+		return nil
+	})
+
+	rec := httptest.NewRecorder()
+	checker.LivenessHandler(rec, httptest.NewRequest(http.MethodGet, "/livez", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("status %d", rec.Code)
+	}
+}
@@ -0,0 +1,118 @@
+package invoice
+
+import (
+	"bytes"
+	"fmt"
+	"inventory-worker/money"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+)
+
+// Seller is printed at the top of every invoice
+const Seller = "Final Project Bootcamp Store"
+
+// Invoice is everything printed on an invoice
+type Invoice struct {
+	Number    string
+	IssuedAt  time.Time
+	OrderID   int
+	OrderedAt time.Time
+
+	CustomerName  string
+	CustomerEmail string
+	// ShipTo is the order's shipping address, one line per entry
+	ShipTo []string
+
+	Lines         []Line
+	Subtotal      money.Money
+	Discount      money.Money
+	PromotionCode string
+	Tax           money.Money
+	// TaxInclusive is set when all of Tax is already part of the prices
+	TaxInclusive bool
+	Total        money.Money
+}
+
+// Line is an invoiced order item
+type Line struct {
+	Name      string
+	Quantity  int
+	UnitPrice money.Money
+	Amount    money.Money
+	Discount  money.Money
+	// TaxName is empty when the item was not taxed
+	TaxName      string
+	TaxRate      string
+	TaxInclusive bool
+	Tax          money.Money
+}
+
+// Number formats the nth invoice of a year, e.g. INV-2026-000042
+func Number(year, n int) string {
+	return fmt.Sprintf("INV-%d-%06d", year, n)
+}
+
+// Render lays out the invoice from its template and returns it as a PDF
+func Render(inv Invoice) ([]byte, error) {
+	var text bytes.Buffer
+	if err := layout.Execute(&text, inv); err != nil {
+		return nil, fmt.Errorf("failed to render invoice %s: %w", inv.Number, err)
+	}
+	return writePDF(strings.Split(strings.TrimRight(text.String(), "\n"), "\n")), nil
+}
+
+var layout = template.Must(template.New("invoice").Funcs(template.FuncMap{
+	"left":   func(width int, v interface{}) string { return pad(fmt.Sprint(v), width, false) },
+	"right":  func(width int, v interface{}) string { return pad(fmt.Sprint(v), width, true) },
+	"rule":   func() string { return strings.Repeat("-", 86) },
+	"date":   func(t time.Time) string { return t.Format("2 January 2006") },
+	"money":  func(m money.Money) string { return m.Format() },
+	"seller": func() string { return Seller },
+}).Parse(`{{seller}}
+INVOICE {{.Number}}
+
+Issued:   {{date .IssuedAt}}
+Order:    #{{.OrderID}}, placed {{date .OrderedAt}}
+
+Bill to:  {{.CustomerName}}
+          {{.CustomerEmail}}
+{{- range $i, $line := .ShipTo}}
+{{if eq $i 0}}Ship to:  {{else}}          {{end}}{{$line}}
+{{- end}}
+
+{{left 42 "Item"}} {{right 5 "Qty"}} {{right 18 "Unit price"}} {{right 19 "Amount"}}
+{{rule}}
+{{- range .Lines}}
+{{left 42 .Name}} {{right 5 .Quantity}} {{right 18 (money .UnitPrice)}} {{right 19 (money .Amount)}}
+{{- if not .Discount.IsZero}}
+{{left 67 "    Discount"}} {{right 19 (printf "-%s" (money .Discount))}}
+{{- end}}
+{{- if .TaxName}}
+{{left 67 (printf "    %s %s%%%s" .TaxName .TaxRate (or (and .TaxInclusive " (included)") ""))}} {{right 19 (money .Tax)}}
+{{- end}}
+{{- end}}
+{{rule}}
+{{right 67 "Subtotal"}} {{right 19 (money .Subtotal)}}
+{{- if not .Discount.IsZero}}
+{{right 67 (printf "Discount %s" .PromotionCode)}} {{right 19 (printf "-%s" (money .Discount))}}
+{{- end}}
+{{right 67 (or (and .TaxInclusive "Tax (included)") "Tax")}} {{right 19 (money .Tax)}}
+{{right 67 "Total"}} {{right 19 (money .Total)}}
+
+Thank you for shopping with us!
+`))
+
+// pad fits s into width characters, truncating it with "..." when too long
+func pad(s string, width int, right bool) string {
+	n := utf8.RuneCountInString(s)
+	if n > width {
+		runes := []rune(s)
+		return string(runes[:width-3]) + "..."
+	}
+	if right {
+		return strings.Repeat(" ", width-n) + s
+	}
+	return s + strings.Repeat(" ", width-n)
+}
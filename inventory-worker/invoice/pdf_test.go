@@ -0,0 +1,165 @@
+package invoice
+
+import (
+	"bytes"
+	"fmt"
+	"inventory-worker/money"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// checkPDF verifies the cross-reference table points at every object and
+// each stream's /Length matches its content, returning the page count
+func checkPDF(t *testing.T, pdf []byte) int {
+	t.Helper()
+
+	if !bytes.HasPrefix(pdf, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(pdf, []byte("%%EOF\n")) {
+		t.Fatal("missing PDF header or trailer")
+	}
+
+	match := regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`).FindSubmatch(pdf)
+	if match == nil {
+		t.Fatal("no startxref")
+	}
+	xref, _ := strconv.Atoi(string(match[1]))
+	if !bytes.HasPrefix(pdf[xref:], []byte("xref\n")) {
+		t.Fatalf("startxref %d does not point at the xref table", xref)
+	}
+
+	lines := strings.Split(string(pdf[xref:]), "\n")
+	var first, count int
+	if _, err := fmt.Sscanf(lines[1], "%d %d", &first, &count); err != nil || first != 0 {
+		t.Fatalf("bad xref subsection %q", lines[1])
+	}
+	if lines[2] != "0000000000 65535 f " {
+		t.Errorf("bad free entry %q", lines[2])
+	}
+	for i := 1; i < count; i++ {
+		entry := lines[2+i]
+		// Entries are exactly 20 bytes, with the two-byte line ending
+		if len(entry)+1 != 20 || !strings.HasSuffix(entry, " 00000 n ") {
+			t.Fatalf("bad xref entry %q", entry)
+		}
+		offset, _ := strconv.Atoi(entry[:10])
+		if want := fmt.Sprintf("%d 0 obj\n", i); !bytes.HasPrefix(pdf[offset:], []byte(want)) {
+			t.Errorf("object %d: offset %d points at %q", i, offset, pdf[offset:min(offset+20, len(pdf))])
+		}
+	}
+	if want := fmt.Sprintf("/Size %d ", count); !bytes.Contains(pdf, []byte(want)) {
+		t.Errorf("trailer does not declare %s", want)
+	}
+
+	streams := regexp.MustCompile(`(?s)<< /Length (\d+) >>\nstream\n(.*?)\nendstream`).FindAllSubmatch(pdf, -1)
+	for _, stream := range streams {
+		if length, _ := strconv.Atoi(string(stream[1])); length != len(stream[2]) {
+			t.Errorf("stream /Length %d, content is %d bytes", length, len(stream[2]))
+		}
+	}
+
+	pages := regexp.MustCompile(`/Type /Pages /Kids \[[^\]]*\] /Count (\d+)`).FindSubmatch(pdf)
+	if pages == nil {
+		t.Fatal("no page tree")
+	}
+	n, _ := strconv.Atoi(string(pages[1]))
+	if n != len(streams) {
+		t.Errorf("page tree counts %d pages, found %d content streams", n, len(streams))
+	}
+	return n
+}
+
+func TestWritePDF(t *testing.T) {
+	tests := []struct {
+		lines     int
+		wantPages int
+	}{
+		{lines: 0, wantPages: 1},
+		{lines: 1, wantPages: 1},
+		{lines: linesPerPage, wantPages: 1},
+		{lines: linesPerPage + 1, wantPages: 2},
+		{lines: 3 * linesPerPage, wantPages: 3},
+	}
+
+	for _, tt := range tests {
+		lines := make([]string, tt.lines)
+		for i := range lines {
+			lines[i] = fmt.Sprintf("line %d (of %d)", i+1, tt.lines)
+		}
+		if pages := checkPDF(t, writePDF(lines)); pages != tt.wantPages {
+			t.Errorf("%d lines: %d pages, want %d", tt.lines, pages, tt.wantPages)
+		}
+	}
+}
+
+func TestEscapeText(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{line: "Plain text 123", want: "Plain text 123"},
+		{line: "Kaos (Merah)", want: `Kaos \(Merah\)`},
+		{line: `C:\path`, want: `C:\\path`},
+		{line: `) (\`, want: `\) \(\\`},
+		{line: "€5", want: `\2005`},
+		{line: "Café", want: `Caf\351`},
+		{line: "Rp 1.000", want: "Rp 1.000"},
+		{line: "日本", want: "??"},
+		{line: "tab\there", want: "tab?here"},
+	}
+
+	for _, tt := range tests {
+		if got := escapeText(tt.line); got != tt.want {
+			t.Errorf("escapeText(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestNumber(t *testing.T) {
+	if got, want := Number(2026, 42), "INV-2026-000042"; got != want {
+		t.Errorf("Number = %q, want %q", got, want)
+	}
+}
+
+func TestRender(t *testing.T) {
+	date := time.Date(2026, 3, 14, 10, 0, 0, 0, time.UTC)
+	price := money.MustParse("150000", "IDR")
+	inv := Invoice{
+		Number:        Number(2026, 7),
+		IssuedAt:      date,
+		OrderID:       12,
+		OrderedAt:     date,
+		CustomerName:  "Budi",
+		CustomerEmail: "budi@example.com",
+		ShipTo:        []string{"Budi", "Jl. Merdeka 1", "Jakarta 10110"},
+		Lines: []Line{{
+			Name:      `Kaos (Merah) \ Promo`,
+			Quantity:  2,
+			UnitPrice: price,
+			Amount:    money.MustParse("300000", "IDR"),
+			Discount:  money.Zero("IDR"),
+			Tax:       money.Zero("IDR"),
+		}},
+		Subtotal: money.MustParse("300000", "IDR"),
+		Discount: money.Zero("IDR"),
+		Tax:      money.Zero("IDR"),
+		Total:    money.MustParse("300000", "IDR"),
+	}
+
+	pdf, err := Render(inv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPDF(t, pdf)
+
+	for _, want := range []string{
+		`(INVOICE INV-2026-000007) '`,
+		`Kaos \(Merah\) \\ Promo`,
+		`Rp 300.000,00`,
+	} {
+		if !bytes.Contains(pdf, []byte(want)) {
+			t.Errorf("PDF does not contain %q", want)
+		}
+	}
+}
@@ -1,13 +1,22 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"inventory-worker/consumers"
 	"inventory-worker/database"
+	"inventory-worker/health"
+	"inventory-worker/logger"
+	"inventory-worker/metrics"
 	"inventory-worker/rabbitmq"
-	"log"
+	"inventory-worker/tracing"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -16,18 +25,28 @@ func main() {
 	// Load environment variables
 	godotenv.Load()
 
-	log.Println("Starting Inventory Worker Service...")
+	// Initialize structured logging
+	logger.Init("inventory-worker")
+
+	slog.Info("starting inventory worker service")
+
+	// Initialize tracing
+	shutdownTracing, err := tracing.Init(context.Background(), "inventory-worker")
+	if err != nil {
+		logger.Fatal("failed to initialize tracing", "error", err)
+	}
+	defer shutdownTracing(context.Background())
 
 	// Initialize database
 	db, err := database.Connect()
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		logger.Fatal("failed to connect to database", "error", err)
 	}
 	defer db.Close()
 
 	// Initialize database schema
 	if err := database.InitDB(db); err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+		logger.Fatal("failed to initialize database", "error", err)
 	}
 
 	// Initialize RabbitMQ
@@ -38,7 +57,7 @@ func main() {
 		os.Getenv("RABBITMQ_PASSWORD"),
 	)
 	if err != nil {
-		log.Fatalf("Failed to connect to RabbitMQ: %v", err)
+		logger.Fatal("failed to connect to RabbitMQ", "error", err)
 	}
 	defer rmq.Close()
 
@@ -49,28 +68,105 @@ func main() {
 	// Start consuming order_placed messages
 	err = rmq.Consume(rabbitmq.QueueOrderPlaced, inventoryConsumer.ProcessOrder)
 	if err != nil {
-		log.Fatalf("Failed to start inventory consumer: %v", err)
+		logger.Fatal("failed to start inventory consumer", "error", err)
 	}
 
 	// Start consuming order_confirmed messages
 	err = rmq.Consume(rabbitmq.QueueOrderConfirmed, notificationConsumer.ProcessConfirmed)
 	if err != nil {
-		log.Fatalf("Failed to start notification consumer for confirmed orders: %v", err)
+		logger.Fatal("failed to start notification consumer for confirmed orders", "error", err)
 	}
 
 	// Start consuming order_failed messages
 	err = rmq.Consume(rabbitmq.QueueOrderFailed, notificationConsumer.ProcessFailed)
 	if err != nil {
-		log.Fatalf("Failed to start notification consumer for failed orders: %v", err)
+		logger.Fatal("failed to start notification consumer for failed orders", "error", err)
 	}
 
-	log.Println("Inventory Worker Service started successfully")
-	log.Println("Waiting for messages. Press CTRL+C to exit.")
+	// Start consuming user_registered messages
+	err = rmq.Consume(rabbitmq.QueueUserRegistered, notificationConsumer.ProcessUserRegistered)
+	if err != nil {
+		logger.Fatal("failed to start notification consumer for registrations", "error", err)
+	}
+
+	// Start consuming password_reset_requested messages
+	err = rmq.Consume(rabbitmq.QueuePasswordReset, notificationConsumer.ProcessPasswordReset)
+	if err != nil {
+		logger.Fatal("failed to start notification consumer for password resets", "error", err)
+	}
+
+	// Start consuming email_change_requested messages
+	err = rmq.Consume(rabbitmq.QueueEmailChange, notificationConsumer.ProcessEmailChange)
+	if err != nil {
+		logger.Fatal("failed to start notification consumer for email changes", "error", err)
+	}
+
+	// Start consuming order_shipment messages
+	err = rmq.Consume(rabbitmq.QueueOrderShipment, notificationConsumer.ProcessShipment)
+	if err != nil {
+		logger.Fatal("failed to start notification consumer for shipments", "error", err)
+	}
+
+	// Start consuming user_deleted events
+	err = rmq.Consume(rabbitmq.QueueUserDeletedInventory, notificationConsumer.ProcessUserDeleted)
+	if err != nil {
+		logger.Fatal("failed to start notification consumer for deleted accounts", "error", err)
+	}
+
+	// Dependency checks for the readiness probe
+	checker := health.NewChecker("inventory-worker", 2*time.Second)
+	checker.Register("database", db.PingContext)
+	checker.Register("rabbitmq", func(ctx context.Context) error {
+		if !rmq.IsConnected() {
+			return errors.New("connection closed")
+		}
+		return nil
+	})
+	checker.Register("consumers", func(ctx context.Context) error {
+		for queue, active := range rmq.ConsumerStatus() {
+			if !active {
+				return fmt.Errorf("consumer for %s stopped", queue)
+			}
+		}
+		return nil
+	})
+
+	// Expose probes and Prometheus metrics
+	mux := http.NewServeMux()
+	mux.HandleFunc("/livez", checker.LivenessHandler)
+	mux.HandleFunc("/readyz", checker.ReadinessHandler)
+	mux.Handle("/metrics", metrics.Handler())
+
+	port := os.Getenv("SERVICE_PORT")
+	if port == "" {
+		port = "8003"
+	}
+
+	srv := &http.Server{
+		Addr:    ":" + port,
+		Handler: mux,
+	}
+
+	go func() {
+		slog.Info("HTTP server starting", "port", port)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Fatal("failed to start HTTP server", "error", err)
+		}
+	}()
+
+	slog.Info("inventory worker service started, waiting for messages")
 
 	// Wait for interrupt signal
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
-	log.Println("Shutting down Inventory Worker Service...")
+	slog.Info("shutting down inventory worker service")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Error("HTTP server forced to shutdown", "error", err)
+	}
 }
@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	// MessagesConsumed counts messages delivered to a handler per queue
+	MessagesConsumed = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "worker_messages_consumed_total",
+			Help: "Total number of messages received from RabbitMQ per queue.",
+		},
+		[]string{"queue"},
+	)
+
+	// MessagesAcked counts messages acknowledged after successful handling
+	MessagesAcked = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "worker_messages_acked_total",
+			Help: "Total number of messages acknowledged per queue.",
+		},
+		[]string{"queue"},
+	)
+
+	// MessagesNacked counts messages rejected and requeued after a handler error
+	MessagesNacked = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "worker_messages_nacked_total",
+			Help: "Total number of messages rejected and requeued per queue.",
+		},
+		[]string{"queue"},
+	)
+
+	// HandlerDuration observes how long a handler takes per queue
+	HandlerDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "worker_handler_duration_seconds",
+			Help:    "Message handler duration in seconds per queue.",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"queue"},
+	)
+
+	// OrdersProcessed counts orders by final status (CONFIRMED or CANCELLED)
+	OrdersProcessed = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "worker_orders_processed_total",
+			Help: "Total number of orders processed by resulting status.",
+		},
+		[]string{"status"},
+	)
+
+	// StockOuts counts orders rejected because a product had insufficient stock
+	StockOuts = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "worker_stock_out_events_total",
+			Help: "Total number of orders rejected due to insufficient stock.",
+		},
+	)
+
+	// EmailFailures counts failed email sends by email type
+	EmailFailures = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "worker_email_send_failures_total",
+			Help: "Total number of failed email sends by type.",
+		},
+		[]string{"type"},
+	)
+)
+
+// Handler exposes the collected metrics in the Prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,241 @@
+package apperror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// Code is a stable, machine-readable error identifier returned to clients
+type Code string
+
+// Generic error codes shared by every service
+const (
+	CodeBadRequest         Code = "BAD_REQUEST"
+	CodeValidation         Code = "VALIDATION_ERROR"
+	CodeUnauthorized       Code = "UNAUTHORIZED"
+	CodeForbidden          Code = "FORBIDDEN"
+	CodeNotFound           Code = "NOT_FOUND"
+	CodeConflict           Code = "CONFLICT"
+	CodeTooManyRequests    Code = "TOO_MANY_REQUESTS"
+	CodeInternal           Code = "INTERNAL_ERROR"
+	CodeServiceUnavailable Code = "SERVICE_UNAVAILABLE"
+)
+
+var statusByCode = map[Code]int{
+	CodeBadRequest:         http.StatusBadRequest,
+	CodeValidation:         http.StatusBadRequest,
+	CodeUnauthorized:       http.StatusUnauthorized,
+	CodeForbidden:          http.StatusForbidden,
+	CodeNotFound:           http.StatusNotFound,
+	CodeConflict:           http.StatusConflict,
+	CodeTooManyRequests:    http.StatusTooManyRequests,
+	CodeInternal:           http.StatusInternalServerError,
+	CodeServiceUnavailable: http.StatusServiceUnavailable,
+}
+
+// register declares a service-specific code and the HTTP status it maps to
+func register(code Code, status int) Code {
+	statusByCode[code] = status
+	return code
+}
+
+// Status returns the HTTP status for a code, defaulting to 500
+func (c Code) Status() int {
+	if status, ok := statusByCode[c]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// FieldError describes why a single request field failed validation
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Error is an application error carrying a code, a client-safe message and
+// optionally the underlying cause, which is logged but never returned
+type Error struct {
+	Code    Code
+	Message string
+	Fields  []FieldError
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s: %v", e.Code, e.Message, e.Err)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Status returns the HTTP status mapped to the error code
+func (e *Error) Status() int {
+	return e.Code.Status()
+}
+
+// New creates an application error
+func New(code Code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// Wrap creates an application error that keeps err as its cause
+func Wrap(err error, code Code, message string) *Error {
+	return &Error{Code: code, Message: message, Err: err}
+}
+
+// Internal wraps an unexpected error behind a generic client message
+func Internal(err error, message string) *Error {
+	return Wrap(err, CodeInternal, message)
+}
+
+// From converts any error into an application error
+func From(err error) *Error {
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+	return Internal(err, "Internal server error")
+}
+
+// Validation converts request binding errors into a VALIDATION_ERROR with
+// per-field details instead of leaking raw validator messages
+func Validation(err error) *Error {
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		fields := make([]FieldError, 0, len(validationErrs))
+		for _, fe := range validationErrs {
+			fields = append(fields, FieldError{
+				Field:   fieldPath(fe),
+				Rule:    fe.Tag(),
+				Message: ruleMessage(fe),
+			})
+		}
+		return &Error{Code: CodeValidation, Message: "Request validation failed", Fields: fields, Err: err}
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return &Error{
+			Code:    CodeValidation,
+			Message: "Request validation failed",
+			Fields: []FieldError{{
+				Field:   typeErr.Field,
+				Rule:    "type",
+				Message: "must be " + jsonKind(typeErr.Type.Kind()),
+			}},
+			Err: err,
+		}
+	}
+
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return Wrap(err, CodeBadRequest, "Request body must be valid JSON")
+	}
+
+	return Wrap(err, CodeBadRequest, "Invalid request")
+}
+
+// InvalidField reports a VALIDATION_ERROR for a single field checked outside
+// the binding validator
+func InvalidField(field, rule, message string) *Error {
+	return &Error{
+		Code:    CodeValidation,
+		Message: "Request validation failed",
+		Fields:  []FieldError{{Field: field, Rule: rule, Message: message}},
+	}
+}
+
+// UseJSONFieldNames makes the binding validator report fields by their JSON names
+func UseJSONFieldNames() {
+	v, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return
+	}
+
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			return field.Name
+		}
+		return name
+	})
+}
+
+// fieldPath drops the top-level struct name from the namespace, e.g.
+// "CreateOrderRequest.items[0].quantity" becomes "items[0].quantity"
+func fieldPath(fe validator.FieldError) string {
+	ns := fe.Namespace()
+	if i := strings.Index(ns, "."); i >= 0 {
+		return ns[i+1:]
+	}
+	return fe.Field()
+}
+
+func ruleMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	case "min":
+		if fe.Kind() == reflect.String {
+			return "must be at least " + fe.Param() + " characters long"
+		}
+		if fe.Kind() == reflect.Slice {
+			return "must contain at least " + fe.Param() + " item(s)"
+		}
+		return "must be at least " + fe.Param()
+	case "max":
+		if fe.Kind() == reflect.String {
+			return "must be at most " + fe.Param() + " characters long"
+		}
+		if fe.Kind() == reflect.Slice {
+			return "must contain at most " + fe.Param() + " item(s)"
+		}
+		return "must be at most " + fe.Param()
+	case "oneof":
+		return "must be one of: " + fe.Param()
+	case "iso3166_1_alpha2":
+		return "must be a two-letter ISO 3166 country code"
+	default:
+		return "failed the " + fe.Tag() + " rule"
+	}
+}
+
+// jsonKind describes a Go kind in JSON terms
+func jsonKind(kind reflect.Kind) string {
+	switch kind {
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	case reflect.Struct, reflect.Map:
+		return "an object"
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	default:
+		return "a valid value"
+	}
+}
@@ -0,0 +1,38 @@
+package apperror
+
+import "net/http"
+
+// Error codes specific to order-service
+var (
+	CodeMissingToken         = register("MISSING_TOKEN", http.StatusUnauthorized)
+	CodeInvalidToken         = register("INVALID_TOKEN", http.StatusUnauthorized)
+	CodeInvalidAPIKey        = register("INVALID_API_KEY", http.StatusUnauthorized)
+	CodeInsufficientScope    = register("INSUFFICIENT_SCOPE", http.StatusForbidden)
+	CodeProductNotFound      = register("PRODUCT_NOT_FOUND", http.StatusNotFound)
+	CodeOrderNotFound        = register("ORDER_NOT_FOUND", http.StatusNotFound)
+	CodeInvalidOrderItem     = register("INVALID_ORDER_ITEM", http.StatusBadRequest)
+	CodeOrderQueueFailed     = register("ORDER_QUEUE_FAILED", http.StatusServiceUnavailable)
+	CodeEmailNotVerified     = register("EMAIL_NOT_VERIFIED", http.StatusForbidden)
+	CodeAddressNotFound      = register("ADDRESS_NOT_FOUND", http.StatusNotFound)
+	CodeInvalidTransition    = register("INVALID_STATUS_TRANSITION", http.StatusConflict)
+	CodeUnsupportedCurrency  = register("UNSUPPORTED_CURRENCY", http.StatusBadRequest)
+	CodeExchangeRateNotFound = register("EXCHANGE_RATE_NOT_FOUND", http.StatusNotFound)
+	CodePromotionNotFound    = register("PROMOTION_NOT_FOUND", http.StatusNotFound)
+	CodePromotionCodeTaken   = register("PROMOTION_CODE_TAKEN", http.StatusConflict)
+	CodeInvalidPromotion     = register("INVALID_PROMOTION", http.StatusUnprocessableEntity)
+	CodeTaxRateNotFound      = register("TAX_RATE_NOT_FOUND", http.StatusNotFound)
+	CodeInvoiceNotFound      = register("INVOICE_NOT_FOUND", http.StatusNotFound)
+	CodeVariantNotFound      = register("VARIANT_NOT_FOUND", http.StatusNotFound)
+	CodeSKUTaken             = register("SKU_TAKEN", http.StatusConflict)
+	CodeCategoryNotFound     = register("CATEGORY_NOT_FOUND", http.StatusNotFound)
+	CodeCategorySlugTaken    = register("CATEGORY_SLUG_TAKEN", http.StatusConflict)
+	CodeCategoryInUse        = register("CATEGORY_IN_USE", http.StatusConflict)
+	CodeImageNotFound        = register("IMAGE_NOT_FOUND", http.StatusNotFound)
+	CodeInvalidImage         = register("INVALID_IMAGE", http.StatusUnprocessableEntity)
+	CodeImageTooLarge        = register("IMAGE_TOO_LARGE", http.StatusRequestEntityTooLarge)
+	CodeImportRejected       = register("IMPORT_REJECTED", http.StatusUnprocessableEntity)
+	CodeImportTooLarge       = register("IMPORT_TOO_LARGE", http.StatusRequestEntityTooLarge)
+	CodeReviewNotFound       = register("REVIEW_NOT_FOUND", http.StatusNotFound)
+	CodeReviewExists         = register("REVIEW_EXISTS", http.StatusConflict)
+	CodeReviewNotAllowed     = register("REVIEW_NOT_ALLOWED", http.StatusForbidden)
+)
@@ -3,7 +3,7 @@ package cache
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"time"
 
@@ -36,7 +36,7 @@ func Connect() (*redis.Client, error) {
 		if err == nil {
 			break
 		}
-		log.Printf("Failed to connect to Redis (attempt %d/10): %v", i+1, err)
+		slog.Warn("failed to connect to Redis", "attempt", i+1, "max_attempts", 10, "error", err)
 		time.Sleep(3 * time.Second)
 	}
 
@@ -44,6 +44,20 @@ func Connect() (*redis.Client, error) {
 		return nil, fmt.Errorf("failed to connect to Redis after 10 attempts: %w", err)
 	}
 
-	log.Println("Successfully connected to Redis")
+	slog.Info("connected to Redis")
 	return client, nil
 }
+
+// Invalidate drops cached entries matching any of the key patterns
+func Invalidate(ctx context.Context, client *redis.Client, patterns ...string) {
+	var keys []string
+	for _, pattern := range patterns {
+		iter := client.Scan(ctx, 0, pattern, 100).Iterator()
+		for iter.Next(ctx) {
+			keys = append(keys, iter.Val())
+		}
+	}
+	if len(keys) > 0 {
+		client.Del(ctx, keys...)
+	}
+}
@@ -0,0 +1,278 @@
+// Package catalog imports and exports products in bulk as CSV or JSON. Each
+// row is one variant with the fields of its product, so a product with three
+// variants takes three rows.
+package catalog
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Format is the file format of an import or export
+type Format string
+
+const (
+	CSV  Format = "csv"
+	JSON Format = "json"
+)
+
+// ErrInvalidFile is wrapped by errors about input that cannot be read as rows
+// at all, as opposed to rows with invalid values
+var ErrInvalidFile = errors.New("invalid import file")
+
+// ParseFormat reads a format name, case-insensitively
+func ParseFormat(name string) (Format, error) {
+	switch format := Format(strings.ToLower(name)); format {
+	case CSV, JSON:
+		return format, nil
+	default:
+		return "", fmt.Errorf("unknown format %q, want csv or json", name)
+	}
+}
+
+// Row is a variant and its product. Prices are decimal strings in major
+// units of Currency, e.g. "149000.00".
+type Row struct {
+	// ProductID adds a new SKU to an existing product; without it, new SKUs
+	// join the product named the same in an earlier row, or start a new one
+	ProductID   int    `json:"product_id,omitempty"`
+	SKU         string `json:"sku"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	// Category is a category slug, or empty for none
+	Category string `json:"category"`
+	Price    string `json:"price"`
+	Currency string `json:"currency"`
+	// VariantPrice overrides Price for this variant when set
+	VariantPrice string            `json:"variant_price,omitempty"`
+	Stock        int               `json:"stock"`
+	Attributes   map[string]string `json:"attributes"`
+}
+
+// columns are the CSV header, in export order
+var columns = []string{"product_id", "sku", "name", "description", "category", "price", "currency", "variant_price", "stock", "attributes"}
+
+// requiredColumns must appear in an imported CSV header
+var requiredColumns = []string{"sku", "name", "price", "stock"}
+
+// RowError is a problem with one row of an import. Row counts data rows from
+// 1, not counting the CSV header.
+type RowError struct {
+	Row     int    `json:"row"`
+	SKU     string `json:"sku,omitempty"`
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// rowReader yields the rows of a file one at a time, with any problems found
+// reading the row's values, and io.EOF after the last row
+type rowReader interface {
+	next() (Row, []RowError, error)
+}
+
+func newReader(r io.Reader, format Format) (rowReader, error) {
+	if format == JSON {
+		dec := json.NewDecoder(r)
+		dec.DisallowUnknownFields()
+		if token, err := dec.Token(); err != nil || token != json.Delim('[') {
+			return nil, fmt.Errorf("%w: JSON imports must be an array of rows", ErrInvalidFile)
+		}
+		return &jsonReader{dec: dec}, nil
+	}
+
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("%w: the file is empty", ErrInvalidFile)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidFile, err)
+	}
+
+	index := map[string]int{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !slices.Contains(columns, name) {
+			return nil, fmt.Errorf("%w: unknown column %q", ErrInvalidFile, name)
+		}
+		if _, ok := index[name]; ok {
+			return nil, fmt.Errorf("%w: column %q appears twice", ErrInvalidFile, name)
+		}
+		index[name] = i
+	}
+	for _, name := range requiredColumns {
+		if _, ok := index[name]; !ok {
+			return nil, fmt.Errorf("%w: missing column %q", ErrInvalidFile, name)
+		}
+	}
+
+	return &csvReader{reader: reader, index: index}, nil
+}
+
+type csvReader struct {
+	reader *csv.Reader
+	index  map[string]int
+}
+
+func (c *csvReader) next() (Row, []RowError, error) {
+	record, err := c.reader.Read()
+	if errors.Is(err, csv.ErrFieldCount) {
+		return Row{}, []RowError{{Message: fmt.Sprintf("has %d fields, want %d", len(record), c.reader.FieldsPerRecord)}}, nil
+	}
+	if err == io.EOF {
+		return Row{}, nil, io.EOF
+	}
+	if err != nil {
+		return Row{}, nil, fmt.Errorf("%w: %w", ErrInvalidFile, err)
+	}
+
+	field := func(name string) string {
+		if i, ok := c.index[name]; ok {
+			return strings.TrimSpace(record[i])
+		}
+		return ""
+	}
+
+	row := Row{
+		SKU:          field("sku"),
+		Name:         field("name"),
+		Description:  field("description"),
+		Category:     field("category"),
+		Price:        field("price"),
+		Currency:     field("currency"),
+		VariantPrice: field("variant_price"),
+	}
+
+	var problems []RowError
+	if value := field("product_id"); value != "" {
+		if row.ProductID, err = strconv.Atoi(value); err != nil {
+			problems = append(problems, RowError{Field: "product_id", Message: "must be a whole number"})
+		}
+	}
+	if row.Stock, err = strconv.Atoi(field("stock")); err != nil {
+		problems = append(problems, RowError{Field: "stock", Message: "must be a whole number"})
+	}
+	if row.Attributes, err = parseAttributes(field("attributes")); err != nil {
+		problems = append(problems, RowError{Field: "attributes", Message: err.Error()})
+	}
+
+	return row, problems, nil
+}
+
+type jsonReader struct {
+	dec *json.Decoder
+}
+
+func (j *jsonReader) next() (Row, []RowError, error) {
+	if !j.dec.More() {
+		if _, err := j.dec.Token(); err != nil {
+			return Row{}, nil, fmt.Errorf("%w: %w", ErrInvalidFile, err)
+		}
+		return Row{}, nil, io.EOF
+	}
+
+	var row Row
+	err := j.dec.Decode(&row)
+
+	// The decoder skips values of the wrong type and unknown fields, and
+	// goes on with the row
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return row, []RowError{{Field: typeErr.Field, Message: "must be a JSON " + jsonType(typeErr.Type.Kind())}}, nil
+	}
+	if field, ok := strings.CutPrefix(fmt.Sprint(err), "json: unknown field "); ok {
+		return row, []RowError{{Field: strings.Trim(field, `"`), Message: "is not a known field"}}, nil
+	}
+	if err != nil {
+		return Row{}, nil, fmt.Errorf("%w: %w", ErrInvalidFile, err)
+	}
+	return row, nil, nil
+}
+
+func jsonType(kind reflect.Kind) string {
+	switch kind {
+	case reflect.String:
+		return "string"
+	case reflect.Map, reflect.Struct:
+		return "object"
+	default:
+		return "number"
+	}
+}
+
+// parseAttributes reads CSV attributes written as "colour=red; size=M". A
+// backslash makes the next character literal, so names and values can hold
+// ";", "=" and "\".
+func parseAttributes(value string) (map[string]string, error) {
+	attributes := map[string]string{}
+	var name, current strings.Builder
+	inValue, escaped := false, false
+
+	// pair ends the name and value read so far; empty pairs are skipped
+	pair := func() error {
+		if !inValue {
+			if strings.TrimSpace(current.String()) != "" {
+				return errors.New(`must be written as "name=value; name=value"`)
+			}
+			return nil
+		}
+		key := strings.TrimSpace(name.String())
+		if key == "" {
+			return errors.New(`must be written as "name=value; name=value"`)
+		}
+		attributes[key] = strings.TrimSpace(current.String())
+		name.Reset()
+		current.Reset()
+		inValue = false
+		return nil
+	}
+
+	for _, r := range value {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '=' && !inValue:
+			name.WriteString(current.String())
+			current.Reset()
+			inValue = true
+		case r == ';':
+			if err := pair(); err != nil {
+				return nil, err
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if escaped {
+		return nil, errors.New("must not end with a lone backslash")
+	}
+	if err := pair(); err != nil {
+		return nil, err
+	}
+	return attributes, nil
+}
+
+// attributeEscaper escapes the characters parseAttributes reads specially
+var attributeEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, "=", `\=`)
+
+// formatAttributes writes attributes for CSV, sorted by name
+func formatAttributes(attributes map[string]string) string {
+	pairs := make([]string, 0, len(attributes))
+	for _, key := range slices.Sorted(maps.Keys(attributes)) {
+		pairs = append(pairs, attributeEscaper.Replace(key)+"="+attributeEscaper.Replace(attributes[key]))
+	}
+	return strings.Join(pairs, "; ")
+}
@@ -0,0 +1,216 @@
+package catalog
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// readAll reads every row of a file, with each row's problems
+func readAll(t *testing.T, input string, format Format) ([]Row, [][]RowError) {
+	t.Helper()
+	reader, err := newReader(strings.NewReader(input), format)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rows []Row
+	var problems [][]RowError
+	for {
+		row, rowProblems, err := reader.next()
+		if err == io.EOF {
+			return rows, problems
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, row)
+		problems = append(problems, rowProblems)
+	}
+}
+
+func TestReadCSV(t *testing.T) {
+	input := "SKU, Name, price, stock, attributes, product_id\n" +
+		"TSHIRT-RED-M, T-Shirt, 149000.00, 25, colour=red; size=M, 1\n" +
+		"MUG-01,Mug,59000.00,100,,\n" +
+		"BAD,Bad,1.00,many,colour,x\n" +
+		"SHORT,Short\n"
+
+	rows, problems := readAll(t, input, CSV)
+	if len(rows) != 4 {
+		t.Fatalf("read %d rows, want 4", len(rows))
+	}
+
+	want := Row{ProductID: 1, SKU: "TSHIRT-RED-M", Name: "T-Shirt", Price: "149000.00", Stock: 25,
+		Attributes: map[string]string{"colour": "red", "size": "M"}}
+	if !reflect.DeepEqual(rows[0], want) {
+		t.Errorf("row 1 = %+v, want %+v", rows[0], want)
+	}
+	if rows[1].SKU != "MUG-01" || rows[1].ProductID != 0 || len(rows[1].Attributes) != 0 {
+		t.Errorf("row 2 = %+v", rows[1])
+	}
+	for i := range 2 {
+		if problems[i] != nil {
+			t.Errorf("row %d: %v", i+1, problems[i])
+		}
+	}
+
+	var fields []string
+	for _, problem := range problems[2] {
+		fields = append(fields, problem.Field)
+	}
+	if want := []string{"product_id", "stock", "attributes"}; !reflect.DeepEqual(fields, want) {
+		t.Errorf("row 3 problems in %v, want %v", fields, want)
+	}
+
+	// A short row is one error, and reading goes on
+	if len(problems[3]) != 1 || !strings.Contains(problems[3][0].Message, "2 fields, want 6") {
+		t.Errorf("row 4 problems = %v", problems[3])
+	}
+}
+
+func TestReadCSVHeader(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{name: "empty", input: "", want: "empty"},
+		{name: "unknown column", input: "sku,name,price,stock,colour\n", want: `unknown column "colour"`},
+		{name: "repeated column", input: "sku,name,price,stock,SKU\n", want: `column "sku" appears twice`},
+		{name: "missing column", input: "sku,name,stock\n", want: `missing column "price"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newReader(strings.NewReader(tt.input), CSV)
+			if !errors.Is(err, ErrInvalidFile) || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadJSON(t *testing.T) {
+	input := `[
+		{"sku": "TSHIRT-RED-M", "name": "T-Shirt", "price": "149000.00", "stock": 25, "attributes": {"colour": "red"}},
+		{"sku": "MUG-01", "name": "Mug", "price": "59000.00", "stock": "many"},
+		{"sku": "PEN-01", "name": "Pen", "price": "5000.00", "stock": 1, "colour": "blue"}
+	]`
+
+	rows, problems := readAll(t, input, JSON)
+	if len(rows) != 3 {
+		t.Fatalf("read %d rows, want 3", len(rows))
+	}
+	if rows[0].SKU != "TSHIRT-RED-M" || rows[0].Stock != 25 || rows[0].Attributes["colour"] != "red" || problems[0] != nil {
+		t.Errorf("row 1 = %+v, %v", rows[0], problems[0])
+	}
+
+	wantProblems := [][]RowError{
+		nil,
+		{{Field: "stock", Message: "must be a JSON number"}},
+		{{Field: "colour", Message: "is not a known field"}},
+	}
+	if !reflect.DeepEqual(problems, wantProblems) {
+		t.Errorf("problems = %v, want %v", problems, wantProblems)
+	}
+}
+
+func TestReadJSONNotArray(t *testing.T) {
+	for _, input := range []string{"", `{"sku": "MUG-01"}`, "sku,name"} {
+		if _, err := newReader(strings.NewReader(input), JSON); !errors.Is(err, ErrInvalidFile) {
+			t.Errorf("%q: error = %v, want ErrInvalidFile", input, err)
+		}
+	}
+
+	// A broken row ends the file rather than the row
+	reader, err := newReader(strings.NewReader(`[{"sku": "MUG-01"`), JSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := reader.next(); !errors.Is(err, ErrInvalidFile) {
+		t.Errorf("error = %v, want ErrInvalidFile", err)
+	}
+}
+
+func TestParseAttributes(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    map[string]string
+		wantErr bool
+	}{
+		{value: "", want: map[string]string{}},
+		{value: "colour=red; size=M", want: map[string]string{"colour": "red", "size": "M"}},
+		{value: " colour = red ;; size=M; ", want: map[string]string{"colour": "red", "size": "M"}},
+		{value: "note=", want: map[string]string{"note": ""}},
+		{value: "fit=a=b", want: map[string]string{"fit": "a=b"}},
+		{value: `ratio=16\:9\; wide`, want: map[string]string{"ratio": "16:9; wide"}},
+		{value: `a\=b=c\\`, want: map[string]string{"a=b": `c\`}},
+		{value: "colour", wantErr: true},
+		{value: "=red", wantErr: true},
+		{value: "colour=red; size", wantErr: true},
+		{value: `colour=red\`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseAttributes(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseAttributes(%q) error = %v, want error %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseAttributes(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestFormatAttributes(t *testing.T) {
+	tests := []struct {
+		attributes map[string]string
+		want       string
+	}{
+		{attributes: nil, want: ""},
+		{attributes: map[string]string{"size": "M", "colour": "red"}, want: "colour=red; size=M"},
+		{attributes: map[string]string{"ratio": "16:9; wide"}, want: `ratio=16:9\; wide`},
+		{attributes: map[string]string{"a=b": `c\`}, want: `a\=b=c\\`},
+	}
+
+	for _, tt := range tests {
+		if got := formatAttributes(tt.attributes); got != tt.want {
+			t.Errorf("formatAttributes(%v) = %q, want %q", tt.attributes, got, tt.want)
+		}
+	}
+}
+
+func TestAttributesRoundTrip(t *testing.T) {
+	for _, attributes := range []map[string]string{
+		{},
+		{"colour": "red", "size": "M"},
+		{"ratio": "16:9; wide", "formula": "x=y+1", "path": `C:\shirts\`},
+		{"a;b": "c=d", `\`: ";", "=": "=="},
+		{"note": ""},
+	} {
+		formatted := formatAttributes(attributes)
+		got, err := parseAttributes(formatted)
+		if err != nil {
+			t.Errorf("%v: parsing %q: %v", attributes, formatted, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, attributes) {
+			t.Errorf("%v: round trip through %q gave %v", attributes, formatted, got)
+		}
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for name, want := range map[string]Format{"csv": CSV, "JSON": JSON} {
+		if got, err := ParseFormat(name); err != nil || got != want {
+			t.Errorf("ParseFormat(%q) = %q, %v", name, got, err)
+		}
+	}
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Error("xml accepted")
+	}
+}
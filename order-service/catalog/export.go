@@ -0,0 +1,125 @@
+package catalog
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"order-service/money"
+	"strconv"
+)
+
+// Export writes every variant as a row in format, ordered by product. Rows
+// are written as they are read from the database, so the catalog is never
+// held in memory. The output can be edited and imported again.
+func Export(ctx context.Context, db *sql.DB, w io.Writer, format Format) error {
+	rows, err := db.QueryContext(ctx,
+		`SELECT p.id, v.sku, p.name, COALESCE(p.description, ''), COALESCE(c.slug, ''), p.price, p.currency,
+			v.price, v.stock, v.attributes
+		 FROM product_variants v
+		 JOIN products p ON p.id = v.product_id
+		 LEFT JOIN categories c ON c.id = p.category_id
+		 ORDER BY p.id, v.id`,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	out := newWriter(w, format)
+	for rows.Next() {
+		var row Row
+		var price int64
+		var variantPrice sql.NullInt64
+		var attributes []byte
+		err := rows.Scan(&row.ProductID, &row.SKU, &row.Name, &row.Description, &row.Category, &price, &row.Currency,
+			&variantPrice, &row.Stock, &attributes)
+		if err != nil {
+			return err
+		}
+
+		row.Price = money.New(price, row.Currency).Decimal()
+		if variantPrice.Valid {
+			row.VariantPrice = money.New(variantPrice.Int64, row.Currency).Decimal()
+		}
+		if err := json.Unmarshal(attributes, &row.Attributes); err != nil {
+			return err
+		}
+
+		if err := out.write(row); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return out.close()
+}
+
+type rowWriter interface {
+	write(Row) error
+	close() error
+}
+
+func newWriter(w io.Writer, format Format) rowWriter {
+	if format == JSON {
+		return &jsonWriter{w: bufio.NewWriter(w)}
+	}
+
+	// Write errors surface when the buffered output is flushed
+	out := csv.NewWriter(w)
+	out.Write(columns)
+	return &csvWriter{w: out}
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func (c *csvWriter) write(row Row) error {
+	productID := ""
+	if row.ProductID != 0 {
+		productID = strconv.Itoa(row.ProductID)
+	}
+	return c.w.Write([]string{
+		productID, row.SKU, row.Name, row.Description, row.Category, row.Price, row.Currency,
+		row.VariantPrice, strconv.Itoa(row.Stock), formatAttributes(row.Attributes),
+	})
+}
+
+func (c *csvWriter) close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// jsonWriter writes an array with one row per line
+type jsonWriter struct {
+	w    *bufio.Writer
+	rows int
+}
+
+func (j *jsonWriter) write(row Row) error {
+	separator := ",\n"
+	if j.rows == 0 {
+		separator = "[\n"
+	}
+	j.rows++
+
+	data, err := json.Marshal(row)
+	if err != nil {
+		return err
+	}
+	j.w.WriteString(separator)
+	_, err = j.w.Write(data)
+	return err
+}
+
+func (j *jsonWriter) close() error {
+	if j.rows == 0 {
+		j.w.WriteString("[")
+	}
+	j.w.WriteString("\n]\n")
+	return j.w.Flush()
+}
@@ -0,0 +1,100 @@
+package catalog
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func expectExport(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery("FROM product_variants v").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "description", "slug", "price", "currency", "variant_price", "stock", "attributes"}).
+			AddRow(1, "TSHIRT-RED-M", "T-Shirt", "Cotton, \"soft\"", "t-shirts", 14900000, "IDR", nil, 25, []byte(`{"size":"M","colour":"red"}`)).
+			AddRow(1, "TSHIRT-RED-L", "T-Shirt", "Cotton, \"soft\"", "t-shirts", 14900000, "IDR", 15900000, 10, []byte(`{"fit":"16:9; wide"}`)).
+			AddRow(2, "MUG-01", "Mug", "", "", 599, "USD", nil, 100, []byte(`{}`)))
+}
+
+// exported are the rows expectExport returns, as read back from a file
+var exported = []Row{
+	{ProductID: 1, SKU: "TSHIRT-RED-M", Name: "T-Shirt", Description: `Cotton, "soft"`, Category: "t-shirts", Price: "149000.00", Currency: "IDR",
+		Stock: 25, Attributes: map[string]string{"colour": "red", "size": "M"}},
+	{ProductID: 1, SKU: "TSHIRT-RED-L", Name: "T-Shirt", Description: `Cotton, "soft"`, Category: "t-shirts", Price: "149000.00", Currency: "IDR",
+		VariantPrice: "159000.00", Stock: 10, Attributes: map[string]string{"fit": "16:9; wide"}},
+	{ProductID: 2, SKU: "MUG-01", Name: "Mug", Price: "5.99", Currency: "USD", Stock: 100, Attributes: map[string]string{}},
+}
+
+func TestExportCSV(t *testing.T) {
+	db, mock := newMock(t)
+	expectExport(mock)
+
+	var out strings.Builder
+	if err := Export(t.Context(), db, &out, CSV); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "product_id,sku,name,description,category,price,currency,variant_price,stock,attributes\n" +
+		"1,TSHIRT-RED-M,T-Shirt,\"Cotton, \"\"soft\"\"\",t-shirts,149000.00,IDR,,25,colour=red; size=M\n" +
+		"1,TSHIRT-RED-L,T-Shirt,\"Cotton, \"\"soft\"\"\",t-shirts,149000.00,IDR,159000.00,10,fit=16:9\\; wide\n" +
+		"2,MUG-01,Mug,,,5.99,USD,,100,\n"
+	if out.String() != want {
+		t.Errorf("export:\n%s\nwant:\n%s", out.String(), want)
+	}
+
+	// The export reads back as the same rows
+	rows, problems := readAll(t, out.String(), CSV)
+	if !reflect.DeepEqual(rows, exported) {
+		t.Errorf("read back %+v, want %+v", rows, exported)
+	}
+	for i, rowProblems := range problems {
+		if rowProblems != nil {
+			t.Errorf("row %d: %v", i+1, rowProblems)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestExportJSON(t *testing.T) {
+	db, mock := newMock(t)
+	expectExport(mock)
+
+	var out strings.Builder
+	if err := Export(t.Context(), db, &out, JSON); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(out.String(), "\n"); lines != 5 {
+		t.Errorf("export has %d lines, want one per row and two for the brackets:\n%s", lines, out.String())
+	}
+
+	rows, problems := readAll(t, out.String(), JSON)
+	if !reflect.DeepEqual(rows, exported) {
+		t.Errorf("read back %+v, want %+v", rows, exported)
+	}
+	for i, rowProblems := range problems {
+		if rowProblems != nil {
+			t.Errorf("row %d: %v", i+1, rowProblems)
+		}
+	}
+}
+
+func TestExportEmpty(t *testing.T) {
+	for format, want := range map[Format]string{
+		CSV:  "product_id,sku,name,description,category,price,currency,variant_price,stock,attributes\n",
+		JSON: "[\n]\n",
+	} {
+		db, mock := newMock(t)
+		mock.ExpectQuery("FROM product_variants v").
+			WillReturnRows(sqlmock.NewRows([]string{"id", "sku", "name", "description", "slug", "price", "currency", "variant_price", "stock", "attributes"}))
+
+		var out strings.Builder
+		if err := Export(t.Context(), db, &out, format); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != want {
+			t.Errorf("%s export = %q, want %q", format, out.String(), want)
+		}
+	}
+}
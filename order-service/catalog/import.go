@@ -0,0 +1,322 @@
+package catalog
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"order-service/money"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+)
+
+// maxReportedErrors bounds the errors listed in a result; the rest are only
+// counted
+const maxReportedErrors = 1000
+
+// Result summarizes an import. Applied is false after a dry run or when any
+// row failed, in which case nothing was changed.
+type Result struct {
+	DryRun          bool       `json:"dry_run"`
+	Applied         bool       `json:"applied"`
+	Rows            int        `json:"rows"`
+	FailedRows      int        `json:"failed_rows"`
+	ProductsCreated int        `json:"products_created"`
+	ProductsUpdated int        `json:"products_updated"`
+	VariantsCreated int        `json:"variants_created"`
+	VariantsUpdated int        `json:"variants_updated"`
+	Errors          []RowError `json:"errors"`
+}
+
+// item is a row that passed validation
+type item struct {
+	Row
+	price        money.Money
+	variantPrice *int64
+	categoryID   *int
+	attributes   []byte
+}
+
+type importer struct {
+	tx     *sql.Tx
+	result *Result
+
+	// categories caches category ids by slug, nil for unknown slugs
+	categories map[string]*int
+	// skuRows is the first row of each SKU, to catch repeats
+	skuRows map[string]int
+	// byName is the product of each name seen, for new SKUs to join
+	byName  map[string]int
+	touched map[int]bool
+}
+
+// Import reads rows in format from r and creates or updates the products and
+// variants they describe, matching variants by SKU. Rows are applied in one
+// transaction, all of them or, when any row has an error or dryRun is set,
+// none. Row errors are listed in the result; the error returned is for input
+// that cannot be read at all, wrapping ErrInvalidFile, or database failures.
+func Import(ctx context.Context, db *sql.DB, r io.Reader, format Format, dryRun bool) (*Result, error) {
+	rows, err := newReader(r, format)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	imp := &importer{
+		tx:         tx,
+		result:     &Result{DryRun: dryRun, Errors: []RowError{}},
+		categories: map[string]*int{},
+		skuRows:    map[string]int{},
+		byName:     map[string]int{},
+		touched:    map[int]bool{},
+	}
+
+	for n := 1; ; n++ {
+		row, problems, err := rows.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", n, err)
+		}
+		imp.result.Rows++
+
+		if len(problems) == 0 {
+			var it *item
+			if it, problems, err = imp.validate(ctx, row, n); err != nil {
+				return nil, err
+			}
+			if len(problems) == 0 {
+				if problems, err = imp.apply(ctx, it); err != nil {
+					return nil, fmt.Errorf("row %d: %w", n, err)
+				}
+			}
+		}
+
+		if len(problems) > 0 {
+			imp.result.FailedRows++
+		}
+		for _, problem := range problems {
+			if len(imp.result.Errors) < maxReportedErrors {
+				problem.Row, problem.SKU = n, row.SKU
+				imp.result.Errors = append(imp.result.Errors, problem)
+			}
+		}
+	}
+
+	if dryRun || imp.result.FailedRows > 0 {
+		return imp.result, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	imp.result.Applied = true
+	return imp.result, nil
+}
+
+// validate checks a row's values and resolves its category
+func (imp *importer) validate(ctx context.Context, row Row, n int) (*item, []RowError, error) {
+	var problems []RowError
+	invalid := func(field, message string) {
+		problems = append(problems, RowError{Field: field, Message: message})
+	}
+
+	it := &item{Row: row}
+	it.SKU = strings.TrimSpace(it.SKU)
+	it.Name = strings.TrimSpace(it.Name)
+	it.Category = strings.ToLower(strings.TrimSpace(it.Category))
+	it.Currency = strings.ToUpper(strings.TrimSpace(it.Currency))
+	if it.Currency == "" {
+		it.Currency = money.DefaultCurrency
+	}
+
+	switch {
+	case it.SKU == "":
+		invalid("sku", "is required")
+	case utf8.RuneCountInString(it.SKU) > 64:
+		invalid("sku", "must be at most 64 characters long")
+	case imp.skuRows[it.SKU] != 0:
+		invalid("sku", "is already in row "+strconv.Itoa(imp.skuRows[it.SKU]))
+	default:
+		imp.skuRows[it.SKU] = n
+	}
+
+	switch {
+	case it.Name == "":
+		invalid("name", "is required")
+	case utf8.RuneCountInString(it.Name) > 255:
+		invalid("name", "must be at most 255 characters long")
+	}
+
+	if it.ProductID < 0 {
+		invalid("product_id", "must be a product ID")
+	}
+
+	if !money.IsSupported(it.Currency) {
+		invalid("currency", "must be a supported currency")
+	} else {
+		price, err := money.Parse(strings.TrimSpace(it.Price), it.Currency)
+		if err != nil || price.IsNegative() {
+			invalid("price", "must be a non-negative amount of "+it.Currency)
+		}
+		it.price = price
+
+		if value := strings.TrimSpace(it.VariantPrice); value != "" {
+			variantPrice, err := money.Parse(value, it.Currency)
+			if err != nil || variantPrice.IsNegative() {
+				invalid("variant_price", "must be a non-negative amount of "+it.Currency)
+			}
+			it.variantPrice = &variantPrice.Amount
+		}
+	}
+
+	if it.Stock < 0 {
+		invalid("stock", "must be at least 0")
+	}
+
+	if len(it.Attributes) > 20 {
+		invalid("attributes", "must have at most 20 entries")
+	}
+	if it.Attributes == nil {
+		it.Attributes = map[string]string{}
+	}
+	it.attributes, _ = json.Marshal(it.Attributes)
+
+	if it.Category != "" {
+		categoryID, ok := imp.categories[it.Category]
+		if !ok {
+			var id int
+			err := imp.tx.QueryRowContext(ctx, "SELECT id FROM categories WHERE slug = $1", it.Category).Scan(&id)
+			if err != nil && err != sql.ErrNoRows {
+				return nil, nil, err
+			}
+			if err == nil {
+				categoryID = &id
+			}
+			imp.categories[it.Category] = categoryID
+		}
+		if categoryID == nil {
+			invalid("category", "must be the slug of an existing category")
+		}
+		it.categoryID = categoryID
+	}
+
+	return it, problems, nil
+}
+
+// apply writes a valid row inside a savepoint, so a row the database rejects
+// is undone alone and the remaining rows can still be checked
+func (imp *importer) apply(ctx context.Context, it *item) ([]RowError, error) {
+	if _, err := imp.tx.ExecContext(ctx, "SAVEPOINT import_row"); err != nil {
+		return nil, err
+	}
+
+	problems, err := imp.upsert(ctx, it)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		problems, err = []RowError{{Message: pqErr.Message}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(problems) > 0 {
+		_, err = imp.tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT import_row")
+	} else {
+		_, err = imp.tx.ExecContext(ctx, "RELEASE SAVEPOINT import_row")
+	}
+	return problems, err
+}
+
+// upsert creates or updates the row's product and variant. Counts and the
+// names seen are only recorded once the row has been written in full.
+func (imp *importer) upsert(ctx context.Context, it *item) ([]RowError, error) {
+	var variantID, productID int
+	err := imp.tx.QueryRowContext(ctx, "SELECT id, product_id FROM product_variants WHERE sku = $1", it.SKU).Scan(&variantID, &productID)
+	switch {
+	case err == sql.ErrNoRows:
+		productID = it.ProductID
+		if productID == 0 {
+			productID = imp.byName[strings.ToLower(it.Name)]
+		}
+	case err != nil:
+		return nil, err
+	case it.ProductID != 0 && it.ProductID != productID:
+		return []RowError{{Field: "product_id", Message: "must be " + strconv.Itoa(productID) + ", the product the SKU belongs to"}}, nil
+	}
+
+	createdProduct := productID == 0
+	if createdProduct {
+		err := imp.tx.QueryRowContext(ctx,
+			"INSERT INTO products (name, description, price, currency, stock, category_id) VALUES ($1, $2, $3, $4, 0, $5) RETURNING id",
+			it.Name, it.Description, it.price.Amount, it.price.Currency, it.categoryID,
+		).Scan(&productID)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// Variant price overrides are in the product's currency, so it
+		// cannot change under them
+		var currency string
+		err := imp.tx.QueryRowContext(ctx, "SELECT currency FROM products WHERE id = $1 FOR UPDATE", productID).Scan(&currency)
+		if err == sql.ErrNoRows {
+			return []RowError{{Field: "product_id", Message: "must be an existing product"}}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if currency != it.price.Currency {
+			return []RowError{{Field: "currency", Message: "must be " + currency + ", the product's currency"}}, nil
+		}
+
+		_, err = imp.tx.ExecContext(ctx,
+			"UPDATE products SET name = $1, description = $2, price = $3, category_id = $4 WHERE id = $5",
+			it.Name, it.Description, it.price.Amount, it.categoryID, productID,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if variantID == 0 {
+		_, err = imp.tx.ExecContext(ctx,
+			"INSERT INTO product_variants (product_id, sku, attributes, price, stock) VALUES ($1, $2, $3, $4, $5)",
+			productID, it.SKU, it.attributes, it.variantPrice, it.Stock,
+		)
+	} else {
+		_, err = imp.tx.ExecContext(ctx,
+			"UPDATE product_variants SET attributes = $1, price = $2, stock = $3 WHERE id = $4",
+			it.attributes, it.variantPrice, it.Stock, variantID,
+		)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case createdProduct:
+		imp.result.ProductsCreated++
+	case !imp.touched[productID]:
+		imp.result.ProductsUpdated++
+	}
+	imp.touched[productID] = true
+	imp.byName[strings.ToLower(it.Name)] = productID
+
+	if variantID == 0 {
+		imp.result.VariantsCreated++
+	} else {
+		imp.result.VariantsUpdated++
+	}
+	return nil, nil
+}
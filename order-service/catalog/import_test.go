@@ -0,0 +1,254 @@
+package catalog
+
+import (
+	"database/sql"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+func newMock(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, mock
+}
+
+// expectNewSKU expects the lookup of a SKU that is not in the catalog yet
+func expectNewSKU(mock sqlmock.Sqlmock, sku string) {
+	mock.ExpectExec("SAVEPOINT import_row").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT id, product_id FROM product_variants WHERE sku = \\$1").
+		WithArgs(sku).
+		WillReturnError(sql.ErrNoRows)
+}
+
+func expectRelease(mock sqlmock.Sqlmock) {
+	mock.ExpectExec("RELEASE SAVEPOINT import_row").WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func TestImport(t *testing.T) {
+	db, mock := newMock(t)
+
+	input := "sku,name,category,price,variant_price,stock,attributes\n" +
+		"TSHIRT-RED-M,T-Shirt,t-shirts,149000.00,,25,colour=red; size=M\n" +
+		"TSHIRT-RED-L,t-shirt,T-Shirts,149000.00,159000.00,10,colour=red; size=L\n" +
+		"MUG-01,Mug,,59000.00,,100,\n"
+
+	mock.ExpectBegin()
+
+	// Row 1 starts a new product
+	mock.ExpectQuery("SELECT id FROM categories WHERE slug = \\$1").
+		WithArgs("t-shirts").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	expectNewSKU(mock, "TSHIRT-RED-M")
+	mock.ExpectQuery("INSERT INTO products").
+		WithArgs("T-Shirt", "", int64(14900000), "IDR", 4).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(10))
+	mock.ExpectExec("INSERT INTO product_variants").
+		WithArgs(10, "TSHIRT-RED-M", []byte(`{"colour":"red","size":"M"}`), nil, 25).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectRelease(mock)
+
+	// Row 2 joins it by name, with the category cached
+	expectNewSKU(mock, "TSHIRT-RED-L")
+	mock.ExpectQuery("SELECT currency FROM products WHERE id = \\$1 FOR UPDATE").
+		WithArgs(10).
+		WillReturnRows(sqlmock.NewRows([]string{"currency"}).AddRow("IDR"))
+	mock.ExpectExec("UPDATE products SET").
+		WithArgs("t-shirt", "", int64(14900000), 4, 10).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO product_variants").
+		WithArgs(10, "TSHIRT-RED-L", []byte(`{"colour":"red","size":"L"}`), int64(15900000), 10).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	expectRelease(mock)
+
+	// Row 3 updates an existing variant by SKU
+	mock.ExpectExec("SAVEPOINT import_row").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT id, product_id FROM product_variants WHERE sku = \\$1").
+		WithArgs("MUG-01").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "product_id"}).AddRow(30, 3))
+	mock.ExpectQuery("SELECT currency FROM products WHERE id = \\$1 FOR UPDATE").
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"currency"}).AddRow("IDR"))
+	mock.ExpectExec("UPDATE products SET").
+		WithArgs("Mug", "", int64(5900000), nil, 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE product_variants SET").
+		WithArgs([]byte(`{}`), nil, 100, 30).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectRelease(mock)
+
+	mock.ExpectCommit()
+
+	result, err := Import(t.Context(), db, strings.NewReader(input), CSV, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.Errors) != 0 {
+		t.Errorf("errors = %+v", result.Errors)
+	}
+	result.Errors = nil
+	want := Result{Applied: true, Rows: 3, ProductsCreated: 1, ProductsUpdated: 1, VariantsCreated: 2, VariantsUpdated: 1}
+	if !reflect.DeepEqual(*result, want) {
+		t.Errorf("result = %+v, want %+v", *result, want)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestImportDryRun(t *testing.T) {
+	db, mock := newMock(t)
+
+	mock.ExpectBegin()
+	expectNewSKU(mock, "MUG-01")
+	mock.ExpectQuery("INSERT INTO products").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(10))
+	mock.ExpectExec("INSERT INTO product_variants").WillReturnResult(sqlmock.NewResult(1, 1))
+	expectRelease(mock)
+	// The rows are written to be checked, then rolled back
+	mock.ExpectRollback()
+
+	input := `[{"sku": "MUG-01", "name": "Mug", "price": "59000.00", "stock": 100}]`
+	result, err := Import(t.Context(), db, strings.NewReader(input), JSON, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !result.DryRun || result.Applied || result.ProductsCreated != 1 || result.VariantsCreated != 1 {
+		t.Errorf("result = %+v", *result)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestImportRowErrors(t *testing.T) {
+	db, mock := newMock(t)
+
+	input := "sku,name,category,price,currency,stock\n" +
+		"MUG-01,Mug,,59000.00,,100\n" +
+		"MUG-01,Mug,,59000.00,,100\n" +
+		",,mugs,cheap,XYZ,-1\n" +
+		"PEN-01,Pen,,5000.00,,1\n" +
+		"CUP-01,Cup,,5000.00,USD,1\n" +
+		"BAG-01,Bag,,20000.00,,1\n"
+
+	mock.ExpectBegin()
+
+	// Row 1 is fine
+	expectNewSKU(mock, "MUG-01")
+	mock.ExpectQuery("INSERT INTO products").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(10))
+	mock.ExpectExec("INSERT INTO product_variants").WillReturnResult(sqlmock.NewResult(1, 1))
+	expectRelease(mock)
+
+	// Row 2 repeats its SKU and row 3 fails validation, so neither is
+	// written; row 3's unknown category is still looked up
+	mock.ExpectQuery("SELECT id FROM categories WHERE slug = \\$1").
+		WithArgs("mugs").
+		WillReturnError(sql.ErrNoRows)
+
+	// Row 4 is rejected by the database and undone alone
+	expectNewSKU(mock, "PEN-01")
+	mock.ExpectQuery("INSERT INTO products").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(11))
+	mock.ExpectExec("INSERT INTO product_variants").
+		WillReturnError(&pq.Error{Code: "23514", Message: "new row violates check constraint"})
+	mock.ExpectExec("ROLLBACK TO SAVEPOINT import_row").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// Row 5 updates an existing SKU of an IDR product in USD
+	mock.ExpectExec("SAVEPOINT import_row").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT id, product_id FROM product_variants WHERE sku = \\$1").
+		WithArgs("CUP-01").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "product_id"}).AddRow(31, 4))
+	mock.ExpectQuery("SELECT currency FROM products WHERE id = \\$1 FOR UPDATE").
+		WithArgs(4).
+		WillReturnRows(sqlmock.NewRows([]string{"currency"}).AddRow("IDR"))
+	mock.ExpectExec("ROLLBACK TO SAVEPOINT import_row").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// Row 6 is still checked
+	expectNewSKU(mock, "BAG-01")
+	mock.ExpectQuery("INSERT INTO products").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(12))
+	mock.ExpectExec("INSERT INTO product_variants").WillReturnResult(sqlmock.NewResult(3, 1))
+	expectRelease(mock)
+
+	// Nothing is committed
+	mock.ExpectRollback()
+
+	result, err := Import(t.Context(), db, strings.NewReader(input), CSV, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Applied || result.Rows != 6 || result.FailedRows != 4 {
+		t.Errorf("result = %+v", *result)
+	}
+
+	want := []RowError{
+		{Row: 2, SKU: "MUG-01", Field: "sku", Message: "is already in row 1"},
+		{Row: 3, Field: "sku", Message: "is required"},
+		{Row: 3, Field: "name", Message: "is required"},
+		{Row: 3, Field: "currency", Message: "must be a supported currency"},
+		{Row: 3, Field: "stock", Message: "must be at least 0"},
+		{Row: 3, Field: "category", Message: "must be the slug of an existing category"},
+		{Row: 4, SKU: "PEN-01", Message: "new row violates check constraint"},
+		{Row: 5, SKU: "CUP-01", Field: "currency", Message: "must be IDR, the product's currency"},
+	}
+	if len(result.Errors) != len(want) {
+		t.Fatalf("errors = %+v, want %+v", result.Errors, want)
+	}
+	for i := range want {
+		if result.Errors[i] != want[i] {
+			t.Errorf("error %d = %+v, want %+v", i, result.Errors[i], want[i])
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestImportSKUOfAnotherProduct(t *testing.T) {
+	db, mock := newMock(t)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("SAVEPOINT import_row").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT id, product_id FROM product_variants WHERE sku = \\$1").
+		WithArgs("MUG-01").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "product_id"}).AddRow(30, 3))
+	mock.ExpectExec("ROLLBACK TO SAVEPOINT import_row").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	input := "product_id,sku,name,price,stock\n5,MUG-01,Mug,59000.00,100\n"
+	result, err := Import(t.Context(), db, strings.NewReader(input), CSV, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := RowError{Row: 1, SKU: "MUG-01", Field: "product_id", Message: "must be 3, the product the SKU belongs to"}
+	if len(result.Errors) != 1 || result.Errors[0] != want {
+		t.Errorf("errors = %+v, want %+v", result.Errors, want)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestImportInvalidFile(t *testing.T) {
+	db, mock := newMock(t)
+
+	// The header is checked before a transaction is started
+	if _, err := Import(t.Context(), db, strings.NewReader("sku,name\n"), CSV, false); err == nil {
+		t.Error("file without price and stock columns accepted")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,185 @@
+// Command catalog imports and exports products in bulk, like the admin
+// import and export endpoints, straight against the database:
+//
+//	catalog import [-format csv|json] [-dry-run] FILE
+//	catalog export [-format csv|json] [-o FILE]
+//
+// FILE may be "-" for standard input. The format defaults to the file's
+// extension, or CSV. Connection settings are read from the environment and
+// .env, as for the service. Logs go to standard error, so an export can be
+// piped.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"order-service/cache"
+	"order-service/catalog"
+	"order-service/database"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	godotenv.Load()
+
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "import":
+		err = runImport(os.Args[2:])
+	case "export":
+		err = runExport(os.Args[2:])
+	default:
+		usage()
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "catalog:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: catalog import [-format csv|json] [-dry-run] FILE")
+	fmt.Fprintln(os.Stderr, "       catalog export [-format csv|json] [-o FILE]")
+	os.Exit(2)
+}
+
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	formatName := flags.String("format", "", "csv or json, defaulting to the file's extension")
+	dryRun := flags.Bool("dry-run", false, "check every row without saving anything")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		usage()
+	}
+	path := flags.Arg(0)
+
+	format, err := formatFor(*formatName, path)
+	if err != nil {
+		return err
+	}
+
+	var in io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		in = file
+	}
+
+	db, err := database.Connect()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+	result, err := catalog.Import(ctx, db, in, format, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	for _, rowErr := range result.Errors {
+		line := fmt.Sprintf("row %d", rowErr.Row)
+		if rowErr.SKU != "" {
+			line += " (" + rowErr.SKU + ")"
+		}
+		line += ": "
+		if rowErr.Field != "" {
+			line += rowErr.Field + " "
+		}
+		fmt.Fprintln(os.Stderr, line+rowErr.Message)
+	}
+	if hidden := result.FailedRows - len(result.Errors); hidden > 0 {
+		fmt.Fprintf(os.Stderr, "... and errors in up to %d more rows\n", hidden)
+	}
+
+	fmt.Fprintf(os.Stderr, "%d rows: %d products created, %d updated; %d variants created, %d updated\n",
+		result.Rows, result.ProductsCreated, result.ProductsUpdated, result.VariantsCreated, result.VariantsUpdated)
+
+	switch {
+	case result.FailedRows > 0:
+		return fmt.Errorf("%d rows have errors; nothing was imported", result.FailedRows)
+	case !result.Applied:
+		fmt.Fprintln(os.Stderr, "dry run: nothing was imported")
+		return nil
+	}
+
+	// Cached product listings would otherwise show the old catalog until
+	// they expire
+	if os.Getenv("REDIS_HOST") == "" {
+		fmt.Fprintln(os.Stderr, "REDIS_HOST is not set; cached products expire within 5 minutes")
+		return nil
+	}
+	redisClient, err := cache.Connect()
+	if err != nil {
+		return fmt.Errorf("imported, but failed to clear the product cache: %w", err)
+	}
+	defer redisClient.Close()
+	cache.Invalidate(ctx, redisClient, "product:*", "products*")
+
+	return nil
+}
+
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+	formatName := flags.String("format", "", "csv or json, defaulting to the output file's extension")
+	output := flags.String("o", "-", "output file, or - for standard output")
+	flags.Parse(args)
+
+	if flags.NArg() != 0 {
+		usage()
+	}
+
+	format, err := formatFor(*formatName, *output)
+	if err != nil {
+		return err
+	}
+
+	db, err := database.Connect()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if *output == "-" {
+		return catalog.Export(context.Background(), db, os.Stdout, format)
+	}
+
+	file, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+
+	if err := catalog.Export(context.Background(), db, file, format); err != nil {
+		file.Close()
+		return errors.Join(err, os.Remove(*output))
+	}
+	return file.Close()
+}
+
+// formatFor picks the format named by the flag, or else by the file's
+// extension, or else CSV
+func formatFor(name, path string) (catalog.Format, error) {
+	if name == "" {
+		name = strings.TrimPrefix(filepath.Ext(path), ".")
+		if name != string(catalog.JSON) {
+			name = string(catalog.CSV)
+		}
+	}
+	return catalog.ParseFormat(name)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"order-service/catalog"
+	"testing"
+)
+
+func TestFormatFor(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		want    catalog.Format
+		wantErr bool
+	}{
+		{path: "products.csv", want: catalog.CSV},
+		{path: "products.json", want: catalog.JSON},
+		{path: "-", want: catalog.CSV},
+		{path: "products.txt", want: catalog.CSV},
+		{name: "json", path: "products.csv", want: catalog.JSON},
+		{name: "CSV", path: "-", want: catalog.CSV},
+		{name: "xml", path: "products.xml", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := formatFor(tt.name, tt.path)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("formatFor(%q, %q) = %q, %v, want %q", tt.name, tt.path, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+// Command mock-s3 runs the in-memory S3-compatible store for local development
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"order-service/logger"
+	"order-service/media/mocks3"
+	"order-service/media/sigv4"
+	"os"
+)
+
+func main() {
+	logger.Init("mock-s3")
+
+	port := getenv("MOCK_S3_PORT", "9002")
+
+	server := mocks3.New(sigv4.Credentials{
+		AccessKeyID:     getenv("MOCK_S3_ACCESS_KEY_ID", "mock-access-key"),
+		SecretAccessKey: getenv("MOCK_S3_SECRET_ACCESS_KEY", "mock-secret-key"),
+	})
+
+	slog.Info("mock S3 starting", "port", port)
+	if err := http.ListenAndServe(":"+port, server.Handler()); err != nil {
+		logger.Fatal("mock S3 stopped", "error", err)
+	}
+}
+
+func getenv(key, def string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return def
+}
@@ -0,0 +1,90 @@
+package consumers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"order-service/logger"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// UserConsumer reacts to account lifecycle events from auth-service
+type UserConsumer struct {
+	db *sql.DB
+}
+
+type UserDeletedMessage struct {
+	UserID    int       `json:"user_id"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func NewUserConsumer(db *sql.DB) *UserConsumer {
+	return &UserConsumer{db: db}
+}
+
+// ProcessUserDeleted cancels orders the deleted user placed that have not been
+// processed yet. auth-service cancels those pending at deletion, so this only
+// catches orders placed while the deletion was being committed. Processed
+// orders are kept for accounting.
+func (c *UserConsumer) ProcessUserDeleted(ctx context.Context, body []byte) error {
+	var msg UserDeletedMessage
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return fmt.Errorf("failed to unmarshal message: %w", err)
+	}
+
+	tx, err := c.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx,
+		`UPDATE orders SET status = 'CANCELLED', updated_at = CURRENT_TIMESTAMP
+		 WHERE user_id = $1 AND status = 'PENDING'
+		 RETURNING id`,
+		msg.UserID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to cancel pending orders: %w", err)
+	}
+
+	var orderIDs []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan cancelled order: %w", err)
+		}
+		orderIDs = append(orderIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to cancel pending orders: %w", err)
+	}
+
+	// Give back the coupons the cancelled orders used
+	_, err = tx.ExecContext(ctx,
+		`WITH released AS (
+			UPDATE promotion_redemptions SET released_at = CURRENT_TIMESTAMP
+			WHERE order_id = ANY($1) AND released_at IS NULL
+			RETURNING promotion_id
+		 )
+		 UPDATE promotions p SET redemption_count = p.redemption_count - r.released
+		 FROM (SELECT promotion_id, COUNT(*) AS released FROM released GROUP BY promotion_id) r
+		 WHERE p.id = r.promotion_id`,
+		pq.Array(orderIDs),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to release promotion redemptions: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	logger.FromContext(ctx).Info("pending orders of deleted user cancelled", "user_id", msg.UserID, "orders", len(orderIDs))
+	return nil
+}
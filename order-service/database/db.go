@@ -1,13 +1,17 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"strings"
 	"time"
 
+	"github.com/XSAM/otelsql"
 	_ "github.com/lib/pq"
+	semconv "go.opentelemetry.io/otel/semconv/v1.43.0"
 )
 
 // Connect establishes connection to PostgreSQL with retry logic
@@ -28,14 +32,15 @@ func Connect() (*sql.DB, error) {
 
 	// Retry connection up to 10 times
 	for i := 0; i < 10; i++ {
-		db, err = sql.Open("postgres", connStr)
+		// Wrap the driver so every query is recorded as a span
+		db, err = otelsql.Open("postgres", connStr, otelsql.WithAttributes(semconv.DBSystemNamePostgreSQL))
 		if err == nil {
 			err = db.Ping()
 			if err == nil {
 				break
 			}
 		}
-		log.Printf("Failed to connect to database (attempt %d/10): %v", i+1, err)
+		slog.Warn("failed to connect to database", "attempt", i+1, "max_attempts", 10, "error", err)
 		time.Sleep(3 * time.Second)
 	}
 
@@ -48,15 +53,49 @@ func Connect() (*sql.DB, error) {
 	db.SetMaxIdleConns(5)
 	db.SetConnMaxLifetime(5 * time.Minute)
 
-	log.Println("Successfully connected to database")
+	slog.Info("connected to database")
 	return db, nil
 }
 
-// InitDB creates database schema if not exists
+// migrationLockID serializes InitDB across the services sharing the database
+const migrationLockID = 7350002
+
+// InitDB creates database schema if not exists. The services start together
+// against the same database, so they take turns under an advisory lock, each
+// seeing the schema the previous one left.
 func InitDB(db *sql.DB) error {
-	log.Println("Initializing database schema...")
+	return withMigrationLock(db, func() error { return initSchema(db) })
+}
+
+// withMigrationLock runs fn while holding the migration lock
+func withMigrationLock(db *sql.DB, fn func() error) error {
+	ctx := context.Background()
+
+	// The lock belongs to a session, so it is held on one connection
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to reserve connection: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	defer conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", migrationLockID)
+
+	return fn()
+}
+
+func initSchema(db *sql.DB) error {
+	slog.Info("initializing database schema")
 
 	schema := `
+	-- Create schema_migrations table recording one-time data conversions
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version VARCHAR(100) PRIMARY KEY,
+		applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
 	-- Create users table
 	CREATE TABLE IF NOT EXISTS users (
 		id SERIAL PRIMARY KEY,
@@ -68,14 +107,26 @@ func InitDB(db *sql.DB) error {
 		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 	);
 
-	-- Create products table
+	-- Create categories table; categories nest through parent_id and are
+	-- addressed by their unique slug
+	CREATE TABLE IF NOT EXISTS categories (
+		id SERIAL PRIMARY KEY,
+		parent_id INTEGER REFERENCES categories(id),
+		name VARCHAR(100) NOT NULL,
+		slug VARCHAR(100) UNIQUE NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		CONSTRAINT not_own_parent CHECK (parent_id <> id)
+	);
+
+	-- Create products table; money columns hold integer minor units of currency
 	CREATE TABLE IF NOT EXISTS products (
 		id SERIAL PRIMARY KEY,
 		name VARCHAR(255) NOT NULL,
 		description TEXT,
-		price DECIMAL(10, 2) NOT NULL,
+		price BIGINT NOT NULL,
+		currency CHAR(3) NOT NULL DEFAULT 'IDR',
 		stock INTEGER NOT NULL DEFAULT 0,
-		category VARCHAR(100),
 		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 		CONSTRAINT positive_price CHECK (price >= 0),
@@ -87,10 +138,11 @@ func InitDB(db *sql.DB) error {
 		id SERIAL PRIMARY KEY,
 		user_id INTEGER NOT NULL REFERENCES users(id),
 		status VARCHAR(50) NOT NULL DEFAULT 'PENDING',
-		total_amount DECIMAL(10, 2) NOT NULL DEFAULT 0,
+		total_amount BIGINT NOT NULL DEFAULT 0,
+		currency CHAR(3) NOT NULL DEFAULT 'IDR',
 		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-		CONSTRAINT valid_status CHECK (status IN ('PENDING', 'CONFIRMED', 'CANCELLED', 'FAILED'))
+		CONSTRAINT valid_status CHECK (status IN ('PENDING', 'CONFIRMED', 'PACKED', 'SHIPPED', 'DELIVERED', 'CANCELLED', 'FAILED'))
 	);
 
 	-- Create order_items table
@@ -99,34 +151,373 @@ func InitDB(db *sql.DB) error {
 		order_id INTEGER NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
 		product_id INTEGER NOT NULL REFERENCES products(id),
 		quantity INTEGER NOT NULL,
-		price DECIMAL(10, 2) NOT NULL,
+		price BIGINT NOT NULL,
 		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 		CONSTRAINT positive_quantity CHECK (quantity > 0)
 	);
+
+	-- Create password_resets table; only a hash of each token is stored
+	CREATE TABLE IF NOT EXISTS password_resets (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		token_hash VARCHAR(64) UNIQUE NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		used_at TIMESTAMP,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	-- Create recovery_codes table for two-factor authentication backups
+	CREATE TABLE IF NOT EXISTS recovery_codes (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		code_hash VARCHAR(64) NOT NULL,
+		used_at TIMESTAMP,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	-- Create user_identities table linking users to external OIDC accounts
+	CREATE TABLE IF NOT EXISTS user_identities (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		provider VARCHAR(50) NOT NULL,
+		subject VARCHAR(255) NOT NULL,
+		email VARCHAR(255),
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (provider, subject)
+	);
+
+	-- Create oidc_login_states table for in-flight OIDC logins
+	CREATE TABLE IF NOT EXISTS oidc_login_states (
+		state_hash VARCHAR(64) PRIMARY KEY,
+		provider VARCHAR(50) NOT NULL,
+		nonce VARCHAR(64) NOT NULL,
+		code_verifier VARCHAR(128) NOT NULL,
+		expires_at TIMESTAMP NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	-- Create api_keys table; keys act as their owner within their scopes and
+	-- only a hash of each key is stored
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		name VARCHAR(255) NOT NULL,
+		prefix VARCHAR(16) NOT NULL,
+		key_hash VARCHAR(64) UNIQUE NOT NULL,
+		scopes TEXT[] NOT NULL DEFAULT '{}',
+		created_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+		expires_at TIMESTAMP NOT NULL,
+		last_used_at TIMESTAMP,
+		revoked_at TIMESTAMP,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	-- Create addresses table, the per-user shipping address book
+	CREATE TABLE IF NOT EXISTS addresses (
+		id SERIAL PRIMARY KEY,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		label VARCHAR(50),
+		recipient_name VARCHAR(255) NOT NULL,
+		phone VARCHAR(20) NOT NULL,
+		line1 VARCHAR(255) NOT NULL,
+		line2 VARCHAR(255),
+		city VARCHAR(100) NOT NULL,
+		region VARCHAR(100),
+		postal_code VARCHAR(20) NOT NULL,
+		country CHAR(2) NOT NULL,
+		is_default BOOLEAN NOT NULL DEFAULT FALSE,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	-- Create exchange_rates table; rate is the price of one unit of currency
+	-- in the base currency (IDR), which is not listed
+	CREATE TABLE IF NOT EXISTS exchange_rates (
+		currency CHAR(3) PRIMARY KEY,
+		rate NUMERIC(30, 12) NOT NULL,
+		updated_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		CONSTRAINT positive_rate CHECK (rate > 0)
+	);
+
+	-- Create promotions table; amount_off and min_spend are minor units of currency
+	CREATE TABLE IF NOT EXISTS promotions (
+		id SERIAL PRIMARY KEY,
+		code VARCHAR(50) UNIQUE NOT NULL,
+		description VARCHAR(255),
+		type VARCHAR(20) NOT NULL,
+		percent_off INTEGER,
+		amount_off BIGINT,
+		free_product_id INTEGER REFERENCES products(id),
+		free_quantity INTEGER,
+		currency CHAR(3) NOT NULL DEFAULT 'IDR',
+		min_spend BIGINT NOT NULL DEFAULT 0,
+		category_id INTEGER REFERENCES categories(id),
+		starts_at TIMESTAMP,
+		ends_at TIMESTAMP,
+		max_redemptions INTEGER,
+		max_redemptions_per_user INTEGER,
+		redemption_count INTEGER NOT NULL DEFAULT 0,
+		active BOOLEAN NOT NULL DEFAULT TRUE,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		CONSTRAINT valid_promotion_type CHECK (type IN ('PERCENTAGE', 'FIXED_AMOUNT', 'FREE_ITEM')),
+		CONSTRAINT valid_percent_off CHECK (percent_off BETWEEN 1 AND 100),
+		CONSTRAINT positive_redemption_count CHECK (redemption_count >= 0)
+	);
+
+	-- Create promotion_redemptions table; released_at is set when the order is
+	-- cancelled, giving the use back
+	CREATE TABLE IF NOT EXISTS promotion_redemptions (
+		id SERIAL PRIMARY KEY,
+		promotion_id INTEGER NOT NULL REFERENCES promotions(id) ON DELETE CASCADE,
+		order_id INTEGER UNIQUE NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL REFERENCES users(id),
+		discount_amount BIGINT NOT NULL,
+		currency CHAR(3) NOT NULL,
+		released_at TIMESTAMP,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	-- Create tax_rates table; rate is a percentage applied to products of the
+	-- category, and the is_default row covers categories without their own.
+	-- Inclusive rates are already part of the catalog price.
+	CREATE TABLE IF NOT EXISTS tax_rates (
+		id SERIAL PRIMARY KEY,
+		category_id INTEGER UNIQUE REFERENCES categories(id) ON DELETE CASCADE,
+		is_default BOOLEAN NOT NULL DEFAULT FALSE,
+		name VARCHAR(50) NOT NULL,
+		rate NUMERIC(7, 4) NOT NULL,
+		inclusive BOOLEAN NOT NULL DEFAULT FALSE,
+		updated_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		CONSTRAINT valid_tax_rate CHECK (rate >= 0 AND rate <= 100),
+		CONSTRAINT tax_rate_scope CHECK (is_default = (category_id IS NULL))
+	);
+
+	-- Create product_variants table; price overrides the product's price when
+	-- set, in the product's currency. products.stock is kept as the sum of
+	-- its variants' stock by a trigger.
+	CREATE TABLE IF NOT EXISTS product_variants (
+		id SERIAL PRIMARY KEY,
+		product_id INTEGER NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+		sku VARCHAR(64) UNIQUE NOT NULL,
+		attributes JSONB NOT NULL DEFAULT '{}',
+		price BIGINT,
+		stock INTEGER NOT NULL DEFAULT 0,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		CONSTRAINT positive_variant_price CHECK (price >= 0),
+		CONSTRAINT positive_variant_stock CHECK (stock >= 0)
+	);
+
+	-- Create invoice_sequences table; numbers are taken in the transaction
+	-- that confirms the order, so a rollback gives the number back and the
+	-- sequence of each year has no gaps
+	CREATE TABLE IF NOT EXISTS invoice_sequences (
+		year INTEGER PRIMARY KEY,
+		last_number INTEGER NOT NULL
+	);
+
+	-- Create invoices table; pdf is the rendered invoice as sent to the customer
+	CREATE TABLE IF NOT EXISTS invoices (
+		id SERIAL PRIMARY KEY,
+		order_id INTEGER UNIQUE NOT NULL REFERENCES orders(id),
+		number VARCHAR(20) UNIQUE NOT NULL,
+		year INTEGER NOT NULL,
+		sequence INTEGER NOT NULL,
+		pdf BYTEA NOT NULL,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (year, sequence)
+	);
+
+	-- Create product_images table; the keys locate the image and its thumbnail
+	-- in media storage, and position orders a product's images from 0
+	CREATE TABLE IF NOT EXISTS product_images (
+		id SERIAL PRIMARY KEY,
+		product_id INTEGER NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+		position INTEGER NOT NULL,
+		storage_key VARCHAR(255) NOT NULL,
+		thumbnail_key VARCHAR(255) NOT NULL,
+		content_type VARCHAR(50) NOT NULL,
+		width INTEGER NOT NULL,
+		height INTEGER NOT NULL,
+		size_bytes INTEGER NOT NULL,
+		alt_text VARCHAR(255) NOT NULL DEFAULT '',
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+	);
+
+	-- Create reviews table; users review a product at most once, and only
+	-- approved reviews are shown and counted in the product's rating
+	CREATE TABLE IF NOT EXISTS reviews (
+		id SERIAL PRIMARY KEY,
+		product_id INTEGER NOT NULL REFERENCES products(id) ON DELETE CASCADE,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		rating SMALLINT NOT NULL,
+		body TEXT NOT NULL DEFAULT '',
+		status VARCHAR(20) NOT NULL DEFAULT 'PENDING',
+		moderated_by INTEGER REFERENCES users(id) ON DELETE SET NULL,
+		moderated_at TIMESTAMP,
+		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+		UNIQUE (product_id, user_id),
+		CONSTRAINT valid_rating CHECK (rating BETWEEN 1 AND 5),
+		CONSTRAINT valid_review_status CHECK (status IN ('PENDING', 'APPROVED', 'REJECTED'))
+	);
 	`
 
 	if _, err := db.Exec(schema); err != nil {
 		return fmt.Errorf("failed to create schema: %w", err)
 	}
 
-	log.Println("Creating indexes...")
+	slog.Info("applying schema migrations")
+
+	// Columns added after the initial schema
+	migrations := []string{
+		"ALTER TABLE users ADD COLUMN IF NOT EXISTS failed_login_attempts INTEGER NOT NULL DEFAULT 0",
+		"ALTER TABLE users ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP",
+		"ALTER TABLE users ADD COLUMN IF NOT EXISTS verified_at TIMESTAMP",
+		"ALTER TABLE users ADD COLUMN IF NOT EXISTS sessions_revoked_at TIMESTAMP",
+		"ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret VARCHAR(64)",
+		"ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN NOT NULL DEFAULT FALSE",
+		"ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_step BIGINT",
+		"ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'user'",
+		"ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP",
+		"ALTER TABLE users ADD COLUMN IF NOT EXISTS pending_email VARCHAR(255)",
+		"ALTER TABLE orders ADD COLUMN IF NOT EXISTS address_id INTEGER REFERENCES addresses(id) ON DELETE SET NULL",
+		// Shipping address as it was when the order was placed
+		"ALTER TABLE orders ADD COLUMN IF NOT EXISTS shipping_recipient_name VARCHAR(255)",
+		"ALTER TABLE orders ADD COLUMN IF NOT EXISTS shipping_phone VARCHAR(20)",
+		"ALTER TABLE orders ADD COLUMN IF NOT EXISTS shipping_line1 VARCHAR(255)",
+		"ALTER TABLE orders ADD COLUMN IF NOT EXISTS shipping_line2 VARCHAR(255)",
+		"ALTER TABLE orders ADD COLUMN IF NOT EXISTS shipping_city VARCHAR(100)",
+		"ALTER TABLE orders ADD COLUMN IF NOT EXISTS shipping_region VARCHAR(100)",
+		"ALTER TABLE orders ADD COLUMN IF NOT EXISTS shipping_postal_code VARCHAR(20)",
+		"ALTER TABLE orders ADD COLUMN IF NOT EXISTS shipping_country CHAR(2)",
+		"ALTER TABLE orders ADD COLUMN IF NOT EXISTS carrier VARCHAR(50)",
+		"ALTER TABLE orders ADD COLUMN IF NOT EXISTS tracking_number VARCHAR(100)",
+		"ALTER TABLE orders ADD COLUMN IF NOT EXISTS packed_at TIMESTAMP",
+		"ALTER TABLE orders ADD COLUMN IF NOT EXISTS shipped_at TIMESTAMP",
+		"ALTER TABLE orders ADD COLUMN IF NOT EXISTS delivered_at TIMESTAMP",
+		// Fulfillment states; the constraint is only replaced while it lacks
+		// one of them, so restarts leave it alone
+		`DO $$
+		BEGIN
+			IF NOT EXISTS (SELECT 1 FROM pg_constraint
+				WHERE conrelid = 'orders'::regclass AND conname = 'valid_status'
+					AND pg_get_constraintdef(oid) LIKE ALL (ARRAY['%''PENDING''%', '%''CONFIRMED''%', '%''PACKED''%',
+						'%''SHIPPED''%', '%''DELIVERED''%', '%''CANCELLED''%', '%''FAILED''%'])) THEN
+				ALTER TABLE orders DROP CONSTRAINT IF EXISTS valid_status;
+				ALTER TABLE orders ADD CONSTRAINT valid_status
+					CHECK (status IN ('PENDING', 'CONFIRMED', 'PACKED', 'SHIPPED', 'DELIVERED', 'CANCELLED', 'FAILED'));
+			END IF;
+		END $$`,
+		// Money moved from DECIMAL(10,2) major units, which lost precision in Go
+		// and overflowed for IDR totals, to BIGINT minor units. Recording the
+		// version and converting commit together, so the conversion runs once
+		// even when sessions race; the type check covers databases converted
+		// before versions were recorded.
+		"ALTER TABLE products ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT 'IDR'",
+		"ALTER TABLE orders ADD COLUMN IF NOT EXISTS currency CHAR(3) NOT NULL DEFAULT 'IDR'",
+		`DO $$
+		BEGIN
+			INSERT INTO schema_migrations (version) VALUES ('money_minor_units') ON CONFLICT DO NOTHING;
+			IF FOUND AND (SELECT data_type FROM information_schema.columns
+				WHERE table_name = 'products' AND column_name = 'price') = 'numeric' THEN
+				ALTER TABLE products ALTER COLUMN price TYPE BIGINT USING ROUND(price * 100);
+				ALTER TABLE orders ALTER COLUMN total_amount TYPE BIGINT USING ROUND(total_amount * 100);
+				ALTER TABLE order_items ALTER COLUMN price TYPE BIGINT USING ROUND(price * 100);
+			END IF;
+		END $$`,
+		// Catalog currency and the rate locked when an order is paid in another currency
+		"ALTER TABLE orders ADD COLUMN IF NOT EXISTS base_currency CHAR(3)",
+		"ALTER TABLE orders ADD COLUMN IF NOT EXISTS exchange_rate NUMERIC(30, 12)",
+		// Promotions: total_amount is subtotal_amount less discount_amount
+		"ALTER TABLE orders ADD COLUMN IF NOT EXISTS subtotal_amount BIGINT",
+		"ALTER TABLE orders ADD COLUMN IF NOT EXISTS discount_amount BIGINT NOT NULL DEFAULT 0",
+		"ALTER TABLE orders ADD COLUMN IF NOT EXISTS promotion_code VARCHAR(50)",
+		"UPDATE orders SET subtotal_amount = total_amount WHERE subtotal_amount IS NULL",
+		// Taxes: total_amount adds tax_amount when it is not already in the prices
+		"ALTER TABLE orders ADD COLUMN IF NOT EXISTS tax_amount BIGINT NOT NULL DEFAULT 0",
+		"ALTER TABLE order_items ADD COLUMN IF NOT EXISTS discount_amount BIGINT NOT NULL DEFAULT 0",
+		"ALTER TABLE order_items ADD COLUMN IF NOT EXISTS tax_name VARCHAR(50)",
+		"ALTER TABLE order_items ADD COLUMN IF NOT EXISTS tax_rate NUMERIC(7, 4) NOT NULL DEFAULT 0",
+		"ALTER TABLE order_items ADD COLUMN IF NOT EXISTS tax_inclusive BOOLEAN NOT NULL DEFAULT FALSE",
+		"ALTER TABLE order_items ADD COLUMN IF NOT EXISTS tax_amount BIGINT NOT NULL DEFAULT 0",
+		// Category tree: products point at a category instead of naming one in
+		// the free-text products.category. Its values become top-level
+		// categories, merging spellings that only differ in case, before it
+		// is dropped.
+		"ALTER TABLE products ADD COLUMN IF NOT EXISTS category_id INTEGER REFERENCES categories(id)",
+		`DO $$
+		BEGIN
+			IF EXISTS (SELECT 1 FROM information_schema.columns
+				WHERE table_name = 'products' AND column_name = 'category') THEN
+				INSERT INTO categories (name, slug)
+				SELECT DISTINCT ON (slug) name, slug FROM (
+					SELECT TRIM(category) AS name,
+						TRIM(BOTH '-' FROM REGEXP_REPLACE(LOWER(category), '[^a-z0-9]+', '-', 'g')) AS slug
+					FROM products WHERE category_id IS NULL AND TRIM(COALESCE(category, '')) <> ''
+				) legacy
+				WHERE slug <> ''
+				ORDER BY slug, name
+				ON CONFLICT (slug) DO NOTHING;
+				UPDATE products p SET category_id = c.id FROM categories c
+				WHERE p.category_id IS NULL
+					AND c.slug = TRIM(BOTH '-' FROM REGEXP_REPLACE(LOWER(p.category), '[^a-z0-9]+', '-', 'g'));
+				DROP INDEX IF EXISTS idx_products_category;
+				ALTER TABLE products DROP COLUMN category;
+			END IF;
+		END $$`,
+		// Variants: items are ordered by variant
+		"ALTER TABLE order_items ADD COLUMN IF NOT EXISTS variant_id INTEGER REFERENCES product_variants(id)",
+		// Reviews: the rating of approved reviews, kept up to date by a trigger
+		"ALTER TABLE products ADD COLUMN IF NOT EXISTS rating_average NUMERIC(3, 2) NOT NULL DEFAULT 0",
+		"ALTER TABLE products ADD COLUMN IF NOT EXISTS rating_count INTEGER NOT NULL DEFAULT 0",
+	}
+
+	for _, migration := range migrations {
+		if _, err := db.Exec(migration); err != nil {
+			return fmt.Errorf("failed to apply migration: %w", err)
+		}
+	}
+
+	slog.Info("creating indexes")
 
 	indexes := []string{
 		"CREATE INDEX IF NOT EXISTS idx_users_email ON users(email)",
-		"CREATE INDEX IF NOT EXISTS idx_products_category ON products(category)",
 		"CREATE INDEX IF NOT EXISTS idx_orders_user_id ON orders(user_id)",
 		"CREATE INDEX IF NOT EXISTS idx_orders_status ON orders(status)",
 		"CREATE INDEX IF NOT EXISTS idx_order_items_order_id ON order_items(order_id)",
 		"CREATE INDEX IF NOT EXISTS idx_order_items_product_id ON order_items(product_id)",
+		"CREATE INDEX IF NOT EXISTS idx_order_items_variant_id ON order_items(variant_id)",
+		"CREATE INDEX IF NOT EXISTS idx_product_variants_product_id ON product_variants(product_id)",
+		"CREATE INDEX IF NOT EXISTS idx_products_category_id ON products(category_id)",
+		"CREATE INDEX IF NOT EXISTS idx_categories_parent_id ON categories(parent_id)",
+		// At most one default tax rate
+		"CREATE UNIQUE INDEX IF NOT EXISTS idx_tax_rates_default ON tax_rates(is_default) WHERE is_default",
+		"CREATE INDEX IF NOT EXISTS idx_product_images_product_id ON product_images(product_id, position)",
+		"CREATE INDEX IF NOT EXISTS idx_reviews_product_id ON reviews(product_id, status)",
+		"CREATE INDEX IF NOT EXISTS idx_reviews_status ON reviews(status)",
+		"CREATE INDEX IF NOT EXISTS idx_password_resets_user_id ON password_resets(user_id)",
+		"CREATE INDEX IF NOT EXISTS idx_recovery_codes_user_id ON recovery_codes(user_id)",
+		"CREATE INDEX IF NOT EXISTS idx_user_identities_user_id ON user_identities(user_id)",
+		"CREATE INDEX IF NOT EXISTS idx_api_keys_user_id ON api_keys(user_id)",
+		"CREATE INDEX IF NOT EXISTS idx_addresses_user_id ON addresses(user_id)",
+		// At most one default address per user
+		"CREATE UNIQUE INDEX IF NOT EXISTS idx_addresses_default ON addresses(user_id) WHERE is_default",
+		"CREATE INDEX IF NOT EXISTS idx_promotion_redemptions_promotion_user ON promotion_redemptions(promotion_id, user_id)",
 	}
 
 	for _, index := range indexes {
 		if _, err := db.Exec(index); err != nil {
-			log.Printf("Warning: failed to create index: %v", err)
+			slog.Warn("failed to create index", "error", err)
 		}
 	}
 
-	log.Println("Seeding sample data...")
+	slog.Info("seeding sample data")
 
 	// Check if products already exist
 	var count int
@@ -136,28 +527,63 @@ func InitDB(db *sql.DB) error {
 	}
 
 	if count == 0 {
+		categories := `
+		INSERT INTO categories (name, slug) VALUES
+		('Electronics', 'electronics'),
+		('Accessories', 'accessories'),
+		('Storage', 'storage'),
+		('Gaming', 'gaming')
+		ON CONFLICT (slug) DO NOTHING
+		`
 		products := `
-		INSERT INTO products (name, description, price, stock, category) VALUES
-		('Laptop Dell XPS 13', 'Ultra-portable laptop with 13-inch display', 15999000, 10, 'Electronics'),
-		('iPhone 15 Pro', 'Latest iPhone with A17 Pro chip', 18999000, 15, 'Electronics'),
-		('Sony WH-1000XM5', 'Premium noise-cancelling headphones', 4999000, 20, 'Electronics'),
-		('Samsung 55" QLED TV', '4K QLED Smart TV', 12999000, 8, 'Electronics'),
-		('Mechanical Keyboard', 'RGB gaming mechanical keyboard', 1299000, 30, 'Accessories'),
-		('Logitech MX Master 3', 'Wireless productivity mouse', 1499000, 25, 'Accessories'),
-		('USB-C Hub', '7-in-1 USB-C multiport adapter', 499000, 50, 'Accessories'),
-		('Portable SSD 1TB', 'Fast external SSD storage', 1999000, 40, 'Storage'),
-		('Nintendo Switch', 'Hybrid gaming console', 4499000, 12, 'Gaming'),
-		('PS5 Controller', 'DualSense wireless controller', 999000, 35, 'Gaming')
+		INSERT INTO products (name, description, price, currency, stock, category_id)
+		SELECT p.name, p.description, p.price, 'IDR', p.stock, c.id
+		FROM (VALUES
+			('Laptop Dell XPS 13', 'Ultra-portable laptop with 13-inch display', 1599900000, 10, 'electronics'),
+			('iPhone 15 Pro', 'Latest iPhone with A17 Pro chip', 1899900000, 15, 'electronics'),
+			('Sony WH-1000XM5', 'Premium noise-cancelling headphones', 499900000, 20, 'electronics'),
+			('Samsung 55" QLED TV', '4K QLED Smart TV', 1299900000, 8, 'electronics'),
+			('Mechanical Keyboard', 'RGB gaming mechanical keyboard', 129900000, 30, 'accessories'),
+			('Logitech MX Master 3', 'Wireless productivity mouse', 149900000, 25, 'accessories'),
+			('USB-C Hub', '7-in-1 USB-C multiport adapter', 49900000, 50, 'accessories'),
+			('Portable SSD 1TB', 'Fast external SSD storage', 199900000, 40, 'storage'),
+			('Nintendo Switch', 'Hybrid gaming console', 449900000, 12, 'gaming'),
+			('PS5 Controller', 'DualSense wireless controller', 99900000, 35, 'gaming')
+		) AS p(name, description, price, stock, category)
+		JOIN categories c ON c.slug = p.category
 		ON CONFLICT DO NOTHING
 		`
-		if _, err := db.Exec(products); err != nil {
-			log.Printf("Warning: failed to seed products: %v", err)
+		if _, err := db.Exec(categories); err != nil {
+			slog.Warn("failed to seed categories", "error", err)
+		} else if _, err := db.Exec(products); err != nil {
+			slog.Warn("failed to seed products", "error", err)
 		} else {
-			log.Println("Sample products inserted")
+			slog.Info("sample products inserted")
+		}
+	}
+
+	if err := seedDefaultTaxRate(db); err != nil {
+		slog.Warn("failed to seed tax rates", "error", err)
+	}
+
+	// Products without variants get a default one holding their stock, and
+	// items ordered before variants existed point at it
+	variantBackfills := []string{
+		`INSERT INTO product_variants (product_id, sku, stock)
+		 SELECT id, 'SKU-' || LPAD(id::TEXT, 6, '0'), stock FROM products p
+		 WHERE NOT EXISTS (SELECT 1 FROM product_variants v WHERE v.product_id = p.id)
+		 ON CONFLICT DO NOTHING`,
+		`UPDATE order_items oi SET variant_id = v.id FROM product_variants v
+		 WHERE oi.variant_id IS NULL AND v.product_id = oi.product_id AND v.sku = 'SKU-' || LPAD(oi.product_id::TEXT, 6, '0')`,
+	}
+
+	for _, backfill := range variantBackfills {
+		if _, err := db.Exec(backfill); err != nil {
+			return fmt.Errorf("failed to backfill product variants: %w", err)
 		}
 	}
 
-	log.Println("Creating triggers...")
+	slog.Info("creating triggers")
 
 	// Create function for updated_at trigger
 	triggerFunction := `
@@ -171,7 +597,55 @@ func InitDB(db *sql.DB) error {
 	`
 
 	if _, err := db.Exec(triggerFunction); err != nil {
-		log.Printf("Warning: failed to create trigger function: %v", err)
+		slog.Warn("failed to create trigger function", "error", err)
+	}
+
+	// Create function keeping products.stock the total of its variants
+	stockFunction := `
+	CREATE OR REPLACE FUNCTION sync_product_stock()
+	RETURNS TRIGGER AS $$
+	BEGIN
+		IF TG_OP <> 'INSERT' THEN
+			UPDATE products SET stock = (SELECT COALESCE(SUM(stock), 0) FROM product_variants WHERE product_id = OLD.product_id)
+			WHERE id = OLD.product_id;
+		END IF;
+		IF TG_OP <> 'DELETE' THEN
+			UPDATE products SET stock = (SELECT COALESCE(SUM(stock), 0) FROM product_variants WHERE product_id = NEW.product_id)
+			WHERE id = NEW.product_id;
+		END IF;
+		RETURN NULL;
+	END;
+	$$ language 'plpgsql';
+	`
+
+	if _, err := db.Exec(stockFunction); err != nil {
+		slog.Warn("failed to create stock function", "error", err)
+	}
+
+	// Create function keeping a product's rating the average of its approved reviews
+	ratingFunction := `
+	CREATE OR REPLACE FUNCTION sync_product_rating()
+	RETURNS TRIGGER AS $$
+	BEGIN
+		IF TG_OP <> 'INSERT' THEN
+			UPDATE products SET (rating_average, rating_count) = (
+				SELECT COALESCE(ROUND(AVG(rating), 2), 0), COUNT(*) FROM reviews WHERE product_id = OLD.product_id AND status = 'APPROVED'
+			)
+			WHERE id = OLD.product_id;
+		END IF;
+		IF TG_OP <> 'DELETE' THEN
+			UPDATE products SET (rating_average, rating_count) = (
+				SELECT COALESCE(ROUND(AVG(rating), 2), 0), COUNT(*) FROM reviews WHERE product_id = NEW.product_id AND status = 'APPROVED'
+			)
+			WHERE id = NEW.product_id;
+		END IF;
+		RETURN NULL;
+	END;
+	$$ language 'plpgsql';
+	`
+
+	if _, err := db.Exec(ratingFunction); err != nil {
+		slog.Warn("failed to create rating function", "error", err)
 	}
 
 	// Create triggers
@@ -182,14 +656,78 @@ func InitDB(db *sql.DB) error {
 		"CREATE TRIGGER update_products_updated_at BEFORE UPDATE ON products FOR EACH ROW EXECUTE FUNCTION update_updated_at_column()",
 		"DROP TRIGGER IF EXISTS update_orders_updated_at ON orders",
 		"CREATE TRIGGER update_orders_updated_at BEFORE UPDATE ON orders FOR EACH ROW EXECUTE FUNCTION update_updated_at_column()",
+		"DROP TRIGGER IF EXISTS update_addresses_updated_at ON addresses",
+		"CREATE TRIGGER update_addresses_updated_at BEFORE UPDATE ON addresses FOR EACH ROW EXECUTE FUNCTION update_updated_at_column()",
+		"DROP TRIGGER IF EXISTS update_exchange_rates_updated_at ON exchange_rates",
+		"CREATE TRIGGER update_exchange_rates_updated_at BEFORE UPDATE ON exchange_rates FOR EACH ROW EXECUTE FUNCTION update_updated_at_column()",
+		"DROP TRIGGER IF EXISTS update_promotions_updated_at ON promotions",
+		"CREATE TRIGGER update_promotions_updated_at BEFORE UPDATE ON promotions FOR EACH ROW EXECUTE FUNCTION update_updated_at_column()",
+		"DROP TRIGGER IF EXISTS update_tax_rates_updated_at ON tax_rates",
+		"CREATE TRIGGER update_tax_rates_updated_at BEFORE UPDATE ON tax_rates FOR EACH ROW EXECUTE FUNCTION update_updated_at_column()",
+		"DROP TRIGGER IF EXISTS update_categories_updated_at ON categories",
+		"CREATE TRIGGER update_categories_updated_at BEFORE UPDATE ON categories FOR EACH ROW EXECUTE FUNCTION update_updated_at_column()",
+		"DROP TRIGGER IF EXISTS update_product_variants_updated_at ON product_variants",
+		"CREATE TRIGGER update_product_variants_updated_at BEFORE UPDATE ON product_variants FOR EACH ROW EXECUTE FUNCTION update_updated_at_column()",
+		"DROP TRIGGER IF EXISTS sync_product_stock ON product_variants",
+		"CREATE TRIGGER sync_product_stock AFTER INSERT OR UPDATE OF stock, product_id OR DELETE ON product_variants FOR EACH ROW EXECUTE FUNCTION sync_product_stock()",
+		"DROP TRIGGER IF EXISTS update_reviews_updated_at ON reviews",
+		"CREATE TRIGGER update_reviews_updated_at BEFORE UPDATE ON reviews FOR EACH ROW EXECUTE FUNCTION update_updated_at_column()",
+		"DROP TRIGGER IF EXISTS sync_product_rating ON reviews",
+		"CREATE TRIGGER sync_product_rating AFTER INSERT OR UPDATE OF rating, status OR DELETE ON reviews FOR EACH ROW EXECUTE FUNCTION sync_product_rating()",
 	}
 
 	for _, trigger := range triggers {
 		if _, err := db.Exec(trigger); err != nil {
-			log.Printf("Warning: failed to create trigger: %v", err)
+			slog.Warn("failed to create trigger", "error", err)
 		}
 	}
 
-	log.Println("Database initialization completed successfully!")
+	slog.Info("database initialization completed")
+	return nil
+}
+
+// seedDefaultTaxRate adds the default rate on the first start only.
+// Administrators may delete it, and later starts must not bring it back, so
+// the seed is recorded as a migration in the same transaction.
+func seedDefaultTaxRate(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("INSERT INTO schema_migrations (version) VALUES ('default_tax_rate') ON CONFLICT DO NOTHING")
+	if err != nil {
+		return err
+	}
+	if seeded, err := result.RowsAffected(); err != nil || seeded == 0 {
+		return err
+	}
+
+	// Catalog prices include Indonesian VAT unless an administrator changes it
+	if _, err := tx.Exec(`INSERT INTO tax_rates (is_default, name, rate, inclusive) VALUES (TRUE, 'PPN', 11, TRUE)
+		ON CONFLICT DO NOTHING`); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GrantAdmins gives the admin role to the users with the given emails.
+// Unknown emails are skipped so accounts can be listed before they register.
+func GrantAdmins(db *sql.DB, emails []string) error {
+	for _, email := range emails {
+		email = strings.ToLower(strings.TrimSpace(email))
+		if email == "" {
+			continue
+		}
+
+		result, err := db.Exec("UPDATE users SET role = 'admin' WHERE LOWER(email) = $1 AND role <> 'admin'", email)
+		if err != nil {
+			return fmt.Errorf("failed to grant admin role to %s: %w", email, err)
+		}
+		if affected, _ := result.RowsAffected(); affected > 0 {
+			slog.Info("granted admin role", "email", email)
+		}
+	}
 	return nil
 }
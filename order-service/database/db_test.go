@@ -0,0 +1,39 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestSeedDefaultTaxRate(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// The first start records the seed and adds the rate
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO schema_migrations \\(version\\) VALUES \\('default_tax_rate'\\) ON CONFLICT DO NOTHING").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO tax_rates").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	if err := seedDefaultTaxRate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// After an administrator deleted the rate, a restart finds the seed
+	// recorded and leaves tax_rates alone
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO schema_migrations \\(version\\) VALUES \\('default_tax_rate'\\) ON CONFLICT DO NOTHING").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	if err := seedDefaultTaxRate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"order-service/apperror"
+	"order-service/cache"
+	"order-service/catalog"
+	"order-service/logger"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MaxImportSize is the largest import file accepted, in bytes
+const MaxImportSize = 50 << 20
+
+// ImportProducts creates and updates products from a CSV or JSON file sent
+// as the request body, matching variants by SKU. The format comes from
+// ?format or the Content-Type. With ?dry_run=true every row is checked, but
+// nothing is saved. A file with any invalid row is rejected as a whole, with
+// an error per row.
+func (h *ProductHandler) ImportProducts(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	format, err := catalogFormat(c)
+	if err != nil {
+		c.Error(apperror.Wrap(err, apperror.CodeBadRequest, err.Error()))
+		return
+	}
+	dryRun := c.Query("dry_run") == "true"
+
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, MaxImportSize)
+	result, err := catalog.Import(ctx, h.db, body, format, dryRun)
+
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		c.Error(apperror.New(apperror.CodeImportTooLarge, "Import file must be at most "+strconv.Itoa(MaxImportSize>>20)+" MB"))
+		return
+	}
+	if errors.Is(err, catalog.ErrInvalidFile) {
+		c.Error(apperror.Wrap(err, apperror.CodeBadRequest, err.Error()))
+		return
+	}
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to import products"))
+		return
+	}
+
+	log := logger.FromContext(ctx)
+	if result.FailedRows > 0 {
+		fields := make([]apperror.FieldError, len(result.Errors))
+		for i, rowErr := range result.Errors {
+			field := fmt.Sprintf("rows[%d]", rowErr.Row)
+			if rowErr.Field != "" {
+				field += "." + rowErr.Field
+			}
+			fields[i] = apperror.FieldError{Field: field, Rule: "import", Message: rowErr.Message}
+		}
+
+		log.Info("product import rejected", "format", format, "rows", result.Rows, "failed_rows", result.FailedRows, "dry_run", dryRun, "by", c.GetInt("user_id"))
+
+		c.Error(&apperror.Error{
+			Code:    apperror.CodeImportRejected,
+			Message: fmt.Sprintf("%d of %d rows have errors; nothing was imported", result.FailedRows, result.Rows),
+			Fields:  fields,
+		})
+		return
+	}
+
+	message := fmt.Sprintf("Dry run: %d rows are valid; nothing was imported", result.Rows)
+	if result.Applied {
+		cache.Invalidate(ctx, h.redis, "product:*", "products*")
+		message = fmt.Sprintf("Imported %d rows", result.Rows)
+	}
+
+	log.Info("products imported", "format", format, "rows", result.Rows, "dry_run", dryRun,
+		"products_created", result.ProductsCreated, "products_updated", result.ProductsUpdated,
+		"variants_created", result.VariantsCreated, "variants_updated", result.VariantsUpdated, "by", c.GetInt("user_id"))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": message,
+		"data":    result,
+	})
+}
+
+// ExportProducts streams every variant as a CSV or JSON file, in the format
+// ImportProducts accepts. The format comes from ?format, defaulting to CSV.
+func (h *ProductHandler) ExportProducts(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	format, err := catalog.ParseFormat(c.DefaultQuery("format", string(catalog.CSV)))
+	if err != nil {
+		c.Error(apperror.Wrap(err, apperror.CodeBadRequest, err.Error()))
+		return
+	}
+
+	contentType := "text/csv; charset=utf-8"
+	if format == catalog.JSON {
+		contentType = "application/json; charset=utf-8"
+	}
+	filename := "products-" + time.Now().Format("2006-01-02") + "." + string(format)
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+
+	// Once rows are written the status is sent, so a failure can only cut
+	// the file short
+	if err := catalog.Export(ctx, h.db, c.Writer, format); err != nil {
+		if !c.Writer.Written() {
+			c.Writer.Header().Del("Content-Type")
+			c.Writer.Header().Del("Content-Disposition")
+			c.Error(apperror.Internal(err, "Failed to export products"))
+			return
+		}
+		logger.FromContext(ctx).Error("product export interrupted", "error", err)
+		return
+	}
+
+	logger.FromContext(ctx).Info("products exported", "format", format, "by", c.GetInt("user_id"))
+}
+
+// catalogFormat reads an import's format from ?format or the Content-Type
+func catalogFormat(c *gin.Context) (catalog.Format, error) {
+	if format := c.Query("format"); format != "" {
+		return catalog.ParseFormat(format)
+	}
+
+	switch c.ContentType() {
+	case "text/csv":
+		return catalog.CSV, nil
+	case "application/json":
+		return catalog.JSON, nil
+	default:
+		return "", errors.New("send the file as text/csv or application/json, or set ?format")
+	}
+}
@@ -0,0 +1,416 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"order-service/apperror"
+	"order-service/cache"
+	"order-service/logger"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// CategoryHandler serves the category tree and lets administrators edit it
+type CategoryHandler struct {
+	db    *sql.DB
+	redis *redis.Client
+}
+
+type Category struct {
+	ID        int         `json:"id"`
+	ParentID  *int        `json:"parent_id"`
+	Name      string      `json:"name"`
+	Slug      string      `json:"slug"`
+	CreatedAt time.Time   `json:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at"`
+	Children  []*Category `json:"children,omitempty"`
+}
+
+type CategoryRequest struct {
+	Name string `json:"name" binding:"required,max=100"`
+	// Slug defaults to one derived from Name
+	Slug     string `json:"slug" binding:"max=100"`
+	ParentID *int   `json:"parent_id"`
+}
+
+type AssignCategoryRequest struct {
+	// CategoryID of nil leaves the product uncategorised
+	CategoryID *int `json:"category_id"`
+}
+
+var (
+	slugPattern   = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+	nonSlugRunsRe = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+const categoryColumns = "id, parent_id, name, slug, created_at, updated_at"
+
+// categoryTreeLockID serializes moves in the category tree, so two moves
+// cannot each pass the cycle check and together form a cycle
+const categoryTreeLockID = 7350003
+
+// categoryPathSQL selects the IDs of a product's category and its
+// ancestors, nearest first, for a query over products p. It stops at a
+// category already on the path, so a cycle cannot make it loop.
+const categoryPathSQL = `ARRAY(
+	WITH RECURSIVE chain AS (
+		SELECT c.id, c.parent_id, ARRAY[c.id] AS path FROM categories c WHERE c.id = p.category_id
+		UNION ALL
+		SELECT c.id, c.parent_id, chain.path || c.id FROM categories c JOIN chain ON c.id = chain.parent_id
+		WHERE c.id <> ALL(chain.path)
+	)
+	SELECT id FROM chain ORDER BY CARDINALITY(path)
+)`
+
+// categorySubtreeSQL selects the ids of the category matching a slug or name
+// and all of its descendants, once formatted with the placeholder to match.
+// UNION drops categories already found, so a cycle cannot make it loop.
+const categorySubtreeSQL = `(
+	WITH RECURSIVE subtree AS (
+		SELECT id FROM categories WHERE slug = LOWER(%[1]s) OR LOWER(name) = LOWER(%[1]s)
+		UNION
+		SELECT c.id FROM categories c JOIN subtree ON c.parent_id = subtree.id
+	)
+	SELECT id FROM subtree
+)`
+
+func scanCategory(row rowScanner) (Category, error) {
+	var cat Category
+	err := row.Scan(&cat.ID, &cat.ParentID, &cat.Name, &cat.Slug, &cat.CreatedAt, &cat.UpdatedAt)
+	return cat, err
+}
+
+// slugify derives a URL-safe slug from a name, e.g. "TV & Audio" becomes "tv-audio"
+func slugify(name string) string {
+	return strings.Trim(nonSlugRunsRe.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+func NewCategoryHandler(db *sql.DB, redis *redis.Client) *CategoryHandler {
+	return &CategoryHandler{
+		db:    db,
+		redis: redis,
+	}
+}
+
+// GetCategories returns all categories as a tree of top-level categories
+func (h *CategoryHandler) GetCategories(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	rows, err := h.db.QueryContext(ctx, "SELECT "+categoryColumns+" FROM categories ORDER BY name")
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to fetch categories"))
+		return
+	}
+	defer rows.Close()
+
+	var all []*Category
+	byID := map[int]*Category{}
+	for rows.Next() {
+		cat, err := scanCategory(rows)
+		if err != nil {
+			c.Error(apperror.Internal(err, "Failed to scan category"))
+			return
+		}
+		all = append(all, &cat)
+		byID[cat.ID] = &cat
+	}
+
+	if err := rows.Err(); err != nil {
+		c.Error(apperror.Internal(err, "Failed to fetch categories"))
+		return
+	}
+
+	// Categories are sorted by name, so children keep that order
+	tree := []*Category{}
+	for _, cat := range all {
+		if cat.ParentID == nil {
+			tree = append(tree, cat)
+		} else if parent, ok := byID[*cat.ParentID]; ok {
+			parent.Children = append(parent.Children, cat)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    tree,
+	})
+}
+
+// CreateCategory adds a category, at the top level or under parent_id
+func (h *CategoryHandler) CreateCategory(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	req, ok := h.bindCategory(c, 0)
+	if !ok {
+		return
+	}
+
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to start transaction"))
+		return
+	}
+	defer tx.Rollback()
+
+	if err := checkParent(ctx, tx, req.ParentID, 0); err != nil {
+		c.Error(err)
+		return
+	}
+
+	cat, err := scanCategory(tx.QueryRowContext(ctx,
+		"INSERT INTO categories (name, slug, parent_id) VALUES ($1, $2, $3) RETURNING "+categoryColumns,
+		req.Name, req.Slug, req.ParentID,
+	))
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to create category"))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.Error(apperror.Internal(err, "Failed to commit transaction"))
+		return
+	}
+
+	logger.FromContext(ctx).Info("category created", "category_id", cat.ID, "slug", cat.Slug, "by", c.GetInt("user_id"))
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"message": "Category created successfully",
+		"data":    cat,
+	})
+}
+
+// UpdateCategory renames or moves a category; its products and subcategories move with it
+func (h *CategoryHandler) UpdateCategory(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	id, ok := categoryIDParam(c)
+	if !ok {
+		return
+	}
+
+	req, ok := h.bindCategory(c, id)
+	if !ok {
+		return
+	}
+
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to start transaction"))
+		return
+	}
+	defer tx.Rollback()
+
+	if err := checkParent(ctx, tx, req.ParentID, id); err != nil {
+		c.Error(err)
+		return
+	}
+
+	cat, err := scanCategory(tx.QueryRowContext(ctx,
+		"UPDATE categories SET name = $1, slug = $2, parent_id = $3 WHERE id = $4 RETURNING "+categoryColumns,
+		req.Name, req.Slug, req.ParentID, id,
+	))
+	if err == sql.ErrNoRows {
+		c.Error(apperror.New(apperror.CodeCategoryNotFound, "Category not found"))
+		return
+	}
+
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to update category"))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.Error(apperror.Internal(err, "Failed to commit transaction"))
+		return
+	}
+
+	// Cached products show the category name and listings its subtree
+	cache.Invalidate(ctx, h.redis, "product:*", "products*")
+
+	logger.FromContext(ctx).Info("category updated", "category_id", id, "by", c.GetInt("user_id"))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Category updated successfully",
+		"data":    cat,
+	})
+}
+
+// DeleteCategory removes a category without subcategories, products or
+// promotions; its tax rate goes with it
+func (h *CategoryHandler) DeleteCategory(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	id, ok := categoryIDParam(c)
+	if !ok {
+		return
+	}
+
+	var inUse bool
+	err := h.db.QueryRowContext(ctx,
+		`SELECT EXISTS(SELECT 1 FROM categories WHERE parent_id = $1)
+			OR EXISTS(SELECT 1 FROM products WHERE category_id = $1)
+			OR EXISTS(SELECT 1 FROM promotions WHERE category_id = $1)`,
+		id,
+	).Scan(&inUse)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Database error"))
+		return
+	}
+	if inUse {
+		c.Error(apperror.New(apperror.CodeCategoryInUse, "Move the category's subcategories, products and promotions first"))
+		return
+	}
+
+	result, err := h.db.ExecContext(ctx, "DELETE FROM categories WHERE id = $1", id)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to delete category"))
+		return
+	}
+
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.Error(apperror.New(apperror.CodeCategoryNotFound, "Category not found"))
+		return
+	}
+
+	logger.FromContext(ctx).Info("category deleted", "category_id", id, "by", c.GetInt("user_id"))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Category deleted",
+	})
+}
+
+// AssignCategory moves a product into a category
+func (h *CategoryHandler) AssignCategory(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(apperror.Wrap(err, apperror.CodeBadRequest, "Invalid product ID"))
+		return
+	}
+
+	var req AssignCategoryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperror.Validation(err))
+		return
+	}
+
+	if req.CategoryID != nil {
+		var exists bool
+		err := h.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM categories WHERE id = $1)", *req.CategoryID).Scan(&exists)
+		if err != nil {
+			c.Error(apperror.Internal(err, "Database error"))
+			return
+		}
+		if !exists {
+			c.Error(apperror.New(apperror.CodeCategoryNotFound, "Category not found"))
+			return
+		}
+	}
+
+	result, err := h.db.ExecContext(ctx, "UPDATE products SET category_id = $1 WHERE id = $2", req.CategoryID, productID)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to update product"))
+		return
+	}
+
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.Error(apperror.New(apperror.CodeProductNotFound, "Product not found"))
+		return
+	}
+
+	cache.Invalidate(ctx, h.redis, "product:"+strconv.Itoa(productID), "products*")
+
+	logger.FromContext(ctx).Info("product category changed", "product_id", productID, "category_id", req.CategoryID, "by", c.GetInt("user_id"))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Product category updated",
+	})
+}
+
+// bindCategory validates a category request, filling in the slug. id is the
+// category being updated, or 0.
+func (h *CategoryHandler) bindCategory(c *gin.Context, id int) (CategoryRequest, bool) {
+	ctx := c.Request.Context()
+
+	var req CategoryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperror.Validation(err))
+		return req, false
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Slug == "" {
+		req.Slug = slugify(req.Name)
+	}
+	if !slugPattern.MatchString(req.Slug) {
+		c.Error(apperror.InvalidField("slug", "slug", "must be lowercase letters and digits separated by single hyphens"))
+		return req, false
+	}
+
+	var taken bool
+	err := h.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM categories WHERE slug = $1 AND id <> $2)", req.Slug, id).Scan(&taken)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Database error"))
+		return req, false
+	}
+	if taken {
+		c.Error(apperror.New(apperror.CodeCategorySlugTaken, "Category slug already exists"))
+		return req, false
+	}
+
+	return req, true
+}
+
+// checkParent verifies that the parent exists and, when moving category id,
+// is not the category itself or one of its descendants. It takes the tree
+// lock, so the check holds until tx, which saves the category, ends.
+func checkParent(ctx context.Context, tx *sql.Tx, parentID *int, id int) error {
+	if parentID == nil {
+		return nil
+	}
+
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", categoryTreeLockID); err != nil {
+		return apperror.Internal(err, "Database error")
+	}
+
+	var exists, cycle bool
+	err := tx.QueryRowContext(ctx,
+		`WITH RECURSIVE ancestors AS (
+			SELECT id, parent_id FROM categories WHERE id = $1
+			UNION
+			SELECT c.id, c.parent_id FROM categories c JOIN ancestors a ON c.id = a.parent_id
+		 )
+		 SELECT EXISTS(SELECT 1 FROM ancestors), EXISTS(SELECT 1 FROM ancestors WHERE id = $2)`,
+		*parentID, id,
+	).Scan(&exists, &cycle)
+	if err != nil {
+		return apperror.Internal(err, "Database error")
+	}
+	if !exists {
+		return apperror.New(apperror.CodeCategoryNotFound, "Parent category not found")
+	}
+	if cycle {
+		return apperror.InvalidField("parent_id", "cycle", "must not be the category itself or one of its subcategories")
+	}
+	return nil
+}
+
+func categoryIDParam(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(apperror.Wrap(err, apperror.CodeBadRequest, "Invalid category ID"))
+		return 0, false
+	}
+	return id, true
+}
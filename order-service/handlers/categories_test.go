@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"order-service/middleware"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestUpdateCategoryParent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name       string
+		exists     bool
+		cycle      bool
+		wantStatus int
+	}{
+		{name: "moved", exists: true, wantStatus: http.StatusOK},
+		{name: "under a subcategory", exists: true, cycle: true, wantStatus: http.StatusBadRequest},
+		{name: "missing parent", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+			client := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+			defer client.Close()
+
+			mock.ExpectQuery("SELECT EXISTS\\(SELECT 1 FROM categories WHERE slug = \\$1 AND id <> \\$2\\)").
+				WithArgs("phones", 5).
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+			// The check and the move share a transaction under the tree lock
+			mock.ExpectBegin()
+			mock.ExpectExec("SELECT pg_advisory_xact_lock").
+				WithArgs(categoryTreeLockID).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectQuery("WITH RECURSIVE ancestors").
+				WithArgs(2, 5).
+				WillReturnRows(sqlmock.NewRows([]string{"exists", "cycle"}).AddRow(tt.exists, tt.cycle))
+			if tt.wantStatus == http.StatusOK {
+				now := time.Now()
+				mock.ExpectQuery("UPDATE categories SET").
+					WithArgs("Phones", "phones", 2, 5).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "parent_id", "name", "slug", "created_at", "updated_at"}).
+						AddRow(5, 2, "Phones", "phones", now, now))
+				mock.ExpectCommit()
+			} else {
+				mock.ExpectRollback()
+			}
+
+			handler := NewCategoryHandler(db, client)
+			router := gin.New()
+			router.Use(middleware.ErrorHandler())
+			router.PUT("/admin/categories/:id", handler.UpdateCategory)
+
+			req := httptest.NewRequest(http.MethodPut, "/admin/categories/5", strings.NewReader(`{"name": "Phones", "parent_id": 2}`))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,292 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"order-service/apperror"
+	"order-service/logger"
+	"order-service/money"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// CurrencyHeader selects the display currency when ?currency is not given
+const CurrencyHeader = "X-Currency"
+
+const (
+	// rateScale is the number of decimals rates are stored and locked with
+	rateScale = 12
+
+	exchangeRatesCacheKey = "exchange_rates"
+	exchangeRatesCacheTTL = 5 * time.Minute
+)
+
+// ExchangeRateHandler manages the exchange rate table, which quotes every
+// currency against the base currency money.DefaultCurrency
+type ExchangeRateHandler struct {
+	db    *sql.DB
+	redis *redis.Client
+}
+
+type ExchangeRate struct {
+	Currency  string    `json:"currency"`
+	Rate      string    `json:"rate"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type SetExchangeRateRequest struct {
+	// Rate is the price of one unit of the currency in the base currency, as a decimal string
+	Rate string `json:"rate" binding:"required,max=32"`
+}
+
+// rateTable maps a currency to the price of one unit in the base currency
+type rateTable map[string]*big.Rat
+
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func NewExchangeRateHandler(db *sql.DB, redis *redis.Client) *ExchangeRateHandler {
+	return &ExchangeRateHandler{
+		db:    db,
+		redis: redis,
+	}
+}
+
+// ListRates returns the current exchange rates
+func (h *ExchangeRateHandler) ListRates(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	rows, err := h.db.QueryContext(ctx, "SELECT currency, rate::TEXT, updated_at FROM exchange_rates ORDER BY currency")
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to fetch exchange rates"))
+		return
+	}
+	defer rows.Close()
+
+	rates := []ExchangeRate{}
+	for rows.Next() {
+		var rate ExchangeRate
+		if err := rows.Scan(&rate.Currency, &rate.Rate, &rate.UpdatedAt); err != nil {
+			c.Error(apperror.Internal(err, "Failed to scan exchange rate"))
+			return
+		}
+		rate.Rate = trimRate(rate.Rate)
+		rates = append(rates, rate)
+	}
+
+	if err := rows.Err(); err != nil {
+		c.Error(apperror.Internal(err, "Failed to fetch exchange rates"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data": gin.H{
+			"base_currency": money.DefaultCurrency,
+			"rates":         rates,
+		},
+	})
+}
+
+// SetRate creates or replaces the rate of a currency. Orders already placed
+// keep the rate they were locked to.
+func (h *ExchangeRateHandler) SetRate(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	currency, ok := rateCurrencyParam(c)
+	if !ok {
+		return
+	}
+
+	var req SetExchangeRateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperror.Validation(err))
+		return
+	}
+
+	rate, ok := new(big.Rat).SetString(req.Rate)
+	if !ok || strings.ContainsAny(req.Rate, "eE/") || rate.Sign() <= 0 {
+		c.Error(apperror.InvalidField("rate", "decimal", "must be a positive decimal number"))
+		return
+	}
+
+	var result ExchangeRate
+	err := h.db.QueryRowContext(ctx,
+		`INSERT INTO exchange_rates (currency, rate, updated_by) VALUES ($1, $2, $3)
+		 ON CONFLICT (currency) DO UPDATE SET rate = EXCLUDED.rate, updated_by = EXCLUDED.updated_by
+		 RETURNING currency, rate::TEXT, updated_at`,
+		currency, rate.FloatString(rateScale), c.GetInt("user_id"),
+	).Scan(&result.Currency, &result.Rate, &result.UpdatedAt)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to save exchange rate"))
+		return
+	}
+	result.Rate = trimRate(result.Rate)
+
+	h.redis.Del(ctx, exchangeRatesCacheKey)
+
+	logger.FromContext(ctx).Info("exchange rate updated", "currency", currency, "rate", result.Rate, "by", c.GetInt("user_id"))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Exchange rate saved",
+		"data":    result,
+	})
+}
+
+// DeleteRate stops accepting a currency
+func (h *ExchangeRateHandler) DeleteRate(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	currency, ok := rateCurrencyParam(c)
+	if !ok {
+		return
+	}
+
+	result, err := h.db.ExecContext(ctx, "DELETE FROM exchange_rates WHERE currency = $1", currency)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to delete exchange rate"))
+		return
+	}
+
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.Error(apperror.New(apperror.CodeExchangeRateNotFound, "Exchange rate not found"))
+		return
+	}
+
+	h.redis.Del(ctx, exchangeRatesCacheKey)
+
+	logger.FromContext(ctx).Info("exchange rate deleted", "currency", currency, "by", c.GetInt("user_id"))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Exchange rate deleted",
+	})
+}
+
+// load returns the rate table, cached in Redis since every product listing
+// in a foreign currency needs it
+func (h *ExchangeRateHandler) load(ctx context.Context) (rateTable, error) {
+	if cached, err := h.redis.Get(ctx, exchangeRatesCacheKey).Result(); err == nil {
+		var raw map[string]string
+		if err := json.Unmarshal([]byte(cached), &raw); err == nil {
+			table := rateTable{}
+			for currency, value := range raw {
+				if rate, ok := new(big.Rat).SetString(value); ok {
+					table[currency] = rate
+				}
+			}
+			return table, nil
+		}
+	}
+
+	table, err := queryRates(ctx, h.db)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := make(map[string]string, len(table))
+	for currency, rate := range table {
+		raw[currency] = rate.FloatString(rateScale)
+	}
+	ratesJSON, _ := json.Marshal(raw)
+	h.redis.Set(ctx, exchangeRatesCacheKey, ratesJSON, exchangeRatesCacheTTL)
+
+	return table, nil
+}
+
+// queryRates reads the rate table, within a transaction when q is one
+func queryRates(ctx context.Context, q queryer) (rateTable, error) {
+	rows, err := q.QueryContext(ctx, "SELECT currency, rate::TEXT FROM exchange_rates")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	table := rateTable{}
+	for rows.Next() {
+		var currency, value string
+		if err := rows.Scan(&currency, &value); err != nil {
+			return nil, err
+		}
+		if rate, ok := new(big.Rat).SetString(value); ok {
+			table[currency] = rate
+		}
+	}
+	return table, rows.Err()
+}
+
+// rate returns the price of one unit of from in units of to, rounded to
+// rateScale decimals so the same value can be stored and reapplied
+func (t rateTable) rate(from, to string) (*big.Rat, error) {
+	quote := func(currency string) (*big.Rat, error) {
+		if currency == money.DefaultCurrency {
+			return big.NewRat(1, 1), nil
+		}
+		if rate, ok := t[currency]; ok {
+			return rate, nil
+		}
+		return nil, apperror.New(apperror.CodeUnsupportedCurrency, "No exchange rate for "+currency)
+	}
+
+	fromRate, err := quote(from)
+	if err != nil {
+		return nil, err
+	}
+	toRate, err := quote(to)
+	if err != nil {
+		return nil, err
+	}
+
+	rate, _ := new(big.Rat).SetString(new(big.Rat).Quo(fromRate, toRate).FloatString(rateScale))
+	if rate.Sign() <= 0 {
+		return nil, apperror.New(apperror.CodeUnsupportedCurrency, "Exchange rate from "+from+" to "+to+" is too small")
+	}
+	return rate, nil
+}
+
+// requestedCurrency returns the currency asked for with ?currency or the
+// X-Currency header, or "" to keep catalog prices
+func requestedCurrency(c *gin.Context) (string, error) {
+	currency := c.Query("currency")
+	if currency == "" {
+		currency = c.GetHeader(CurrencyHeader)
+	}
+	if currency == "" {
+		return "", nil
+	}
+
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	if !money.IsSupported(currency) {
+		return "", apperror.New(apperror.CodeUnsupportedCurrency, "Currency "+currency+" is not supported")
+	}
+	return currency, nil
+}
+
+func rateCurrencyParam(c *gin.Context) (string, bool) {
+	currency := strings.ToUpper(c.Param("currency"))
+	if !money.IsSupported(currency) {
+		c.Error(apperror.New(apperror.CodeUnsupportedCurrency, "Currency "+currency+" is not supported"))
+		return "", false
+	}
+	if currency == money.DefaultCurrency {
+		c.Error(apperror.New(apperror.CodeBadRequest, currency+" is the base currency and has no rate"))
+		return "", false
+	}
+	return currency, true
+}
+
+// trimRate drops the trailing zeros NUMERIC pads rates with
+func trimRate(rate string) string {
+	if !strings.Contains(rate, ".") {
+		return rate
+	}
+	return strings.TrimSuffix(strings.TrimRight(rate, "0"), ".")
+}
@@ -0,0 +1,298 @@
+package handlers
+
+import (
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"order-service/apperror"
+	"order-service/middleware"
+	"order-service/money"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+// rates quotes USD at Rp15,500.50, JPY at Rp103.25 and EUR at Rp17,000
+var rates = rateTable{
+	"USD": big.NewRat(1550050, 100),
+	"JPY": big.NewRat(10325, 100),
+	"EUR": big.NewRat(17000, 1),
+}
+
+func TestRateTable(t *testing.T) {
+	tests := []struct {
+		name     string
+		from, to string
+		want     string
+	}{
+		{name: "into the base currency", from: "USD", to: "IDR", want: "15500.500000000000"},
+		{name: "out of the base currency", from: "IDR", to: "USD", want: "0.000064514048"}, // 0.0000645140479...
+		{name: "cross rate", from: "USD", to: "JPY", want: "150.125907990315"},             // 150.1259079903147...
+		{name: "cross rate rounds up", from: "JPY", to: "EUR", want: "0.006073529412"},     // 0.0060735294117...
+		{name: "same currency", from: "EUR", to: "EUR", want: "1.000000000000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rate, err := rates.rate(tt.from, tt.to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Rates keep 12 decimals, the precision orders lock them with
+			if got := rate.FloatString(rateScale); got != tt.want {
+				t.Errorf("rate %s", got)
+			}
+		})
+	}
+}
+
+func TestRateTableRejects(t *testing.T) {
+	tests := []struct {
+		name     string
+		table    rateTable
+		from, to string
+	}{
+		{name: "no rate", table: rates, from: "IDR", to: "GBP"},
+		{name: "no rate for the source", table: rates, from: "GBP", to: "USD"},
+		// Rounds to zero at 12 decimals
+		{name: "too small", table: rateTable{"USD": big.NewRat(1, 1), "JPY": big.NewRat(1e13, 1)}, from: "USD", to: "JPY"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.table.rate(tt.from, tt.to)
+			var appErr *apperror.Error
+			if !errors.As(err, &appErr) || appErr.Code != apperror.CodeUnsupportedCurrency {
+				t.Errorf("error = %v, want UNSUPPORTED_CURRENCY", err)
+			}
+		})
+	}
+}
+
+func TestRequestedCurrency(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name    string
+		query   string
+		header  string
+		want    string
+		wantErr bool
+	}{
+		{name: "catalog prices"},
+		{name: "query", query: "?currency=usd", want: "USD"},
+		{name: "header", header: " jpy ", want: "JPY"},
+		{name: "query wins", query: "?currency=EUR", header: "JPY", want: "EUR"},
+		{name: "unknown currency", query: "?currency=XYZ", wantErr: true},
+		{name: "unknown header", header: "BTC", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodGet, "/products"+tt.query, nil)
+			if tt.header != "" {
+				c.Request.Header.Set(CurrencyHeader, tt.header)
+			}
+
+			got, err := requestedCurrency(c)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("currency %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// rateTest serves the exchange rate routes against a mock database and Redis
+type rateTest struct {
+	handler *ExchangeRateHandler
+	router  *gin.Engine
+	mock    sqlmock.Sqlmock
+	redis   *miniredis.Miniredis
+}
+
+func newRateTest(t *testing.T) *rateTest {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	handler := NewExchangeRateHandler(db, client)
+	router := gin.New()
+	router.Use(middleware.ErrorHandler(), func(c *gin.Context) { c.Set("user_id", 1) })
+	router.PUT("/admin/exchange-rates/:currency", handler.SetRate)
+	router.DELETE("/admin/exchange-rates/:currency", handler.DeleteRate)
+
+	return &rateTest{handler: handler, router: router, mock: mock, redis: server}
+}
+
+func (rt *rateTest) do(method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	rt.router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestLoadCachesRates(t *testing.T) {
+	rt := newRateTest(t)
+	rt.mock.ExpectQuery("SELECT currency, rate::TEXT FROM exchange_rates").
+		WillReturnRows(sqlmock.NewRows([]string{"currency", "rate"}).AddRow("USD", "15500.500000000000"))
+
+	for range 2 {
+		table, err := rt.handler.load(t.Context())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if table["USD"].Cmp(big.NewRat(1550050, 100)) != 0 {
+			t.Errorf("USD rate %v", table["USD"])
+		}
+	}
+
+	// The second load came from Redis
+	if err := rt.mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	if ttl := rt.redis.TTL(exchangeRatesCacheKey); ttl != exchangeRatesCacheTTL {
+		t.Errorf("cached for %s", ttl)
+	}
+}
+
+func TestSetRate(t *testing.T) {
+	rt := newRateTest(t)
+	rt.redis.Set(exchangeRatesCacheKey, `{"USD":"15000"}`)
+
+	rt.mock.ExpectQuery("INSERT INTO exchange_rates").
+		WithArgs("USD", "15500.123456789012", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"currency", "rate", "updated_at"}).AddRow("USD", "15500.123456789012", time.Now()))
+
+	// Rates are stored with 12 decimals
+	rec := rt.do(http.MethodPut, "/admin/exchange-rates/usd", `{"rate": "15500.1234567890123"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if rt.redis.Exists(exchangeRatesCacheKey) {
+		t.Error("the cached rates were kept")
+	}
+	if err := rt.mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSetRateRejects(t *testing.T) {
+	tests := []struct {
+		name       string
+		currency   string
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "zero", currency: "USD", body: `{"rate": "0"}`, wantStatus: http.StatusBadRequest, wantCode: "VALIDATION_ERROR"},
+		{name: "negative", currency: "USD", body: `{"rate": "-15000"}`, wantStatus: http.StatusBadRequest, wantCode: "VALIDATION_ERROR"},
+		{name: "exponent", currency: "USD", body: `{"rate": "1.5e4"}`, wantStatus: http.StatusBadRequest, wantCode: "VALIDATION_ERROR"},
+		{name: "fraction", currency: "USD", body: `{"rate": "31/2"}`, wantStatus: http.StatusBadRequest, wantCode: "VALIDATION_ERROR"},
+		{name: "missing", currency: "USD", body: `{}`, wantStatus: http.StatusBadRequest, wantCode: "VALIDATION_ERROR"},
+		{name: "base currency", currency: "IDR", body: `{"rate": "1"}`, wantStatus: http.StatusBadRequest, wantCode: "BAD_REQUEST"},
+		{name: "unknown currency", currency: "XYZ", body: `{"rate": "1"}`, wantStatus: http.StatusBadRequest, wantCode: "UNSUPPORTED_CURRENCY"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := newRateTest(t)
+			rec := rt.do(http.MethodPut, "/admin/exchange-rates/"+tt.currency, tt.body)
+			if rec.Code != tt.wantStatus || !strings.Contains(rec.Body.String(), `"code":"`+tt.wantCode+`"`) {
+				t.Errorf("status %d, want %d with %s: %s", rec.Code, tt.wantStatus, tt.wantCode, rec.Body)
+			}
+			if err := rt.mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestDeleteRate(t *testing.T) {
+	rt := newRateTest(t)
+	rt.redis.Set(exchangeRatesCacheKey, `{"JPY":"103.25"}`)
+
+	rt.mock.ExpectExec("DELETE FROM exchange_rates WHERE currency = \\$1").
+		WithArgs("JPY").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	if rec := rt.do(http.MethodDelete, "/admin/exchange-rates/JPY", ""); rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if rt.redis.Exists(exchangeRatesCacheKey) {
+		t.Error("the cached rates were kept")
+	}
+
+	rt.mock.ExpectExec("DELETE FROM exchange_rates").
+		WithArgs("EUR").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	if rec := rt.do(http.MethodDelete, "/admin/exchange-rates/EUR", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("without a rate: status %d, want 404", rec.Code)
+	}
+	if err := rt.mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLocalize(t *testing.T) {
+	rt := newRateTest(t)
+	rt.redis.Set(exchangeRatesCacheKey, `{"USD":"15500.5","JPY":"103.25"}`)
+	handler := NewProductHandler(nil, nil, rt.handler, nil)
+
+	tests := []struct {
+		currency string
+		want     money.Money
+	}{
+		// Rp149,000.00 at Rp15,500.50 is $9.6126...
+		{currency: "USD", want: money.New(961, "USD")},
+		// JPY has no minor unit: Rp149,000.00 at Rp103.25 is ¥1443.09...
+		{currency: "JPY", want: money.New(1443, "JPY")},
+		{currency: "IDR", want: money.New(14900000, "IDR")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.currency, func(t *testing.T) {
+			price := money.New(14900000, "IDR")
+			products := []Product{{
+				Price:    price,
+				Variants: []Variant{{Price: price}},
+			}}
+			if err := handler.localize(t.Context(), tt.currency, products); err != nil {
+				t.Fatal(err)
+			}
+
+			p := products[0]
+			if p.Price != tt.want || p.Variants[0].Price != tt.want {
+				t.Errorf("priced at %v and %v, want %v", p.Price, p.Variants[0].Price, tt.want)
+			}
+			// Converted prices keep the catalog price alongside
+			converted := tt.currency != "IDR"
+			if (p.BasePrice != nil) != converted || (converted && *p.BasePrice != price) {
+				t.Errorf("base price %v", p.BasePrice)
+			}
+		})
+	}
+
+	// A supported currency without a rate cannot be shown
+	products := []Product{{Price: money.New(14900000, "IDR")}}
+	if err := handler.localize(t.Context(), "EUR", products); err == nil {
+		t.Error("priced in EUR without a rate")
+	}
+}
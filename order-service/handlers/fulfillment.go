@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"order-service/apperror"
+	"order-service/logger"
+	"order-service/rabbitmq"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fulfillmentTransitions lists the states an order may move to from each
+// state once it is confirmed. Packing may be skipped.
+var fulfillmentTransitions = map[string][]string{
+	"CONFIRMED": {"PACKED", "SHIPPED"},
+	"PACKED":    {"SHIPPED"},
+	"SHIPPED":   {"DELIVERED"},
+}
+
+type ShipOrderRequest struct {
+	Carrier        string `json:"carrier" binding:"required,max=50"`
+	TrackingNumber string `json:"tracking_number" binding:"required,max=100"`
+}
+
+// OrderShipmentMessage asks the notification worker to tell the customer
+// their order has shipped or been delivered
+type OrderShipmentMessage struct {
+	OrderID        int       `json:"order_id"`
+	UserID         int       `json:"user_id"`
+	UserEmail      string    `json:"user_email"`
+	Status         string    `json:"status"`
+	Carrier        string    `json:"carrier"`
+	TrackingNumber string    `json:"tracking_number"`
+	Timestamp      time.Time `json:"timestamp"`
+}
+
+// PackOrder marks a confirmed order as packed
+func (h *OrderHandler) PackOrder(c *gin.Context) {
+	h.advanceFulfillment(c, "PACKED", "packed_at = CURRENT_TIMESTAMP")
+}
+
+// ShipOrder marks an order as handed to the carrier and records its tracking number
+func (h *OrderHandler) ShipOrder(c *gin.Context) {
+	var req ShipOrderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperror.Validation(err))
+		return
+	}
+
+	h.advanceFulfillment(c, "SHIPPED", "shipped_at = CURRENT_TIMESTAMP, carrier = $3, tracking_number = $4",
+		req.Carrier, req.TrackingNumber)
+}
+
+// DeliverOrder marks a shipped order as delivered
+func (h *OrderHandler) DeliverOrder(c *gin.Context) {
+	h.advanceFulfillment(c, "DELIVERED", "delivered_at = CURRENT_TIMESTAMP")
+}
+
+// advanceFulfillment moves the order in the :id param to status, applying
+// assignments ($1 is the order ID, $2 the status, args follow), and notifies
+// the customer of shipment and delivery
+func (h *OrderHandler) advanceFulfillment(c *gin.Context, status, assignments string, args ...interface{}) {
+	ctx := c.Request.Context()
+
+	orderID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(apperror.Wrap(err, apperror.CodeBadRequest, "Invalid order ID"))
+		return
+	}
+
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to start transaction"))
+		return
+	}
+	defer tx.Rollback()
+
+	var current string
+	err = tx.QueryRowContext(ctx, "SELECT status FROM orders WHERE id = $1 FOR UPDATE", orderID).Scan(&current)
+	if err == sql.ErrNoRows {
+		c.Error(apperror.New(apperror.CodeOrderNotFound, "Order not found"))
+		return
+	}
+
+	if err != nil {
+		c.Error(apperror.Internal(err, "Database error"))
+		return
+	}
+
+	if !slices.Contains(fulfillmentTransitions[current], status) {
+		c.Error(apperror.New(apperror.CodeInvalidTransition, "Order is "+current+" and cannot be marked as "+status))
+		return
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE orders SET status = $2, "+assignments+" WHERE id = $1",
+		append([]interface{}{orderID, status}, args...)...,
+	)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to update order"))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.Error(apperror.Internal(err, "Failed to commit transaction"))
+		return
+	}
+
+	logger.FromContext(ctx).Info("order fulfillment updated", "order_id", orderID, "from", current, "to", status, "by", c.GetInt("user_id"))
+
+	order, err := h.loadOrder(ctx, orderID)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Database error"))
+		return
+	}
+
+	if status == "SHIPPED" || status == "DELIVERED" {
+		h.notifyShipment(c, order)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Order marked as " + status,
+		"data":    order,
+	})
+}
+
+// notifyShipment queues the shipment email. The status change is already
+// committed, so failures are logged rather than returned.
+func (h *OrderHandler) notifyShipment(c *gin.Context, order *Order) {
+	ctx := c.Request.Context()
+	log := logger.FromContext(ctx).With("order_id", order.ID, "user_id", order.UserID)
+
+	var email string
+	err := h.db.QueryRowContext(ctx,
+		"SELECT email FROM users WHERE id = $1 AND deleted_at IS NULL",
+		order.UserID,
+	).Scan(&email)
+	if err == sql.ErrNoRows {
+		return
+	}
+
+	if err != nil {
+		log.Error("failed to get user email for shipment notification", "error", err)
+		return
+	}
+
+	message := OrderShipmentMessage{
+		OrderID:   order.ID,
+		UserID:    order.UserID,
+		UserEmail: email,
+		Status:    order.Status,
+		Timestamp: time.Now(),
+	}
+	if order.Tracking != nil {
+		message.Carrier = order.Tracking.Carrier
+		message.TrackingNumber = order.Tracking.TrackingNumber
+	}
+
+	if err := h.rmq.Publish(ctx, rabbitmq.QueueOrderShipment, message); err != nil {
+		log.Error("failed to publish order_shipment message", "error", err)
+	}
+}
@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"order-service/middleware"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+var orderRowColumns = []string{"id", "user_id", "status", "total_amount", "currency", "address_id",
+	"shipping_recipient_name", "shipping_phone", "shipping_line1", "shipping_line2",
+	"shipping_city", "shipping_region", "shipping_postal_code", "shipping_country",
+	"carrier", "tracking_number", "packed_at", "shipped_at", "delivered_at",
+	"base_currency", "exchange_rate", "subtotal_amount", "discount_amount", "tax_amount", "promotion_code",
+	"invoice_number", "created_at", "updated_at"}
+
+// fulfillmentTest serves the fulfillment routes as main.go does, to principal
+type fulfillmentTest struct {
+	router *gin.Engine
+	mock   sqlmock.Sqlmock
+}
+
+func newFulfillmentTest(t *testing.T, principal *middleware.Principal) *fulfillmentTest {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	handler := NewOrderHandler(db, nil, false)
+	router := gin.New()
+	router.Use(middleware.ErrorHandler(), func(c *gin.Context) {
+		c.Set("principal", principal)
+		c.Set("user_id", principal.UserID)
+	})
+	fulfill := middleware.RequireAdmin(db, middleware.ScopeOrdersFulfill)
+	router.POST("/admin/orders/:id/pack", fulfill, handler.PackOrder)
+	router.POST("/admin/orders/:id/ship", fulfill, handler.ShipOrder)
+	router.POST("/admin/orders/:id/deliver", fulfill, handler.DeliverOrder)
+
+	return &fulfillmentTest{router: router, mock: mock}
+}
+
+// fulfillmentKey is a warehouse integration's API key
+var fulfillmentKey = &middleware.Principal{Type: middleware.PrincipalAPIKey, UserID: 1, APIKeyID: 3, Scopes: []string{middleware.ScopeOrdersFulfill}}
+
+func (ft *fulfillmentTest) do(t *testing.T, action, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/admin/orders/42/"+action, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	ft.router.ServeHTTP(rec, req)
+	return rec
+}
+
+func (ft *fulfillmentTest) expectStatus(status string) {
+	ft.mock.ExpectBegin()
+	ft.mock.ExpectQuery("SELECT status FROM orders WHERE id = \\$1 FOR UPDATE").
+		WithArgs(42).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(status))
+}
+
+// expectReload returns order 42 as stored after the update, to a customer
+// who has since deleted their account so no shipment email is queued
+func (ft *fulfillmentTest) expectReload(status, carrier, trackingNumber string, packedAt, shippedAt, deliveredAt interface{}) {
+	now := time.Now()
+	ft.mock.ExpectQuery("FROM orders WHERE id = \\$1").
+		WithArgs(42).
+		WillReturnRows(sqlmock.NewRows(orderRowColumns).AddRow(
+			42, 7, status, 15000000, "IDR", nil,
+			nil, "", "", "", "", "", "", "",
+			carrier, trackingNumber, packedAt, shippedAt, deliveredAt,
+			nil, nil, 15000000, 0, 0, nil,
+			"INV-2026-000042", now, now))
+	ft.mock.ExpectQuery("FROM order_items oi").
+		WithArgs(42).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "order_id", "product_id", "variant_id", "sku", "quantity", "price",
+			"discount_amount", "tax_name", "tax_rate", "tax_inclusive", "tax_amount", "created_at"}))
+	if status != "PACKED" {
+		ft.mock.ExpectQuery("SELECT email FROM users WHERE id = \\$1 AND deleted_at IS NULL").
+			WithArgs(7).
+			WillReturnError(sql.ErrNoRows)
+	}
+}
+
+func TestFulfillmentTransitions(t *testing.T) {
+	tests := []struct {
+		from    string
+		action  string
+		allowed bool
+	}{
+		{from: "CONFIRMED", action: "pack", allowed: true},
+		{from: "CONFIRMED", action: "ship", allowed: true},
+		{from: "PACKED", action: "ship", allowed: true},
+		{from: "SHIPPED", action: "deliver", allowed: true},
+		{from: "PENDING", action: "pack"},
+		{from: "PENDING", action: "ship"},
+		{from: "CONFIRMED", action: "deliver"},
+		{from: "PACKED", action: "pack"},
+		{from: "PACKED", action: "deliver"},
+		{from: "SHIPPED", action: "pack"},
+		{from: "SHIPPED", action: "ship"},
+		{from: "DELIVERED", action: "deliver"},
+		{from: "CANCELLED", action: "ship"},
+		{from: "FAILED", action: "pack"},
+	}
+
+	status := map[string]string{"pack": "PACKED", "ship": "SHIPPED", "deliver": "DELIVERED"}
+	for _, tt := range tests {
+		t.Run(tt.from+" "+tt.action, func(t *testing.T) {
+			ft := newFulfillmentTest(t, fulfillmentKey)
+			ft.expectStatus(tt.from)
+			to := status[tt.action]
+			if tt.allowed {
+				ft.mock.ExpectExec("UPDATE orders SET status = \\$2, ").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				ft.mock.ExpectCommit()
+				ft.expectReload(to, "JNE", "JNE123", time.Now(), nil, nil)
+			} else {
+				ft.mock.ExpectRollback()
+			}
+
+			rec := ft.do(t, tt.action, `{"carrier": "JNE", "tracking_number": "JNE123"}`)
+			if tt.allowed && rec.Code != http.StatusOK {
+				t.Errorf("status %d, want 200: %s", rec.Code, rec.Body)
+			}
+			if !tt.allowed && (rec.Code != http.StatusConflict || !strings.Contains(rec.Body.String(), "Order is "+tt.from+" and cannot be marked as "+to)) {
+				t.Errorf("status %d, want 409: %s", rec.Code, rec.Body)
+			}
+			if err := ft.mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestShipOrderTracking(t *testing.T) {
+	ft := newFulfillmentTest(t, fulfillmentKey)
+	ft.expectStatus("PACKED")
+	ft.mock.ExpectExec("UPDATE orders SET status = \\$2, shipped_at = CURRENT_TIMESTAMP, carrier = \\$3, tracking_number = \\$4 WHERE id = \\$1").
+		WithArgs(42, "SHIPPED", "JNE", "JNE123").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	ft.mock.ExpectCommit()
+	packedAt, shippedAt := time.Now().Add(-time.Hour), time.Now()
+	ft.expectReload("SHIPPED", "JNE", "JNE123", packedAt, shippedAt, nil)
+
+	rec := ft.do(t, "ship", `{"carrier": "JNE", "tracking_number": "JNE123"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+
+	var body struct {
+		Data Order `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	tracking := body.Data.Tracking
+	if body.Data.Status != "SHIPPED" || tracking == nil || tracking.Carrier != "JNE" || tracking.TrackingNumber != "JNE123" ||
+		tracking.PackedAt == nil || tracking.ShippedAt == nil || tracking.DeliveredAt != nil {
+		t.Errorf("order %+v with tracking %+v", body.Data, tracking)
+	}
+	if err := ft.mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestShipOrderRequiresTracking(t *testing.T) {
+	for _, body := range []string{`{}`, `{"carrier": "JNE"}`, `{"tracking_number": "JNE123"}`} {
+		ft := newFulfillmentTest(t, fulfillmentKey)
+		// Nothing is read or written without tracking details
+		rec := ft.do(t, "ship", body)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400: %s", body, rec.Code, rec.Body)
+		}
+		if err := ft.mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestFulfillmentScope(t *testing.T) {
+	tests := []struct {
+		name       string
+		principal  *middleware.Principal
+		role       string
+		wantStatus int
+	}{
+		// Let through to the order, which does not exist
+		{name: "key with orders:fulfill", principal: fulfillmentKey, wantStatus: http.StatusNotFound},
+		{name: "admin user", principal: &middleware.Principal{Type: middleware.PrincipalUser, UserID: 1}, role: "admin", wantStatus: http.StatusNotFound},
+		{
+			name:       "key without orders:fulfill",
+			principal:  &middleware.Principal{Type: middleware.PrincipalAPIKey, UserID: 1, Scopes: []string{middleware.ScopeOrdersRead, middleware.ScopeOrdersWrite}},
+			wantStatus: http.StatusForbidden,
+		},
+		{name: "customer", principal: &middleware.Principal{Type: middleware.PrincipalUser, UserID: 7}, role: "customer", wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		for _, action := range []string{"pack", "ship", "deliver"} {
+			t.Run(tt.name+" "+action, func(t *testing.T) {
+				ft := newFulfillmentTest(t, tt.principal)
+				if tt.role != "" {
+					ft.mock.ExpectQuery("SELECT role FROM users WHERE id = \\$1").
+						WithArgs(tt.principal.UserID).
+						WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow(tt.role))
+				}
+				if tt.wantStatus == http.StatusNotFound {
+					ft.mock.ExpectBegin()
+					ft.mock.ExpectQuery("SELECT status FROM orders").
+						WithArgs(42).
+						WillReturnError(sql.ErrNoRows)
+					ft.mock.ExpectRollback()
+				}
+
+				rec := ft.do(t, action, `{"carrier": "JNE", "tracking_number": "JNE123"}`)
+				if rec.Code != tt.wantStatus {
+					t.Errorf("status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+				}
+				if err := ft.mock.ExpectationsWereMet(); err != nil {
+					t.Error(err)
+				}
+			})
+		}
+	}
+}
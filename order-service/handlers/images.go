@@ -0,0 +1,465 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"order-service/apperror"
+	"order-service/logger"
+	"order-service/media"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// ProductImage is one of a product's images, in display order
+type ProductImage struct {
+	ID           int       `json:"id"`
+	Position     int       `json:"position"`
+	URL          string    `json:"url"`
+	ThumbnailURL string    `json:"thumbnail_url"`
+	ContentType  string    `json:"content_type"`
+	Width        int       `json:"width"`
+	Height       int       `json:"height"`
+	Size         int       `json:"size"`
+	Alt          string    `json:"alt"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type ReorderImagesRequest struct {
+	ImageIDs []int `json:"image_ids" binding:"required"`
+}
+
+const imageColumns = "id, product_id, position, storage_key, thumbnail_key, content_type, width, height, size_bytes, alt_text, created_at"
+
+// scanImage reads an image row, returning the product it belongs to
+func (h *ProductHandler) scanImage(row rowScanner) (int, ProductImage, error) {
+	var img ProductImage
+	var productID int
+	var key, thumbnailKey string
+	err := row.Scan(&img.ID, &productID, &img.Position, &key, &thumbnailKey, &img.ContentType,
+		&img.Width, &img.Height, &img.Size, &img.Alt, &img.CreatedAt)
+	img.URL, img.ThumbnailURL = h.media.URL(key), h.media.URL(thumbnailKey)
+	return productID, img, err
+}
+
+// loadImages returns the images of each of the products, in order
+func (h *ProductHandler) loadImages(ctx context.Context, q queryer, productIDs []int) (map[int][]ProductImage, error) {
+	rows, err := q.QueryContext(ctx,
+		"SELECT "+imageColumns+" FROM product_images WHERE product_id = ANY($1) ORDER BY product_id, position",
+		pq.Array(productIDs),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	images := map[int][]ProductImage{}
+	for rows.Next() {
+		productID, img, err := h.scanImage(rows)
+		if err != nil {
+			return nil, err
+		}
+		images[productID] = append(images[productID], img)
+	}
+	return images, rows.Err()
+}
+
+// attachImages fills in the images of each product
+func (h *ProductHandler) attachImages(ctx context.Context, products []Product) error {
+	ids := make([]int, len(products))
+	for i, p := range products {
+		ids[i] = p.ID
+	}
+
+	images, err := h.loadImages(ctx, h.db, ids)
+	if err != nil {
+		return err
+	}
+
+	for i := range products {
+		products[i].Images = images[products[i].ID]
+		if products[i].Images == nil {
+			products[i].Images = []ProductImage{}
+		}
+	}
+	return nil
+}
+
+// UploadImage adds an image, sent as the multipart field "image", to the end
+// of a product's images. The type is checked from the content, and the image
+// and its thumbnail are stored under keys derived from that content, so
+// their URLs can be cached for good.
+func (h *ProductHandler) UploadImage(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(apperror.Wrap(err, apperror.CodeBadRequest, "Invalid product ID"))
+		return
+	}
+
+	var exists bool
+	if err := h.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM products WHERE id = $1)", productID).Scan(&exists); err != nil {
+		c.Error(apperror.Internal(err, "Database error"))
+		return
+	}
+	if !exists {
+		c.Error(apperror.New(apperror.CodeProductNotFound, "Product not found"))
+		return
+	}
+
+	// Leave room for the multipart framing and the other fields
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, media.MaxImageSize+64<<10)
+
+	file, header, err := c.Request.FormFile("image")
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) || (err == nil && header.Size > media.MaxImageSize) {
+		c.Error(apperror.New(apperror.CodeImageTooLarge, "Image must be at most "+strconv.Itoa(media.MaxImageSize>>20)+" MB"))
+		return
+	}
+	if err != nil {
+		c.Error(apperror.InvalidField("image", "required", "must be an uploaded file"))
+		return
+	}
+	defer file.Close()
+
+	alt := strings.TrimSpace(c.Request.FormValue("alt"))
+	if len(alt) > 255 {
+		c.Error(apperror.InvalidField("alt", "max", "must be at most 255 characters"))
+		return
+	}
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to read image"))
+		return
+	}
+
+	img, err := media.ProcessImage(data)
+	if err != nil {
+		c.Error(apperror.Wrap(err, apperror.CodeInvalidImage, err.Error()))
+		return
+	}
+
+	sum := sha256.Sum256(data)
+	name := "products/" + strconv.Itoa(productID) + "/" + hex.EncodeToString(sum[:16])
+	key, thumbnailKey := name+img.Ext, name+"-thumb"+img.ThumbnailExt
+
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to start transaction"))
+		return
+	}
+	defer tx.Rollback()
+
+	// The files are written under the key lock, held until the row using
+	// them is saved, so DeleteImage cannot remove them in between
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext($1))", key); err != nil {
+		c.Error(apperror.Internal(err, "Database error"))
+		return
+	}
+
+	if err := h.media.Put(ctx, key, img.ContentType, data); err != nil {
+		c.Error(apperror.Internal(err, "Failed to store image"))
+		return
+	}
+	if err := h.media.Put(ctx, thumbnailKey, img.ThumbnailContentType, img.Thumbnail); err != nil {
+		c.Error(apperror.Internal(err, "Failed to store thumbnail"))
+		return
+	}
+
+	// The product row is locked so concurrent uploads take distinct positions
+	err = tx.QueryRowContext(ctx, "SELECT id FROM products WHERE id = $1 FOR UPDATE", productID).Scan(&productID)
+	if err == sql.ErrNoRows {
+		c.Error(apperror.New(apperror.CodeProductNotFound, "Product not found"))
+		return
+	}
+
+	if err != nil {
+		c.Error(apperror.Internal(err, "Database error"))
+		return
+	}
+
+	_, image, err := h.scanImage(tx.QueryRowContext(ctx,
+		`INSERT INTO product_images (product_id, position, storage_key, thumbnail_key, content_type, width, height, size_bytes, alt_text)
+		 SELECT $1, COALESCE(MAX(position) + 1, 0), $2, $3, $4, $5, $6, $7, $8 FROM product_images WHERE product_id = $1
+		 RETURNING `+imageColumns,
+		productID, key, thumbnailKey, img.ContentType, img.Width, img.Height, len(data), alt,
+	))
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to save image"))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.Error(apperror.Internal(err, "Failed to commit transaction"))
+		return
+	}
+
+	h.invalidateProduct(ctx, productID)
+
+	logger.FromContext(ctx).Info("product image uploaded", "product_id", productID, "image_id", image.ID, "key", key, "by", c.GetInt("user_id"))
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"message": "Image uploaded successfully",
+		"data":    image,
+	})
+}
+
+// ReorderImages sets the order of a product's images, which must all be
+// listed exactly once
+func (h *ProductHandler) ReorderImages(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(apperror.Wrap(err, apperror.CodeBadRequest, "Invalid product ID"))
+		return
+	}
+
+	var req ReorderImagesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperror.Validation(err))
+		return
+	}
+
+	tx, err := h.db.BeginTx(ctx, nil)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to start transaction"))
+		return
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, "SELECT id FROM products WHERE id = $1 FOR UPDATE", productID).Scan(&productID)
+	if err == sql.ErrNoRows {
+		c.Error(apperror.New(apperror.CodeProductNotFound, "Product not found"))
+		return
+	}
+
+	if err != nil {
+		c.Error(apperror.Internal(err, "Database error"))
+		return
+	}
+
+	var matches bool
+	err = tx.QueryRowContext(ctx,
+		`SELECT COALESCE(ARRAY_AGG(id ORDER BY id), '{}') = (SELECT COALESCE(ARRAY_AGG(x ORDER BY x), '{}') FROM UNNEST($2::INT[]) x)
+		 FROM product_images WHERE product_id = $1`,
+		productID, pq.Array(req.ImageIDs),
+	).Scan(&matches)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Database error"))
+		return
+	}
+	if !matches {
+		c.Error(apperror.InvalidField("image_ids", "permutation", "must list each of the product's images exactly once"))
+		return
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE product_images SET position = ARRAY_POSITION($2::INT[], id) - 1 WHERE product_id = $1",
+		productID, pq.Array(req.ImageIDs),
+	)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to reorder images"))
+		return
+	}
+
+	images, err := h.loadImages(ctx, tx, []int{productID})
+	if err != nil {
+		c.Error(apperror.Internal(err, "Database error"))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.Error(apperror.Internal(err, "Failed to commit transaction"))
+		return
+	}
+
+	h.invalidateProduct(ctx, productID)
+
+	logger.FromContext(ctx).Info("product images reordered", "product_id", productID, "by", c.GetInt("user_id"))
+
+	result := images[productID]
+	if result == nil {
+		result = []ProductImage{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Images reordered successfully",
+		"data":    result,
+	})
+}
+
+// DeleteImage removes one of a product's images, closing the gap in the
+// order, and deletes its files unless another image shares them
+func (h *ProductHandler) DeleteImage(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(apperror.Wrap(err, apperror.CodeBadRequest, "Invalid product ID"))
+		return
+	}
+
+	imageID, err := strconv.Atoi(c.Param("imageId"))
+	if err != nil {
+		c.Error(apperror.Wrap(err, apperror.CodeBadRequest, "Invalid image ID"))
+		return
+	}
+
+	// Files are shared by the images with the same key. Deleting them and
+	// uploading them again take turns under a lock on the key, taken before
+	// the product lock as UploadImage does.
+	var key, thumbnailKey string
+	err = h.db.QueryRowContext(ctx,
+		"SELECT storage_key FROM product_images WHERE id = $1 AND product_id = $2",
+		imageID, productID,
+	).Scan(&key)
+	if err == sql.ErrNoRows {
+		c.Error(apperror.New(apperror.CodeImageNotFound, "Image not found"))
+		return
+	}
+
+	if err != nil {
+		c.Error(apperror.Internal(err, "Database error"))
+		return
+	}
+
+	// The lock belongs to a session, so it outlasts the transaction and is
+	// still held when the files are deleted after the commit
+	conn, err := h.db.Conn(ctx)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Database error"))
+		return
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock(hashtext($1))", key); err != nil {
+		c.Error(apperror.Internal(err, "Database error"))
+		return
+	}
+	// Released even when the request is cancelled, as the connection goes
+	// back to the pool
+	defer conn.ExecContext(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock(hashtext($1))", key)
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to start transaction"))
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "SELECT 1 FROM products WHERE id = $1 FOR UPDATE", productID); err != nil {
+		c.Error(apperror.Internal(err, "Database error"))
+		return
+	}
+
+	var position int
+	err = tx.QueryRowContext(ctx,
+		"DELETE FROM product_images WHERE id = $1 AND product_id = $2 RETURNING position, storage_key, thumbnail_key",
+		imageID, productID,
+	).Scan(&position, &key, &thumbnailKey)
+	if err == sql.ErrNoRows {
+		c.Error(apperror.New(apperror.CodeImageNotFound, "Image not found"))
+		return
+	}
+
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to delete image"))
+		return
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE product_images SET position = position - 1 WHERE product_id = $1 AND position > $2", productID, position)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to delete image"))
+		return
+	}
+
+	// The same upload to the same product gets the same keys
+	var shared bool
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM product_images WHERE storage_key = $1)", key).Scan(&shared)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Database error"))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.Error(apperror.Internal(err, "Failed to commit transaction"))
+		return
+	}
+
+	h.invalidateProduct(ctx, productID)
+
+	log := logger.FromContext(ctx)
+	if !shared {
+		// Leftover files are harmless, so failing to delete them is not an error
+		for _, k := range []string{key, thumbnailKey} {
+			if err := h.media.Delete(ctx, k); err != nil {
+				log.Warn("failed to delete image file", "key", k, "error", err)
+			}
+		}
+	}
+
+	log.Info("product image deleted", "product_id", productID, "image_id", imageID, "by", c.GetInt("user_id"))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Image deleted",
+	})
+}
+
+// ServeMedia streams a stored file. Keys never change content, so responses
+// may be cached indefinitely and revalidate on the key alone.
+func (h *ProductHandler) ServeMedia(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	if !media.ValidKey(key) {
+		c.Error(apperror.New(apperror.CodeImageNotFound, "Image not found"))
+		return
+	}
+
+	etag := `"` + key + `"`
+	c.Header("Cache-Control", media.CacheControl)
+	c.Header("ETag", etag)
+	if c.GetHeader("If-None-Match") == etag {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	body, err := h.media.Open(ctx, key)
+	if errors.Is(err, media.ErrNotFound) {
+		c.Header("Cache-Control", "no-store")
+		c.Error(apperror.New(apperror.CodeImageNotFound, "Image not found"))
+		return
+	}
+
+	if err != nil {
+		c.Header("Cache-Control", "no-store")
+		c.Error(apperror.Internal(err, "Failed to read image"))
+		return
+	}
+	defer body.Close()
+
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	c.DataFromReader(http.StatusOK, -1, contentType, body, map[string]string{
+		"X-Content-Type-Options": "nosniff",
+	})
+}
@@ -0,0 +1,287 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"order-service/media"
+	"order-service/middleware"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+type uploadTest struct {
+	router *gin.Engine
+	mock   sqlmock.Sqlmock
+	dir    string
+}
+
+func newUploadTest(t *testing.T) *uploadTest {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	// Each test uploads once, to product 7
+	mock.ExpectQuery("SELECT EXISTS").
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	dir := t.TempDir()
+	storage, err := media.NewLocal(dir, "http://localhost/media/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	handler := NewProductHandler(db, client, nil, storage)
+	router := gin.New()
+	router.Use(middleware.ErrorHandler())
+	router.POST("/admin/products/:id/images", handler.UploadImage)
+
+	return &uploadTest{router: router, mock: mock, dir: dir}
+}
+
+// upload sends data as the multipart field "image", claiming contentType
+func (u *uploadTest) upload(t *testing.T, contentType string, data []byte) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreatePart(map[string][]string{
+		"Content-Disposition": {`form-data; name="image"; filename="upload"`},
+		"Content-Type":        {contentType},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	form.WriteField("alt", "Front view")
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/admin/products/7/images", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	rec := httptest.NewRecorder()
+	u.router.ServeHTTP(rec, req)
+	return rec
+}
+
+// storedFiles counts the objects written to storage
+func (u *uploadTest) storedFiles(t *testing.T) int {
+	t.Helper()
+	var count int
+	err := filepath.WalkDir(u.dir, func(path string, entry os.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() {
+			count++
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 640, 480))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestUploadImageRejects(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		data        []byte
+		wantStatus  int
+		wantCode    string
+	}{
+		{
+			name:        "text",
+			contentType: "text/plain",
+			data:        []byte("not an image"),
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantCode:    "INVALID_IMAGE",
+		},
+		{
+			// The claimed type is ignored in favour of the content
+			name:        "text claiming to be png",
+			contentType: "image/png",
+			data:        []byte("not an image"),
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantCode:    "INVALID_IMAGE",
+		},
+		{
+			name:        "svg",
+			contentType: "image/svg+xml",
+			data:        []byte(`<svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`),
+			wantStatus:  http.StatusUnprocessableEntity,
+			wantCode:    "INVALID_IMAGE",
+		},
+		{
+			name:        "too large",
+			contentType: "image/png",
+			data:        append(testPNG(t), make([]byte, media.MaxImageSize)...),
+			wantStatus:  http.StatusRequestEntityTooLarge,
+			wantCode:    "IMAGE_TOO_LARGE",
+		},
+		{
+			name:        "far too large",
+			contentType: "image/png",
+			data:        make([]byte, 2*media.MaxImageSize),
+			wantStatus:  http.StatusRequestEntityTooLarge,
+			wantCode:    "IMAGE_TOO_LARGE",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u := newUploadTest(t)
+			rec := u.upload(t, tt.contentType, tt.data)
+
+			var body struct {
+				Code string `json:"code"`
+			}
+			json.Unmarshal(rec.Body.Bytes(), &body)
+			if rec.Code != tt.wantStatus || body.Code != tt.wantCode {
+				t.Errorf("status %d %s, want %d %s: %s", rec.Code, body.Code, tt.wantStatus, tt.wantCode, rec.Body)
+			}
+			if n := u.storedFiles(t); n != 0 {
+				t.Errorf("%d files stored for a rejected upload", n)
+			}
+			if err := u.mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestUploadImage(t *testing.T) {
+	u := newUploadTest(t)
+
+	u.mock.ExpectBegin()
+	// Taken before the files are written
+	u.mock.ExpectExec("SELECT pg_advisory_xact_lock\\(hashtext\\(\\$1\\)\\)").
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	u.mock.ExpectQuery("SELECT id FROM products WHERE id = \\$1 FOR UPDATE").
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	u.mock.ExpectQuery("INSERT INTO product_images").
+		WithArgs(7, sqlmock.AnyArg(), sqlmock.AnyArg(), "image/png", 640, 480, sqlmock.AnyArg(), "Front view").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "product_id", "position", "storage_key", "thumbnail_key", "content_type", "width", "height", "size_bytes", "alt_text", "created_at"}).
+			AddRow(1, 7, 0, "products/7/a.png", "products/7/a-thumb.png", "image/png", 640, 480, 100, "Front view", time.Now()))
+	u.mock.ExpectCommit()
+
+	rec := u.upload(t, "image/png", testPNG(t))
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	// The image and its thumbnail
+	if n := u.storedFiles(t); n != 2 {
+		t.Errorf("%d files stored, want 2", n)
+	}
+	if err := u.mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDeleteImage(t *testing.T) {
+	const key, thumbnailKey = "products/7/a.png", "products/7/a-thumb.png"
+
+	tests := []struct {
+		name string
+		// shared is whether another image still uses the files
+		shared bool
+	}{
+		{name: "last use"},
+		{name: "shared", shared: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			storage, err := media.NewLocal(t.TempDir(), "http://localhost/media/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, k := range []string{key, thumbnailKey} {
+				if err := storage.Put(t.Context(), k, "image/png", []byte("data")); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			mock.ExpectQuery("SELECT storage_key FROM product_images WHERE id = \\$1 AND product_id = \\$2").
+				WithArgs(3, 7).
+				WillReturnRows(sqlmock.NewRows([]string{"storage_key"}).AddRow(key))
+			mock.ExpectExec("SELECT pg_advisory_lock\\(hashtext\\(\\$1\\)\\)").
+				WithArgs(key).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectBegin()
+			mock.ExpectExec("SELECT 1 FROM products WHERE id = \\$1 FOR UPDATE").
+				WithArgs(7).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectQuery("DELETE FROM product_images").
+				WithArgs(3, 7).
+				WillReturnRows(sqlmock.NewRows([]string{"position", "storage_key", "thumbnail_key"}).AddRow(0, key, thumbnailKey))
+			mock.ExpectExec("UPDATE product_images SET position = position - 1").
+				WithArgs(7, 0).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectQuery("SELECT EXISTS\\(SELECT 1 FROM product_images WHERE storage_key = \\$1\\)").
+				WithArgs(key).
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(tt.shared))
+			mock.ExpectCommit()
+			// Only released once the files are gone, so an upload of the same
+			// file waits until then to write them again
+			mock.ExpectExec("SELECT pg_advisory_unlock\\(hashtext\\(\\$1\\)\\)").
+				WithArgs(key).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			client := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+			defer client.Close()
+			router := gin.New()
+			router.Use(middleware.ErrorHandler())
+			router.DELETE("/admin/products/:id/images/:imageId", NewProductHandler(db, client, nil, storage).DeleteImage)
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/admin/products/7/images/3", nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+
+			for _, k := range []string{key, thumbnailKey} {
+				_, err := storage.Open(t.Context(), k)
+				if kept := err == nil; kept != tt.shared {
+					t.Errorf("%s kept %v, want %v (error %v)", k, kept, tt.shared, err)
+				}
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -1,45 +1,114 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
+	"math/big"
 	"net/http"
+	"order-service/apperror"
+	"order-service/logger"
+	"order-service/money"
 	"order-service/rabbitmq"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
 )
 
 type OrderHandler struct {
 	db  *sql.DB
 	rmq *rabbitmq.RabbitMQ
+
+	// requireVerifiedEmail rejects orders from users who have not verified their email
+	requireVerifiedEmail bool
 }
 
 type Order struct {
 	ID          int         `json:"id"`
 	UserID      int         `json:"user_id"`
 	Status      string      `json:"status"`
-	TotalAmount float64     `json:"total_amount"`
+	TotalAmount money.Money `json:"total_amount"`
+	AddressID   *int        `json:"address_id"`
 	CreatedAt   time.Time   `json:"created_at"`
 	UpdatedAt   time.Time   `json:"updated_at"`
 	Items       []OrderItem `json:"items,omitempty"`
+
+	// Subtotal is the sum of the items before Discount; TotalAmount is what is paid
+	Subtotal *money.Money `json:"subtotal,omitempty"`
+	Discount *money.Money `json:"discount,omitempty"`
+	// Tax includes inclusive taxes, which are already part of Subtotal
+	Tax           *money.Money `json:"tax,omitempty"`
+	PromotionCode *string      `json:"promotion_code,omitempty"`
+	// InvoiceNumber is set once the order is confirmed; see GET /orders/:id/invoice
+	InvoiceNumber *string `json:"invoice_number,omitempty"`
+
+	ShippingAddress *ShippingAddress `json:"shipping_address,omitempty"`
+	Tracking        *Tracking        `json:"tracking,omitempty"`
+	// ExchangeRate is set when the order is paid in another currency than the catalog's
+	ExchangeRate *LockedRate `json:"exchange_rate,omitempty"`
+}
+
+// LockedRate is the exchange rate an order was placed at: one unit of
+// BaseCurrency cost Rate units of the order's currency
+type LockedRate struct {
+	BaseCurrency string `json:"base_currency"`
+	Rate         string `json:"rate"`
+}
+
+// ShippingAddress is copied from the address book when the order is placed,
+// so later edits or deletions of the address do not change where it ships
+type ShippingAddress struct {
+	RecipientName string `json:"recipient_name"`
+	Phone         string `json:"phone"`
+	Line1         string `json:"line1"`
+	Line2         string `json:"line2"`
+	City          string `json:"city"`
+	Region        string `json:"region"`
+	PostalCode    string `json:"postal_code"`
+	Country       string `json:"country"`
+}
+
+// Tracking is the fulfillment progress of an order after confirmation
+type Tracking struct {
+	Carrier        string     `json:"carrier,omitempty"`
+	TrackingNumber string     `json:"tracking_number,omitempty"`
+	PackedAt       *time.Time `json:"packed_at"`
+	ShippedAt      *time.Time `json:"shipped_at"`
+	DeliveredAt    *time.Time `json:"delivered_at"`
 }
 
 type OrderItem struct {
-	ID        int       `json:"id"`
-	OrderID   int       `json:"order_id"`
-	ProductID int       `json:"product_id"`
-	Quantity  int       `json:"quantity"`
-	Price     float64   `json:"price"`
-	CreatedAt time.Time `json:"created_at"`
+	ID        int         `json:"id"`
+	OrderID   int         `json:"order_id"`
+	ProductID int         `json:"product_id"`
+	VariantID *int        `json:"variant_id"`
+	SKU       string      `json:"sku,omitempty"`
+	Quantity  int         `json:"quantity"`
+	Price     money.Money `json:"price"`
+	// Discount is the item's share of the order discount
+	Discount  money.Money `json:"discount"`
+	Tax       *ItemTax    `json:"tax,omitempty"`
+	CreatedAt time.Time   `json:"created_at"`
 }
 
 type CreateOrderRequest struct {
-	Items []OrderItemRequest `json:"items" binding:"required,min=1"`
+	Items []OrderItemRequest `json:"items" binding:"required,min=1,dive"`
+	// AddressID picks a shipping address from the user's address book,
+	// defaulting to their default address
+	AddressID *int `json:"address_id"`
+	// Currency to pay in, defaulting to ?currency, X-Currency or the catalog currency
+	Currency string `json:"currency" binding:"omitempty,len=3"`
+	// PromotionCode is a coupon to apply to the order
+	PromotionCode string `json:"promotion_code" binding:"max=50"`
 }
 
 type OrderItemRequest struct {
-	ProductID int `json:"product_id" binding:"required"`
+	// VariantID is the variant ordered; a product with a single variant may
+	// be ordered by ProductID alone
+	VariantID int `json:"variant_id" binding:"required_without=ProductID"`
+	ProductID int `json:"product_id" binding:"required_without=VariantID"`
 	Quantity  int `json:"quantity" binding:"required,min=1"`
 }
 
@@ -47,113 +116,240 @@ type OrderPlacedMessage struct {
 	OrderID     int                `json:"order_id"`
 	UserID      int                `json:"user_id"`
 	Items       []OrderItemRequest `json:"items"`
-	TotalAmount float64            `json:"total_amount"`
+	TotalAmount money.Money        `json:"total_amount"`
 	Timestamp   time.Time          `json:"timestamp"`
 }
 
-func NewOrderHandler(db *sql.DB, rmq *rabbitmq.RabbitMQ) *OrderHandler {
+func NewOrderHandler(db *sql.DB, rmq *rabbitmq.RabbitMQ, requireVerifiedEmail bool) *OrderHandler {
 	return &OrderHandler{
-		db:  db,
-		rmq: rmq,
+		db:                   db,
+		rmq:                  rmq,
+		requireVerifiedEmail: requireVerifiedEmail,
 	}
 }
 
 // CreateOrder creates a new order (async processing with RabbitMQ)
 func (h *OrderHandler) CreateOrder(c *gin.Context) {
 	userID := c.GetInt("user_id")
+	ctx := c.Request.Context()
 
 	var req CreateOrderRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   err.Error(),
-		})
+		c.Error(apperror.Validation(err))
+		return
+	}
+
+	currency, err := requestedCurrency(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+	if req.Currency != "" {
+		currency = strings.ToUpper(req.Currency)
+		if !money.IsSupported(currency) {
+			c.Error(apperror.New(apperror.CodeUnsupportedCurrency, "Currency "+currency+" is not supported"))
+			return
+		}
+	}
+
+	if h.requireVerifiedEmail {
+		var verified bool
+		err := h.db.QueryRowContext(ctx,
+			"SELECT verified_at IS NOT NULL FROM users WHERE id = $1",
+			userID,
+		).Scan(&verified)
+		if err != nil && err != sql.ErrNoRows {
+			c.Error(apperror.Internal(err, "Database error"))
+			return
+		}
+		if !verified {
+			c.Error(apperror.New(apperror.CodeEmailNotVerified, "Please verify your email address before placing orders"))
+			return
+		}
+	}
+
+	addressID, shipping, err := h.resolveAddress(ctx, userID, req.AddressID)
+	if err != nil {
+		c.Error(err)
 		return
 	}
 
 	// Start transaction
-	tx, err := h.db.Begin()
+	tx, err := h.db.BeginTx(ctx, nil)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   "Failed to start transaction",
-		})
+		c.Error(apperror.Internal(err, "Failed to start transaction"))
 		return
 	}
 	defer tx.Rollback()
 
-	// Validate products exist and calculate total
-	totalAmount := 0.0
-	for _, item := range req.Items {
-		var price float64
-		var stock int
-		err := tx.QueryRow(
-			"SELECT price, stock FROM products WHERE id = $1",
-			item.ProductID,
-		).Scan(&price, &stock)
-
-		if err == sql.ErrNoRows {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"success": false,
-				"error":   "Product ID " + strconv.Itoa(item.ProductID) + " not found",
-			})
+	// Validate variants exist and read their catalog prices
+	lines := make([]orderLine, len(req.Items))
+	for i := range req.Items {
+		item := &req.Items[i]
+		if item.VariantID == 0 {
+			if item.VariantID, err = singleVariant(ctx, tx, item.ProductID); err != nil {
+				c.Error(err)
+				return
+			}
+		}
+
+		line := orderLine{VariantID: item.VariantID, Quantity: item.Quantity}
+		err := tx.QueryRowContext(ctx,
+			`SELECT p.id, `+categoryPathSQL+`, COALESCE(v.price, p.price), p.currency
+			 FROM product_variants v JOIN products p ON p.id = v.product_id WHERE v.id = $1`,
+			item.VariantID,
+		).Scan(&line.ProductID, pq.Array(&line.CategoryIDs), &line.Price.Amount, &line.Price.Currency)
+
+		if err == sql.ErrNoRows || (err == nil && item.ProductID != 0 && item.ProductID != line.ProductID) {
+			c.Error(apperror.New(apperror.CodeInvalidOrderItem, "Variant ID "+strconv.Itoa(item.VariantID)+" not found"))
 			return
 		}
 
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"success": false,
-				"error":   "Database error",
-			})
+			c.Error(apperror.Internal(err, "Database error"))
 			return
 		}
+		// The worker reserves stock by variant and reports by product
+		item.ProductID = line.ProductID
 
-		totalAmount += price * float64(item.Quantity)
+		if i > 0 && line.Price.Currency != lines[0].Price.Currency {
+			c.Error(apperror.New(apperror.CodeInvalidOrderItem, "All items in an order must be priced in the same currency"))
+			return
+		}
+		lines[i] = line
 	}
 
-	// Create order with PENDING status
+	// Lock the order to the requested currency at the current rate. Unit
+	// prices are converted before multiplying, so line totals stay exact.
+	baseCurrency := lines[0].Price.Currency
+	var rate *big.Rat
+	if currency != "" && currency != baseCurrency {
+		rates, err := queryRates(ctx, tx)
+		if err != nil {
+			c.Error(apperror.Internal(err, "Failed to load exchange rates"))
+			return
+		}
+
+		rate, err = rates.rate(baseCurrency, currency)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		for i := range lines {
+			if lines[i].Price, err = lines[i].Price.Convert(currency, rate); err != nil {
+				c.Error(apperror.Wrap(err, apperror.CodeInvalidOrderItem, "Order total is too large"))
+				return
+			}
+		}
+	}
+	currency = lines[0].Price.Currency
+
+	// Check the coupon while its row is locked, so usage limits hold
+	var promotion *appliedPromotion
+	if req.PromotionCode != "" {
+		promotion, err = applyPromotion(ctx, tx, userID, req.PromotionCode, lines, currency, baseCurrency, rate)
+		if err != nil {
+			c.Error(err)
+			return
+		}
+
+		// A free item ships like any other, so the worker reserves its stock
+		if promotion.FreeItem != nil {
+			lines = append(lines, *promotion.FreeItem)
+			req.Items = append(req.Items, OrderItemRequest{
+				VariantID: promotion.FreeItem.VariantID,
+				ProductID: promotion.FreeItem.ProductID,
+				Quantity:  promotion.FreeItem.Quantity,
+			})
+		}
+	}
+
+	discount := money.Zero(currency)
+	var promotionCode interface{}
+	if promotion != nil {
+		discount, promotionCode = promotion.Discount, promotion.Code
+	}
+
+	shares, err := allocateDiscount(lines, promotion)
+	if err != nil {
+		c.Error(apperror.Wrap(err, apperror.CodeInvalidOrderItem, "Order total is too large"))
+		return
+	}
+
+	taxes, err := queryTaxRates(ctx, tx)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to load tax rates"))
+		return
+	}
+
+	totals, err := taxOrder(lines, shares, discount, taxes)
+	if err != nil {
+		c.Error(apperror.Wrap(err, apperror.CodeInvalidOrderItem, "Order total is too large"))
+		return
+	}
+
+	var lockedRate, lockedBase interface{}
+	if rate != nil {
+		lockedRate, lockedBase = rate.FloatString(rateScale), baseCurrency
+	}
+
+	// Create order with PENDING status, snapshotting the shipping address
+	var snapshot ShippingAddress
+	if shipping != nil {
+		snapshot = *shipping
+	}
 	var orderID int
-	err = tx.QueryRow(
-		`INSERT INTO orders (user_id, status, total_amount) 
-		 VALUES ($1, $2, $3) 
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO orders (user_id, status, subtotal_amount, discount_amount, tax_amount, total_amount, currency, promotion_code,
+			base_currency, exchange_rate, address_id,
+			shipping_recipient_name, shipping_phone, shipping_line1, shipping_line2, shipping_city,
+			shipping_region, shipping_postal_code, shipping_country)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, NULLIF($12, ''), NULLIF($13, ''), NULLIF($14, ''), NULLIF($15, ''), NULLIF($16, ''),
+			NULLIF($17, ''), NULLIF($18, ''), NULLIF($19, ''))
 		 RETURNING id`,
-		userID, "PENDING", totalAmount,
+		userID, "PENDING", totals.Subtotal.Amount, discount.Amount, totals.Tax.Amount, totals.Total.Amount, totals.Total.Currency, promotionCode,
+		lockedBase, lockedRate, addressID,
+		snapshot.RecipientName, snapshot.Phone, snapshot.Line1, snapshot.Line2, snapshot.City,
+		snapshot.Region, snapshot.PostalCode, snapshot.Country,
 	).Scan(&orderID)
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   "Failed to create order",
-		})
+		c.Error(apperror.Internal(err, "Failed to create order"))
 		return
 	}
 
 	// Insert order items
-	for _, item := range req.Items {
-		var price float64
-		tx.QueryRow("SELECT price FROM products WHERE id = $1", item.ProductID).Scan(&price)
-
-		_, err := tx.Exec(
-			`INSERT INTO order_items (order_id, product_id, quantity, price) 
-			 VALUES ($1, $2, $3, $4)`,
-			orderID, item.ProductID, item.Quantity, price,
+	for _, line := range lines {
+		var taxName, taxRate interface{}
+		taxInclusive := false
+		if line.TaxRule != nil {
+			taxName, taxRate, taxInclusive = line.TaxRule.Name, line.TaxRule.Rate, line.TaxRule.Inclusive
+		}
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO order_items (order_id, product_id, variant_id, quantity, price, discount_amount,
+				tax_name, tax_rate, tax_inclusive, tax_amount) 
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, COALESCE($8::NUMERIC, 0), $9, $10)`,
+			orderID, line.ProductID, line.VariantID, line.Quantity, line.Price.Amount, line.Discount.Amount,
+			taxName, taxRate, taxInclusive, line.Tax.Amount,
 		)
 
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"success": false,
-				"error":   "Failed to create order items",
-			})
+			c.Error(apperror.Internal(err, "Failed to create order items"))
+			return
+		}
+	}
+
+	if promotion != nil {
+		if err := redeemPromotion(ctx, tx, promotion, orderID, userID); err != nil {
+			c.Error(apperror.Internal(err, "Failed to redeem promotion"))
 			return
 		}
 	}
 
 	// Commit transaction
 	if err := tx.Commit(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   "Failed to commit transaction",
-		})
+		c.Error(apperror.Internal(err, "Failed to commit transaction"))
 		return
 	}
 
@@ -162,95 +358,188 @@ func (h *OrderHandler) CreateOrder(c *gin.Context) {
 		OrderID:     orderID,
 		UserID:      userID,
 		Items:       req.Items,
-		TotalAmount: totalAmount,
+		TotalAmount: totals.Total,
 		Timestamp:   time.Now(),
 	}
 
-	err = h.rmq.Publish(rabbitmq.QueueOrderPlaced, message)
+	err = h.rmq.Publish(ctx, rabbitmq.QueueOrderPlaced, message)
 	if err != nil {
-		// Order created but failed to publish - log error
+		// Order created but failed to publish
 		// In production, you might want to implement retry logic
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   "Order created but failed to queue for processing",
-		})
+		logger.FromContext(ctx).Error("failed to publish order_placed message", "order_id", orderID, "error", err)
+		c.Error(apperror.Wrap(err, apperror.CodeOrderQueueFailed, "Order created but failed to queue for processing"))
 		return
 	}
 
+	logger.FromContext(ctx).Info("order placed", "order_id", orderID, "user_id", userID, "total_amount", totals.Total.String())
+
 	// Return 202 Accepted - order is being processed
 	c.JSON(http.StatusAccepted, gin.H{
 		"success": true,
 		"message": "Order received and is being processed",
 		"data": gin.H{
-			"order_id": orderID,
-			"status":   "PENDING",
+			"order_id":         orderID,
+			"status":           "PENDING",
+			"subtotal":         totals.Subtotal,
+			"discount":         discount,
+			"tax":              totals.Tax,
+			"promotion_code":   promotionCode,
+			"total_amount":     totals.Total,
+			"address_id":       addressID,
+			"shipping_address": shipping,
 		},
 	})
 }
 
-// GetOrderByID returns order details
-func (h *OrderHandler) GetOrderByID(c *gin.Context) {
-	userID := c.GetInt("user_id")
-	orderIDStr := c.Param("id")
-	orderID, err := strconv.Atoi(orderIDStr)
+// singleVariant returns the only variant of a product ordered without
+// choosing one
+func singleVariant(ctx context.Context, tx *sql.Tx, productID int) (int, error) {
+	var count, variantID int
+	err := tx.QueryRowContext(ctx,
+		"SELECT COUNT(*), COALESCE(MIN(id), 0) FROM product_variants WHERE product_id = $1",
+		productID,
+	).Scan(&count, &variantID)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   "Invalid order ID",
-		})
-		return
+		return 0, apperror.Internal(err, "Database error")
 	}
 
-	// Get order
-	var order Order
-	err = h.db.QueryRow(
-		`SELECT id, user_id, status, total_amount, created_at, updated_at 
-		 FROM orders WHERE id = $1 AND user_id = $2`,
-		orderID, userID,
-	).Scan(&order.ID, &order.UserID, &order.Status, &order.TotalAmount, &order.CreatedAt, &order.UpdatedAt)
+	switch count {
+	case 0:
+		return 0, apperror.New(apperror.CodeInvalidOrderItem, "Product ID "+strconv.Itoa(productID)+" not found")
+	case 1:
+		return variantID, nil
+	}
+	return 0, apperror.New(apperror.CodeInvalidOrderItem, "Product ID "+strconv.Itoa(productID)+" has several variants; choose a variant_id")
+}
+
+// resolveAddress checks that the requested address belongs to the user, or
+// falls back to their default address, and returns it for the order's
+// snapshot. Orders may have no address when the user has none.
+func (h *OrderHandler) resolveAddress(ctx context.Context, userID int, requested *int) (*int, *ShippingAddress, error) {
+	const query = `SELECT id, recipient_name, phone, line1, COALESCE(line2, ''), city, COALESCE(region, ''), postal_code, country
+		 FROM addresses WHERE user_id = $1 AND `
+
+	var row *sql.Row
+	if requested != nil {
+		row = h.db.QueryRowContext(ctx, query+"id = $2", userID, *requested)
+	} else {
+		row = h.db.QueryRowContext(ctx, query+"is_default", userID)
+	}
 
+	var addressID int
+	var a ShippingAddress
+	err := row.Scan(&addressID, &a.RecipientName, &a.Phone, &a.Line1, &a.Line2, &a.City, &a.Region, &a.PostalCode, &a.Country)
 	if err == sql.ErrNoRows {
-		c.JSON(http.StatusNotFound, gin.H{
-			"success": false,
-			"error":   "Order not found",
-		})
-		return
+		if requested != nil {
+			return nil, nil, apperror.New(apperror.CodeAddressNotFound, "Address not found in your address book")
+		}
+		return nil, nil, nil
 	}
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   "Database error",
-		})
-		return
+		return nil, nil, apperror.Internal(err, "Database error")
 	}
+	return &addressID, &a, nil
+}
 
-	// Get order items
-	rows, err := h.db.Query(
-		`SELECT id, order_id, product_id, quantity, price, created_at 
-		 FROM order_items WHERE order_id = $1`,
+const orderColumns = `id, user_id, status, total_amount, currency, address_id,
+	shipping_recipient_name, COALESCE(shipping_phone, ''), COALESCE(shipping_line1, ''), COALESCE(shipping_line2, ''),
+	COALESCE(shipping_city, ''), COALESCE(shipping_region, ''), COALESCE(shipping_postal_code, ''), COALESCE(shipping_country, ''),
+	COALESCE(carrier, ''), COALESCE(tracking_number, ''), packed_at, shipped_at, delivered_at,
+	base_currency, exchange_rate::TEXT, COALESCE(subtotal_amount, total_amount), discount_amount, tax_amount, promotion_code,
+	(SELECT number FROM invoices WHERE order_id = orders.id), created_at, updated_at`
+
+// loadOrder returns an order with its items, shipping address and tracking
+func (h *OrderHandler) loadOrder(ctx context.Context, orderID int) (*Order, error) {
+	var order Order
+	var recipientName *string
+	var a ShippingAddress
+	var t Tracking
+	var baseCurrency, rate *string
+	var subtotal, discount, tax money.Money
+	err := h.db.QueryRowContext(ctx,
+		"SELECT "+orderColumns+" FROM orders WHERE id = $1",
+		orderID,
+	).Scan(&order.ID, &order.UserID, &order.Status, &order.TotalAmount.Amount, &order.TotalAmount.Currency, &order.AddressID,
+		&recipientName, &a.Phone, &a.Line1, &a.Line2, &a.City, &a.Region, &a.PostalCode, &a.Country,
+		&t.Carrier, &t.TrackingNumber, &t.PackedAt, &t.ShippedAt, &t.DeliveredAt,
+		&baseCurrency, &rate, &subtotal.Amount, &discount.Amount, &tax.Amount, &order.PromotionCode,
+		&order.InvoiceNumber, &order.CreatedAt, &order.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if recipientName != nil {
+		a.RecipientName = *recipientName
+		order.ShippingAddress = &a
+	}
+	if t.PackedAt != nil || t.ShippedAt != nil {
+		order.Tracking = &t
+	}
+	currency := order.TotalAmount.Currency
+	subtotal.Currency, discount.Currency, tax.Currency = currency, currency, currency
+	order.Subtotal, order.Discount, order.Tax = &subtotal, &discount, &tax
+
+	if baseCurrency != nil && rate != nil {
+		order.ExchangeRate = &LockedRate{BaseCurrency: *baseCurrency, Rate: trimRate(*rate)}
+	}
+
+	rows, err := h.db.QueryContext(ctx,
+		`SELECT oi.id, oi.order_id, oi.product_id, oi.variant_id, COALESCE(v.sku, ''), oi.quantity, oi.price, oi.discount_amount,
+			oi.tax_name, oi.tax_rate::TEXT, oi.tax_inclusive, oi.tax_amount, oi.created_at 
+		 FROM order_items oi LEFT JOIN product_variants v ON v.id = oi.variant_id
+		 WHERE oi.order_id = $1 ORDER BY oi.id`,
 		orderID,
 	)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   "Failed to get order items",
-		})
-		return
+		return nil, err
 	}
 	defer rows.Close()
 
 	items := []OrderItem{}
 	for rows.Next() {
 		var item OrderItem
-		err := rows.Scan(&item.ID, &item.OrderID, &item.ProductID, &item.Quantity, &item.Price, &item.CreatedAt)
+		var taxName *string
+		var t ItemTax
+		err := rows.Scan(&item.ID, &item.OrderID, &item.ProductID, &item.VariantID, &item.SKU, &item.Quantity, &item.Price.Amount, &item.Discount.Amount,
+			&taxName, &t.Rate, &t.Inclusive, &t.Amount.Amount, &item.CreatedAt)
 		if err != nil {
 			continue
 		}
+		// Items are always priced in the order's currency
+		item.Price.Currency, item.Discount.Currency = currency, currency
+		if taxName != nil {
+			t.Name, t.Rate, t.Amount.Currency = *taxName, trimRate(t.Rate), currency
+			item.Tax = &t
+		}
 		items = append(items, item)
 	}
 
 	order.Items = items
+	return &order, nil
+}
+
+// GetOrderByID returns order details
+func (h *OrderHandler) GetOrderByID(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	ctx := c.Request.Context()
+	orderIDStr := c.Param("id")
+	orderID, err := strconv.Atoi(orderIDStr)
+	if err != nil {
+		c.Error(apperror.Wrap(err, apperror.CodeBadRequest, "Invalid order ID"))
+		return
+	}
+
+	order, err := h.loadOrder(ctx, orderID)
+	if err == sql.ErrNoRows || (err == nil && order.UserID != userID) {
+		c.Error(apperror.New(apperror.CodeOrderNotFound, "Order not found"))
+		return
+	}
+
+	if err != nil {
+		c.Error(apperror.Internal(err, "Database error"))
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
@@ -258,20 +547,57 @@ func (h *OrderHandler) GetOrderByID(c *gin.Context) {
 	})
 }
 
+// GetInvoice returns the PDF invoice issued when the order was confirmed
+func (h *OrderHandler) GetInvoice(c *gin.Context) {
+	userID := c.GetInt("user_id")
+	ctx := c.Request.Context()
+	orderID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(apperror.Wrap(err, apperror.CodeBadRequest, "Invalid order ID"))
+		return
+	}
+
+	var ownerID int
+	var number *string
+	var pdf []byte
+	err = h.db.QueryRowContext(ctx,
+		`SELECT o.user_id, i.number, i.pdf
+		 FROM orders o LEFT JOIN invoices i ON i.order_id = o.id
+		 WHERE o.id = $1`,
+		orderID,
+	).Scan(&ownerID, &number, &pdf)
+	if err == sql.ErrNoRows || (err == nil && ownerID != userID) {
+		c.Error(apperror.New(apperror.CodeOrderNotFound, "Order not found"))
+		return
+	}
+
+	if err != nil {
+		c.Error(apperror.Internal(err, "Database error"))
+		return
+	}
+
+	if number == nil {
+		c.Error(apperror.New(apperror.CodeInvoiceNotFound, "Invoices are issued once the order is confirmed"))
+		return
+	}
+
+	c.Header("Content-Disposition", `attachment; filename="`+*number+`.pdf"`)
+	c.Header("Cache-Control", "private, max-age=86400")
+	c.Data(http.StatusOK, "application/pdf", pdf)
+}
+
 // GetUserOrders returns all orders for the logged-in user
 func (h *OrderHandler) GetUserOrders(c *gin.Context) {
 	userID := c.GetInt("user_id")
+	ctx := c.Request.Context()
 
-	rows, err := h.db.Query(
-		`SELECT id, user_id, status, total_amount, created_at, updated_at 
+	rows, err := h.db.QueryContext(ctx,
+		`SELECT id, user_id, status, total_amount, currency, address_id, created_at, updated_at 
 		 FROM orders WHERE user_id = $1 ORDER BY created_at DESC`,
 		userID,
 	)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   "Database error",
-		})
+		c.Error(apperror.Internal(err, "Database error"))
 		return
 	}
 	defer rows.Close()
@@ -279,7 +605,7 @@ func (h *OrderHandler) GetUserOrders(c *gin.Context) {
 	orders := []Order{}
 	for rows.Next() {
 		var order Order
-		err := rows.Scan(&order.ID, &order.UserID, &order.Status, &order.TotalAmount, &order.CreatedAt, &order.UpdatedAt)
+		err := rows.Scan(&order.ID, &order.UserID, &order.Status, &order.TotalAmount.Amount, &order.TotalAmount.Currency, &order.AddressID, &order.CreatedAt, &order.UpdatedAt)
 		if err != nil {
 			continue
 		}
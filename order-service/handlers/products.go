@@ -4,8 +4,13 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"order-service/apperror"
+	"order-service/media"
+	"order-service/money"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -15,33 +20,113 @@ import (
 type ProductHandler struct {
 	db    *sql.DB
 	redis *redis.Client
+	rates *ExchangeRateHandler
+	media media.Storage
 }
 
 type Product struct {
-	ID          int       `json:"id"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	Price       float64   `json:"price"`
-	Stock       int       `json:"stock"`
-	Category    string    `json:"category"`
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
+	ID          int         `json:"id"`
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Price       money.Money `json:"price"`
+	// BasePrice is the catalog price when Price was converted to another currency
+	BasePrice *money.Money `json:"base_price,omitempty"`
+	Stock     int          `json:"stock"`
+	// Category is the name of the product's category
+	Category   string    `json:"category"`
+	CategoryID *int      `json:"category_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+	// RatingAverage is the average rating of the approved reviews, 0 without any
+	RatingAverage float64 `json:"rating_average"`
+	RatingCount   int     `json:"rating_count"`
+	// Images are in display order, the first being the main image
+	Images []ProductImage `json:"images"`
+	// Variants are only listed for a single product
+	Variants []Variant `json:"variants,omitempty"`
 }
 
 const ProductCacheTTL = 5 * time.Minute
 
-func NewProductHandler(db *sql.DB, redis *redis.Client) *ProductHandler {
+const productColumns = `p.id, p.name, p.description, p.price, p.currency, p.stock,
+	COALESCE(c.name, ''), p.category_id, p.rating_average, p.rating_count, p.created_at, p.updated_at`
+
+func scanProduct(row rowScanner) (Product, error) {
+	var p Product
+	err := row.Scan(&p.ID, &p.Name, &p.Description, &p.Price.Amount, &p.Price.Currency, &p.Stock,
+		&p.Category, &p.CategoryID, &p.RatingAverage, &p.RatingCount, &p.CreatedAt, &p.UpdatedAt)
+	return p, err
+}
+
+func NewProductHandler(db *sql.DB, redis *redis.Client, rates *ExchangeRateHandler, storage media.Storage) *ProductHandler {
 	return &ProductHandler{
 		db:    db,
 		redis: redis,
+		rates: rates,
+		media: storage,
 	}
 }
 
-// SearchProducts returns products with optional category filter (cached)
+// localize converts prices to currency at the current rates. Products are
+// cached with catalog prices, so this runs on every request.
+func (h *ProductHandler) localize(ctx context.Context, currency string, products []Product) error {
+	if currency == "" {
+		return nil
+	}
+
+	rates, err := h.rates.load(ctx)
+	if err != nil {
+		return apperror.Internal(err, "Failed to load exchange rates")
+	}
+
+	convert := func(price *money.Money, basePrice **money.Money) error {
+		if price.Currency == currency {
+			return nil
+		}
+
+		rate, err := rates.rate(price.Currency, currency)
+		if err != nil {
+			return err
+		}
+
+		converted, err := price.Convert(currency, rate)
+		if err != nil {
+			return apperror.Internal(err, "Failed to convert price")
+		}
+
+		base := *price
+		*basePrice = &base
+		*price = converted
+		return nil
+	}
+
+	for i := range products {
+		p := &products[i]
+		if err := convert(&p.Price, &p.BasePrice); err != nil {
+			return err
+		}
+		for j := range p.Variants {
+			if err := convert(&p.Variants[j].Price, &p.Variants[j].BasePrice); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// SearchProducts returns products with optional category filter (cached),
+// priced in the currency asked for with ?currency or X-Currency. The
+// category is matched by slug or name and includes its subcategories.
 func (h *ProductHandler) SearchProducts(c *gin.Context) {
-	category := c.Query("category")
+	category := strings.ToLower(strings.TrimSpace(c.Query("category")))
 	name := c.Query("name")
 
+	currency, err := requestedCurrency(c)
+	if err != nil {
+		c.Error(err)
+		return
+	}
+
 	// Create cache key based on query parameters
 	cacheKey := "products"
 	if category != "" {
@@ -51,7 +136,7 @@ func (h *ProductHandler) SearchProducts(c *gin.Context) {
 		cacheKey += ":name:" + name
 	}
 
-	ctx := context.Background()
+	ctx := c.Request.Context()
 
 	// Try to get from cache first
 	cachedData, err := h.redis.Get(ctx, cacheKey).Result()
@@ -59,6 +144,11 @@ func (h *ProductHandler) SearchProducts(c *gin.Context) {
 		// Cache hit
 		var products []Product
 		if err := json.Unmarshal([]byte(cachedData), &products); err == nil {
+			if err := h.localize(ctx, currency, products); err != nil {
+				c.Error(err)
+				return
+			}
+
 			c.JSON(http.StatusOK, gin.H{
 				"success": true,
 				"data":    products,
@@ -69,52 +159,55 @@ func (h *ProductHandler) SearchProducts(c *gin.Context) {
 	}
 
 	// Cache miss - query database
-	query := "SELECT id, name, description, price, stock, category, created_at, updated_at FROM products WHERE 1=1"
+	query := "SELECT " + productColumns + " FROM products p LEFT JOIN categories c ON c.id = p.category_id WHERE 1=1"
 	args := []interface{}{}
 	argCount := 1
 
 	if category != "" {
-		query += " AND category = $" + strconv.Itoa(argCount)
+		query += " AND p.category_id IN " + fmt.Sprintf(categorySubtreeSQL, "$"+strconv.Itoa(argCount))
 		args = append(args, category)
 		argCount++
 	}
 
 	if name != "" {
-		query += " AND name ILIKE $" + strconv.Itoa(argCount)
+		query += " AND p.name ILIKE $" + strconv.Itoa(argCount)
 		args = append(args, "%"+name+"%")
 		argCount++
 	}
 
-	query += " ORDER BY id"
+	query += " ORDER BY p.id"
 
-	rows, err := h.db.Query(query, args...)
+	rows, err := h.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   "Database error",
-		})
+		c.Error(apperror.Internal(err, "Database error"))
 		return
 	}
 	defer rows.Close()
 
 	products := []Product{}
 	for rows.Next() {
-		var p Product
-		err := rows.Scan(&p.ID, &p.Name, &p.Description, &p.Price, &p.Stock, &p.Category, &p.CreatedAt, &p.UpdatedAt)
+		p, err := scanProduct(rows)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"success": false,
-				"error":   "Failed to scan product",
-			})
+			c.Error(apperror.Internal(err, "Failed to scan product"))
 			return
 		}
 		products = append(products, p)
 	}
 
+	if err := h.attachImages(ctx, products); err != nil {
+		c.Error(apperror.Internal(err, "Failed to fetch images"))
+		return
+	}
+
 	// Store in cache for 5 minutes
 	productsJSON, _ := json.Marshal(products)
 	h.redis.Set(ctx, cacheKey, productsJSON, ProductCacheTTL)
 
+	if err := h.localize(ctx, currency, products); err != nil {
+		c.Error(err)
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"data":    products,
@@ -127,24 +220,33 @@ func (h *ProductHandler) GetProductByID(c *gin.Context) {
 	idStr := c.Param("id")
 	id, err := strconv.Atoi(idStr)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"success": false,
-			"error":   "Invalid product ID",
-		})
+		c.Error(apperror.Wrap(err, apperror.CodeBadRequest, "Invalid product ID"))
+		return
+	}
+
+	currency, err := requestedCurrency(c)
+	if err != nil {
+		c.Error(err)
 		return
 	}
 
 	// Try cache first
 	cacheKey := "product:" + idStr
-	ctx := context.Background()
+	ctx := c.Request.Context()
 
 	cachedData, err := h.redis.Get(ctx, cacheKey).Result()
 	if err == nil {
 		var product Product
 		if err := json.Unmarshal([]byte(cachedData), &product); err == nil {
+			products := []Product{product}
+			if err := h.localize(ctx, currency, products); err != nil {
+				c.Error(err)
+				return
+			}
+
 			c.JSON(http.StatusOK, gin.H{
 				"success": true,
-				"data":    product,
+				"data":    products[0],
 				"cached":  true,
 			})
 			return
@@ -152,36 +254,45 @@ func (h *ProductHandler) GetProductByID(c *gin.Context) {
 	}
 
 	// Query database
-	var product Product
-	err = h.db.QueryRow(
-		`SELECT id, name, description, price, stock, category, created_at, updated_at 
-		 FROM products WHERE id = $1`,
+	product, err := scanProduct(h.db.QueryRowContext(ctx,
+		"SELECT "+productColumns+" FROM products p LEFT JOIN categories c ON c.id = p.category_id WHERE p.id = $1",
 		id,
-	).Scan(&product.ID, &product.Name, &product.Description, &product.Price, &product.Stock, &product.Category, &product.CreatedAt, &product.UpdatedAt)
+	))
 
 	if err == sql.ErrNoRows {
-		c.JSON(http.StatusNotFound, gin.H{
-			"success": false,
-			"error":   "Product not found",
-		})
+		c.Error(apperror.New(apperror.CodeProductNotFound, "Product not found"))
+		return
+	}
+
+	if err != nil {
+		c.Error(apperror.Internal(err, "Database error"))
 		return
 	}
 
+	product.Variants, err = loadVariants(ctx, h.db, id)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"success": false,
-			"error":   "Database error",
-		})
+		c.Error(apperror.Internal(err, "Failed to fetch variants"))
+		return
+	}
+
+	products := []Product{product}
+	if err := h.attachImages(ctx, products); err != nil {
+		c.Error(apperror.Internal(err, "Failed to fetch images"))
 		return
 	}
 
 	// Cache the product
-	productJSON, _ := json.Marshal(product)
+	productJSON, _ := json.Marshal(products[0])
 	h.redis.Set(ctx, cacheKey, productJSON, ProductCacheTTL)
 
+	if err := h.localize(ctx, currency, products); err != nil {
+		c.Error(err)
+		return
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
-		"data":    product,
+		"data":    products[0],
 		"cached":  false,
 	})
 }
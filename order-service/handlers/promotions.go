@@ -0,0 +1,550 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"math/big"
+	"net/http"
+	"order-service/apperror"
+	"order-service/logger"
+	"order-service/money"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// Promotion types
+const (
+	PromotionPercentage  = "PERCENTAGE"
+	PromotionFixedAmount = "FIXED_AMOUNT"
+	PromotionFreeItem    = "FREE_ITEM"
+)
+
+// PromotionHandler lets administrators manage coupon codes
+type PromotionHandler struct {
+	db *sql.DB
+}
+
+type Promotion struct {
+	ID            int          `json:"id"`
+	Code          string       `json:"code"`
+	Description   string       `json:"description"`
+	Type          string       `json:"type"`
+	PercentOff    *int         `json:"percent_off,omitempty"`
+	AmountOff     *money.Money `json:"amount_off,omitempty"`
+	FreeProductID *int         `json:"free_product_id,omitempty"`
+	FreeQuantity  *int         `json:"free_quantity,omitempty"`
+	// MinSpend applies to the items the promotion covers
+	MinSpend              money.Money `json:"min_spend"`
+	CategoryID            *int        `json:"category_id"`
+	StartsAt              *time.Time  `json:"starts_at"`
+	EndsAt                *time.Time  `json:"ends_at"`
+	MaxRedemptions        *int        `json:"max_redemptions"`
+	MaxRedemptionsPerUser *int        `json:"max_redemptions_per_user"`
+	RedemptionCount       int         `json:"redemption_count"`
+	Active                bool        `json:"active"`
+	CreatedAt             time.Time   `json:"created_at"`
+	UpdatedAt             time.Time   `json:"updated_at"`
+}
+
+type PromotionRequest struct {
+	Code        string `json:"code" binding:"required,alphanum,max=50"`
+	Description string `json:"description" binding:"max=255"`
+	Type        string `json:"type" binding:"required,oneof=PERCENTAGE FIXED_AMOUNT FREE_ITEM"`
+	// PercentOff is required for PERCENTAGE promotions
+	PercentOff int `json:"percent_off" binding:"omitempty,min=1,max=100"`
+	// AmountOff is required for FIXED_AMOUNT promotions
+	AmountOff *money.Money `json:"amount_off"`
+	// FreeProductID is required for FREE_ITEM promotions
+	FreeProductID         int          `json:"free_product_id"`
+	FreeQuantity          int          `json:"free_quantity" binding:"omitempty,min=1,max=100"`
+	MinSpend              *money.Money `json:"min_spend"`
+	CategoryID            *int         `json:"category_id"`
+	StartsAt              *time.Time   `json:"starts_at"`
+	EndsAt                *time.Time   `json:"ends_at"`
+	MaxRedemptions        int          `json:"max_redemptions" binding:"omitempty,min=1"`
+	MaxRedemptionsPerUser int          `json:"max_redemptions_per_user" binding:"omitempty,min=1"`
+	Active                *bool        `json:"active"`
+}
+
+const promotionColumns = `id, code, COALESCE(description, ''), type, percent_off, amount_off, free_product_id, free_quantity,
+	currency, min_spend, category_id, starts_at, ends_at, max_redemptions, max_redemptions_per_user,
+	redemption_count, active, created_at, updated_at`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanPromotion(row rowScanner) (Promotion, error) {
+	var p Promotion
+	var amountOff *int64
+	var currency string
+	err := row.Scan(&p.ID, &p.Code, &p.Description, &p.Type, &p.PercentOff, &amountOff, &p.FreeProductID, &p.FreeQuantity,
+		&currency, &p.MinSpend.Amount, &p.CategoryID, &p.StartsAt, &p.EndsAt, &p.MaxRedemptions, &p.MaxRedemptionsPerUser,
+		&p.RedemptionCount, &p.Active, &p.CreatedAt, &p.UpdatedAt)
+	if err != nil {
+		return p, err
+	}
+
+	p.MinSpend.Currency = currency
+	if amountOff != nil {
+		p.AmountOff = &money.Money{Amount: *amountOff, Currency: currency}
+	}
+	return p, nil
+}
+
+func NewPromotionHandler(db *sql.DB) *PromotionHandler {
+	return &PromotionHandler{db: db}
+}
+
+// ListPromotions returns all promotions, optionally only ?active=true ones
+func (h *PromotionHandler) ListPromotions(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	query := "SELECT " + promotionColumns + " FROM promotions"
+	if c.Query("active") == "true" {
+		query += " WHERE active"
+	}
+	query += " ORDER BY created_at DESC"
+
+	rows, err := h.db.QueryContext(ctx, query)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to fetch promotions"))
+		return
+	}
+	defer rows.Close()
+
+	promotions := []Promotion{}
+	for rows.Next() {
+		promotion, err := scanPromotion(rows)
+		if err != nil {
+			c.Error(apperror.Internal(err, "Failed to scan promotion"))
+			return
+		}
+		promotions = append(promotions, promotion)
+	}
+
+	if err := rows.Err(); err != nil {
+		c.Error(apperror.Internal(err, "Failed to fetch promotions"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    promotions,
+	})
+}
+
+// GetPromotion returns a promotion with its redemption count
+func (h *PromotionHandler) GetPromotion(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	id, ok := promotionIDParam(c)
+	if !ok {
+		return
+	}
+
+	promotion, err := scanPromotion(h.db.QueryRowContext(ctx, "SELECT "+promotionColumns+" FROM promotions WHERE id = $1", id))
+	if err == sql.ErrNoRows {
+		c.Error(apperror.New(apperror.CodePromotionNotFound, "Promotion not found"))
+		return
+	}
+
+	if err != nil {
+		c.Error(apperror.Internal(err, "Database error"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    promotion,
+	})
+}
+
+// CreatePromotion adds a coupon code
+func (h *PromotionHandler) CreatePromotion(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	req, values, ok := h.bindPromotion(c, 0)
+	if !ok {
+		return
+	}
+
+	promotion, err := scanPromotion(h.db.QueryRowContext(ctx,
+		`INSERT INTO promotions (code, description, type, percent_off, amount_off, free_product_id, free_quantity,
+			currency, min_spend, category_id, starts_at, ends_at, max_redemptions, max_redemptions_per_user, active)
+		 VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+		 RETURNING `+promotionColumns,
+		values...,
+	))
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to create promotion"))
+		return
+	}
+
+	logger.FromContext(ctx).Info("promotion created", "promotion_id", promotion.ID, "code", req.Code, "by", c.GetInt("user_id"))
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"message": "Promotion created successfully",
+		"data":    promotion,
+	})
+}
+
+// UpdatePromotion replaces a promotion's settings. Redemptions made so far
+// are kept and still count towards the limits.
+func (h *PromotionHandler) UpdatePromotion(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	id, ok := promotionIDParam(c)
+	if !ok {
+		return
+	}
+
+	_, values, ok := h.bindPromotion(c, id)
+	if !ok {
+		return
+	}
+
+	promotion, err := scanPromotion(h.db.QueryRowContext(ctx,
+		`UPDATE promotions SET code = $1, description = NULLIF($2, ''), type = $3, percent_off = $4, amount_off = $5,
+			free_product_id = $6, free_quantity = $7, currency = $8, min_spend = $9, category_id = $10,
+			starts_at = $11, ends_at = $12, max_redemptions = $13, max_redemptions_per_user = $14, active = $15
+		 WHERE id = $16
+		 RETURNING `+promotionColumns,
+		append(values, id)...,
+	))
+	if err == sql.ErrNoRows {
+		c.Error(apperror.New(apperror.CodePromotionNotFound, "Promotion not found"))
+		return
+	}
+
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to update promotion"))
+		return
+	}
+
+	logger.FromContext(ctx).Info("promotion updated", "promotion_id", id, "by", c.GetInt("user_id"))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Promotion updated successfully",
+		"data":    promotion,
+	})
+}
+
+// bindPromotion validates a promotion request and returns the column values
+// in INSERT order. id is the promotion being updated, or 0.
+func (h *PromotionHandler) bindPromotion(c *gin.Context, id int) (PromotionRequest, []interface{}, bool) {
+	ctx := c.Request.Context()
+
+	var req PromotionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperror.Validation(err))
+		return req, nil, false
+	}
+	req.Code = strings.ToUpper(req.Code)
+
+	var percentOff, amountOff, freeProductID, freeQuantity interface{}
+	switch req.Type {
+	case PromotionPercentage:
+		if req.PercentOff == 0 {
+			c.Error(apperror.InvalidField("percent_off", "required", "is required for PERCENTAGE promotions"))
+			return req, nil, false
+		}
+		percentOff = req.PercentOff
+	case PromotionFixedAmount:
+		if req.AmountOff == nil || req.AmountOff.Amount <= 0 {
+			c.Error(apperror.InvalidField("amount_off", "required", "must be a positive amount for FIXED_AMOUNT promotions"))
+			return req, nil, false
+		}
+		amountOff = req.AmountOff.Amount
+	case PromotionFreeItem:
+		if req.FreeProductID == 0 {
+			c.Error(apperror.InvalidField("free_product_id", "required", "is required for FREE_ITEM promotions"))
+			return req, nil, false
+		}
+		if req.FreeQuantity == 0 {
+			req.FreeQuantity = 1
+		}
+		freeProductID, freeQuantity = req.FreeProductID, req.FreeQuantity
+	}
+
+	// Amounts are kept in one currency: amount_off's, else min_spend's, else
+	// the catalog's
+	currency := money.DefaultCurrency
+	switch {
+	case req.AmountOff != nil:
+		currency = req.AmountOff.Currency
+	case req.MinSpend != nil:
+		currency = req.MinSpend.Currency
+	}
+	minSpend := money.Zero(currency)
+	if req.MinSpend != nil {
+		if req.MinSpend.Currency != currency {
+			c.Error(apperror.InvalidField("min_spend", "currency", "must be in the same currency as amount_off"))
+			return req, nil, false
+		}
+		minSpend = *req.MinSpend
+	}
+	if minSpend.IsNegative() {
+		c.Error(apperror.InvalidField("min_spend", "min", "must not be negative"))
+		return req, nil, false
+	}
+
+	if req.StartsAt != nil && req.EndsAt != nil && !req.EndsAt.After(*req.StartsAt) {
+		c.Error(apperror.InvalidField("ends_at", "gtfield", "must be after starts_at"))
+		return req, nil, false
+	}
+
+	var exists bool
+	err := h.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM promotions WHERE code = $1 AND id <> $2)", req.Code, id).Scan(&exists)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Database error"))
+		return req, nil, false
+	}
+	if exists {
+		c.Error(apperror.New(apperror.CodePromotionCodeTaken, "Promotion code already exists"))
+		return req, nil, false
+	}
+
+	if req.Type == PromotionFreeItem {
+		err := h.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM products WHERE id = $1)", req.FreeProductID).Scan(&exists)
+		if err != nil {
+			c.Error(apperror.Internal(err, "Database error"))
+			return req, nil, false
+		}
+		if !exists {
+			c.Error(apperror.New(apperror.CodeProductNotFound, "Free product not found"))
+			return req, nil, false
+		}
+	}
+
+	if req.CategoryID != nil {
+		err := h.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM categories WHERE id = $1)", *req.CategoryID).Scan(&exists)
+		if err != nil {
+			c.Error(apperror.Internal(err, "Database error"))
+			return req, nil, false
+		}
+		if !exists {
+			c.Error(apperror.New(apperror.CodeCategoryNotFound, "Category not found"))
+			return req, nil, false
+		}
+	}
+
+	active := true
+	if req.Active != nil {
+		active = *req.Active
+	}
+
+	values := []interface{}{
+		req.Code, req.Description, req.Type, percentOff, amountOff, freeProductID, freeQuantity,
+		currency, minSpend.Amount, req.CategoryID, req.StartsAt, req.EndsAt,
+		nullIfZero(req.MaxRedemptions), nullIfZero(req.MaxRedemptionsPerUser), active,
+	}
+	return req, values, true
+}
+
+// orderLine is an item being priced in CreateOrder
+type orderLine struct {
+	ProductID int
+	VariantID int
+	Quantity  int
+	// CategoryIDs are the product's category and its ancestors, nearest first
+	CategoryIDs []int64
+	Price       money.Money
+	// Free is set on the item a FREE_ITEM promotion adds
+	Free bool
+
+	// Discount is the line's share of the order discount, and Tax the tax
+	// on what remains under TaxRule
+	Discount money.Money
+	Tax      money.Money
+	TaxRule  *taxRule
+}
+
+// inCategory reports whether the line's product is in the category or one of
+// its subcategories. A nil category matches every line.
+func (l orderLine) inCategory(categoryID *int) bool {
+	if categoryID == nil {
+		return true
+	}
+	for _, id := range l.CategoryIDs {
+		if id == int64(*categoryID) {
+			return true
+		}
+	}
+	return false
+}
+
+// appliedPromotion is a promotion that passed every check for an order
+type appliedPromotion struct {
+	ID         int
+	Code       string
+	CategoryID *int
+	Discount   money.Money
+	// FreeItem is added to the order, fully discounted, by FREE_ITEM promotions
+	FreeItem *orderLine
+}
+
+// applyPromotion checks a coupon code against the lines of an order priced in
+// currency and works out the discount. It locks the promotion row, so usage
+// limits hold under concurrent orders until the transaction ends.
+// baseCurrency and rate are the catalog currency and the order's locked rate,
+// used to convert the promotion's amounts and a free item's price.
+func applyPromotion(ctx context.Context, tx *sql.Tx, userID int, code string, lines []orderLine, currency, baseCurrency string, rate *big.Rat) (*appliedPromotion, error) {
+	promotion, err := scanPromotion(tx.QueryRowContext(ctx,
+		"SELECT "+promotionColumns+" FROM promotions WHERE code = $1 FOR UPDATE",
+		strings.ToUpper(code),
+	))
+	if err == sql.ErrNoRows {
+		return nil, apperror.New(apperror.CodeInvalidPromotion, "Promotion code not found")
+	}
+	if err != nil {
+		return nil, apperror.Internal(err, "Database error")
+	}
+
+	var started, ended bool
+	err = tx.QueryRowContext(ctx,
+		"SELECT $1::TIMESTAMP IS NULL OR $1 <= CURRENT_TIMESTAMP, $2::TIMESTAMP IS NOT NULL AND $2 <= CURRENT_TIMESTAMP",
+		promotion.StartsAt, promotion.EndsAt,
+	).Scan(&started, &ended)
+	if err != nil {
+		return nil, apperror.Internal(err, "Database error")
+	}
+
+	switch {
+	case !promotion.Active:
+		return nil, apperror.New(apperror.CodeInvalidPromotion, "Promotion is no longer active")
+	case !started:
+		return nil, apperror.New(apperror.CodeInvalidPromotion, "Promotion has not started yet")
+	case ended:
+		return nil, apperror.New(apperror.CodeInvalidPromotion, "Promotion has expired")
+	case promotion.MaxRedemptions != nil && promotion.RedemptionCount >= *promotion.MaxRedemptions:
+		return nil, apperror.New(apperror.CodeInvalidPromotion, "Promotion has been fully redeemed")
+	}
+
+	if promotion.MaxRedemptionsPerUser != nil {
+		var used int
+		err := tx.QueryRowContext(ctx,
+			"SELECT COUNT(*) FROM promotion_redemptions WHERE promotion_id = $1 AND user_id = $2 AND released_at IS NULL",
+			promotion.ID, userID,
+		).Scan(&used)
+		if err != nil {
+			return nil, apperror.Internal(err, "Database error")
+		}
+		if used >= *promotion.MaxRedemptionsPerUser {
+			return nil, apperror.New(apperror.CodeInvalidPromotion, "You have already used this promotion")
+		}
+	}
+
+	// Promotion amounts are set in the catalog currency or the order's own
+	inOrderCurrency := func(m money.Money) (money.Money, error) {
+		if m.Currency == currency {
+			return m, nil
+		}
+		if rate != nil && m.Currency == baseCurrency {
+			return m.Convert(currency, rate)
+		}
+		return money.Money{}, apperror.New(apperror.CodeInvalidPromotion, "Promotion is not available in "+currency)
+	}
+
+	// Only items in the promotion's category count towards it
+	eligible := money.Zero(currency)
+	for _, line := range lines {
+		if !line.inCategory(promotion.CategoryID) {
+			continue
+		}
+		lineTotal, err := line.Price.Mul(int64(line.Quantity))
+		if err == nil {
+			eligible, err = eligible.Add(lineTotal)
+		}
+		if err != nil {
+			return nil, apperror.Wrap(err, apperror.CodeInvalidOrderItem, "Order total is too large")
+		}
+	}
+	if eligible.IsZero() {
+		return nil, apperror.New(apperror.CodeInvalidPromotion, "Promotion does not apply to any item in this order")
+	}
+
+	minSpend, err := inOrderCurrency(promotion.MinSpend)
+	if err != nil {
+		return nil, err
+	}
+	if eligible.Amount < minSpend.Amount {
+		return nil, apperror.New(apperror.CodeInvalidPromotion, "Minimum spend of "+minSpend.Format()+" not reached")
+	}
+
+	applied := &appliedPromotion{ID: promotion.ID, Code: promotion.Code, CategoryID: promotion.CategoryID}
+	switch promotion.Type {
+	case PromotionPercentage:
+		applied.Discount, err = eligible.MulRat(int64(*promotion.PercentOff), 100)
+	case PromotionFixedAmount:
+		applied.Discount, err = inOrderCurrency(*promotion.AmountOff)
+		// Never discount more than the items it covers
+		if err == nil && applied.Discount.Amount > eligible.Amount {
+			applied.Discount = eligible
+		}
+	case PromotionFreeItem:
+		// The product's first variant in stock is given away
+		item := orderLine{ProductID: *promotion.FreeProductID, Quantity: *promotion.FreeQuantity, Free: true}
+		err = tx.QueryRowContext(ctx,
+			`SELECT v.id, `+categoryPathSQL+`, COALESCE(v.price, p.price), p.currency
+			 FROM product_variants v JOIN products p ON p.id = v.product_id
+			 WHERE v.product_id = $1 ORDER BY v.stock >= $2 DESC, v.id LIMIT 1`,
+			item.ProductID, item.Quantity,
+		).Scan(&item.VariantID, pq.Array(&item.CategoryIDs), &item.Price.Amount, &item.Price.Currency)
+		if err == sql.ErrNoRows {
+			return nil, apperror.New(apperror.CodeInvalidPromotion, "Free item is no longer available")
+		}
+		if err != nil {
+			return nil, apperror.Internal(err, "Database error")
+		}
+
+		if item.Price, err = inOrderCurrency(item.Price); err != nil {
+			return nil, err
+		}
+		applied.FreeItem = &item
+		applied.Discount, err = item.Price.Mul(int64(item.Quantity))
+	}
+	if err != nil {
+		return nil, apperror.From(err)
+	}
+
+	return applied, nil
+}
+
+// redeemPromotion records the use of a promotion by an order, within the
+// transaction that created it
+func redeemPromotion(ctx context.Context, tx *sql.Tx, promotion *appliedPromotion, orderID, userID int) error {
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO promotion_redemptions (promotion_id, order_id, user_id, discount_amount, currency)
+		 VALUES ($1, $2, $3, $4, $5)`,
+		promotion.ID, orderID, userID, promotion.Discount.Amount, promotion.Discount.Currency,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE promotions SET redemption_count = redemption_count + 1 WHERE id = $1", promotion.ID)
+	return err
+}
+
+func promotionIDParam(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(apperror.Wrap(err, apperror.CodeBadRequest, "Invalid promotion ID"))
+		return 0, false
+	}
+	return id, true
+}
+
+func nullIfZero(value int) interface{} {
+	if value == 0 {
+		return nil
+	}
+	return value
+}
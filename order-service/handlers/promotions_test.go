@@ -0,0 +1,433 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"order-service/apperror"
+	"order-service/middleware"
+	"order-service/money"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+)
+
+func idr(amount int64) money.Money {
+	return money.New(amount, "IDR")
+}
+
+// Category IDs used by the tests
+const (
+	electronics = iota + 1
+	books
+	phones
+	novels
+)
+
+func line(price int64, quantity int, categoryIDs ...int64) orderLine {
+	return orderLine{Price: idr(price), Quantity: quantity, CategoryIDs: categoryIDs}
+}
+
+func TestAllocateDiscount(t *testing.T) {
+	booksID := books
+
+	tests := []struct {
+		name      string
+		lines     []orderLine
+		promotion *appliedPromotion
+		want      []int64
+	}{
+		{
+			name:  "no promotion",
+			lines: []orderLine{line(1000, 1), line(2000, 1)},
+			want:  []int64{0, 0},
+		},
+		{
+			name:      "proportional",
+			lines:     []orderLine{line(1000, 1), line(1500, 2)},
+			promotion: &appliedPromotion{Discount: idr(400)},
+			want:      []int64{100, 300},
+		},
+		{
+			name:      "remainder on the last line",
+			lines:     []orderLine{line(100, 1), line(100, 1), line(100, 1)},
+			promotion: &appliedPromotion{Discount: idr(100)},
+			want:      []int64{33, 33, 34},
+		},
+		{
+			name:      "halves round away from zero",
+			lines:     []orderLine{line(1, 1), line(1, 1)},
+			promotion: &appliedPromotion{Discount: idr(3)},
+			want:      []int64{2, 1},
+		},
+		{
+			name:      "smallest unit",
+			lines:     []orderLine{line(500, 1), line(500, 1), line(500, 1)},
+			promotion: &appliedPromotion{Discount: idr(1)},
+			want:      []int64{0, 0, 1},
+		},
+		{
+			name:      "category",
+			lines:     []orderLine{line(1000, 1, novels, books), line(5000, 1, phones, electronics), line(3000, 1, books)},
+			promotion: &appliedPromotion{Discount: idr(800), CategoryID: &booksID},
+			want:      []int64{200, 0, 600},
+		},
+		{
+			name:      "category matching nothing",
+			lines:     []orderLine{line(5000, 1, phones)},
+			promotion: &appliedPromotion{Discount: idr(800), CategoryID: &booksID},
+			want:      []int64{0},
+		},
+		{
+			name:      "free item",
+			lines:     []orderLine{line(5000, 2), {Price: idr(700), Quantity: 3, Free: true}},
+			promotion: &appliedPromotion{Discount: idr(2100), FreeItem: &orderLine{Free: true}},
+			want:      []int64{0, 2100},
+		},
+	}
+
+	for _, tt := range tests {
+		shares, err := allocateDiscount(tt.lines, tt.promotion)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		for i, share := range shares {
+			if share.Amount != tt.want[i] || share.Currency != "IDR" {
+				t.Errorf("%s: shares %v, want %v", tt.name, shares, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestAllocateDiscountSumsExactly(t *testing.T) {
+	lines := []orderLine{line(333, 1), line(1999, 3), line(7, 5), line(15999000, 1)}
+
+	for _, discount := range []int64{0, 1, 2, 3, 7, 99, 100, 101, 999, 12345, 1599900, 16005032} {
+		shares, err := allocateDiscount(lines, &appliedPromotion{Discount: idr(discount)})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var sum int64
+		for i, share := range shares {
+			lineTotal := lines[i].Price.Amount * int64(lines[i].Quantity)
+			if share.Amount < 0 || share.Amount > lineTotal {
+				t.Errorf("discount %d: share %d of a %d line", discount, share.Amount, lineTotal)
+			}
+			sum += share.Amount
+		}
+		if sum != discount {
+			t.Errorf("discount %d: shares %v sum to %d", discount, shares, sum)
+		}
+	}
+}
+
+// testPromotion is a promotions row; nil fields are NULL
+type testPromotion struct {
+	typ            string
+	percentOff     driver.Value
+	amountOff      driver.Value
+	freeProductID  driver.Value
+	freeQuantity   driver.Value
+	currency       string
+	minSpend       int64
+	categoryID     driver.Value
+	maxRedemptions driver.Value
+	maxPerUser     driver.Value
+	redemptions    int64
+	inactive       bool
+}
+
+func (p testPromotion) rows() *sqlmock.Rows {
+	currency := p.currency
+	if currency == "" {
+		currency = "IDR"
+	}
+	now := time.Now()
+	return sqlmock.NewRows([]string{"id", "code", "description", "type", "percent_off", "amount_off", "free_product_id",
+		"free_quantity", "currency", "min_spend", "category_id", "starts_at", "ends_at", "max_redemptions",
+		"max_redemptions_per_user", "redemption_count", "active", "created_at", "updated_at"}).
+		AddRow(3, "SAVE", "", p.typ, p.percentOff, p.amountOff, p.freeProductID, p.freeQuantity,
+			currency, p.minSpend, p.categoryID, nil, nil, p.maxRedemptions,
+			p.maxPerUser, p.redemptions, !p.inactive, now, now)
+}
+
+func TestApplyPromotion(t *testing.T) {
+	lines := []orderLine{line(15000000, 1, phones, electronics), line(5000000, 2, novels, books)}
+
+	tests := []struct {
+		name      string
+		promotion testPromotion
+		// notStarted and ended are what the database reports for the dates
+		notStarted, ended bool
+		// used is how often the user redeemed it, when limited per user
+		used     int64
+		lines    []orderLine
+		currency string
+		rate     *big.Rat
+		want     money.Money
+		wantFree bool
+		wantErr  bool
+	}{
+		{
+			name:      "percentage",
+			promotion: testPromotion{typ: PromotionPercentage, percentOff: 15},
+			want:      idr(3750000),
+		},
+		{
+			name:      "percentage rounds halves up",
+			promotion: testPromotion{typ: PromotionPercentage, percentOff: 50},
+			lines:     []orderLine{line(1001, 1)},
+			want:      idr(501),
+		},
+		{
+			name:      "percentage of a category",
+			promotion: testPromotion{typ: PromotionPercentage, percentOff: 10, categoryID: electronics},
+			want:      idr(1500000),
+		},
+		{
+			name:      "fixed amount",
+			promotion: testPromotion{typ: PromotionFixedAmount, amountOff: 2500000},
+			want:      idr(2500000),
+		},
+		{
+			name:      "fixed amount capped at the covered items",
+			promotion: testPromotion{typ: PromotionFixedAmount, amountOff: 20000000, categoryID: books},
+			want:      idr(10000000),
+		},
+		{
+			name:      "fixed amount converted from the catalog currency",
+			promotion: testPromotion{typ: PromotionFixedAmount, amountOff: 16000000},
+			lines:     []orderLine{{Price: money.New(5000, "USD"), Quantity: 1}},
+			currency:  "USD",
+			rate:      big.NewRat(1, 16000),
+			want:      money.New(1000, "USD"),
+		},
+		{
+			name:      "fixed amount in another currency",
+			promotion: testPromotion{typ: PromotionFixedAmount, amountOff: 1000, currency: "EUR"},
+			wantErr:   true,
+		},
+		{
+			name:      "minimum spend reached",
+			promotion: testPromotion{typ: PromotionPercentage, percentOff: 10, minSpend: 25000000},
+			want:      idr(2500000),
+		},
+		{
+			name:      "minimum spend of the category not reached",
+			promotion: testPromotion{typ: PromotionPercentage, percentOff: 10, minSpend: 12000000, categoryID: books},
+			wantErr:   true,
+		},
+		{
+			name:      "no item in the category",
+			promotion: testPromotion{typ: PromotionPercentage, percentOff: 10, categoryID: 9},
+			wantErr:   true,
+		},
+		{
+			name:      "free item",
+			promotion: testPromotion{typ: PromotionFreeItem, freeProductID: 9, freeQuantity: 2},
+			want:      idr(1500000),
+			wantFree:  true,
+		},
+		{
+			name:      "inactive",
+			promotion: testPromotion{typ: PromotionPercentage, percentOff: 10, inactive: true},
+			wantErr:   true,
+		},
+		{
+			name:       "not started",
+			promotion:  testPromotion{typ: PromotionPercentage, percentOff: 10},
+			notStarted: true,
+			wantErr:    true,
+		},
+		{
+			name:      "expired",
+			promotion: testPromotion{typ: PromotionPercentage, percentOff: 10},
+			ended:     true,
+			wantErr:   true,
+		},
+		{
+			name:      "fully redeemed",
+			promotion: testPromotion{typ: PromotionPercentage, percentOff: 10, maxRedemptions: 5, redemptions: 5},
+			wantErr:   true,
+		},
+		{
+			name:      "redeemed by the user",
+			promotion: testPromotion{typ: PromotionPercentage, percentOff: 10, maxPerUser: 1},
+			used:      1,
+			wantErr:   true,
+		},
+		{
+			name:      "available to the user",
+			promotion: testPromotion{typ: PromotionPercentage, percentOff: 10, maxPerUser: 2},
+			used:      1,
+			want:      idr(2500000),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			mock.ExpectBegin()
+			mock.ExpectQuery("FROM promotions WHERE code = \\$1 FOR UPDATE").
+				WithArgs("SAVE").
+				WillReturnRows(tt.promotion.rows())
+			mock.ExpectQuery("CURRENT_TIMESTAMP").
+				WillReturnRows(sqlmock.NewRows([]string{"started", "ended"}).AddRow(!tt.notStarted, tt.ended))
+			if tt.promotion.maxPerUser != nil {
+				mock.ExpectQuery("FROM promotion_redemptions").
+					WithArgs(3, 1).
+					WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(tt.used))
+			}
+			if tt.promotion.typ == PromotionFreeItem {
+				mock.ExpectQuery("FROM product_variants").
+					WithArgs(9, 2).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "categories", "price", "currency"}).
+						AddRow(4, "{7}", 750000, "IDR"))
+			}
+
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			orderLines, currency := tt.lines, tt.currency
+			if orderLines == nil {
+				orderLines = lines
+			}
+			if currency == "" {
+				currency = "IDR"
+			}
+
+			got, err := applyPromotion(context.Background(), tx, 1, "save", orderLines, currency, "IDR", tt.rate)
+			if tt.wantErr {
+				var appErr *apperror.Error
+				if !errors.As(err, &appErr) || appErr.Code != apperror.CodeInvalidPromotion {
+					t.Errorf("error = %v, want an invalid promotion", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got.Discount != tt.want {
+				t.Errorf("discount %s, want %s", got.Discount, tt.want)
+			}
+			if (got.FreeItem != nil) != tt.wantFree {
+				t.Errorf("free item %+v, want one %v", got.FreeItem, tt.wantFree)
+			}
+			if got.FreeItem != nil && (!got.FreeItem.Free || got.FreeItem.VariantID != 4 || got.FreeItem.Quantity != 2) {
+				t.Errorf("free item %+v, want 2 of variant 4", got.FreeItem)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestApplyPromotionNotFound(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("FROM promotions").WillReturnError(sql.ErrNoRows)
+	tx, _ := db.Begin()
+
+	_, err = applyPromotion(context.Background(), tx, 1, "missing", []orderLine{line(1000, 1)}, "IDR", "IDR", nil)
+	var appErr *apperror.Error
+	if !errors.As(err, &appErr) || appErr.Code != apperror.CodeInvalidPromotion {
+		t.Errorf("error = %v, want an invalid promotion", err)
+	}
+}
+
+func TestCreatePromotionCurrency(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name         string
+		body         string
+		wantCurrency string
+		wantMinSpend int64
+		wantStatus   int
+	}{
+		{
+			name:         "catalog currency",
+			body:         `{"code": "save", "type": "PERCENTAGE", "percent_off": 10}`,
+			wantCurrency: "IDR",
+			wantStatus:   http.StatusCreated,
+		},
+		{
+			name:         "from min_spend",
+			body:         `{"code": "save", "type": "PERCENTAGE", "percent_off": 10, "min_spend": {"amount": "50.00", "currency": "USD"}}`,
+			wantCurrency: "USD",
+			wantMinSpend: 5000,
+			wantStatus:   http.StatusCreated,
+		},
+		{
+			name:         "from amount_off",
+			body:         `{"code": "save", "type": "FIXED_AMOUNT", "amount_off": {"amount": "5.00", "currency": "USD"}, "min_spend": {"amount": "50.00", "currency": "USD"}}`,
+			wantCurrency: "USD",
+			wantMinSpend: 5000,
+			wantStatus:   http.StatusCreated,
+		},
+		{
+			name:       "mixed currencies",
+			body:       `{"code": "save", "type": "FIXED_AMOUNT", "amount_off": {"amount": "5.00", "currency": "USD"}, "min_spend": {"amount": "500000.00", "currency": "IDR"}}`,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			if tt.wantStatus == http.StatusCreated {
+				mock.ExpectQuery("SELECT EXISTS\\(SELECT 1 FROM promotions WHERE code = \\$1 AND id <> \\$2\\)").
+					WithArgs("SAVE", 0).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+				mock.ExpectQuery("INSERT INTO promotions").
+					WithArgs("SAVE", "", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), nil, nil,
+						tt.wantCurrency, tt.wantMinSpend, nil, nil, nil, nil, nil, true).
+					WillReturnRows(testPromotion{typ: PromotionPercentage, percentOff: 10, currency: tt.wantCurrency, minSpend: tt.wantMinSpend}.rows())
+			}
+
+			router := gin.New()
+			router.Use(middleware.ErrorHandler())
+			router.POST("/admin/promotions", NewPromotionHandler(db).CreatePromotion)
+
+			req := httptest.NewRequest(http.MethodPost, "/admin/promotions", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
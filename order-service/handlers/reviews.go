@@ -0,0 +1,386 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"order-service/apperror"
+	"order-service/cache"
+	"order-service/logger"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+)
+
+// ReviewHandler lets customers review products they bought and
+// administrators moderate the reviews
+type ReviewHandler struct {
+	db    *sql.DB
+	redis *redis.Client
+}
+
+type Review struct {
+	ID           int        `json:"id"`
+	ProductID    int        `json:"product_id"`
+	UserID       int        `json:"user_id"`
+	ReviewerName string     `json:"reviewer_name"`
+	Rating       int        `json:"rating"`
+	Body         string     `json:"body"`
+	Status       string     `json:"status"`
+	ModeratedAt  *time.Time `json:"moderated_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+type ReviewRequest struct {
+	Rating int    `json:"rating" binding:"required,min=1,max=5"`
+	Body   string `json:"body" binding:"max=5000"`
+}
+
+type ModerateReviewRequest struct {
+	Status string `json:"status" binding:"required,oneof=APPROVED REJECTED"`
+}
+
+// reviewStatuses lists the moderation states; new and edited reviews are
+// PENDING until an administrator approves or rejects them
+var reviewStatuses = []string{"PENDING", "APPROVED", "REJECTED"}
+
+// purchasedStatuses are the order states that count as buying the items:
+// CONFIRMED once stock is reserved, and PACKED, SHIPPED and DELIVERED as
+// fulfillment moves it on. PENDING orders may still fail, and CANCELLED and
+// FAILED ones never went through, so they do not count.
+var purchasedStatuses = []string{"CONFIRMED", "PACKED", "SHIPPED", "DELIVERED"}
+
+// reviewQuery selects reviews with their reviewer's name from r, a table or
+// CTE of review rows
+const reviewQuery = `SELECT r.id, r.product_id, r.user_id, u.name, r.rating, r.body, r.status, r.moderated_at, r.created_at, r.updated_at
+	FROM r JOIN users u ON u.id = r.user_id`
+
+func scanReview(row rowScanner) (Review, error) {
+	var review Review
+	err := row.Scan(&review.ID, &review.ProductID, &review.UserID, &review.ReviewerName, &review.Rating, &review.Body,
+		&review.Status, &review.ModeratedAt, &review.CreatedAt, &review.UpdatedAt)
+	return review, err
+}
+
+func NewReviewHandler(db *sql.DB, redis *redis.Client) *ReviewHandler {
+	return &ReviewHandler{
+		db:    db,
+		redis: redis,
+	}
+}
+
+// ListReviews returns a product's approved reviews, newest first
+func (h *ReviewHandler) ListReviews(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	productID, ok := productIDParam(c)
+	if !ok {
+		return
+	}
+
+	var exists bool
+	err := h.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM products WHERE id = $1)", productID).Scan(&exists)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Database error"))
+		return
+	}
+	if !exists {
+		c.Error(apperror.New(apperror.CodeProductNotFound, "Product not found"))
+		return
+	}
+
+	reviews, err := h.queryReviews(ctx,
+		"WITH r AS (SELECT * FROM reviews WHERE product_id = $1 AND status = 'APPROVED') "+reviewQuery+" ORDER BY r.created_at DESC, r.id DESC",
+		productID,
+	)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to fetch reviews"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    reviews,
+	})
+}
+
+// GetMyReview returns the user's review of a product, whatever its status
+func (h *ReviewHandler) GetMyReview(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	productID, ok := productIDParam(c)
+	if !ok {
+		return
+	}
+
+	review, err := scanReview(h.db.QueryRowContext(ctx,
+		"WITH r AS (SELECT * FROM reviews WHERE product_id = $1 AND user_id = $2) "+reviewQuery,
+		productID, c.GetInt("user_id"),
+	))
+	if err == sql.ErrNoRows {
+		c.Error(apperror.New(apperror.CodeReviewNotFound, "You have not reviewed this product"))
+		return
+	}
+	if err != nil {
+		c.Error(apperror.Internal(err, "Database error"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    review,
+	})
+}
+
+// CreateReview adds the user's review of a product. Only customers with an
+// order for the product in one of purchasedStatuses may review it, once; the
+// review is shown after an administrator approves it.
+func (h *ReviewHandler) CreateReview(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.GetInt("user_id")
+
+	productID, ok := productIDParam(c)
+	if !ok {
+		return
+	}
+
+	var req ReviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperror.Validation(err))
+		return
+	}
+
+	var exists, purchased bool
+	err := h.db.QueryRowContext(ctx,
+		`SELECT EXISTS(SELECT 1 FROM products WHERE id = $1),
+			EXISTS(SELECT 1 FROM orders o JOIN order_items oi ON oi.order_id = o.id
+				WHERE o.user_id = $2 AND oi.product_id = $1 AND o.status = ANY($3))`,
+		productID, userID, pq.Array(purchasedStatuses),
+	).Scan(&exists, &purchased)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Database error"))
+		return
+	}
+	if !exists {
+		c.Error(apperror.New(apperror.CodeProductNotFound, "Product not found"))
+		return
+	}
+	if !purchased {
+		c.Error(apperror.New(apperror.CodeReviewNotAllowed, "Only customers with a confirmed order for this product can review it"))
+		return
+	}
+
+	// The unique (product_id, user_id) constraint settles concurrent
+	// requests: the loser inserts nothing
+	review, err := scanReview(h.db.QueryRowContext(ctx,
+		`WITH r AS (
+			INSERT INTO reviews (product_id, user_id, rating, body) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (product_id, user_id) DO NOTHING
+			RETURNING *
+		 ) `+reviewQuery,
+		productID, userID, req.Rating, strings.TrimSpace(req.Body),
+	))
+	if err == sql.ErrNoRows {
+		c.Error(apperror.New(apperror.CodeReviewExists, "You have already reviewed this product"))
+		return
+	}
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to create review"))
+		return
+	}
+
+	logger.FromContext(ctx).Info("review created", "review_id", review.ID, "product_id", productID, "rating", review.Rating, "by", userID)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"message": "Review submitted for moderation",
+		"data":    review,
+	})
+}
+
+// UpdateMyReview changes the user's review of a product. The new text has
+// not been moderated, so the review is hidden again until it is approved.
+func (h *ReviewHandler) UpdateMyReview(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.GetInt("user_id")
+
+	productID, ok := productIDParam(c)
+	if !ok {
+		return
+	}
+
+	var req ReviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperror.Validation(err))
+		return
+	}
+
+	review, err := scanReview(h.db.QueryRowContext(ctx,
+		`WITH r AS (
+			UPDATE reviews SET rating = $1, body = $2, status = 'PENDING', moderated_by = NULL, moderated_at = NULL
+			WHERE product_id = $3 AND user_id = $4
+			RETURNING *
+		 ) `+reviewQuery,
+		req.Rating, strings.TrimSpace(req.Body), productID, userID,
+	))
+	if err == sql.ErrNoRows {
+		c.Error(apperror.New(apperror.CodeReviewNotFound, "You have not reviewed this product"))
+		return
+	}
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to update review"))
+		return
+	}
+
+	// An approved review no longer counts towards the cached rating
+	cache.Invalidate(ctx, h.redis, "product:"+strconv.Itoa(productID), "products*")
+
+	logger.FromContext(ctx).Info("review updated", "review_id", review.ID, "product_id", productID, "rating", review.Rating, "by", userID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Review updated and submitted for moderation",
+		"data":    review,
+	})
+}
+
+// DeleteMyReview removes the user's review of a product
+func (h *ReviewHandler) DeleteMyReview(c *gin.Context) {
+	ctx := c.Request.Context()
+	userID := c.GetInt("user_id")
+
+	productID, ok := productIDParam(c)
+	if !ok {
+		return
+	}
+
+	var status string
+	err := h.db.QueryRowContext(ctx,
+		"DELETE FROM reviews WHERE product_id = $1 AND user_id = $2 RETURNING status",
+		productID, userID,
+	).Scan(&status)
+	if err == sql.ErrNoRows {
+		c.Error(apperror.New(apperror.CodeReviewNotFound, "You have not reviewed this product"))
+		return
+	}
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to delete review"))
+		return
+	}
+
+	if status == "APPROVED" {
+		cache.Invalidate(ctx, h.redis, "product:"+strconv.Itoa(productID), "products*")
+	}
+
+	logger.FromContext(ctx).Info("review deleted", "product_id", productID, "by", userID)
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Review deleted",
+	})
+}
+
+// ListReviewsForModeration returns the reviews with ?status, PENDING by
+// default, oldest first
+func (h *ReviewHandler) ListReviewsForModeration(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	status := strings.ToUpper(c.DefaultQuery("status", "PENDING"))
+	if !slices.Contains(reviewStatuses, status) {
+		c.Error(apperror.InvalidField("status", "oneof", "must be one of "+strings.Join(reviewStatuses, ", ")))
+		return
+	}
+
+	reviews, err := h.queryReviews(ctx,
+		"WITH r AS (SELECT * FROM reviews WHERE status = $1) "+reviewQuery+" ORDER BY r.created_at, r.id",
+		status,
+	)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to fetch reviews"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    reviews,
+	})
+}
+
+// ModerateReview approves or rejects a review. Only approved reviews are
+// shown and counted in the product's rating.
+func (h *ReviewHandler) ModerateReview(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(apperror.Wrap(err, apperror.CodeBadRequest, "Invalid review ID"))
+		return
+	}
+
+	var req ModerateReviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperror.Validation(err))
+		return
+	}
+
+	review, err := scanReview(h.db.QueryRowContext(ctx,
+		`WITH r AS (
+			UPDATE reviews SET status = $1, moderated_by = $2, moderated_at = CURRENT_TIMESTAMP
+			WHERE id = $3
+			RETURNING *
+		 ) `+reviewQuery,
+		req.Status, c.GetInt("user_id"), id,
+	))
+	if err == sql.ErrNoRows {
+		c.Error(apperror.New(apperror.CodeReviewNotFound, "Review not found"))
+		return
+	}
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to moderate review"))
+		return
+	}
+
+	// Cached products carry the rating
+	cache.Invalidate(ctx, h.redis, "product:"+strconv.Itoa(review.ProductID), "products*")
+
+	logger.FromContext(ctx).Info("review moderated", "review_id", id, "product_id", review.ProductID, "status", req.Status, "by", c.GetInt("user_id"))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Review " + strings.ToLower(req.Status),
+		"data":    review,
+	})
+}
+
+func (h *ReviewHandler) queryReviews(ctx context.Context, query string, args ...interface{}) ([]Review, error) {
+	rows, err := h.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reviews := []Review{}
+	for rows.Next() {
+		review, err := scanReview(rows)
+		if err != nil {
+			return nil, err
+		}
+		reviews = append(reviews, review)
+	}
+	return reviews, rows.Err()
+}
+
+func productIDParam(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(apperror.Wrap(err, apperror.CodeBadRequest, "Invalid product ID"))
+		return 0, false
+	}
+	return id, true
+}
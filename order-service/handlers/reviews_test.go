@@ -0,0 +1,339 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"order-service/database"
+	"order-service/middleware"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+var reviewColumns = []string{"id", "product_id", "user_id", "name", "rating", "body", "status", "moderated_at", "created_at", "updated_at"}
+
+// reviewTest serves the review routes as user 7 against a mock database
+type reviewTest struct {
+	router *gin.Engine
+	mock   sqlmock.Sqlmock
+	redis  *miniredis.Miniredis
+}
+
+func newReviewTest(t *testing.T) *reviewTest {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	handler := NewReviewHandler(db, client)
+	router := gin.New()
+	router.Use(middleware.ErrorHandler(), func(c *gin.Context) { c.Set("user_id", 7) })
+	router.POST("/products/:id/reviews", handler.CreateReview)
+	router.PUT("/products/:id/reviews/mine", handler.UpdateMyReview)
+	router.DELETE("/products/:id/reviews/mine", handler.DeleteMyReview)
+	router.GET("/admin/reviews", handler.ListReviewsForModeration)
+	router.PUT("/admin/reviews/:id/status", handler.ModerateReview)
+
+	return &reviewTest{router: router, mock: mock, redis: server}
+}
+
+func (rt *reviewTest) do(t *testing.T, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	rt.router.ServeHTTP(rec, req)
+	return rec
+}
+
+// cached reports whether product 5 is still cached, after caching it and
+// running fn
+func (rt *reviewTest) cached(t *testing.T, fn func()) bool {
+	t.Helper()
+	rt.redis.Set("product:5", "{}")
+	fn()
+	return rt.redis.Exists("product:5")
+}
+
+func reviewRow(status string) *sqlmock.Rows {
+	now := time.Now()
+	return sqlmock.NewRows(reviewColumns).AddRow(1, 5, 7, "Buyer", 4, "Good", status, nil, now, now)
+}
+
+func TestCreateReview(t *testing.T) {
+	tests := []struct {
+		name       string
+		exists     bool
+		purchased  bool
+		duplicate  bool
+		wantStatus int
+	}{
+		{name: "verified buyer", exists: true, purchased: true, wantStatus: http.StatusCreated},
+		{name: "not bought", exists: true, wantStatus: http.StatusForbidden},
+		{name: "already reviewed", exists: true, purchased: true, duplicate: true, wantStatus: http.StatusConflict},
+		{name: "no such product", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := newReviewTest(t)
+
+			// Confirmed orders count as purchases, as do those fulfillment
+			// has moved on; pending, cancelled and failed ones do not
+			rt.mock.ExpectQuery("o.status = ANY\\(\\$3\\)").
+				WithArgs(5, 7, `{"CONFIRMED","PACKED","SHIPPED","DELIVERED"}`).
+				WillReturnRows(sqlmock.NewRows([]string{"exists", "purchased"}).AddRow(tt.exists, tt.purchased))
+			if tt.purchased {
+				insert := rt.mock.ExpectQuery("ON CONFLICT \\(product_id, user_id\\) DO NOTHING").
+					WithArgs(5, 7, 4, "Good")
+				if tt.duplicate {
+					insert.WillReturnRows(sqlmock.NewRows(reviewColumns))
+				} else {
+					insert.WillReturnRows(reviewRow("PENDING"))
+				}
+			}
+
+			rec := rt.do(t, http.MethodPost, "/products/5/reviews", `{"rating": 4, "body": " Good "}`)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if err := rt.mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestCreateReviewValidation(t *testing.T) {
+	rt := newReviewTest(t)
+	for _, body := range []string{`{"rating": 0}`, `{"rating": 6}`, `{"body": "No rating"}`} {
+		if rec := rt.do(t, http.MethodPost, "/products/5/reviews", body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", body, rec.Code)
+		}
+	}
+	if rec := rt.do(t, http.MethodPost, "/products/five/reviews", `{"rating": 4}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid product ID: status %d, want 400", rec.Code)
+	}
+	if err := rt.mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUpdateMyReview(t *testing.T) {
+	rt := newReviewTest(t)
+
+	// An edited review goes back to moderation
+	rt.mock.ExpectQuery("UPDATE reviews SET rating = \\$1, body = \\$2, status = 'PENDING', moderated_by = NULL, moderated_at = NULL").
+		WithArgs(4, "Good", 5, 7).
+		WillReturnRows(reviewRow("PENDING"))
+
+	cached := rt.cached(t, func() {
+		if rec := rt.do(t, http.MethodPut, "/products/5/reviews/mine", `{"rating": 4, "body": "Good"}`); rec.Code != http.StatusOK {
+			t.Errorf("status %d: %s", rec.Code, rec.Body)
+		}
+	})
+	if cached {
+		t.Error("the product's cached rating was kept")
+	}
+
+	rt.mock.ExpectQuery("UPDATE reviews SET").WillReturnRows(sqlmock.NewRows(reviewColumns))
+	if rec := rt.do(t, http.MethodPut, "/products/5/reviews/mine", `{"rating": 4}`); rec.Code != http.StatusNotFound {
+		t.Errorf("without a review: status %d, want 404", rec.Code)
+	}
+	if err := rt.mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDeleteMyReview(t *testing.T) {
+	tests := []struct {
+		status     string
+		wantCached bool
+	}{
+		{status: "APPROVED"},
+		// Unapproved reviews are not in the cached rating
+		{status: "PENDING", wantCached: true},
+		{status: "REJECTED", wantCached: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			rt := newReviewTest(t)
+			rt.mock.ExpectQuery("DELETE FROM reviews WHERE product_id = \\$1 AND user_id = \\$2 RETURNING status").
+				WithArgs(5, 7).
+				WillReturnRows(sqlmock.NewRows([]string{"status"}).AddRow(tt.status))
+
+			cached := rt.cached(t, func() {
+				if rec := rt.do(t, http.MethodDelete, "/products/5/reviews/mine", ""); rec.Code != http.StatusOK {
+					t.Errorf("status %d: %s", rec.Code, rec.Body)
+				}
+			})
+			if cached != tt.wantCached {
+				t.Errorf("product cached %v, want %v", cached, tt.wantCached)
+			}
+			if err := rt.mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestModerateReview(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		status     string
+		found      bool
+		wantStatus int
+	}{
+		{name: "approve", body: `{"status": "APPROVED"}`, status: "APPROVED", found: true, wantStatus: http.StatusOK},
+		{name: "reject", body: `{"status": "REJECTED"}`, status: "REJECTED", found: true, wantStatus: http.StatusOK},
+		{name: "missing", body: `{"status": "APPROVED"}`, status: "APPROVED", wantStatus: http.StatusNotFound},
+		// Reviews only go back to pending when their author edits them
+		{name: "back to pending", body: `{"status": "PENDING"}`, wantStatus: http.StatusBadRequest},
+		{name: "unknown status", body: `{"status": "HIDDEN"}`, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt := newReviewTest(t)
+			if tt.status != "" {
+				rows := sqlmock.NewRows(reviewColumns)
+				if tt.found {
+					rows = reviewRow(tt.status)
+				}
+				rt.mock.ExpectQuery("UPDATE reviews SET status = \\$1, moderated_by = \\$2, moderated_at = CURRENT_TIMESTAMP").
+					WithArgs(tt.status, 7, 1).
+					WillReturnRows(rows)
+			}
+
+			cached := rt.cached(t, func() {
+				if rec := rt.do(t, http.MethodPut, "/admin/reviews/1/status", tt.body); rec.Code != tt.wantStatus {
+					t.Errorf("status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+				}
+			})
+			// The trigger has changed the product's rating
+			if cached == tt.found {
+				t.Errorf("product cached %v after moderation", cached)
+			}
+			if err := rt.mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestListReviewsForModeration(t *testing.T) {
+	rt := newReviewTest(t)
+
+	rt.mock.ExpectQuery("SELECT \\* FROM reviews WHERE status = \\$1").
+		WithArgs("PENDING").
+		WillReturnRows(reviewRow("PENDING"))
+	if rec := rt.do(t, http.MethodGet, "/admin/reviews", ""); rec.Code != http.StatusOK {
+		t.Errorf("status %d: %s", rec.Code, rec.Body)
+	}
+
+	rt.mock.ExpectQuery("SELECT \\* FROM reviews WHERE status = \\$1").
+		WithArgs("REJECTED").
+		WillReturnRows(sqlmock.NewRows(reviewColumns))
+	if rec := rt.do(t, http.MethodGet, "/admin/reviews?status=rejected", ""); rec.Code != http.StatusOK {
+		t.Errorf("status %d: %s", rec.Code, rec.Body)
+	}
+
+	if rec := rt.do(t, http.MethodGet, "/admin/reviews?status=HIDDEN", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown status: %d, want 400", rec.Code)
+	}
+	if err := rt.mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// TestSyncProductRating checks the trigger keeping products' ratings, against
+// the database in TEST_DATABASE_URL
+func TestSyncProductRating(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := database.InitDB(db); err != nil {
+		t.Fatal(err)
+	}
+
+	var productID int
+	if err := db.QueryRow("INSERT INTO products (name, price) VALUES ('Rating Test', 100) RETURNING id").Scan(&productID); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Exec("DELETE FROM products WHERE id = $1", productID) })
+
+	review := func(rating int, status string) int {
+		t.Helper()
+		var userID, reviewID int
+		err := db.QueryRow("INSERT INTO users (name, email, password) VALUES ('Rating Test', $1, '') RETURNING id",
+			"rating-test-"+time.Now().Format("150405.000000000")+"@example.com").Scan(&userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Exec("DELETE FROM users WHERE id = $1", userID) })
+
+		err = db.QueryRow("INSERT INTO reviews (product_id, user_id, rating, status) VALUES ($1, $2, $3, $4) RETURNING id",
+			productID, userID, rating, status).Scan(&reviewID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return reviewID
+	}
+	check := func(step, wantAverage string, wantCount int) {
+		t.Helper()
+		var average string
+		var count int
+		err := db.QueryRow("SELECT rating_average::TEXT, rating_count FROM products WHERE id = $1", productID).Scan(&average, &count)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if average != wantAverage || count != wantCount {
+			t.Errorf("%s: rating %s from %d reviews, want %s from %d", step, average, count, wantAverage, wantCount)
+		}
+	}
+
+	exec := func(query string, id int) {
+		t.Helper()
+		if _, err := db.Exec(query, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	first := review(5, "APPROVED")
+	pending := review(2, "PENDING")
+	check("one approved", "5.00", 1)
+
+	review(4, "APPROVED")
+	check("two approved", "4.50", 2)
+
+	exec("UPDATE reviews SET status = 'APPROVED' WHERE id = $1", pending)
+	check("pending approved", "3.67", 3)
+
+	exec("UPDATE reviews SET status = 'REJECTED' WHERE id = $1", first)
+	check("approved rejected", "3.00", 2)
+
+	exec("DELETE FROM reviews WHERE id = $1", pending)
+	check("approved deleted", "4.00", 1)
+}
@@ -0,0 +1,390 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"math/big"
+	"net/http"
+	"order-service/apperror"
+	"order-service/logger"
+	"order-service/money"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultTaxCategory addresses the tax rate used for categories without a
+// rate of their own, in place of a category ID
+const DefaultTaxCategory = "default"
+
+// taxRateScale is the number of decimals tax percentages are stored with
+const taxRateScale = 4
+
+// TaxRateHandler manages the tax rates applied to orders
+type TaxRateHandler struct {
+	db *sql.DB
+}
+
+type TaxRate struct {
+	// CategoryID is nil for the default rate
+	CategoryID *int   `json:"category_id"`
+	Default    bool   `json:"default"`
+	Name       string `json:"name"`
+	// Rate is a percentage, e.g. "11" for PPN
+	Rate string `json:"rate"`
+	// Inclusive rates are already part of the catalog price
+	Inclusive bool      `json:"inclusive"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type SetTaxRateRequest struct {
+	Name      string `json:"name" binding:"required,max=50"`
+	Rate      string `json:"rate" binding:"required,max=16"`
+	Inclusive bool   `json:"inclusive"`
+}
+
+// ItemTax is the tax charged on an order item
+type ItemTax struct {
+	Name      string      `json:"name"`
+	Rate      string      `json:"rate"`
+	Inclusive bool        `json:"inclusive"`
+	Amount    money.Money `json:"amount"`
+}
+
+// taxRule is a tax rate ready to apply; scaled is the percentage times 10^taxRateScale
+type taxRule struct {
+	Name      string
+	Rate      string
+	Inclusive bool
+	scaled    int64
+}
+
+// taxTable holds the tax rules of categories by ID, and the default rule
+type taxTable struct {
+	categories map[int64]taxRule
+	fallback   *taxRule
+}
+
+func NewTaxRateHandler(db *sql.DB) *TaxRateHandler {
+	return &TaxRateHandler{db: db}
+}
+
+// ListTaxRates returns the configured tax rates
+func (h *TaxRateHandler) ListTaxRates(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	rows, err := h.db.QueryContext(ctx,
+		"SELECT category_id, is_default, name, rate::TEXT, inclusive, updated_at FROM tax_rates ORDER BY is_default DESC, category_id")
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to fetch tax rates"))
+		return
+	}
+	defer rows.Close()
+
+	rates := []TaxRate{}
+	for rows.Next() {
+		var rate TaxRate
+		if err := rows.Scan(&rate.CategoryID, &rate.Default, &rate.Name, &rate.Rate, &rate.Inclusive, &rate.UpdatedAt); err != nil {
+			c.Error(apperror.Internal(err, "Failed to scan tax rate"))
+			return
+		}
+		rate.Rate = trimRate(rate.Rate)
+		rates = append(rates, rate)
+	}
+
+	if err := rows.Err(); err != nil {
+		c.Error(apperror.Internal(err, "Failed to fetch tax rates"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"data":    rates,
+	})
+}
+
+// SetTaxRate creates or replaces the tax rate of a product category, or the
+// default rate. Orders already placed keep the tax they were charged.
+func (h *TaxRateHandler) SetTaxRate(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	categoryID, ok := taxCategoryParam(c)
+	if !ok {
+		return
+	}
+
+	var req SetTaxRateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperror.Validation(err))
+		return
+	}
+
+	rate, ok := new(big.Rat).SetString(req.Rate)
+	if !ok || strings.ContainsAny(req.Rate, "eE/") || rate.Sign() < 0 || rate.Cmp(big.NewRat(100, 1)) > 0 {
+		c.Error(apperror.InvalidField("rate", "decimal", "must be a percentage between 0 and 100"))
+		return
+	}
+	if !new(big.Rat).Mul(rate, new(big.Rat).SetInt(money.Pow10(taxRateScale))).IsInt() {
+		c.Error(apperror.InvalidField("rate", "decimal", "must have at most 4 decimal places"))
+		return
+	}
+
+	conflict := "(is_default) WHERE is_default"
+	if categoryID != nil {
+		var exists bool
+		err := h.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM categories WHERE id = $1)", *categoryID).Scan(&exists)
+		if err != nil {
+			c.Error(apperror.Internal(err, "Database error"))
+			return
+		}
+		if !exists {
+			c.Error(apperror.New(apperror.CodeCategoryNotFound, "Category not found"))
+			return
+		}
+		conflict = "(category_id)"
+	}
+
+	var result TaxRate
+	err := h.db.QueryRowContext(ctx,
+		`INSERT INTO tax_rates (category_id, is_default, name, rate, inclusive, updated_by) VALUES ($1, $2, $3, $4, $5, $6)
+		 ON CONFLICT `+conflict+` DO UPDATE SET name = EXCLUDED.name, rate = EXCLUDED.rate,
+			inclusive = EXCLUDED.inclusive, updated_by = EXCLUDED.updated_by
+		 RETURNING category_id, is_default, name, rate::TEXT, inclusive, updated_at`,
+		categoryID, categoryID == nil, req.Name, rate.FloatString(taxRateScale), req.Inclusive, c.GetInt("user_id"),
+	).Scan(&result.CategoryID, &result.Default, &result.Name, &result.Rate, &result.Inclusive, &result.UpdatedAt)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to save tax rate"))
+		return
+	}
+	result.Rate = trimRate(result.Rate)
+
+	logger.FromContext(ctx).Info("tax rate updated", "category_id", categoryID, "rate", result.Rate, "inclusive", result.Inclusive, "by", c.GetInt("user_id"))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Tax rate saved",
+		"data":    result,
+	})
+}
+
+// DeleteTaxRate removes a category's rate, so the default rate applies again
+func (h *TaxRateHandler) DeleteTaxRate(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	categoryID, ok := taxCategoryParam(c)
+	if !ok {
+		return
+	}
+
+	query, args := "DELETE FROM tax_rates WHERE is_default", []interface{}{}
+	if categoryID != nil {
+		query, args = "DELETE FROM tax_rates WHERE category_id = $1", []interface{}{*categoryID}
+	}
+
+	result, err := h.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to delete tax rate"))
+		return
+	}
+
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.Error(apperror.New(apperror.CodeTaxRateNotFound, "Tax rate not found"))
+		return
+	}
+
+	logger.FromContext(ctx).Info("tax rate deleted", "category_id", categoryID, "by", c.GetInt("user_id"))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Tax rate deleted",
+	})
+}
+
+// queryTaxRates reads the tax table, within a transaction when q is one
+func queryTaxRates(ctx context.Context, q queryer) (taxTable, error) {
+	rows, err := q.QueryContext(ctx, "SELECT category_id, name, rate::TEXT, inclusive FROM tax_rates")
+	if err != nil {
+		return taxTable{}, err
+	}
+	defer rows.Close()
+
+	table := taxTable{categories: map[int64]taxRule{}}
+	for rows.Next() {
+		var categoryID sql.NullInt64
+		var rule taxRule
+		if err := rows.Scan(&categoryID, &rule.Name, &rule.Rate, &rule.Inclusive); err != nil {
+			return taxTable{}, err
+		}
+
+		rate, ok := new(big.Rat).SetString(rule.Rate)
+		if !ok {
+			continue
+		}
+		rule.Rate = trimRate(rule.Rate)
+		rule.scaled = new(big.Rat).Mul(rate, new(big.Rat).SetInt(money.Pow10(taxRateScale))).Num().Int64()
+		if categoryID.Valid {
+			table.categories[categoryID.Int64] = rule
+		} else {
+			table.fallback = &rule
+		}
+	}
+	return table, rows.Err()
+}
+
+// lookup returns the rule of the nearest of a product's categories that has
+// one, given their IDs nearest first, falling back to the default rate. ok is
+// false when no tax applies at all.
+func (t taxTable) lookup(categoryIDs []int64) (taxRule, bool) {
+	for _, id := range categoryIDs {
+		if rule, ok := t.categories[id]; ok {
+			return rule, true
+		}
+	}
+	if t.fallback == nil {
+		return taxRule{}, false
+	}
+	return *t.fallback, true
+}
+
+// tax returns the tax on an amount paid. Inclusive taxes are the part of the
+// amount that is tax; exclusive taxes come on top of it. Both are rounded to
+// the nearest minor unit, halves away from zero.
+func (r taxRule) tax(amount money.Money) (money.Money, error) {
+	hundred := 100 * money.Pow10(taxRateScale).Int64()
+	if r.Inclusive {
+		return amount.MulRat(r.scaled, hundred+r.scaled)
+	}
+	return amount.MulRat(r.scaled, hundred)
+}
+
+// allocateDiscount splits an order's discount over its lines, so each line
+// is taxed on what is actually paid for it. A free item takes its whole
+// discount; other promotions are split over the lines they cover in
+// proportion to their totals, with rounding left on the last of them.
+func allocateDiscount(lines []orderLine, promotion *appliedPromotion) ([]money.Money, error) {
+	shares := make([]money.Money, len(lines))
+	for i, line := range lines {
+		shares[i] = money.Zero(line.Price.Currency)
+	}
+	if promotion == nil {
+		return shares, nil
+	}
+
+	var covered []int
+	eligible := money.Zero(promotion.Discount.Currency)
+	for i, line := range lines {
+		if promotion.FreeItem != nil {
+			if line.Free {
+				shares[i] = promotion.Discount
+				return shares, nil
+			}
+			continue
+		}
+		if !line.inCategory(promotion.CategoryID) {
+			continue
+		}
+
+		lineTotal, err := line.Price.Mul(int64(line.Quantity))
+		if err == nil {
+			eligible, err = eligible.Add(lineTotal)
+		}
+		if err != nil {
+			return nil, err
+		}
+		covered = append(covered, i)
+	}
+	if len(covered) == 0 || eligible.IsZero() {
+		return shares, nil
+	}
+
+	remaining := promotion.Discount
+	for n, i := range covered {
+		if n == len(covered)-1 {
+			shares[i] = remaining
+			break
+		}
+
+		lineTotal, err := lines[i].Price.Mul(int64(lines[i].Quantity))
+		if err == nil {
+			shares[i], err = promotion.Discount.MulRat(lineTotal.Amount, eligible.Amount)
+		}
+		if err == nil {
+			remaining, err = remaining.Sub(shares[i])
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return shares, nil
+}
+
+// orderTotals are the amounts of an order. Tax includes inclusive taxes,
+// which are already part of Subtotal.
+type orderTotals struct {
+	Subtotal money.Money
+	Tax      money.Money
+	Total    money.Money
+}
+
+// taxOrder sets each line's share of the discount and its tax, and returns
+// the order's totals, calculated exactly in minor units. Each line is taxed
+// on what is paid for it after its share of the discount; inclusive taxes
+// are already in the price, exclusive ones are added to the total.
+func taxOrder(lines []orderLine, shares []money.Money, discount money.Money, taxes taxTable) (orderTotals, error) {
+	currency := discount.Currency
+	subtotal, tax, addedTax := money.Zero(currency), money.Zero(currency), money.Zero(currency)
+	for i := range lines {
+		line := &lines[i]
+		line.Discount, line.Tax = shares[i], money.Zero(currency)
+
+		lineTotal, err := line.Price.Mul(int64(line.Quantity))
+		if err == nil {
+			subtotal, err = subtotal.Add(lineTotal)
+		}
+		if rule, ok := taxes.lookup(line.CategoryIDs); ok && err == nil {
+			line.TaxRule = &rule
+
+			var taxable money.Money
+			taxable, err = lineTotal.Sub(line.Discount)
+			if err == nil {
+				line.Tax, err = rule.tax(taxable)
+			}
+			if err == nil {
+				tax, err = tax.Add(line.Tax)
+			}
+			if err == nil && !rule.Inclusive {
+				addedTax, err = addedTax.Add(line.Tax)
+			}
+		}
+		if err != nil {
+			return orderTotals{}, err
+		}
+	}
+
+	total, err := subtotal.Sub(discount)
+	if err == nil {
+		total, err = total.Add(addedTax)
+	}
+	if err != nil {
+		return orderTotals{}, err
+	}
+	return orderTotals{Subtotal: subtotal, Tax: tax, Total: total}, nil
+}
+
+// taxCategoryParam parses the :category parameter, a category ID or
+// DefaultTaxCategory, for which it returns nil
+func taxCategoryParam(c *gin.Context) (*int, bool) {
+	param := c.Param("category")
+	if param == DefaultTaxCategory {
+		return nil, true
+	}
+
+	id, err := strconv.Atoi(param)
+	if err != nil {
+		c.Error(apperror.Wrap(err, apperror.CodeBadRequest, `Tax category must be a category ID or "default"`))
+		return nil, false
+	}
+	return &id, true
+}
@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"math"
+	"order-service/money"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// Rules as percentages scaled by 10^taxRateScale
+var (
+	ppn      = taxRule{Name: "PPN", Rate: "11", Inclusive: true, scaled: 110000}
+	luxury   = taxRule{Name: "Luxury", Rate: "20", scaled: 200000}
+	bookTax  = taxRule{Name: "Books", Rate: "5", Inclusive: true, scaled: 50000}
+	fraction = taxRule{Name: "Fraction", Rate: "2.5", scaled: 25000}
+)
+
+func TestTaxRule(t *testing.T) {
+	tests := []struct {
+		name   string
+		rule   taxRule
+		amount int64
+		want   int64
+	}{
+		{name: "exclusive", rule: luxury, amount: 1000000, want: 200000},
+		{name: "inclusive", rule: ppn, amount: 11100, want: 1100},
+		{name: "inclusive rounds up", rule: ppn, amount: 100, want: 10},    // 9.91
+		{name: "inclusive rounds down", rule: ppn, amount: 1000, want: 99}, // 99.10
+		{name: "exclusive half away from zero", rule: fraction, amount: 20, want: 1},
+		{name: "exclusive rounds down", rule: fraction, amount: 19, want: 0},                                          // 0.475
+		{name: "inclusive half away from zero", rule: taxRule{Inclusive: true, scaled: 1000000}, amount: 11, want: 6}, // 5.5
+		{name: "fractional rate", rule: taxRule{scaled: 71234}, amount: 1000000, want: 71234},
+		{name: "zero rate", rule: taxRule{Inclusive: true}, amount: 1000000, want: 0},
+		{name: "nothing paid", rule: luxury, amount: 0, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.rule.tax(idr(tt.amount))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != idr(tt.want) {
+				t.Errorf("tax on %d = %v, want %d", tt.amount, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQueryTaxRates(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	mock.ExpectQuery("SELECT category_id, name, rate::TEXT, inclusive FROM tax_rates").
+		WillReturnRows(sqlmock.NewRows([]string{"category_id", "name", "rate", "inclusive"}).
+			AddRow(nil, "PPN", "11.0000", true).
+			AddRow(books, "Books", "5.0000", true).
+			AddRow(phones, "Luxury", "20.0000", false).
+			AddRow(novels, "Fraction", "2.5000", false))
+
+	table, err := queryTaxRates(t.Context(), db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[int64]taxRule{books: bookTax, phones: luxury, novels: fraction}
+	for id, rule := range want {
+		if table.categories[id] != rule {
+			t.Errorf("category %d: %+v, want %+v", id, table.categories[id], rule)
+		}
+	}
+	if len(table.categories) != len(want) {
+		t.Errorf("%d category rules, want %d", len(table.categories), len(want))
+	}
+	if table.fallback == nil || *table.fallback != ppn {
+		t.Errorf("default rule %+v, want %+v", table.fallback, ppn)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTaxTableLookup(t *testing.T) {
+	table := taxTable{categories: map[int64]taxRule{books: bookTax, phones: luxury}, fallback: &ppn}
+	withoutDefault := taxTable{categories: table.categories}
+
+	tests := []struct {
+		name        string
+		table       taxTable
+		categoryIDs []int64
+		want        taxRule
+		wantOK      bool
+	}{
+		{name: "own category", table: table, categoryIDs: []int64{phones, electronics}, want: luxury, wantOK: true},
+		{name: "nearest ancestor", table: table, categoryIDs: []int64{novels, books}, want: bookTax, wantOK: true},
+		{name: "nearest wins", table: table, categoryIDs: []int64{phones, books}, want: luxury, wantOK: true},
+		{name: "default", table: table, categoryIDs: []int64{electronics}, want: ppn, wantOK: true},
+		{name: "uncategorized", table: table, want: ppn, wantOK: true},
+		{name: "no default", table: withoutDefault, categoryIDs: []int64{electronics}},
+		{name: "no default, own category", table: withoutDefault, categoryIDs: []int64{phones}, want: luxury, wantOK: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.table.lookup(tt.categoryIDs)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("lookup(%v) = %+v, %v, want %+v, %v", tt.categoryIDs, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestTaxOrder(t *testing.T) {
+	table := taxTable{categories: map[int64]taxRule{books: bookTax, phones: luxury}, fallback: &ppn}
+
+	tests := []struct {
+		name     string
+		table    taxTable
+		shares   []int64
+		discount int64
+		wantTax  []int64
+		want     [3]int64 // subtotal, tax, total
+	}{
+		{
+			name:    "mixed rates",
+			table:   table,
+			shares:  []int64{0, 0, 0},
+			wantTax: []int64{200000, 4762, 1100},
+			// Only the exclusive luxury tax is added to the total
+			want: [3]int64{1111100, 205862, 1311100},
+		},
+		{
+			name:     "taxed after the discount",
+			table:    table,
+			shares:   []int64{10000, 1000, 110},
+			discount: 11110,
+			wantTax:  []int64{198000, 4714, 1089},
+			want:     [3]int64{1111100, 203803, 1297990},
+		},
+		{
+			name:    "untaxed without a default",
+			table:   taxTable{categories: table.categories},
+			shares:  []int64{0, 0, 0},
+			wantTax: []int64{200000, 4762, 0},
+			want:    [3]int64{1111100, 204762, 1311100},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := []orderLine{
+				line(1000000, 1, phones, electronics),
+				line(50000, 2, novels, books),
+				line(11100, 1),
+			}
+			shares := make([]money.Money, len(tt.shares))
+			for i, share := range tt.shares {
+				shares[i] = idr(share)
+			}
+
+			totals, err := taxOrder(lines, shares, idr(tt.discount), tt.table)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := [3]int64{totals.Subtotal.Amount, totals.Tax.Amount, totals.Total.Amount}
+			if got != tt.want {
+				t.Errorf("subtotal, tax, total = %v, want %v", got, tt.want)
+			}
+			for i, line := range lines {
+				if line.Tax != idr(tt.wantTax[i]) || line.Discount != shares[i] {
+					t.Errorf("line %d: tax %v, discount %v, want %d, %v", i, line.Tax, line.Discount, tt.wantTax[i], shares[i])
+				}
+				if (line.TaxRule != nil) != (tt.wantTax[i] != 0) {
+					t.Errorf("line %d: rule %+v", i, line.TaxRule)
+				}
+			}
+		})
+	}
+}
+
+func TestTaxOrderOverflow(t *testing.T) {
+	lines := []orderLine{line(math.MaxInt64/2+1, 2)}
+	_, err := taxOrder(lines, []money.Money{idr(0)}, idr(0), taxTable{})
+	if err == nil {
+		t.Error("overflowing total accepted")
+	}
+}
@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"order-service/apperror"
+	"order-service/cache"
+	"order-service/logger"
+	"order-service/money"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Variant is a purchasable version of a product, e.g. a size or colour,
+// with its own SKU and stock
+type Variant struct {
+	ID         int               `json:"id"`
+	ProductID  int               `json:"product_id"`
+	SKU        string            `json:"sku"`
+	Attributes map[string]string `json:"attributes"`
+	// Price is the variant's own price, or the product's when it has none
+	Price money.Money `json:"price"`
+	// BasePrice is the catalog price when Price was converted to another currency
+	BasePrice *money.Money `json:"base_price,omitempty"`
+	// PriceOverride is set when the variant has its own price
+	PriceOverride bool      `json:"price_override"`
+	Stock         int       `json:"stock"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+type VariantRequest struct {
+	SKU        string            `json:"sku" binding:"required,max=64"`
+	Attributes map[string]string `json:"attributes" binding:"max=20"`
+	// Price overrides the product's price, in the product's currency; omit it
+	// to use the product's price
+	Price *money.Money `json:"price"`
+	Stock int          `json:"stock" binding:"min=0"`
+}
+
+const variantColumns = `v.id, v.product_id, v.sku, v.attributes, COALESCE(v.price, p.price), p.currency, v.price IS NOT NULL,
+	v.stock, v.created_at, v.updated_at`
+
+func scanVariant(row rowScanner) (Variant, error) {
+	var v Variant
+	var attributes []byte
+	err := row.Scan(&v.ID, &v.ProductID, &v.SKU, &attributes, &v.Price.Amount, &v.Price.Currency, &v.PriceOverride,
+		&v.Stock, &v.CreatedAt, &v.UpdatedAt)
+	if err != nil {
+		return v, err
+	}
+
+	v.Attributes = map[string]string{}
+	if err := json.Unmarshal(attributes, &v.Attributes); err != nil {
+		return v, err
+	}
+	return v, nil
+}
+
+// loadVariants returns the variants of a product in the order they were added
+func loadVariants(ctx context.Context, q queryer, productID int) ([]Variant, error) {
+	rows, err := q.QueryContext(ctx,
+		"SELECT "+variantColumns+" FROM product_variants v JOIN products p ON p.id = v.product_id WHERE v.product_id = $1 ORDER BY v.id",
+		productID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	variants := []Variant{}
+	for rows.Next() {
+		variant, err := scanVariant(rows)
+		if err != nil {
+			return nil, err
+		}
+		variants = append(variants, variant)
+	}
+	return variants, rows.Err()
+}
+
+// CreateVariant adds a variant to a product
+func (h *ProductHandler) CreateVariant(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	productID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(apperror.Wrap(err, apperror.CodeBadRequest, "Invalid product ID"))
+		return
+	}
+
+	var currency string
+	err = h.db.QueryRowContext(ctx, "SELECT currency FROM products WHERE id = $1", productID).Scan(&currency)
+	if err == sql.ErrNoRows {
+		c.Error(apperror.New(apperror.CodeProductNotFound, "Product not found"))
+		return
+	}
+
+	if err != nil {
+		c.Error(apperror.Internal(err, "Database error"))
+		return
+	}
+
+	req, attributes, price, ok := h.bindVariant(c, currency, 0)
+	if !ok {
+		return
+	}
+
+	variant, err := scanVariant(h.db.QueryRowContext(ctx,
+		`WITH v AS (
+			INSERT INTO product_variants (product_id, sku, attributes, price, stock) VALUES ($1, $2, $3, $4, $5)
+			RETURNING *
+		 )
+		 SELECT `+variantColumns+` FROM v JOIN products p ON p.id = v.product_id`,
+		productID, req.SKU, attributes, price, req.Stock,
+	))
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to create variant"))
+		return
+	}
+
+	h.invalidateProduct(ctx, productID)
+
+	logger.FromContext(ctx).Info("variant created", "product_id", productID, "variant_id", variant.ID, "sku", variant.SKU, "by", c.GetInt("user_id"))
+
+	c.JSON(http.StatusCreated, gin.H{
+		"success": true,
+		"message": "Variant created successfully",
+		"data":    variant,
+	})
+}
+
+// UpdateVariant replaces a variant's SKU, attributes, price and stock
+func (h *ProductHandler) UpdateVariant(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	variantID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.Error(apperror.Wrap(err, apperror.CodeBadRequest, "Invalid variant ID"))
+		return
+	}
+
+	var productID int
+	var currency string
+	err = h.db.QueryRowContext(ctx,
+		"SELECT p.id, p.currency FROM product_variants v JOIN products p ON p.id = v.product_id WHERE v.id = $1",
+		variantID,
+	).Scan(&productID, &currency)
+	if err == sql.ErrNoRows {
+		c.Error(apperror.New(apperror.CodeVariantNotFound, "Variant not found"))
+		return
+	}
+
+	if err != nil {
+		c.Error(apperror.Internal(err, "Database error"))
+		return
+	}
+
+	req, attributes, price, ok := h.bindVariant(c, currency, variantID)
+	if !ok {
+		return
+	}
+
+	variant, err := scanVariant(h.db.QueryRowContext(ctx,
+		`WITH v AS (
+			UPDATE product_variants SET sku = $1, attributes = $2, price = $3, stock = $4 WHERE id = $5
+			RETURNING *
+		 )
+		 SELECT `+variantColumns+` FROM v JOIN products p ON p.id = v.product_id`,
+		req.SKU, attributes, price, req.Stock, variantID,
+	))
+	if err == sql.ErrNoRows {
+		c.Error(apperror.New(apperror.CodeVariantNotFound, "Variant not found"))
+		return
+	}
+
+	if err != nil {
+		c.Error(apperror.Internal(err, "Failed to update variant"))
+		return
+	}
+
+	h.invalidateProduct(ctx, productID)
+
+	logger.FromContext(ctx).Info("variant updated", "product_id", productID, "variant_id", variantID, "by", c.GetInt("user_id"))
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "Variant updated successfully",
+		"data":    variant,
+	})
+}
+
+// bindVariant validates a variant request for a product priced in currency,
+// returning the attributes as JSON and the price override or nil. id is the
+// variant being updated, or 0.
+func (h *ProductHandler) bindVariant(c *gin.Context, currency string, id int) (VariantRequest, []byte, interface{}, bool) {
+	ctx := c.Request.Context()
+
+	var req VariantRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apperror.Validation(err))
+		return req, nil, nil, false
+	}
+
+	var price interface{}
+	if req.Price != nil {
+		if req.Price.Currency != currency {
+			c.Error(apperror.InvalidField("price", "currency", "must be in the product's currency, "+currency))
+			return req, nil, nil, false
+		}
+		if req.Price.IsNegative() {
+			c.Error(apperror.InvalidField("price", "min", "must not be negative"))
+			return req, nil, nil, false
+		}
+		price = req.Price.Amount
+	}
+
+	if req.Attributes == nil {
+		req.Attributes = map[string]string{}
+	}
+	attributes, _ := json.Marshal(req.Attributes)
+
+	var exists bool
+	err := h.db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM product_variants WHERE sku = $1 AND id <> $2)", req.SKU, id).Scan(&exists)
+	if err != nil {
+		c.Error(apperror.Internal(err, "Database error"))
+		return req, nil, nil, false
+	}
+	if exists {
+		c.Error(apperror.New(apperror.CodeSKUTaken, "SKU already exists"))
+		return req, nil, nil, false
+	}
+
+	return req, attributes, price, true
+}
+
+// invalidateProduct drops the cached product and every cached listing, which
+// may include it
+func (h *ProductHandler) invalidateProduct(ctx context.Context, productID int) {
+	cache.Invalidate(ctx, h.redis, "product:"+strconv.Itoa(productID), "products*")
+}
@@ -0,0 +1,386 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"order-service/apperror"
+	"order-service/database"
+	"order-service/middleware"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+var variantRowColumns = []string{"id", "product_id", "sku", "attributes", "price", "currency", "price_override", "stock", "created_at", "updated_at"}
+
+// variantTest serves the variant and order routes as user 7 against a mock
+// database
+type variantTest struct {
+	router *gin.Engine
+	mock   sqlmock.Sqlmock
+	redis  *miniredis.Miniredis
+}
+
+func newVariantTest(t *testing.T) *variantTest {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	products := NewProductHandler(db, client, NewExchangeRateHandler(db, client), nil)
+	orders := NewOrderHandler(db, nil, false)
+	router := gin.New()
+	router.Use(middleware.ErrorHandler(), func(c *gin.Context) { c.Set("user_id", 7) })
+	router.POST("/admin/products/:id/variants", products.CreateVariant)
+	router.PUT("/admin/variants/:id", products.UpdateVariant)
+	router.POST("/orders", orders.CreateOrder)
+
+	return &variantTest{router: router, mock: mock, redis: server}
+}
+
+func (vt *variantTest) do(t *testing.T, method, path, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	vt.router.ServeHTTP(rec, req)
+	return rec
+}
+
+func variantRow(price int64, override bool) *sqlmock.Rows {
+	now := time.Now()
+	return sqlmock.NewRows(variantRowColumns).AddRow(12, 5, "TEE-RED-M", `{"colour":"red","size":"M"}`, price, "IDR", override, 4, now, now)
+}
+
+func TestSingleVariant(t *testing.T) {
+	tests := []struct {
+		name    string
+		count   int
+		want    int
+		wantErr string
+	}{
+		{name: "one variant", count: 1, want: 12},
+		{name: "no variants", count: 0, wantErr: "Product ID 5 not found"},
+		{name: "several variants", count: 3, wantErr: "Product ID 5 has several variants; choose a variant_id"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer db.Close()
+
+			mock.ExpectBegin()
+			minID := 0
+			if tt.count > 0 {
+				minID = 12
+			}
+			mock.ExpectQuery("SELECT COUNT\\(\\*\\), COALESCE\\(MIN\\(id\\), 0\\) FROM product_variants WHERE product_id = \\$1").
+				WithArgs(5).
+				WillReturnRows(sqlmock.NewRows([]string{"count", "min"}).AddRow(tt.count, minID))
+
+			tx, err := db.Begin()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := singleVariant(t.Context(), tx, 5)
+
+			if tt.wantErr == "" {
+				if err != nil || got != tt.want {
+					t.Errorf("variant %d, %v; want %d", got, err, tt.want)
+				}
+				return
+			}
+			var appErr *apperror.Error
+			if !errors.As(err, &appErr) || appErr.Code != apperror.CodeInvalidOrderItem || appErr.Message != tt.wantErr {
+				t.Errorf("error = %v, want INVALID_ORDER_ITEM %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCreateOrderVariants(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		// variants is how many variants product 5 has, when ordered by product
+		variants   int
+		wantStatus int
+		wantError  string
+	}{
+		{
+			name:       "by variant",
+			body:       `{"items": [{"variant_id": 12, "quantity": 2}]}`,
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name:       "by product with a single variant",
+			body:       `{"items": [{"product_id": 5, "quantity": 2}]}`,
+			variants:   1,
+			wantStatus: http.StatusInternalServerError,
+		},
+		{
+			name:       "by product with several variants",
+			body:       `{"items": [{"product_id": 5, "quantity": 2}]}`,
+			variants:   2,
+			wantStatus: http.StatusBadRequest,
+			wantError:  "has several variants",
+		},
+		{
+			name:       "variant of another product",
+			body:       `{"items": [{"product_id": 6, "variant_id": 12, "quantity": 2}]}`,
+			wantStatus: http.StatusBadRequest,
+			wantError:  "Variant ID 12 not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vt := newVariantTest(t)
+			vt.mock.ExpectQuery("FROM addresses WHERE user_id = \\$1 AND is_default").
+				WithArgs(7).
+				WillReturnError(sql.ErrNoRows)
+			vt.mock.ExpectBegin()
+			if tt.variants > 0 {
+				vt.mock.ExpectQuery("SELECT COUNT\\(\\*\\)").
+					WithArgs(5).
+					WillReturnRows(sqlmock.NewRows([]string{"count", "min"}).AddRow(tt.variants, 12))
+			}
+
+			if tt.variants < 2 {
+				// Variant 12 of product 5 overrides the product's price
+				vt.mock.ExpectQuery("FROM product_variants v JOIN products p ON p.id = v.product_id WHERE v.id = \\$1").
+					WithArgs(12).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "categories", "price", "currency"}).AddRow(5, "{}", 17500000, "IDR"))
+			}
+
+			if tt.wantError == "" {
+				vt.mock.ExpectQuery("FROM tax_rates").
+					WillReturnRows(sqlmock.NewRows([]string{"category_id", "name", "rate", "inclusive"}))
+				vt.mock.ExpectQuery("INSERT INTO orders").
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(100))
+				vt.mock.ExpectExec("INSERT INTO order_items").
+					WithArgs(100, 5, 12, 2, int64(17500000), int64(0), nil, nil, false, int64(0)).
+					WillReturnResult(sqlmock.NewResult(1, 1))
+				// Stop before publishing, which needs a broker
+				vt.mock.ExpectCommit().WillReturnError(errors.New("connection reset"))
+			} else {
+				vt.mock.ExpectRollback()
+			}
+
+			rec := vt.do(t, http.MethodPost, "/orders", tt.body)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantError != "" && !strings.Contains(rec.Body.String(), tt.wantError) {
+				t.Errorf("body %s, want %q", rec.Body, tt.wantError)
+			}
+			if err := vt.mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestCreateVariant(t *testing.T) {
+	vt := newVariantTest(t)
+	vt.redis.Set("product:5", "{}")
+	vt.redis.Set("products:page=1", "[]")
+
+	vt.mock.ExpectQuery("SELECT currency FROM products WHERE id = \\$1").
+		WithArgs(5).
+		WillReturnRows(sqlmock.NewRows([]string{"currency"}).AddRow("IDR"))
+	vt.mock.ExpectQuery("SELECT EXISTS\\(SELECT 1 FROM product_variants WHERE sku = \\$1 AND id <> \\$2\\)").
+		WithArgs("TEE-RED-M", 0).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	vt.mock.ExpectQuery("INSERT INTO product_variants").
+		WithArgs(5, "TEE-RED-M", []byte(`{"colour":"red","size":"M"}`), int64(17500000), 4).
+		WillReturnRows(variantRow(17500000, true))
+
+	rec := vt.do(t, http.MethodPost, "/admin/products/5/variants",
+		`{"sku": "TEE-RED-M", "attributes": {"colour": "red", "size": "M"}, "price": {"amount": "175000.00", "currency": "IDR"}, "stock": 4}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if !strings.Contains(rec.Body.String(), `"price_override":true`) {
+		t.Errorf("body %s", rec.Body)
+	}
+	if vt.redis.Exists("product:5") || vt.redis.Exists("products:page=1") {
+		t.Error("the cached product and listings were kept")
+	}
+	if err := vt.mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUpdateVariant(t *testing.T) {
+	vt := newVariantTest(t)
+	vt.redis.Set("product:5", "{}")
+
+	vt.mock.ExpectQuery("SELECT p.id, p.currency FROM product_variants v JOIN products p").
+		WithArgs(12).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "currency"}).AddRow(5, "IDR"))
+	vt.mock.ExpectQuery("SELECT EXISTS").
+		WithArgs("TEE-RED-M", 12).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	// Without a price the variant goes back to the product's
+	vt.mock.ExpectQuery("UPDATE product_variants SET sku = \\$1, attributes = \\$2, price = \\$3, stock = \\$4 WHERE id = \\$5").
+		WithArgs("TEE-RED-M", []byte(`{}`), nil, 9, 12).
+		WillReturnRows(variantRow(15000000, false))
+
+	rec := vt.do(t, http.MethodPut, "/admin/variants/12", `{"sku": "TEE-RED-M", "stock": 9}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if vt.redis.Exists("product:5") {
+		t.Error("the cached product was kept")
+	}
+	if err := vt.mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUpdateVariantRejects(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		found      bool
+		skuTaken   bool
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "unknown variant", body: `{"sku": "TEE-RED-M"}`, wantStatus: http.StatusNotFound, wantCode: "VARIANT_NOT_FOUND"},
+		{
+			name:       "price in another currency",
+			body:       `{"sku": "TEE-RED-M", "price": {"amount": "10.00", "currency": "USD"}}`,
+			found:      true,
+			wantStatus: http.StatusBadRequest,
+			wantCode:   "VALIDATION_ERROR",
+		},
+		{
+			name:       "negative price",
+			body:       `{"sku": "TEE-RED-M", "price": {"amount": "-1.00", "currency": "IDR"}}`,
+			found:      true,
+			wantStatus: http.StatusBadRequest,
+			wantCode:   "VALIDATION_ERROR",
+		},
+		{name: "negative stock", body: `{"sku": "TEE-RED-M", "stock": -1}`, found: true, wantStatus: http.StatusBadRequest, wantCode: "VALIDATION_ERROR"},
+		{name: "SKU taken", body: `{"sku": "TEE-RED-L"}`, found: true, skuTaken: true, wantStatus: http.StatusConflict, wantCode: "SKU_TAKEN"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vt := newVariantTest(t)
+			lookup := vt.mock.ExpectQuery("SELECT p.id, p.currency FROM product_variants v JOIN products p").WithArgs(12)
+			if !tt.found {
+				lookup.WillReturnError(sql.ErrNoRows)
+			} else {
+				lookup.WillReturnRows(sqlmock.NewRows([]string{"id", "currency"}).AddRow(5, "IDR"))
+			}
+			if tt.skuTaken {
+				vt.mock.ExpectQuery("SELECT EXISTS").
+					WithArgs("TEE-RED-L", 12).
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+			}
+
+			rec := vt.do(t, http.MethodPut, "/admin/variants/12", tt.body)
+			if rec.Code != tt.wantStatus || !strings.Contains(rec.Body.String(), `"code":"`+tt.wantCode+`"`) {
+				t.Errorf("status %d, want %d with %s: %s", rec.Code, tt.wantStatus, tt.wantCode, rec.Body)
+			}
+			if err := vt.mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// TestSyncProductStock checks the trigger keeping products' stock the sum of
+// their variants', against the database in TEST_DATABASE_URL
+func TestSyncProductStock(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := database.InitDB(db); err != nil {
+		t.Fatal(err)
+	}
+
+	product := func() int {
+		t.Helper()
+		var id int
+		if err := db.QueryRow("INSERT INTO products (name, price) VALUES ('Stock Test', 100) RETURNING id").Scan(&id); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			db.Exec("DELETE FROM product_variants WHERE product_id = $1", id)
+			db.Exec("DELETE FROM products WHERE id = $1", id)
+		})
+		return id
+	}
+	first, second := product(), product()
+
+	suffix := time.Now().Format("150405.000000000")
+	variant := func(productID, stock int, sku string) int {
+		t.Helper()
+		var id int
+		err := db.QueryRow("INSERT INTO product_variants (product_id, sku, stock) VALUES ($1, $2, $3) RETURNING id",
+			productID, "stock-test-"+sku+"-"+suffix, stock).Scan(&id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+	check := func(step string, want map[int]int) {
+		t.Helper()
+		for id, wantStock := range want {
+			var stock int
+			if err := db.QueryRow("SELECT stock FROM products WHERE id = $1", id).Scan(&stock); err != nil {
+				t.Fatal(err)
+			}
+			if stock != wantStock {
+				t.Errorf("%s: product %d has %d in stock, want %d", step, id, stock, wantStock)
+			}
+		}
+	}
+	exec := func(query string, args ...interface{}) {
+		t.Helper()
+		if _, err := db.Exec(query, args...); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	small := variant(first, 3, "s")
+	medium := variant(first, 5, "m")
+	check("variants added", map[int]int{first: 8, second: 0})
+
+	exec("UPDATE product_variants SET stock = stock - 2 WHERE id = $1", medium)
+	check("stock reserved", map[int]int{first: 6})
+
+	exec("UPDATE product_variants SET product_id = $1 WHERE id = $2", second, small)
+	check("variant moved", map[int]int{first: 3, second: 3})
+
+	exec("DELETE FROM product_variants WHERE id = $1", medium)
+	check("variant deleted", map[int]int{first: 0, second: 3})
+}
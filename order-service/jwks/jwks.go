@@ -0,0 +1,214 @@
+package jwks
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// Algorithms of the keys auth-service signs with
+const (
+	AlgorithmEdDSA = "EdDSA"
+	AlgorithmRS256 = "RS256"
+)
+
+// minRefresh bounds how often an unknown kid forces a refetch, so tokens with
+// made-up kids cannot flood auth-service
+const minRefresh = 30 * time.Second
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+type jwk struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+}
+
+type publicKey struct {
+	algorithm string
+	key       interface{}
+}
+
+// Client fetches auth-service's JSON Web Key Set and caches it for ttl.
+// auth-service publishes the next key well before it signs, so a cache no
+// older than the set's max-age already holds it; keys are still refetched
+// early when a token names a kid the cache does not know.
+type Client struct {
+	url        string
+	ttl        time.Duration
+	httpClient *http.Client
+
+	mu        sync.RWMutex
+	keys      map[string]publicKey
+	fetchedAt time.Time
+
+	// refreshMu lets one goroutine fetch while others wait for its result
+	refreshMu sync.Mutex
+}
+
+func NewClient(url string, ttl time.Duration) *Client {
+	return &Client{
+		url: url,
+		ttl: ttl,
+		httpClient: &http.Client{
+			Timeout:   5 * time.Second,
+			Transport: otelhttp.NewTransport(http.DefaultTransport),
+		},
+		keys: make(map[string]publicKey),
+	}
+}
+
+// Refresh fetches the key set and replaces the cache
+func (c *Client) Refresh(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch JWKS: unexpected status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("failed to decode JWKS: %w", err)
+	}
+
+	keys := make(map[string]publicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		key, err := k.publicKey()
+		if err != nil {
+			slog.Warn("skipping unusable JWK", "kid", k.KeyID, "error", err)
+			continue
+		}
+		keys[k.KeyID] = publicKey{algorithm: k.Algorithm, key: key}
+	}
+
+	c.mu.Lock()
+	c.keys = keys
+	c.fetchedAt = time.Now()
+	c.mu.Unlock()
+
+	slog.Debug("JWKS refreshed", "keys", len(keys))
+	return nil
+}
+
+// Ready reports an error when no keys are cached and none can be fetched
+func (c *Client) Ready(ctx context.Context) error {
+	c.mu.RLock()
+	cached := len(c.keys)
+	c.mu.RUnlock()
+
+	if cached > 0 {
+		return nil
+	}
+	return c.Refresh(ctx)
+}
+
+// Keyfunc resolves the public key for a token by its kid header
+func (c *Client) Keyfunc(token *jwt.Token) (interface{}, error) {
+	id, _ := token.Header["kid"].(string)
+
+	key, ok, fresh := c.lookup(id)
+	if !ok || !fresh {
+		c.refresh(!ok)
+		key, ok, _ = c.lookup(id)
+	}
+
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if token.Method.Alg() != key.algorithm {
+		return nil, fmt.Errorf("unexpected signing algorithm %s", token.Method.Alg())
+	}
+	return key.key, nil
+}
+
+// lookup returns the cached key and whether the cache is within its ttl
+func (c *Client) lookup(id string) (publicKey, bool, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	key, ok := c.keys[id]
+	return key, ok, time.Since(c.fetchedAt) < c.ttl
+}
+
+// refresh refetches the key set unless another caller just did. A stale
+// cache is kept when the fetch fails so auth-service outages do not reject
+// tokens signed by known keys.
+func (c *Client) refresh(unknownKid bool) {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+
+	c.mu.RLock()
+	age := time.Since(c.fetchedAt)
+	c.mu.RUnlock()
+
+	if age < minRefresh || (!unknownKid && age < c.ttl) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := c.Refresh(ctx); err != nil {
+		slog.Error("failed to refresh JWKS", "error", err)
+	}
+}
+
+func (k jwk) publicKey() (interface{}, error) {
+	switch k.KeyType {
+	case "OKP":
+		if k.Curve != "Ed25519" || k.Algorithm != AlgorithmEdDSA {
+			return nil, fmt.Errorf("unsupported OKP key %s/%s", k.Curve, k.Algorithm)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid Ed25519 public key")
+		}
+		return ed25519.PublicKey(x), nil
+
+	case "RSA":
+		if k.Algorithm != AlgorithmRS256 {
+			return nil, fmt.Errorf("unsupported RSA algorithm %s", k.Algorithm)
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA modulus")
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", k.KeyType)
+	}
+}
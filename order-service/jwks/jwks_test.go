@@ -0,0 +1,248 @@
+package jwks
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// keyServer serves a JWKS of its keys, counting fetches, or fails while down
+type keyServer struct {
+	mu      sync.Mutex
+	keys    []jwk
+	fetches int
+	down    bool
+}
+
+func newKeyServer(t *testing.T) (*keyServer, *Client) {
+	t.Helper()
+	server := &keyServer{}
+	ts := httptest.NewServer(server)
+	t.Cleanup(ts.Close)
+	return server, NewClient(ts.URL, 5*time.Minute)
+}
+
+func (s *keyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.fetches++
+	if s.down {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string][]jwk{"keys": s.keys})
+}
+
+func (s *keyServer) publish(k jwk) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = append(s.keys, k)
+}
+
+func (s *keyServer) setDown(down bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.down = down
+}
+
+func (s *keyServer) fetchCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fetches
+}
+
+// signer is a key pair as auth-service would publish and sign with it
+type signer struct {
+	jwk     jwk
+	method  jwt.SigningMethod
+	private crypto.Signer
+}
+
+func newSigner(t *testing.T, kid, algorithm string) signer {
+	t.Helper()
+	if algorithm == AlgorithmRS256 {
+		private, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signer{
+			jwk: jwk{
+				KeyType: "RSA", KeyID: kid, Algorithm: AlgorithmRS256,
+				N: base64.RawURLEncoding.EncodeToString(private.N.Bytes()),
+				E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(private.E)).Bytes()),
+			},
+			method:  jwt.SigningMethodRS256,
+			private: private,
+		}
+	}
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer{
+		jwk:     jwk{KeyType: "OKP", KeyID: kid, Algorithm: AlgorithmEdDSA, Curve: "Ed25519", X: base64.RawURLEncoding.EncodeToString(public)},
+		method:  jwt.SigningMethodEdDSA,
+		private: private,
+	}
+}
+
+func (s signer) sign(t *testing.T) string {
+	t.Helper()
+	token := jwt.NewWithClaims(s.method, jwt.RegisteredClaims{Subject: "7"})
+	token.Header["kid"] = s.jwk.KeyID
+	signed, err := token.SignedString(s.private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func parse(c *Client, signed string) error {
+	_, err := jwt.Parse(signed, c.Keyfunc, jwt.WithValidMethods([]string{AlgorithmEdDSA, AlgorithmRS256}))
+	return err
+}
+
+func TestKeyfunc(t *testing.T) {
+	for _, algorithm := range []string{AlgorithmEdDSA, AlgorithmRS256} {
+		t.Run(algorithm, func(t *testing.T) {
+			server, client := newKeyServer(t)
+			key := newSigner(t, "k1", algorithm)
+			server.publish(key.jwk)
+
+			if err := client.Refresh(t.Context()); err != nil {
+				t.Fatal(err)
+			}
+			if err := parse(client, key.sign(t)); err != nil {
+				t.Error(err)
+			}
+
+			// A token signed by another key under the same kid fails
+			forged := newSigner(t, "k1", algorithm)
+			if err := parse(client, forged.sign(t)); err == nil {
+				t.Error("token from another key was accepted")
+			}
+		})
+	}
+}
+
+func TestKeyfuncRejectsOtherAlgorithm(t *testing.T) {
+	server, client := newKeyServer(t)
+	server.publish(newSigner(t, "ed", AlgorithmEdDSA).jwk)
+	if err := client.Refresh(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	token := jwt.New(jwt.SigningMethodRS256)
+	token.Header["kid"] = "ed"
+	if _, err := client.Keyfunc(token); err == nil {
+		t.Error("an RS256 token was accepted for an EdDSA key")
+	}
+}
+
+func TestKeyfuncRefetchesUnknownKid(t *testing.T) {
+	server, client := newKeyServer(t)
+	server.publish(newSigner(t, "old", AlgorithmEdDSA).jwk)
+	if err := client.Refresh(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	// auth-service rotates
+	rotated := newSigner(t, "new", AlgorithmEdDSA)
+	server.publish(rotated.jwk)
+	signed := rotated.sign(t)
+
+	// Right after a fetch, unknown kids do not refetch
+	if err := parse(client, signed); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("error = %v, want ErrUnknownKey", err)
+	}
+	if n := server.fetchCount(); n != 1 {
+		t.Errorf("%d fetches within the throttle window, want 1", n)
+	}
+
+	client.fetchedAt = time.Now().Add(-minRefresh)
+	if err := parse(client, signed); err != nil {
+		t.Errorf("token from the rotated key: %v", err)
+	}
+	if n := server.fetchCount(); n != 2 {
+		t.Errorf("%d fetches, want 2", n)
+	}
+}
+
+func TestKeyfuncKeepsStaleKeys(t *testing.T) {
+	server, client := newKeyServer(t)
+	key := newSigner(t, "k1", AlgorithmRS256)
+	server.publish(key.jwk)
+	if err := client.Refresh(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	// The cache expires while auth-service is down
+	server.setDown(true)
+	client.fetchedAt = time.Now().Add(-client.ttl)
+
+	if err := parse(client, key.sign(t)); err != nil {
+		t.Errorf("known key rejected while auth-service is down: %v", err)
+	}
+	if n := server.fetchCount(); n != 2 {
+		t.Errorf("%d fetches, want a refetch of the expired cache", n)
+	}
+	if err := client.Ready(t.Context()); err != nil {
+		t.Errorf("not ready with cached keys: %v", err)
+	}
+}
+
+func TestReady(t *testing.T) {
+	server, client := newKeyServer(t)
+	server.setDown(true)
+	if err := client.Ready(t.Context()); err == nil {
+		t.Error("ready without keys")
+	}
+
+	server.setDown(false)
+	server.publish(newSigner(t, "k1", AlgorithmEdDSA).jwk)
+	if err := client.Ready(t.Context()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRefreshSkipsUnusableKeys(t *testing.T) {
+	server, client := newKeyServer(t)
+	good := newSigner(t, "good", AlgorithmEdDSA)
+	server.publish(good.jwk)
+
+	ed := good.jwk
+	rs := newSigner(t, "rsa", AlgorithmRS256).jwk
+	for _, bad := range []jwk{
+		{KeyType: "oct", KeyID: "symmetric", Algorithm: "HS256"},
+		{KeyType: "OKP", KeyID: "curve", Algorithm: AlgorithmEdDSA, Curve: "X25519", X: ed.X},
+		{KeyType: "OKP", KeyID: "short", Algorithm: AlgorithmEdDSA, Curve: "Ed25519", X: ed.X[:10]},
+		{KeyType: "RSA", KeyID: "pss", Algorithm: "PS256", N: rs.N, E: rs.E},
+		{KeyType: "RSA", KeyID: "exponent", Algorithm: AlgorithmRS256, N: rs.N, E: ""},
+	} {
+		server.publish(bad)
+	}
+
+	if err := client.Refresh(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.keys) != 1 {
+		t.Errorf("cached %d keys, want only the usable one", len(client.keys))
+	}
+	if err := parse(client, good.sign(t)); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,72 @@
+package logger
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+type contextKey struct{}
+
+// Init installs a JSON slog logger as the process default.
+// The level is read from LOG_LEVEL (debug, info, warn, error) and defaults to info.
+func Init(service string) {
+	var level slog.Level
+	switch strings.ToLower(os.Getenv("LOG_LEVEL")) {
+	case "debug":
+		level = slog.LevelDebug
+	case "warn":
+		level = slog.LevelWarn
+	case "error":
+		level = slog.LevelError
+	default:
+		level = slog.LevelInfo
+	}
+
+	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level})
+	slog.SetDefault(slog.New(handler).With("service", service))
+}
+
+// Fatal logs at error level and exits the process
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// NewID generates a random identifier for requests and messages
+func NewID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// WithCorrelationID stores the correlation ID in the context
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// CorrelationID returns the correlation ID stored in the context, if any
+func CorrelationID(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// FromContext returns the default logger annotated with the correlation ID
+// and, when a span is active, the trace ID
+func FromContext(ctx context.Context) *slog.Logger {
+	l := slog.Default()
+	if id := CorrelationID(ctx); id != "" {
+		l = l.With("correlation_id", id)
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		l = l.With("trace_id", sc.TraceID().String())
+	}
+	return l
+}
@@ -2,42 +2,65 @@ package main
 
 import (
 	"context"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"order-service/apperror"
 	"order-service/cache"
+	"order-service/consumers"
 	"order-service/database"
 	"order-service/handlers"
+	"order-service/health"
+	"order-service/jwks"
+	"order-service/logger"
+	"order-service/media"
+	"order-service/metrics"
 	"order-service/middleware"
 	"order-service/rabbitmq"
+	"order-service/ratelimit"
+	"order-service/tracing"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
 func main() {
 	// Load environment variables
 	godotenv.Load()
 
+	// Initialize structured logging
+	logger.Init("order-service")
+
+	// Initialize tracing
+	shutdownTracing, err := tracing.Init(context.Background(), "order-service")
+	if err != nil {
+		logger.Fatal("failed to initialize tracing", "error", err)
+	}
+	defer shutdownTracing(context.Background())
+
 	// Initialize database
 	db, err := database.Connect()
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		logger.Fatal("failed to connect to database", "error", err)
 	}
 	defer db.Close()
 
 	// Initialize database schema
 	if err := database.InitDB(db); err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
+		logger.Fatal("failed to initialize database", "error", err)
 	}
 
 	// Initialize Redis cache
 	redisClient, err := cache.Connect()
 	if err != nil {
-		log.Fatalf("Failed to connect to Redis: %v", err)
+		logger.Fatal("failed to connect to Redis", "error", err)
 	}
 	defer redisClient.Close()
 
@@ -49,33 +72,179 @@ func main() {
 		os.Getenv("RABBITMQ_PASSWORD"),
 	)
 	if err != nil {
-		log.Fatalf("Failed to connect to RabbitMQ: %v", err)
+		logger.Fatal("failed to connect to RabbitMQ", "error", err)
 	}
 	defer rmq.Close()
 
+	// Cancel unprocessed orders of deleted accounts
+	userConsumer := consumers.NewUserConsumer(db)
+	if err := rmq.Consume(rabbitmq.QueueUserDeletedOrders, userConsumer.ProcessUserDeleted); err != nil {
+		logger.Fatal("failed to start user_deleted consumer", "error", err)
+	}
+
+	// Access tokens are verified with auth-service's published public keys
+	jwksURL := os.Getenv("AUTH_JWKS_URL")
+	if jwksURL == "" {
+		jwksURL = "http://localhost:8001/.well-known/jwks.json"
+	}
+	// auth-service publishes keys at least this long before they sign
+	jwksTTL := 5 * time.Minute
+	if value := os.Getenv("JWKS_CACHE_TTL"); value != "" {
+		if jwksTTL, err = time.ParseDuration(value); err != nil {
+			logger.Fatal("invalid JWKS_CACHE_TTL", "error", err)
+		}
+	}
+	jwksClient := jwks.NewClient(jwksURL, jwksTTL)
+	if err := jwksClient.Refresh(context.Background()); err != nil {
+		// auth-service may start later; keys are fetched on first use
+		slog.Warn("initial JWKS fetch failed", "url", jwksURL, "error", err)
+	}
+
+	// Product images are kept in local or S3-compatible storage
+	storage, err := media.FromEnv()
+	if err != nil {
+		logger.Fatal("failed to initialize media storage", "error", err)
+	}
+
 	// Initialize handlers
-	productHandler := handlers.NewProductHandler(db, redisClient)
-	orderHandler := handlers.NewOrderHandler(db, rmq)
+	rateHandler := handlers.NewExchangeRateHandler(db, redisClient)
+	promotionHandler := handlers.NewPromotionHandler(db)
+	taxRateHandler := handlers.NewTaxRateHandler(db)
+	productHandler := handlers.NewProductHandler(db, redisClient, rateHandler, storage)
+	categoryHandler := handlers.NewCategoryHandler(db, redisClient)
+	reviewHandler := handlers.NewReviewHandler(db, redisClient)
+	orderHandler := handlers.NewOrderHandler(db, rmq, os.Getenv("REQUIRE_VERIFIED_EMAIL") == "true")
 
 	// Setup Gin router
-	router := gin.Default()
+	router := gin.New()
+
+	// Only proxies listed in TRUSTED_PROXIES may set X-Forwarded-For;
+	// otherwise any client could choose the IP that rate limits key on
+	var trustedProxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			trustedProxies = append(trustedProxies, proxy)
+		}
+	}
+	if err := router.SetTrustedProxies(trustedProxies); err != nil {
+		logger.Fatal("invalid TRUSTED_PROXIES", "error", err)
+	}
+
+	router.Use(gin.Recovery())
+	router.Use(middleware.RequestID())
+	router.Use(otelgin.Middleware("order-service"))
+	router.Use(metrics.Middleware())
+	router.Use(middleware.RequestLogger())
+	router.Use(middleware.ErrorHandler())
+	apperror.UseJSONFieldNames()
 
-	// Health check endpoint
-	router.GET("/health", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"status": "healthy", "service": "order-service"})
+	// Prometheus metrics endpoint
+	router.GET("/metrics", metrics.Handler())
+
+	// Liveness and readiness probes
+	checker := health.NewChecker("order-service", 2*time.Second)
+	checker.Register("database", db.PingContext)
+	checker.Register("redis", func(ctx context.Context) error {
+		return redisClient.Ping(ctx).Err()
+	})
+	checker.Register("rabbitmq", func(ctx context.Context) error {
+		if !rmq.IsConnected() {
+			return errors.New("connection closed")
+		}
+		return nil
+	})
+	checker.Register("consumers", func(ctx context.Context) error {
+		for queue, active := range rmq.ConsumerStatus() {
+			if !active {
+				return fmt.Errorf("consumer for %s stopped", queue)
+			}
+		}
+		return nil
 	})
+	checker.Register("jwks", jwksClient.Ready)
+	router.GET("/livez", gin.WrapF(checker.LivenessHandler))
+	router.GET("/readyz", gin.WrapF(checker.ReadinessHandler))
+	// Kept for monitors configured before /readyz existed
+	router.GET("/health", gin.WrapF(checker.ReadinessHandler))
 
 	// Public routes - Products
 	router.GET("/products", productHandler.SearchProducts)
 	router.GET("/products/:id", productHandler.GetProductByID)
+	router.GET("/products/:id/reviews", reviewHandler.ListReviews)
+	router.GET("/categories", categoryHandler.GetCategories)
+	router.GET("/media/*key", productHandler.ServeMedia)
+	router.GET("/exchange-rates", rateHandler.ListRates)
+
+	// Rate limits, overridable as "<requests>/<window>"
+	limiter := ratelimit.New(redisClient)
+	createOrderLimit := middleware.RateLimit(limiter, middleware.RateLimitRule{
+		Name:  "create_order",
+		Limit: ratelimit.FromEnv("RATE_LIMIT_CREATE_ORDER", ratelimit.Limit{Requests: 10, Window: time.Minute}),
+		Key:   middleware.UserKey,
+	})
 
 	// Protected routes - Orders
 	protected := router.Group("/")
-	protected.Use(middleware.AuthMiddleware())
+	protected.Use(middleware.AuthMiddleware(db, jwksClient.Keyfunc))
 	{
-		protected.POST("/orders", orderHandler.CreateOrder)
-		protected.GET("/orders/:id", orderHandler.GetOrderByID)
-		protected.GET("/orders", orderHandler.GetUserOrders)
+		protected.POST("/orders", middleware.RequireScope(middleware.ScopeOrdersWrite), createOrderLimit, orderHandler.CreateOrder)
+		protected.GET("/orders/:id", middleware.RequireScope(middleware.ScopeOrdersRead), orderHandler.GetOrderByID)
+		protected.GET("/orders/:id/invoice", middleware.RequireScope(middleware.ScopeOrdersRead), orderHandler.GetInvoice)
+		protected.GET("/orders", middleware.RequireScope(middleware.ScopeOrdersRead), orderHandler.GetUserOrders)
+
+		// Reviews are written by customers themselves, not API keys
+		protected.POST("/products/:id/reviews", middleware.RequireUser(), reviewHandler.CreateReview)
+		protected.GET("/products/:id/reviews/mine", middleware.RequireUser(), reviewHandler.GetMyReview)
+		protected.PUT("/products/:id/reviews/mine", middleware.RequireUser(), reviewHandler.UpdateMyReview)
+		protected.DELETE("/products/:id/reviews/mine", middleware.RequireUser(), reviewHandler.DeleteMyReview)
+
+		admin := protected.Group("/admin")
+		{
+			// Fulfillment, for administrators and keys with the orders:fulfill scope
+			fulfill := middleware.RequireAdmin(db, middleware.ScopeOrdersFulfill)
+			admin.POST("/orders/:id/pack", fulfill, orderHandler.PackOrder)
+			admin.POST("/orders/:id/ship", fulfill, orderHandler.ShipOrder)
+			admin.POST("/orders/:id/deliver", fulfill, orderHandler.DeliverOrder)
+
+			// Exchange rates, for administrators only
+			adminOnly := middleware.RequireAdmin(db, "")
+			admin.PUT("/exchange-rates/:currency", adminOnly, rateHandler.SetRate)
+			admin.DELETE("/exchange-rates/:currency", adminOnly, rateHandler.DeleteRate)
+
+			// Promotions, for administrators only; deactivate with active=false
+			admin.GET("/promotions", adminOnly, promotionHandler.ListPromotions)
+			admin.POST("/promotions", adminOnly, promotionHandler.CreatePromotion)
+			admin.GET("/promotions/:id", adminOnly, promotionHandler.GetPromotion)
+			admin.PUT("/promotions/:id", adminOnly, promotionHandler.UpdatePromotion)
+
+			// Product variants, for administrators only
+			admin.POST("/products/:id/variants", adminOnly, productHandler.CreateVariant)
+			admin.PUT("/variants/:id", adminOnly, productHandler.UpdateVariant)
+
+			// Bulk import and export of products, for administrators only
+			admin.POST("/products/import", adminOnly, productHandler.ImportProducts)
+			admin.GET("/products/export", adminOnly, productHandler.ExportProducts)
+
+			// Product images, for administrators only
+			admin.POST("/products/:id/images", adminOnly, productHandler.UploadImage)
+			admin.PUT("/products/:id/images/order", adminOnly, productHandler.ReorderImages)
+			admin.DELETE("/products/:id/images/:imageId", adminOnly, productHandler.DeleteImage)
+
+			// Category tree, for administrators only
+			admin.POST("/categories", adminOnly, categoryHandler.CreateCategory)
+			admin.PUT("/categories/:id", adminOnly, categoryHandler.UpdateCategory)
+			admin.DELETE("/categories/:id", adminOnly, categoryHandler.DeleteCategory)
+			admin.PUT("/products/:id/category", adminOnly, categoryHandler.AssignCategory)
+
+			// Tax rates per product category, for administrators only
+			admin.GET("/tax-rates", adminOnly, taxRateHandler.ListTaxRates)
+			admin.PUT("/tax-rates/:category", adminOnly, taxRateHandler.SetTaxRate)
+			admin.DELETE("/tax-rates/:category", adminOnly, taxRateHandler.DeleteTaxRate)
+
+			// Review moderation, for administrators only
+			admin.GET("/reviews", adminOnly, reviewHandler.ListReviewsForModeration)
+			admin.PUT("/reviews/:id/status", adminOnly, reviewHandler.ModerateReview)
+		}
 	}
 
 	// Get service port
@@ -92,9 +261,9 @@ func main() {
 
 	// Start server in a goroutine
 	go func() {
-		log.Printf("Order Service starting on port %s", port)
+		slog.Info("order service starting", "port", port)
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Failed to start server: %v", err)
+			logger.Fatal("failed to start server", "error", err)
 		}
 	}()
 
@@ -103,15 +272,15 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 
-	log.Println("Shutting down server...")
+	slog.Info("shutting down server")
 
 	// Graceful shutdown with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
 	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatal("Server forced to shutdown:", err)
+		logger.Fatal("server forced to shutdown", "error", err)
 	}
 
-	log.Println("Server exited")
+	slog.Info("server exited")
 }
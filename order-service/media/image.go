@@ -0,0 +1,124 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // registers the GIF decoder
+	"image/jpeg"
+	"image/png"
+	"net/http"
+)
+
+const (
+	// MaxImageSize is the largest image upload accepted, in bytes
+	MaxImageSize = 5 << 20
+	// ThumbnailSize bounds the longer side of thumbnails, in pixels
+	ThumbnailSize = 320
+	// maxPixels rejects images that would take too much memory to decode
+	maxPixels = 25_000_000
+)
+
+var ErrUnsupportedImage = errors.New("image must be a JPEG, PNG or GIF")
+
+// imageFormats maps the accepted content types to their decoder's format
+// name and the file extension they are stored with
+var imageFormats = map[string]struct{ format, ext string }{
+	"image/jpeg": {"jpeg", ".jpg"},
+	"image/png":  {"png", ".png"},
+	"image/gif":  {"gif", ".gif"},
+}
+
+// Image is a validated upload and its thumbnail
+type Image struct {
+	ContentType string
+	Ext         string
+	Width       int
+	Height      int
+
+	Thumbnail            []byte
+	ThumbnailContentType string
+	ThumbnailExt         string
+}
+
+// ProcessImage checks that data is an image of an accepted type, judged by
+// its content rather than what the client claims, and renders its thumbnail.
+// JPEGs get a JPEG thumbnail; PNGs and GIFs a PNG one, keeping transparency.
+func ProcessImage(data []byte) (*Image, error) {
+	contentType := http.DetectContentType(data)
+	kind, ok := imageFormats[contentType]
+	if !ok {
+		return nil, ErrUnsupportedImage
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || format != kind.format {
+		return nil, fmt.Errorf("invalid %s image", kind.format)
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxPixels {
+		return nil, fmt.Errorf("image must have at most %d pixels", maxPixels)
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s image", kind.format)
+	}
+
+	img := &Image{ContentType: contentType, Ext: kind.ext, Width: config.Width, Height: config.Height}
+
+	var thumbnail bytes.Buffer
+	if contentType == "image/jpeg" {
+		err = jpeg.Encode(&thumbnail, resize(src, ThumbnailSize), &jpeg.Options{Quality: 85})
+		img.ThumbnailContentType, img.ThumbnailExt = "image/jpeg", ".jpg"
+	} else {
+		err = png.Encode(&thumbnail, resize(src, ThumbnailSize))
+		img.ThumbnailContentType, img.ThumbnailExt = "image/png", ".png"
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode thumbnail: %w", err)
+	}
+	img.Thumbnail = thumbnail.Bytes()
+
+	return img, nil
+}
+
+// resize scales src down to fit within size x size, keeping its aspect ratio.
+// Each thumbnail pixel averages the block of source pixels it covers, which
+// avoids the aliasing of nearest-neighbour sampling. Smaller images keep
+// their size.
+func resize(src image.Image, size int) image.Image {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	dstWidth, dstHeight := width, height
+	if width > size || height > size {
+		if width >= height {
+			dstWidth, dstHeight = size, max(1, height*size/width)
+		} else {
+			dstWidth, dstHeight = max(1, width*size/height), size
+		}
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	for y := 0; y < dstHeight; y++ {
+		y0, y1 := bounds.Min.Y+y*height/dstHeight, bounds.Min.Y+(y+1)*height/dstHeight
+		for x := 0; x < dstWidth; x++ {
+			x0, x1 := bounds.Min.X+x*width/dstWidth, bounds.Min.X+(x+1)*width/dstWidth
+
+			// Colours are averaged premultiplied, so transparent pixels do
+			// not bleed into their neighbours
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := src.At(sx, sy).RGBA()
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			dst.Set(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: uint16(a / n)})
+		}
+	}
+	return dst
+}
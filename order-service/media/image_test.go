@@ -0,0 +1,133 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func encodePNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodeJPEG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height)), nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodeGIF(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	palette := color.Palette{color.Transparent, color.Black}
+	if err := gif.Encode(&buf, image.NewPaletted(image.Rect(0, 0, width, height), palette), nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestProcessImage(t *testing.T) {
+	tests := []struct {
+		name            string
+		data            []byte
+		wantType        string
+		wantExt         string
+		wantThumbType   string
+		wantThumbWidth  int
+		wantThumbHeight int
+	}{
+		{name: "landscape png", data: encodePNG(t, 640, 480), wantType: "image/png", wantExt: ".png", wantThumbType: "image/png", wantThumbWidth: 320, wantThumbHeight: 240},
+		{name: "portrait jpeg", data: encodeJPEG(t, 100, 1000), wantType: "image/jpeg", wantExt: ".jpg", wantThumbType: "image/jpeg", wantThumbWidth: 32, wantThumbHeight: 320},
+		{name: "gif", data: encodeGIF(t, 400, 400), wantType: "image/gif", wantExt: ".gif", wantThumbType: "image/png", wantThumbWidth: 320, wantThumbHeight: 320},
+		{name: "small image", data: encodePNG(t, 200, 100), wantType: "image/png", wantExt: ".png", wantThumbType: "image/png", wantThumbWidth: 200, wantThumbHeight: 100},
+		{name: "thin strip", data: encodePNG(t, 2000, 1), wantType: "image/png", wantExt: ".png", wantThumbType: "image/png", wantThumbWidth: 320, wantThumbHeight: 1},
+	}
+
+	for _, tt := range tests {
+		img, err := ProcessImage(tt.data)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if img.ContentType != tt.wantType || img.Ext != tt.wantExt || img.ThumbnailContentType != tt.wantThumbType {
+			t.Errorf("%s: got %s %s, thumbnail %s", tt.name, img.ContentType, img.Ext, img.ThumbnailContentType)
+		}
+
+		thumbnail, format, err := image.DecodeConfig(bytes.NewReader(img.Thumbnail))
+		if err != nil {
+			t.Errorf("%s: thumbnail does not decode: %v", tt.name, err)
+			continue
+		}
+		if "image/"+format != img.ThumbnailContentType {
+			t.Errorf("%s: thumbnail is %s, labelled %s", tt.name, format, img.ThumbnailContentType)
+		}
+		if thumbnail.Width != tt.wantThumbWidth || thumbnail.Height != tt.wantThumbHeight {
+			t.Errorf("%s: thumbnail is %dx%d, want %dx%d", tt.name, thumbnail.Width, thumbnail.Height, tt.wantThumbWidth, tt.wantThumbHeight)
+		}
+	}
+}
+
+func TestProcessImageRejects(t *testing.T) {
+	// A GIF whose header claims far more pixels than it holds
+	huge := encodeGIF(t, 1, 1)
+	binary.LittleEndian.PutUint16(huge[6:], 60000)
+	binary.LittleEndian.PutUint16(huge[8:], 60000)
+
+	valid := encodePNG(t, 10, 10)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{name: "text", data: []byte("hello, world")},
+		{name: "svg", data: []byte(`<svg xmlns="http://www.w3.org/2000/svg"></svg>`)},
+		{name: "empty", data: nil},
+		{name: "truncated png", data: valid[:len(valid)/2]},
+		{name: "too many pixels", data: huge},
+	}
+
+	for _, tt := range tests {
+		if _, err := ProcessImage(tt.data); err == nil {
+			t.Errorf("%s: accepted", tt.name)
+		}
+	}
+
+	if _, err := ProcessImage([]byte("hello, world")); !errors.Is(err, ErrUnsupportedImage) {
+		t.Errorf("text error = %v, want ErrUnsupportedImage", err)
+	}
+}
+
+func TestResizeAverages(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	src.Set(0, 0, color.White)
+	src.Set(1, 1, color.White)
+	src.Set(1, 0, color.Black)
+	src.Set(0, 1, color.Black)
+
+	r, g, b, a := resize(src, 1).At(0, 0).RGBA()
+	if r != 0x7f7f || g != 0x7f7f || b != 0x7f7f || a != 0xffff {
+		t.Errorf("2x2 checkerboard averaged to %04x %04x %04x %04x, want mid grey", r, g, b, a)
+	}
+
+	// Transparent pixels must not darken their opaque neighbours
+	src = image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	src.Set(0, 0, color.NRGBA{R: 255, A: 255})
+	r, g, b, a = resize(src, 1).At(0, 0).RGBA()
+	if r != 0x7f7f || g != 0 || b != 0 || a != 0x7f7f {
+		t.Errorf("red next to transparent averaged to %04x %04x %04x %04x, want half transparent red", r, g, b, a)
+	}
+}
@@ -0,0 +1,76 @@
+// Package media stores uploaded files, such as product images, in a local
+// directory or an S3-compatible object store
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// CacheControl is served with every stored object. Keys are derived from the
+// content, so an object never changes and clients may cache it for good.
+const CacheControl = "public, max-age=31536000, immutable"
+
+var (
+	ErrNotFound   = errors.New("media object not found")
+	ErrInvalidKey = errors.New("invalid media key")
+)
+
+// keyPattern allows slash-separated segments of lowercase letters, digits,
+// '-', '_' and '.', none of them starting with '.'
+var keyPattern = regexp.MustCompile(`^[a-z0-9_-][a-z0-9._-]*(/[a-z0-9_-][a-z0-9._-]*)*$`)
+
+// Storage holds objects by key
+type Storage interface {
+	// Put stores data under key, replacing any object already there
+	Put(ctx context.Context, key, contentType string, data []byte) error
+	// Open returns the object stored under key, or ErrNotFound
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the object under key; missing objects are not an error
+	Delete(ctx context.Context, key string) error
+	// URL is where clients download the object from
+	URL(key string) string
+}
+
+// ValidKey reports whether key is safe to use as a path and an object name
+func ValidKey(key string) bool {
+	return len(key) <= 255 && keyPattern.MatchString(key)
+}
+
+// FromEnv returns the storage selected by MEDIA_STORAGE. "local", the
+// default, keeps files under MEDIA_DIR; "s3" uses the bucket S3_BUCKET at
+// S3_ENDPOINT with S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY. Objects are
+// served by this service under MEDIA_BASE_URL + "/media/", or straight from
+// S3_PUBLIC_URL when the bucket is public.
+func FromEnv() (Storage, error) {
+	baseURL := strings.TrimSuffix(os.Getenv("MEDIA_BASE_URL"), "/") + "/media/"
+
+	switch backend := os.Getenv("MEDIA_STORAGE"); backend {
+	case "", "local":
+		dir := os.Getenv("MEDIA_DIR")
+		if dir == "" {
+			dir = "uploads"
+		}
+		return NewLocal(dir, baseURL)
+	case "s3":
+		config := S3Config{
+			Endpoint:        os.Getenv("S3_ENDPOINT"),
+			Region:          os.Getenv("S3_REGION"),
+			Bucket:          os.Getenv("S3_BUCKET"),
+			AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+			PublicURL:       os.Getenv("S3_PUBLIC_URL"),
+		}
+		if config.PublicURL == "" {
+			config.PublicURL = baseURL
+		}
+		return NewS3(config)
+	default:
+		return nil, fmt.Errorf("unknown MEDIA_STORAGE %q, want local or s3", backend)
+	}
+}
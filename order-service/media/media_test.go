@@ -0,0 +1,106 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"order-service/media/mocks3"
+	"order-service/media/sigv4"
+	"testing"
+)
+
+var testCreds = sigv4.Credentials{AccessKeyID: "test-key", SecretAccessKey: "test-secret"}
+
+func newTestS3(t *testing.T, creds sigv4.Credentials) *S3 {
+	t.Helper()
+	server := httptest.NewServer(mocks3.New(testCreds).Handler())
+	t.Cleanup(server.Close)
+
+	storage, err := NewS3(S3Config{
+		Endpoint:        server.URL,
+		Bucket:          "media",
+		AccessKeyID:     creds.AccessKeyID,
+		SecretAccessKey: creds.SecretAccessKey,
+		PublicURL:       "https://cdn.example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return storage
+}
+
+func TestStorage(t *testing.T) {
+	storages := map[string]func(t *testing.T) Storage{
+		"local": func(t *testing.T) Storage {
+			storage, err := NewLocal(t.TempDir(), "http://localhost/media/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			return storage
+		},
+		"s3": func(t *testing.T) Storage {
+			return newTestS3(t, testCreds)
+		},
+	}
+
+	for name, newStorage := range storages {
+		t.Run(name, func(t *testing.T) {
+			storage := newStorage(t)
+			ctx := context.Background()
+			key := "products/1/abc.png"
+
+			if _, err := storage.Open(ctx, key); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("Open before Put error = %v, want ErrNotFound", err)
+			}
+
+			for _, data := range []string{"first", "second"} {
+				if err := storage.Put(ctx, key, "image/png", []byte(data)); err != nil {
+					t.Fatalf("Put: %v", err)
+				}
+				file, err := storage.Open(ctx, key)
+				if err != nil {
+					t.Fatalf("Open: %v", err)
+				}
+				got, err := io.ReadAll(file)
+				file.Close()
+				if err != nil || string(got) != data {
+					t.Errorf("Open = %q, %v, want %q", got, err, data)
+				}
+			}
+
+			if err := storage.Delete(ctx, key); err != nil {
+				t.Fatalf("Delete: %v", err)
+			}
+			if _, err := storage.Open(ctx, key); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Open after Delete error = %v, want ErrNotFound", err)
+			}
+			// Deleting twice is not an error
+			if err := storage.Delete(ctx, key); err != nil {
+				t.Errorf("second Delete: %v", err)
+			}
+
+			for _, invalid := range []string{"", "../secret", "products/.hidden", "Products/1.png", "a//b"} {
+				if err := storage.Put(ctx, invalid, "image/png", nil); !errors.Is(err, ErrInvalidKey) {
+					t.Errorf("Put(%q) error = %v, want ErrInvalidKey", invalid, err)
+				}
+			}
+		})
+	}
+}
+
+func TestS3RejectsWrongCredentials(t *testing.T) {
+	storage := newTestS3(t, sigv4.Credentials{AccessKeyID: "test-key", SecretAccessKey: "wrong"})
+
+	err := storage.Put(context.Background(), "products/1/abc.png", "image/png", []byte("data"))
+	if err == nil {
+		t.Fatal("Put with the wrong secret succeeded")
+	}
+}
+
+func TestS3URL(t *testing.T) {
+	storage := newTestS3(t, testCreds)
+	if got, want := storage.URL("products/1/abc.png"), "https://cdn.example.com/products/1/abc.png"; got != want {
+		t.Errorf("URL = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,153 @@
+// Package mocks3 is a minimal in-memory S3-compatible object store for local
+// development and tests. It serves path-style PUT, GET, HEAD and DELETE on
+// objects in any bucket, checking the Signature Version 4 signature and
+// payload hash of every write, so the S3 storage can be exercised end to end
+// without network access. Reads are allowed anonymously, as from a public
+// bucket.
+package mocks3
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"order-service/media/sigv4"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxSkew is how far a request's signing time may be from the server clock
+const maxSkew = 15 * time.Minute
+
+type object struct {
+	data         []byte
+	contentType  string
+	cacheControl string
+	etag         string
+	modifiedAt   time.Time
+}
+
+// Server holds objects by bucket and key
+type Server struct {
+	creds sigv4.Credentials
+
+	mu      sync.Mutex
+	objects map[string]object
+}
+
+// New creates an empty store accepting requests signed with creds
+func New(creds sigv4.Credentials) *Server {
+	return &Server{creds: creds, objects: make(map[string]object)}
+}
+
+// Handler returns the store's HTTP routes
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("PUT /{bucket}/{key...}", s.put)
+	mux.HandleFunc("GET /{bucket}/{key...}", s.get)
+	mux.HandleFunc("HEAD /{bucket}/{key...}", s.get)
+	mux.HandleFunc("DELETE /{bucket}/{key...}", s.delete)
+	return mux
+}
+
+func (s *Server) put(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody", "Failed to read the request body")
+		return
+	}
+	if !s.authorize(w, r, body) {
+		return
+	}
+
+	hash := sigv4.HashPayload(body)
+	s.mu.Lock()
+	s.objects[r.PathValue("bucket")+"/"+r.PathValue("key")] = object{
+		data:         body,
+		contentType:  r.Header.Get("Content-Type"),
+		cacheControl: r.Header.Get("Cache-Control"),
+		etag:         `"` + hash[:32] + `"`,
+		modifiedAt:   time.Now().UTC(),
+	}
+	s.mu.Unlock()
+
+	w.Header().Set("ETag", `"`+hash[:32]+`"`)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) get(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	obj, ok := s.objects[r.PathValue("bucket")+"/"+r.PathValue("key")]
+	s.mu.Unlock()
+
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+
+	header := w.Header()
+	header.Set("Content-Type", obj.contentType)
+	header.Set("Content-Length", strconv.Itoa(len(obj.data)))
+	header.Set("ETag", obj.etag)
+	header.Set("Last-Modified", obj.modifiedAt.Format(http.TimeFormat))
+	if obj.cacheControl != "" {
+		header.Set("Cache-Control", obj.cacheControl)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if r.Method != http.MethodHead {
+		w.Write(obj.data)
+	}
+}
+
+func (s *Server) delete(w http.ResponseWriter, r *http.Request) {
+	if !s.authorize(w, r, nil) {
+		return
+	}
+
+	s.mu.Lock()
+	delete(s.objects, r.PathValue("bucket")+"/"+r.PathValue("key"))
+	s.mu.Unlock()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authorize verifies the request's signature and that body matches the
+// payload hash it signed, writing the S3 error otherwise
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request, body []byte) bool {
+	_, err := sigv4.Verify(r, func(accessKeyID string) (string, bool) {
+		return s.creds.SecretAccessKey, accessKeyID == s.creds.AccessKeyID
+	}, maxSkew, time.Now())
+
+	switch {
+	case errors.Is(err, sigv4.ErrMissingSignature):
+		writeError(w, http.StatusForbidden, "AccessDenied", "Access Denied")
+	case errors.Is(err, sigv4.ErrUnknownKey):
+		writeError(w, http.StatusForbidden, "InvalidAccessKeyId", "The AWS Access Key Id you provided does not exist in our records.")
+	case errors.Is(err, sigv4.ErrExpired):
+		writeError(w, http.StatusForbidden, "RequestTimeTooSkewed", "The difference between the request time and the current time is too large.")
+	case errors.Is(err, sigv4.ErrMismatch):
+		writeError(w, http.StatusForbidden, "SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided.")
+	case err != nil:
+		writeError(w, http.StatusBadRequest, "AuthorizationHeaderMalformed", err.Error())
+	default:
+		payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+		if payloadHash == sigv4.UnsignedPayload || strings.EqualFold(payloadHash, sigv4.HashPayload(body)) {
+			return true
+		}
+		writeError(w, http.StatusBadRequest, "XAmzContentSHA256Mismatch", "The provided 'x-amz-content-sha256' header does not match what was computed.")
+	}
+	return false
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}{Code: code, Message: message})
+}
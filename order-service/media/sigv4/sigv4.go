@@ -0,0 +1,198 @@
+// Package sigv4 signs and verifies requests with AWS Signature Version 4, as
+// spoken by S3 and S3-compatible object stores
+package sigv4
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	algorithm  = "AWS4-HMAC-SHA256"
+	timeFormat = "20060102T150405Z"
+
+	// UnsignedPayload is the payload hash of requests whose body is not signed
+	UnsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+var (
+	ErrMissingSignature = errors.New("request is not signed")
+	ErrMalformed        = errors.New("malformed authorization header")
+	ErrUnknownKey       = errors.New("unknown access key")
+	ErrExpired          = errors.New("request time is too skewed")
+	ErrMismatch         = errors.New("signature does not match")
+)
+
+// Credentials identify the signer
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+}
+
+// HashPayload returns the hex SHA-256 of a request body, as sent in
+// X-Amz-Content-Sha256
+func HashPayload(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// Sign sets the X-Amz-Date, X-Amz-Content-Sha256 and Authorization headers of
+// req. The host, Content-Type, Content-MD5 and every X-Amz-* header are signed.
+func Sign(req *http.Request, creds Credentials, region, service, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format(timeFormat)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	var headers []string
+	for name := range req.Header {
+		name = strings.ToLower(name)
+		if name == "content-type" || name == "content-md5" || strings.HasPrefix(name, "x-amz-") {
+			headers = append(headers, name)
+		}
+	}
+	headers = append(headers, "host")
+	sort.Strings(headers)
+
+	scope := strings.Join([]string{amzDate[:8], region, service, "aws4_request"}, "/")
+	signature := sign(req, creds.SecretAccessKey, amzDate, scope, headers, payloadHash)
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		algorithm, creds.AccessKeyID, scope, strings.Join(headers, ";"), signature))
+}
+
+// Verify checks the signature of a request signed by Sign. secret looks up
+// the secret of an access key, and requests dated more than maxSkew from now
+// are rejected. It returns the access key the request was signed with.
+//
+// The body is not read: callers must compare its hash with the
+// X-Amz-Content-Sha256 header unless that is UnsignedPayload.
+func Verify(req *http.Request, secret func(accessKeyID string) (string, bool), maxSkew time.Duration, now time.Time) (string, error) {
+	authorization := req.Header.Get("Authorization")
+	if authorization == "" {
+		return "", ErrMissingSignature
+	}
+
+	rest, ok := strings.CutPrefix(authorization, algorithm+" ")
+	if !ok {
+		return "", ErrMalformed
+	}
+
+	fields := map[string]string{}
+	for _, part := range strings.Split(rest, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return "", ErrMalformed
+		}
+		fields[key] = value
+	}
+
+	accessKeyID, scope, ok := strings.Cut(fields["Credential"], "/")
+	headers := strings.Split(fields["SignedHeaders"], ";")
+	if !ok || fields["Signature"] == "" || !slices.Contains(headers, "host") {
+		return "", ErrMalformed
+	}
+
+	amzDate := req.Header.Get("X-Amz-Date")
+	signedAt, err := time.Parse(timeFormat, amzDate)
+	if err != nil || !strings.HasPrefix(scope, amzDate[:8]+"/") {
+		return "", ErrMalformed
+	}
+	if skew := now.Sub(signedAt); skew > maxSkew || skew < -maxSkew {
+		return "", ErrExpired
+	}
+
+	secretAccessKey, ok := secret(accessKeyID)
+	if !ok {
+		return "", ErrUnknownKey
+	}
+
+	expected := sign(req, secretAccessKey, amzDate, scope, headers, req.Header.Get("X-Amz-Content-Sha256"))
+	if !hmac.Equal([]byte(expected), []byte(fields["Signature"])) {
+		return "", ErrMismatch
+	}
+	return accessKeyID, nil
+}
+
+// sign returns the hex signature of req over the named headers, which must
+// be lowercase and sorted
+func sign(req *http.Request, secretAccessKey, amzDate, scope string, headers []string, payloadHash string) string {
+	var canonicalHeaders strings.Builder
+	for _, name := range headers {
+		value := req.Host
+		if name != "host" {
+			value = strings.Join(strings.Fields(strings.Join(req.Header.Values(name), ",")), " ")
+		}
+		canonicalHeaders.WriteString(name + ":" + value + "\n")
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI(req.URL.Path),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		strings.Join(headers, ";"),
+		payloadHash,
+	}, "\n")
+
+	stringToSign := strings.Join([]string{algorithm, amzDate, scope, HashPayload([]byte(canonicalRequest))}, "\n")
+
+	// The key is derived from the secret through each part of the scope
+	key := []byte("AWS4" + secretAccessKey)
+	for _, part := range strings.Split(scope, "/") {
+		key = hmacSHA256(key, part)
+	}
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// canonicalURI encodes each segment of a path the way S3 expects, which is
+// stricter than url.PathEscape
+func canonicalURI(path string) string {
+	if path == "" {
+		return "/"
+	}
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = uriEncode(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+func canonicalQuery(query url.Values) string {
+	var pairs []string
+	for key, values := range query {
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(key)+"="+uriEncode(value))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode percent-encodes everything but the unreserved characters
+func uriEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '.' || c == '_' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	httpRequestsTotal = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "http_requests_total",
+			Help: "Total number of HTTP requests by method, route and status code.",
+		},
+		[]string{"method", "route", "status"},
+	)
+
+	httpRequestDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "http_request_duration_seconds",
+			Help:    "HTTP request latency in seconds by method, route and status code.",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"method", "route", "status"},
+	)
+
+	// MessagesConsumed counts messages delivered to a handler per queue
+	MessagesConsumed = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rabbitmq_messages_consumed_total",
+			Help: "Total number of messages received from RabbitMQ per queue.",
+		},
+		[]string{"queue"},
+	)
+
+	// MessagesAcked counts messages acknowledged after successful handling
+	MessagesAcked = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rabbitmq_messages_acked_total",
+			Help: "Total number of messages acknowledged per queue.",
+		},
+		[]string{"queue"},
+	)
+
+	// MessagesNacked counts messages rejected and requeued after a handler error
+	MessagesNacked = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rabbitmq_messages_nacked_total",
+			Help: "Total number of messages rejected and requeued per queue.",
+		},
+		[]string{"queue"},
+	)
+
+	// HandlerDuration observes how long a handler takes per queue
+	HandlerDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "rabbitmq_handler_duration_seconds",
+			Help:    "Message handler duration in seconds per queue.",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"queue"},
+	)
+)
+
+// Middleware records request count and latency for every request
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		c.Next()
+
+		// Use the route template (e.g. /orders/:id) to keep label cardinality low
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(c.Writer.Status())
+
+		httpRequestsTotal.WithLabelValues(c.Request.Method, route, status).Inc()
+		httpRequestDuration.WithLabelValues(c.Request.Method, route, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Handler exposes the collected metrics in the Prometheus text format
+func Handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
@@ -0,0 +1,219 @@
+package middleware
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testAPIKey = "ak_test-key"
+
+// apiKeyQuery only matches keys that are neither revoked nor expired
+const apiKeyQuery = "WHERE k.key_hash = \\$1 AND k.revoked_at IS NULL AND k.expires_at > CURRENT_TIMESTAMP"
+
+// lastUsedUpdate only writes when the last recorded use is a minute old
+const lastUsedUpdate = "UPDATE api_keys SET last_used_at = CURRENT_TIMESTAMP\\s+WHERE id = \\$1 AND \\(last_used_at IS NULL OR last_used_at < CURRENT_TIMESTAMP - INTERVAL '1 minute'\\)"
+
+func newMockDB(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, mock
+}
+
+func noKeyfunc(*jwt.Token) (interface{}, error) {
+	return nil, errors.New("no tokens in this test")
+}
+
+// serveAPIKey sends a request with key through AuthMiddleware, then
+// handlers, to a handler recording the principal
+func serveAPIKey(t *testing.T, mock sqlmock.Sqlmock, db *sql.DB, key string, handlers ...gin.HandlerFunc) (*httptest.ResponseRecorder, *Principal) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	var principal *Principal
+	router := gin.New()
+	router.Use(ErrorHandler(), AuthMiddleware(db, noKeyfunc))
+	handlers = append(handlers, func(c *gin.Context) {
+		principal = GetPrincipal(c)
+		c.Status(http.StatusNoContent)
+	})
+	router.GET("/orders", handlers...)
+
+	req := httptest.NewRequest(http.MethodGet, "/orders", nil)
+	req.Header.Set(APIKeyHeader, key)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+	return rec, principal
+}
+
+func expectAPIKey(mock sqlmock.Sqlmock, scopes string) {
+	mock.ExpectQuery(apiKeyQuery).
+		WithArgs(HashAPIKey(testAPIKey)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "email", "scopes"}).AddRow(3, 7, "shop@example.com", scopes))
+}
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	db, mock := newMockDB(t)
+	expectAPIKey(mock, "{orders:read,orders:fulfill}")
+	mock.ExpectExec(lastUsedUpdate).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	rec, principal := serveAPIKey(t, mock, db, testAPIKey)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	want := Principal{Type: PrincipalAPIKey, UserID: 7, Email: "shop@example.com", APIKeyID: 3, Scopes: []string{ScopeOrdersRead, ScopeOrdersFulfill}}
+	if principal == nil || !reflect.DeepEqual(*principal, want) {
+		t.Errorf("principal %+v, want %+v", principal, want)
+	}
+}
+
+func TestAPIKeyLastUsedThrottled(t *testing.T) {
+	db, mock := newMockDB(t)
+	expectAPIKey(mock, "{orders:read}")
+	// Used within the last minute, so nothing is written
+	mock.ExpectExec(lastUsedUpdate).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if rec, _ := serveAPIKey(t, mock, db, testAPIKey); rec.Code != http.StatusNoContent {
+		t.Errorf("status %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestAPIKeyLastUsedFailure(t *testing.T) {
+	db, mock := newMockDB(t)
+	expectAPIKey(mock, "{orders:read}")
+	mock.ExpectExec(lastUsedUpdate).WithArgs(3).WillReturnError(errors.New("connection reset"))
+
+	// Failing to record the use does not fail the request
+	if rec, _ := serveAPIKey(t, mock, db, testAPIKey); rec.Code != http.StatusNoContent {
+		t.Errorf("status %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestAPIKeyRejected(t *testing.T) {
+	// Unknown, expired and revoked keys all find no row
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(apiKeyQuery).
+		WithArgs(HashAPIKey(testAPIKey)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "email", "scopes"}))
+
+	rec, principal := serveAPIKey(t, mock, db, testAPIKey)
+	if rec.Code != http.StatusUnauthorized || principal != nil {
+		t.Errorf("status %d, principal %+v: %s", rec.Code, principal, rec.Body)
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	tests := []struct {
+		name       string
+		principal  *Principal
+		wantStatus int
+	}{
+		{name: "granted", principal: &Principal{Type: PrincipalAPIKey, Scopes: []string{ScopeOrdersRead, ScopeOrdersWrite}}, wantStatus: http.StatusNoContent},
+		{name: "not granted", principal: &Principal{Type: PrincipalAPIKey, Scopes: []string{ScopeOrdersRead}}, wantStatus: http.StatusForbidden},
+		{name: "no scopes", principal: &Principal{Type: PrincipalAPIKey}, wantStatus: http.StatusForbidden},
+		// Users are not limited by scopes
+		{name: "user", principal: &Principal{Type: PrincipalUser}, wantStatus: http.StatusNoContent},
+		{name: "unauthenticated", wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(gin.TestMode)
+			router := gin.New()
+			router.Use(ErrorHandler(), func(c *gin.Context) {
+				if tt.principal != nil {
+					c.Set("principal", tt.principal)
+				}
+			})
+			router.POST("/orders", RequireScope(ScopeOrdersWrite), func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/orders", nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+		})
+	}
+}
+
+func TestScopedAPIKeyRequest(t *testing.T) {
+	db, mock := newMockDB(t)
+	expectAPIKey(mock, "{orders:read}")
+	mock.ExpectExec(lastUsedUpdate).WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// A read-only key cannot place orders
+	rec, _ := serveAPIKey(t, mock, db, testAPIKey, RequireScope(ScopeOrdersWrite))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status %d, want 403: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	tests := []struct {
+		name       string
+		principal  *Principal
+		scope      string
+		role       string
+		wantStatus int
+	}{
+		{name: "admin user", principal: &Principal{Type: PrincipalUser, UserID: 7}, scope: ScopeOrdersFulfill, role: RoleAdmin, wantStatus: http.StatusNoContent},
+		{name: "customer", principal: &Principal{Type: PrincipalUser, UserID: 7}, scope: ScopeOrdersFulfill, role: "customer", wantStatus: http.StatusForbidden},
+		{name: "key with the scope", principal: &Principal{Type: PrincipalAPIKey, Scopes: []string{ScopeOrdersFulfill}}, scope: ScopeOrdersFulfill, wantStatus: http.StatusNoContent},
+		{name: "key without the scope", principal: &Principal{Type: PrincipalAPIKey, Scopes: []string{ScopeOrdersRead}}, scope: ScopeOrdersFulfill, wantStatus: http.StatusForbidden},
+		// Without a scope, only admin users are let in
+		{name: "key on an admin-only endpoint", principal: &Principal{Type: PrincipalAPIKey, Scopes: Scopes}, wantStatus: http.StatusForbidden},
+		{name: "unauthenticated", scope: ScopeOrdersFulfill, wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			if tt.role != "" {
+				mock.ExpectQuery("SELECT role FROM users WHERE id = \\$1").
+					WithArgs(7).
+					WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow(tt.role))
+			}
+
+			gin.SetMode(gin.TestMode)
+			router := gin.New()
+			router.Use(ErrorHandler(), func(c *gin.Context) {
+				if tt.principal != nil {
+					c.Set("principal", tt.principal)
+				}
+			})
+			router.POST("/admin/orders/1/ship", RequireAdmin(db, tt.scope), func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/admin/orders/1/ship", nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestHashAPIKey(t *testing.T) {
+	// The SHA-256 of "abc", as stored by auth-service
+	want := "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+	if got := HashAPIKey("abc"); got != want {
+		t.Errorf("HashAPIKey = %s, want %s", got, want)
+	}
+}
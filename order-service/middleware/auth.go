@@ -1,29 +1,48 @@
 package middleware
 
 import (
-	"net/http"
-	"os"
+	"database/sql"
+	"order-service/apperror"
+	"order-service/jwks"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 )
 
+// TokenIssuer is the iss claim of access tokens minted by auth-service
+const TokenIssuer = "auth-service"
+
 type Claims struct {
 	UserID int    `json:"user_id"`
 	Email  string `json:"email"`
 	jwt.RegisteredClaims
 }
 
-// AuthMiddleware validates JWT token
-func AuthMiddleware() gin.HandlerFunc {
+// AuthMiddleware authenticates the caller by API key or by JWT token. Tokens
+// are validated against the public keys resolved by keyfunc, rejecting those
+// issued before the user's sessions were revoked, e.g. by a password reset.
+func AuthMiddleware(db *sql.DB, keyfunc jwt.Keyfunc) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		if apiKey := c.GetHeader(APIKeyHeader); apiKey != "" {
+			principal, err := authenticateAPIKey(c, db, apiKey)
+			if err != nil {
+				c.Error(err)
+				c.Abort()
+				return
+			}
+
+			c.Set("principal", principal)
+			c.Set("user_id", principal.UserID)
+			c.Set("user_email", principal.Email)
+
+			c.Next()
+			return
+		}
+
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"success": false,
-				"error":   "Authorization header is required",
-			})
+			c.Error(apperror.New(apperror.CodeMissingToken, "Authorization header or API key is required"))
 			c.Abort()
 			return
 		}
@@ -31,10 +50,7 @@ func AuthMiddleware() gin.HandlerFunc {
 		// Extract token from "Bearer <token>"
 		parts := strings.Split(authHeader, " ")
 		if len(parts) != 2 || parts[0] != "Bearer" {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"success": false,
-				"error":   "Invalid authorization header format",
-			})
+			c.Error(apperror.New(apperror.CodeInvalidToken, "Invalid authorization header format"))
 			c.Abort()
 			return
 		}
@@ -42,15 +58,14 @@ func AuthMiddleware() gin.HandlerFunc {
 		tokenString := parts[1]
 
 		// Parse and validate token
-		token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-			return []byte(os.Getenv("JWT_SECRET")), nil
-		})
+		token, err := jwt.ParseWithClaims(tokenString, &Claims{}, keyfunc,
+			jwt.WithValidMethods([]string{jwks.AlgorithmEdDSA, jwks.AlgorithmRS256}),
+			jwt.WithIssuer(TokenIssuer),
+			jwt.WithExpirationRequired(),
+		)
 
 		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"success": false,
-				"error":   "Invalid or expired token",
-			})
+			c.Error(apperror.Wrap(err, apperror.CodeInvalidToken, "Invalid or expired token"))
 			c.Abort()
 			return
 		}
@@ -58,18 +73,51 @@ func AuthMiddleware() gin.HandlerFunc {
 		// Extract claims
 		claims, ok := token.Claims.(*Claims)
 		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"success": false,
-				"error":   "Invalid token claims",
-			})
+			c.Error(apperror.New(apperror.CodeInvalidToken, "Invalid token claims"))
+			c.Abort()
+			return
+		}
+
+		revoked, err := sessionRevoked(c, db, claims)
+		if err != nil {
+			c.Error(apperror.Internal(err, "Failed to validate session"))
+			c.Abort()
+			return
+		}
+		if revoked {
+			c.Error(apperror.New(apperror.CodeInvalidToken, "Session has been revoked, please log in again"))
 			c.Abort()
 			return
 		}
 
 		// Set user info in context
+		c.Set("principal", &Principal{Type: PrincipalUser, UserID: claims.UserID, Email: claims.Email})
 		c.Set("user_id", claims.UserID)
 		c.Set("user_email", claims.Email)
 
 		c.Next()
 	}
 }
+
+// sessionRevoked reports whether the token predates the user's last session
+// revocation. JWT timestamps have second precision, so the revocation time is
+// truncated to keep tokens issued right after it valid. The comparison runs in
+// SQL so it uses the same time zone as CURRENT_TIMESTAMP.
+func sessionRevoked(c *gin.Context, db *sql.DB, claims *Claims) (bool, error) {
+	var issuedAt int64
+	if claims.IssuedAt != nil {
+		issuedAt = claims.IssuedAt.Unix()
+	}
+
+	var revoked bool
+	err := db.QueryRowContext(c.Request.Context(),
+		`SELECT COALESCE(date_trunc('second', sessions_revoked_at) > to_timestamp($2), FALSE)
+		 FROM users WHERE id = $1`,
+		claims.UserID, issuedAt,
+	).Scan(&revoked)
+
+	if err == sql.ErrNoRows {
+		return true, nil
+	}
+	return revoked, err
+}
@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"order-service/logger"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDHeader carries the request ID between clients and services
+const RequestIDHeader = "X-Request-ID"
+
+// RequestID assigns an X-Request-ID to every request, reusing the incoming
+// one when present, and stores it as the correlation ID of the request context
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(RequestIDHeader)
+		if requestID == "" {
+			requestID = logger.NewID()
+		}
+
+		c.Set("request_id", requestID)
+		c.Header(RequestIDHeader, requestID)
+		c.Request = c.Request.WithContext(logger.WithCorrelationID(c.Request.Context(), requestID))
+
+		c.Next()
+	}
+}
+
+// RequestLogger writes one structured access log line per request
+func RequestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		c.Next()
+
+		log := logger.FromContext(c.Request.Context())
+		attrs := []any{
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"route", c.FullPath(),
+			"status", c.Writer.Status(),
+			"latency_ms", time.Since(start).Milliseconds(),
+			"client_ip", c.ClientIP(),
+		}
+		if userID, ok := c.Get("user_id"); ok {
+			attrs = append(attrs, "user_id", userID)
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, "errors", c.Errors.String())
+		}
+
+		switch {
+		case c.Writer.Status() >= 500:
+			log.Error("request completed", attrs...)
+		case c.Writer.Status() >= 400:
+			log.Warn("request completed", attrs...)
+		default:
+			log.Info("request completed", attrs...)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"order-service/logger"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// captureLogs sends the default logger's JSON output to the returned buffer
+// for the rest of the test
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	previous := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(previous) })
+
+	var buf bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, nil)))
+	return &buf
+}
+
+func TestRequestIDLogging(t *testing.T) {
+	tests := []struct {
+		name      string
+		requestID string
+	}{
+		{name: "incoming", requestID: "req-1"},
+		{name: "generated"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+
+			gin.SetMode(gin.TestMode)
+			router := gin.New()
+			router.Use(RequestID(), RequestLogger())
+			router.GET("/orders/:id", func(c *gin.Context) {
+				logger.FromContext(c.Request.Context()).Info("order loaded")
+				c.Status(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/orders/42", nil)
+			if tt.requestID != "" {
+				req.Header.Set(RequestIDHeader, tt.requestID)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			requestID := rec.Header().Get(RequestIDHeader)
+			if requestID == "" || (tt.requestID != "" && requestID != tt.requestID) {
+				t.Fatalf("response request ID %q, want %q", requestID, tt.requestID)
+			}
+
+			// Both the handler's line and the access log carry the request ID
+			var lines []map[string]any
+			for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+				var entry map[string]any
+				if err := json.Unmarshal([]byte(line), &entry); err != nil {
+					t.Fatalf("log line %q: %v", line, err)
+				}
+				lines = append(lines, entry)
+			}
+			if len(lines) != 2 || lines[0]["msg"] != "order loaded" || lines[1]["msg"] != "request completed" {
+				t.Fatalf("logged %v", lines)
+			}
+			for _, entry := range lines {
+				if entry["correlation_id"] != requestID {
+					t.Errorf("%q logged with correlation ID %v, want %s", entry["msg"], entry["correlation_id"], requestID)
+				}
+			}
+			if lines[1]["route"] != "/orders/:id" || lines[1]["path"] != "/orders/42" {
+				t.Errorf("access log %v", lines[1])
+			}
+		})
+	}
+}
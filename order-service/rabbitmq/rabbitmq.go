@@ -1,23 +1,71 @@
 package rabbitmq
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
+	"log/slog"
+	"order-service/logger"
+	"order-service/metrics"
+	"sync"
 	"time"
 
 	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
 	QueueOrderPlaced    = "order_placed"
 	QueueOrderConfirmed = "order_confirmed"
 	QueueOrderFailed    = "order_failed"
+	QueueUserRegistered = "user_registered"
+	QueuePasswordReset  = "password_reset_requested"
+	QueueEmailChange    = "email_change_requested"
+	QueueOrderShipment  = "order_shipment"
+)
+
+// ExchangeUserDeleted fans user_deleted events out to one queue per service
+const ExchangeUserDeleted = "user_deleted"
+
+// Queues bound to ExchangeUserDeleted
+const (
+	QueueUserDeletedOrders    = "order-service.user_deleted"
+	QueueUserDeletedInventory = "inventory-worker.user_deleted"
 )
 
 type RabbitMQ struct {
 	conn    *amqp.Connection
 	channel *amqp.Channel
+
+	mu        sync.RWMutex
+	consumers map[string]bool // queue name -> delivery channel still open
+}
+
+var tracer = otel.Tracer("order-service/rabbitmq")
+
+// headerCarrier adapts AMQP headers to carry trace context
+type headerCarrier amqp.Table
+
+func (h headerCarrier) Get(key string) string {
+	if v, ok := h[key].(string); ok {
+		return v
+	}
+	return ""
+}
+
+func (h headerCarrier) Set(key, value string) {
+	h[key] = value
+}
+
+func (h headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	return keys
 }
 
 // Connect establishes connection to RabbitMQ with retry logic
@@ -33,7 +81,7 @@ func Connect(host, port, user, password string) (*RabbitMQ, error) {
 		if err == nil {
 			break
 		}
-		log.Printf("Failed to connect to RabbitMQ (attempt %d/10): %v", i+1, err)
+		slog.Warn("failed to connect to RabbitMQ", "attempt", i+1, "max_attempts", 10, "error", err)
 		time.Sleep(3 * time.Second)
 	}
 
@@ -47,11 +95,12 @@ func Connect(host, port, user, password string) (*RabbitMQ, error) {
 		return nil, fmt.Errorf("failed to open channel: %w", err)
 	}
 
-	log.Println("Successfully connected to RabbitMQ")
+	slog.Info("connected to RabbitMQ")
 
 	rmq := &RabbitMQ{
-		conn:    conn,
-		channel: channel,
+		conn:      conn,
+		channel:   channel,
+		consumers: make(map[string]bool),
 	}
 
 	// Declare all queues
@@ -65,7 +114,7 @@ func Connect(host, port, user, password string) (*RabbitMQ, error) {
 
 // declareQueues declares all required queues
 func (r *RabbitMQ) declareQueues() error {
-	queues := []string{QueueOrderPlaced, QueueOrderConfirmed, QueueOrderFailed}
+	queues := []string{QueueOrderPlaced, QueueOrderConfirmed, QueueOrderFailed, QueueUserRegistered, QueuePasswordReset, QueueEmailChange, QueueOrderShipment}
 
 	for _, queue := range queues {
 		_, err := r.channel.QueueDeclare(
@@ -79,16 +128,61 @@ func (r *RabbitMQ) declareQueues() error {
 		if err != nil {
 			return fmt.Errorf("failed to declare queue %s: %w", queue, err)
 		}
-		log.Printf("Queue declared: %s", queue)
+		slog.Info("queue declared", "queue", queue)
+	}
+
+	// Declaring the bound queues here too keeps events from being dropped
+	// while a consuming service is down
+	if err := r.declareFanout(ExchangeUserDeleted, QueueUserDeletedOrders, QueueUserDeletedInventory); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// declareFanout declares a durable fanout exchange and binds the given queues to it
+func (r *RabbitMQ) declareFanout(exchange string, queues ...string) error {
+	if err := r.channel.ExchangeDeclare(
+		exchange, // name
+		"fanout", // kind
+		true,     // durable
+		false,    // auto-deleted
+		false,    // internal
+		false,    // no-wait
+		nil,      // arguments
+	); err != nil {
+		return fmt.Errorf("failed to declare exchange %s: %w", exchange, err)
+	}
+
+	for _, queue := range queues {
+		if _, err := r.channel.QueueDeclare(queue, true, false, false, false, nil); err != nil {
+			return fmt.Errorf("failed to declare queue %s: %w", queue, err)
+		}
+		if err := r.channel.QueueBind(queue, "", exchange, false, nil); err != nil {
+			return fmt.Errorf("failed to bind queue %s to %s: %w", queue, exchange, err)
+		}
 	}
 
+	slog.Info("exchange declared", "exchange", exchange, "queues", queues)
 	return nil
 }
 
-// Publish publishes a message to a queue
-func (r *RabbitMQ) Publish(queueName string, message interface{}) error {
+// Publish publishes a message to a queue, propagating the trace context in its
+// headers and the correlation ID of ctx in the message properties
+func (r *RabbitMQ) Publish(ctx context.Context, queueName string, message interface{}) error {
+	ctx, span := tracer.Start(ctx, queueName+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "rabbitmq"),
+			attribute.String("messaging.destination.name", queueName),
+		),
+	)
+	defer span.End()
+
 	body, err := json.Marshal(message)
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "marshal failed")
 		return fmt.Errorf("failed to marshal message: %w", err)
 	}
 
@@ -97,21 +191,118 @@ func (r *RabbitMQ) Publish(queueName string, message interface{}) error {
 		queueName, // routing key (queue name)
 		false,     // mandatory
 		false,     // immediate
-		amqp.Publishing{
-			DeliveryMode: amqp.Persistent,
-			ContentType:  "application/json",
-			Body:         body,
-		},
+		newPublishing(ctx, body),
 	)
 
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "publish failed")
 		return fmt.Errorf("failed to publish message: %w", err)
 	}
 
-	log.Printf("Message published to queue %s", queueName)
+	logger.FromContext(ctx).Info("message published", "queue", queueName)
 	return nil
 }
 
+// Consume starts consuming messages from a queue. Each message is handled
+// inside a consumer span that continues the trace found in its headers, with
+// the message correlation ID stored in the handler context.
+func (r *RabbitMQ) Consume(queueName string, handler func(context.Context, []byte) error) error {
+	// Set QoS to process one message at a time
+	err := r.channel.Qos(
+		1,     // prefetch count
+		0,     // prefetch size
+		false, // global
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set QoS: %w", err)
+	}
+
+	msgs, err := r.channel.Consume(
+		queueName, // queue
+		"",        // consumer
+		false,     // auto-ack (manual ack for reliability)
+		false,     // exclusive
+		false,     // no-local
+		false,     // no-wait
+		nil,       // args
+	)
+	if err != nil {
+		return fmt.Errorf("failed to register consumer: %w", err)
+	}
+
+	slog.Info("started consuming", "queue", queueName)
+	r.setConsuming(queueName, true)
+
+	go func() {
+		// The delivery channel closes when the consumer is cancelled or the channel drops
+		defer r.setConsuming(queueName, false)
+
+		for msg := range msgs {
+			metrics.MessagesConsumed.WithLabelValues(queueName).Inc()
+
+			ctx, span := tracer.Start(deliveryContext(msg), queueName+" process",
+				trace.WithSpanKind(trace.SpanKindConsumer),
+				trace.WithAttributes(
+					attribute.String("messaging.system", "rabbitmq"),
+					attribute.String("messaging.destination.name", queueName),
+				),
+			)
+
+			log := logger.FromContext(ctx).With("queue", queueName)
+			log.Info("message received")
+
+			start := time.Now()
+			err := handler(ctx, msg.Body)
+			metrics.HandlerDuration.WithLabelValues(queueName).Observe(time.Since(start).Seconds())
+
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, "handler failed")
+				log.Error("failed to handle message", "error", err)
+				// Reject and requeue the message
+				msg.Nack(false, true)
+				metrics.MessagesNacked.WithLabelValues(queueName).Inc()
+			} else {
+				// Acknowledge successful processing
+				msg.Ack(false)
+				metrics.MessagesAcked.WithLabelValues(queueName).Inc()
+				log.Info("message processed")
+			}
+			span.End()
+		}
+	}()
+
+	return nil
+}
+
+// newPublishing wraps a JSON body in a persistent message carrying the trace
+// context of ctx in its headers and its correlation ID
+func newPublishing(ctx context.Context, body []byte) amqp.Publishing {
+	headers := amqp.Table{}
+	otel.GetTextMapPropagator().Inject(ctx, headerCarrier(headers))
+
+	return amqp.Publishing{
+		Headers:       headers,
+		CorrelationId: logger.CorrelationID(ctx),
+		DeliveryMode:  amqp.Persistent,
+		ContentType:   "application/json",
+		Body:          body,
+	}
+}
+
+// deliveryContext returns the context to handle a delivery in, continuing the
+// trace in its headers under its correlation ID, or a new one when it has none
+func deliveryContext(msg amqp.Delivery) context.Context {
+	correlationID := msg.CorrelationId
+	if correlationID == "" {
+		correlationID = logger.NewID()
+	}
+
+	ctx := logger.WithCorrelationID(context.Background(), correlationID)
+	return otel.GetTextMapPropagator().Extract(ctx, headerCarrier(msg.Headers))
+}
+
 // Close closes the RabbitMQ connection
 func (r *RabbitMQ) Close() {
 	if r.channel != nil {
@@ -120,5 +311,32 @@ func (r *RabbitMQ) Close() {
 	if r.conn != nil {
 		r.conn.Close()
 	}
-	log.Println("RabbitMQ connection closed")
+	slog.Info("RabbitMQ connection closed")
+}
+
+// IsConnected checks if the connection is alive
+func (r *RabbitMQ) IsConnected() bool {
+	return r.conn != nil && !r.conn.IsClosed()
+}
+
+// ConsumerStatus reports, per queue, whether its consumer is still receiving deliveries
+func (r *RabbitMQ) ConsumerStatus() map[string]bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	status := make(map[string]bool, len(r.consumers))
+	for queue, active := range r.consumers {
+		status[queue] = active
+	}
+	return status
+}
+
+func (r *RabbitMQ) setConsuming(queueName string, active bool) {
+	r.mu.Lock()
+	r.consumers[queueName] = active
+	r.mu.Unlock()
+
+	if !active {
+		slog.Warn("consumer stopped", "queue", queueName)
+	}
 }
@@ -0,0 +1,69 @@
+package rabbitmq
+
+import (
+	"context"
+	"order-service/logger"
+	"testing"
+
+	"github.com/streadway/amqp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// startTrace starts a sampled span under W3C trace context propagation, as
+// tracing.Init sets up
+func startTrace(t *testing.T) (context.Context, trace.SpanContext) {
+	t.Helper()
+	previous := otel.GetTextMapPropagator()
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTextMapPropagator(previous) })
+
+	ctx, span := sdktrace.NewTracerProvider().Tracer("test").Start(context.Background(), "POST /orders")
+	t.Cleanup(func() { span.End() })
+	return ctx, span.SpanContext()
+}
+
+func TestNewPublishing(t *testing.T) {
+	ctx, sc := startTrace(t)
+	ctx = logger.WithCorrelationID(ctx, "req-1")
+
+	msg := newPublishing(ctx, []byte(`{"order_id":42}`))
+
+	want := "00-" + sc.TraceID().String() + "-" + sc.SpanID().String() + "-01"
+	if got := msg.Headers["traceparent"]; got != want {
+		t.Errorf("traceparent %v, want %s", got, want)
+	}
+	if msg.CorrelationId != "req-1" {
+		t.Errorf("correlation ID %q", msg.CorrelationId)
+	}
+	if msg.DeliveryMode != amqp.Persistent || msg.ContentType != "application/json" || string(msg.Body) != `{"order_id":42}` {
+		t.Errorf("message %+v", msg)
+	}
+}
+
+func TestDeliveryContext(t *testing.T) {
+	ctx, sc := startTrace(t)
+	ctx = logger.WithCorrelationID(ctx, "req-1")
+	msg := newPublishing(ctx, nil)
+
+	// The consumer continues the publisher's trace under its correlation ID
+	received := deliveryContext(amqp.Delivery{Headers: msg.Headers, CorrelationId: msg.CorrelationId})
+	got := trace.SpanContextFromContext(received)
+	if got.TraceID() != sc.TraceID() || got.SpanID() != sc.SpanID() || !got.IsRemote() {
+		t.Errorf("span context %+v, want the remote parent %+v", got, sc)
+	}
+	if id := logger.CorrelationID(received); id != "req-1" {
+		t.Errorf("correlation ID %q", id)
+	}
+
+	// Messages from publishers without tracing still get a correlation ID
+	received = deliveryContext(amqp.Delivery{})
+	if trace.SpanContextFromContext(received).IsValid() {
+		t.Error("continued a trace from a message without one")
+	}
+	if logger.CorrelationID(received) == "" {
+		t.Error("no correlation ID for a message without one")
+	}
+}
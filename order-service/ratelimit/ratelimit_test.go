@@ -0,0 +1,186 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Limit
+		wantErr bool
+	}{
+		{value: "10/1m", want: Limit{Requests: 10, Window: time.Minute}},
+		{value: " 5 / 15m ", want: Limit{Requests: 5, Window: 15 * time.Minute}},
+		{value: "3/1h", want: Limit{Requests: 3, Window: time.Hour}},
+		{value: "10", wantErr: true},
+		{value: "0/1m", wantErr: true},
+		{value: "-1/1m", wantErr: true},
+		{value: "ten/1m", wantErr: true},
+		{value: "10/0s", wantErr: true},
+		{value: "10/soon", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := Parse(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Parse(%q) error = %v, want error %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Parse(%q) = %+v, want %+v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func newRedisClient(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	// No retries, so requests fail fast once the server is closed
+	client := redis.NewClient(&redis.Options{Addr: server.Addr(), MaxRetries: -1})
+	t.Cleanup(func() { client.Close() })
+	return server, client
+}
+
+func TestSlidingWindow(t *testing.T) {
+	limiters := map[string]func(t *testing.T) Limiter{
+		"redis": func(t *testing.T) Limiter {
+			_, client := newRedisClient(t)
+			return NewRedisLimiter(client)
+		},
+		"memory": func(t *testing.T) Limiter {
+			return NewMemoryLimiter()
+		},
+	}
+
+	for name, newLimiter := range limiters {
+		t.Run(name, func(t *testing.T) {
+			limiter := newLimiter(t)
+			ctx := context.Background()
+			limit := Limit{Requests: 3, Window: 200 * time.Millisecond}
+
+			for i, wantRemaining := range []int{2, 1, 0} {
+				result, err := limiter.Allow(ctx, "client", limit)
+				if err != nil {
+					t.Fatalf("request %d: %v", i+1, err)
+				}
+				if !result.Allowed || result.Remaining != wantRemaining {
+					t.Fatalf("request %d = %+v, want allowed with %d remaining", i+1, result, wantRemaining)
+				}
+			}
+
+			result, err := limiter.Allow(ctx, "client", limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Allowed {
+				t.Fatal("request over the limit was allowed")
+			}
+			if result.RetryAfter <= 0 || result.RetryAfter > limit.Window {
+				t.Errorf("RetryAfter = %v, want within (0, %v]", result.RetryAfter, limit.Window)
+			}
+
+			// Keys are limited independently
+			if result, err := limiter.Allow(ctx, "other", limit); err != nil || !result.Allowed {
+				t.Errorf("other key = %+v, %v, want allowed", result, err)
+			}
+
+			// Once the oldest request slides out of the window, one more fits
+			time.Sleep(result.RetryAfter + 20*time.Millisecond)
+			if result, err := limiter.Allow(ctx, "client", limit); err != nil || !result.Allowed {
+				t.Errorf("after the window = %+v, %v, want allowed", result, err)
+			}
+		})
+	}
+}
+
+func TestRedisLimiterSharesState(t *testing.T) {
+	_, client := newRedisClient(t)
+	ctx := context.Background()
+	limit := Limit{Requests: 2, Window: time.Minute}
+
+	// Two instances against the same Redis count requests together
+	first, second := NewRedisLimiter(client), NewRedisLimiter(client)
+	first.Allow(ctx, "client", limit)
+	second.Allow(ctx, "client", limit)
+
+	result, err := first.Allow(ctx, "client", limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Allowed {
+		t.Error("third request across instances was allowed")
+	}
+}
+
+func TestNewFallsBackToMemory(t *testing.T) {
+	server, client := newRedisClient(t)
+	limiter := New(client)
+	ctx := context.Background()
+	limit := Limit{Requests: 1, Window: time.Minute}
+
+	if result, err := limiter.Allow(ctx, "client", limit); err != nil || !result.Allowed {
+		t.Fatalf("with Redis = %+v, %v, want allowed", result, err)
+	}
+
+	// Without Redis, requests are still limited, from process memory
+	server.Close()
+
+	result, err := limiter.Allow(ctx, "client", limit)
+	if err != nil || !result.Allowed {
+		t.Fatalf("first request in memory = %+v, %v, want allowed", result, err)
+	}
+	result, err = limiter.Allow(ctx, "client", limit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Allowed {
+		t.Error("second request in memory was allowed")
+	}
+}
+
+type failingLimiter struct{}
+
+func (failingLimiter) Allow(context.Context, string, Limit) (Result, error) {
+	return Result{}, errors.New("unavailable")
+}
+
+func TestFallbackLimiter(t *testing.T) {
+	limiter := &fallbackLimiter{primary: failingLimiter{}, fallback: NewMemoryLimiter()}
+	limit := Limit{Requests: 1, Window: time.Minute}
+
+	result, err := limiter.Allow(context.Background(), "client", limit)
+	if err != nil || !result.Allowed {
+		t.Fatalf("Allow = %+v, %v, want allowed by the fallback", result, err)
+	}
+	if result, _ := limiter.Allow(context.Background(), "client", limit); result.Allowed {
+		t.Error("fallback did not limit the second request")
+	}
+}
+
+func TestNewWithoutRedis(t *testing.T) {
+	if _, ok := New(nil).(*MemoryLimiter); !ok {
+		t.Error("New(nil) is not a memory limiter")
+	}
+}
+
+func TestMemoryLimiterSweepsIdleKeys(t *testing.T) {
+	limiter := NewMemoryLimiter()
+	ctx := context.Background()
+	limit := Limit{Requests: 1, Window: time.Millisecond}
+
+	for i := 0; i <= maxTrackedKeys; i++ {
+		limiter.requests[string(rune(i))] = []time.Time{time.Now().Add(-time.Hour)}
+	}
+	limiter.Allow(ctx, "client", limit)
+
+	if len(limiter.requests) > 1 {
+		t.Errorf("%d keys tracked after a sweep, want 1", len(limiter.requests))
+	}
+}
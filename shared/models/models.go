@@ -18,19 +18,34 @@ type Product struct {
 	ID          int       `json:"id" db:"id"`
 	Name        string    `json:"name" db:"name"`
 	Description string    `json:"description" db:"description"`
-	Price       float64   `json:"price" db:"price"`
+	Price       int64     `json:"price" db:"price"` // minor units of Currency
+	Currency    string    `json:"currency" db:"currency"`
 	Stock       int       `json:"stock" db:"stock"`
-	Category    string    `json:"category" db:"category"`
+	CategoryID  *int      `json:"category_id" db:"category_id"`
 	CreatedAt   time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
 }
 
+// ProductVariant is a size, colour or other version of a product with its own
+// SKU and stock
+type ProductVariant struct {
+	ID         int               `json:"id" db:"id"`
+	ProductID  int               `json:"product_id" db:"product_id"`
+	SKU        string            `json:"sku" db:"sku"`
+	Attributes map[string]string `json:"attributes" db:"attributes"`
+	Price      *int64            `json:"price" db:"price"` // own price, or nil for the product's
+	Stock      int               `json:"stock" db:"stock"`
+	CreatedAt  time.Time         `json:"created_at" db:"created_at"`
+	UpdatedAt  time.Time         `json:"updated_at" db:"updated_at"`
+}
+
 // Order represents an order
 type Order struct {
 	ID          int         `json:"id" db:"id"`
 	UserID      int         `json:"user_id" db:"user_id"`
-	Status      string      `json:"status" db:"status"` // PENDING, CONFIRMED, CANCELLED, FAILED
-	TotalAmount float64     `json:"total_amount" db:"total_amount"`
+	Status      string      `json:"status" db:"status"`             // PENDING, CONFIRMED, PACKED, SHIPPED, DELIVERED, CANCELLED, FAILED
+	TotalAmount int64       `json:"total_amount" db:"total_amount"` // minor units of Currency
+	Currency    string      `json:"currency" db:"currency"`
 	CreatedAt   time.Time   `json:"created_at" db:"created_at"`
 	UpdatedAt   time.Time   `json:"updated_at" db:"updated_at"`
 	Items       []OrderItem `json:"items,omitempty" db:"-"`
@@ -41,8 +56,9 @@ type OrderItem struct {
 	ID        int       `json:"id" db:"id"`
 	OrderID   int       `json:"order_id" db:"order_id"`
 	ProductID int       `json:"product_id" db:"product_id"`
+	VariantID *int      `json:"variant_id" db:"variant_id"`
 	Quantity  int       `json:"quantity" db:"quantity"`
-	Price     float64   `json:"price" db:"price"`
+	Price     int64     `json:"price" db:"price"` // minor units of the order's currency
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 }
 
@@ -81,7 +97,8 @@ type CreateOrderRequest struct {
 
 // OrderItemRequest represents an item in order request
 type OrderItemRequest struct {
-	ProductID int `json:"product_id" binding:"required"`
+	VariantID int `json:"variant_id" binding:"required_without=ProductID"`
+	ProductID int `json:"product_id" binding:"required_without=VariantID"`
 	Quantity  int `json:"quantity" binding:"required,min=1"`
 }
 
@@ -94,12 +111,20 @@ type CreateOrderResponse struct {
 
 // RabbitMQ Message Structures
 
+// Amount is money as messages carry it: the amount in major units as a
+// decimal string, with all the currency's minor digits, and its ISO 4217
+// currency, e.g. {"amount": "15999000.00", "currency": "IDR"}
+type Amount struct {
+	Amount   string `json:"amount"`
+	Currency string `json:"currency"`
+}
+
 // OrderPlacedMessage sent when order is placed
 type OrderPlacedMessage struct {
 	OrderID     int                `json:"order_id"`
 	UserID      int                `json:"user_id"`
 	Items       []OrderItemRequest `json:"items"`
-	TotalAmount float64            `json:"total_amount"`
+	TotalAmount Amount             `json:"total_amount"`
 	Timestamp   time.Time          `json:"timestamp"`
 }
 
@@ -130,6 +155,16 @@ type Response struct {
 
 // ErrorResponse for error handling
 type ErrorResponse struct {
-	Success bool   `json:"success"`
-	Error   string `json:"error"`
+	Success   bool         `json:"success"`
+	Error     string       `json:"error"`
+	Code      string       `json:"code"`                 // stable machine-readable code, e.g. VALIDATION_ERROR
+	Details   []FieldError `json:"details,omitempty"`    // per-field validation failures
+	RequestID string       `json:"request_id,omitempty"` // matches the X-Request-ID response header
+}
+
+// FieldError describes a single invalid request field
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
 }
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestOrderPlacedMessageWireFormat(t *testing.T) {
+	// As order-service publishes it
+	body := `{"order_id": 12, "user_id": 7, "items": [{"variant_id": 3, "product_id": 5, "quantity": 2}],
+		"total_amount": {"amount": "149000.00", "currency": "IDR"}, "timestamp": "2026-10-18T09:30:00Z"}`
+
+	var msg OrderPlacedMessage
+	if err := json.Unmarshal([]byte(body), &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.TotalAmount != (Amount{Amount: "149000.00", Currency: "IDR"}) {
+		t.Errorf("total amount %+v", msg.TotalAmount)
+	}
+	if len(msg.Items) != 1 || msg.Items[0].VariantID != 3 || msg.Items[0].Quantity != 2 {
+		t.Errorf("items %+v", msg.Items)
+	}
+}
@@ -13,6 +13,19 @@ const (
 	QueueOrderPlaced    = "order_placed"
 	QueueOrderConfirmed = "order_confirmed"
 	QueueOrderFailed    = "order_failed"
+	QueueUserRegistered = "user_registered"
+	QueuePasswordReset  = "password_reset_requested"
+	QueueEmailChange    = "email_change_requested"
+	QueueOrderShipment  = "order_shipment"
+)
+
+// ExchangeUserDeleted fans user_deleted events out to one queue per service
+const ExchangeUserDeleted = "user_deleted"
+
+// Queues bound to ExchangeUserDeleted
+const (
+	QueueUserDeletedOrders    = "order-service.user_deleted"
+	QueueUserDeletedInventory = "inventory-worker.user_deleted"
 )
 
 type RabbitMQ struct {
@@ -65,7 +78,7 @@ func Connect(host, port, user, password string) (*RabbitMQ, error) {
 
 // declareQueues declares all required queues
 func (r *RabbitMQ) declareQueues() error {
-	queues := []string{QueueOrderPlaced, QueueOrderConfirmed, QueueOrderFailed}
+	queues := []string{QueueOrderPlaced, QueueOrderConfirmed, QueueOrderFailed, QueueUserRegistered, QueuePasswordReset, QueueEmailChange, QueueOrderShipment}
 
 	for _, queue := range queues {
 		_, err := r.channel.QueueDeclare(
@@ -82,6 +95,39 @@ func (r *RabbitMQ) declareQueues() error {
 		log.Printf("Queue declared: %s", queue)
 	}
 
+	// Declaring the bound queues here too keeps events from being dropped
+	// while a consuming service is down
+	if err := r.declareFanout(ExchangeUserDeleted, QueueUserDeletedOrders, QueueUserDeletedInventory); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// declareFanout declares a durable fanout exchange and binds the given queues to it
+func (r *RabbitMQ) declareFanout(exchange string, queues ...string) error {
+	if err := r.channel.ExchangeDeclare(
+		exchange, // name
+		"fanout", // kind
+		true,     // durable
+		false,    // auto-deleted
+		false,    // internal
+		false,    // no-wait
+		nil,      // arguments
+	); err != nil {
+		return fmt.Errorf("failed to declare exchange %s: %w", exchange, err)
+	}
+
+	for _, queue := range queues {
+		if _, err := r.channel.QueueDeclare(queue, true, false, false, false, nil); err != nil {
+			return fmt.Errorf("failed to declare queue %s: %w", queue, err)
+		}
+		if err := r.channel.QueueBind(queue, "", exchange, false, nil); err != nil {
+			return fmt.Errorf("failed to bind queue %s to %s: %w", queue, exchange, err)
+		}
+	}
+
+	log.Printf("Exchange declared: %s -> %v", exchange, queues)
 	return nil
 }
 